@@ -0,0 +1,165 @@
+/*
+ * Compares the field data of two FDF files and prints the fields that were added, removed or changed.
+ * Exits with status 1 if the files differ.
+ *
+ * Run as: go run fdf_diff.go a.fdf b.fdf
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/fdf"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf("Compare field data of two FDF files\n")
+		fmt.Printf("Usage: go run fdf_diff.go a.fdf b.fdf\n")
+		os.Exit(1)
+	}
+
+	// Enable debug-level logging.
+	common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
+
+	numDiffs, err := diffFdf(os.Args[1], os.Args[2])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if numDiffs > 0 {
+		fmt.Printf("%d difference(s)\n", numDiffs)
+		os.Exit(1)
+	}
+	fmt.Printf("No differences\n")
+}
+
+// diffFdf prints the differences between the fields of FDF files `pathA` and `pathB`.
+// Returns the number of differences.
+func diffFdf(pathA, pathB string) (int, error) {
+	fieldsA, err := loadFdfFields(pathA)
+	if err != nil {
+		return 0, err
+	}
+	fieldsB, err := loadFdfFields(pathB)
+	if err != nil {
+		return 0, err
+	}
+
+	valuesA := map[string]string{}
+	for _, field := range fieldsA {
+		valuesA[field.name] = valueString(field.value)
+	}
+	valuesB := map[string]string{}
+	for _, field := range fieldsB {
+		valuesB[field.name] = valueString(field.value)
+	}
+
+	var names []string
+	for name := range valuesA {
+		names = append(names, name)
+	}
+	for name := range valuesB {
+		if _, has := valuesA[name]; !has {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	numDiffs := 0
+	for _, name := range names {
+		a, hasA := valuesA[name]
+		b, hasB := valuesB[name]
+		switch {
+		case !hasB:
+			fmt.Printf("- %s: '%s'\n", name, a)
+		case !hasA:
+			fmt.Printf("+ %s: '%s'\n", name, b)
+		case a != b:
+			fmt.Printf("~ %s: '%s' -> '%s'\n", name, a, b)
+		default:
+			continue
+		}
+		numDiffs++
+	}
+	return numDiffs, nil
+}
+
+// fdfField is a terminal field of an FDF file.
+type fdfField struct {
+	name  string // Full name.
+	value core.PdfObject
+}
+
+// loadFdfFields returns the terminal fields of FDF file `fdfPath`, including fields nested as Kids.
+func loadFdfFields(fdfPath string) ([]fdfField, error) {
+	fdfData, err := fdf.LoadFromPath(fdfPath)
+	if err != nil {
+		return nil, err
+	}
+	fieldMap, err := fdfData.FieldDictionaries()
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort field names alphabetically.
+	keys := []string{}
+	for key := range fieldMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []fdfField
+	for _, key := range keys {
+		fields = appendFdfFields(fields, fieldMap[key], "")
+	}
+	return fields, nil
+}
+
+// appendFdfFields appends the terminal fields of FDF field dictionary `fieldDict` to `fields`.
+// `parent` is the full name of the parent field.
+func appendFdfFields(fields []fdfField, fieldDict *core.PdfObjectDictionary, parent string) []fdfField {
+	name := parent
+	if t, ok := core.GetString(fieldDict.Get("T")); ok {
+		if name != "" {
+			name += "."
+		}
+		name += t.Decoded()
+	}
+
+	kids, ok := core.GetArray(fieldDict.Get("Kids"))
+	if !ok {
+		return append(fields, fdfField{name: name, value: core.TraceToDirectObject(fieldDict.Get("V"))})
+	}
+	for _, kid := range kids.Elements() {
+		if kidDict, ok := core.GetDict(kid); ok {
+			fields = appendFdfFields(fields, kidDict, name)
+		}
+	}
+	return fields
+}
+
+// valueString returns a printable representation of FDF field value `val`.
+func valueString(val core.PdfObject) string {
+	switch t := val.(type) {
+	case nil:
+		return ""
+	case *core.PdfObjectString:
+		return t.Decoded()
+	case *core.PdfObjectName:
+		return "/" + t.String()
+	case *core.PdfObjectArray:
+		var values []string
+		for _, v := range t.Elements() {
+			values = append(values, valueString(core.TraceToDirectObject(v)))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+	return val.String()
+}
@@ -0,0 +1,263 @@
+/*
+ * Generates an FDF file from form data in JSON or CSV format.
+ *
+ * JSON input uses the same format as pdf_form_fill_json.go: [{"name": "field", "value": "value"}, ...].
+ * CSV input has one field per row: name,value (an optional header row "name,value" is skipped).
+ *
+ * By default the partial field names (last component of hierarchical names such as "a.b") are written as
+ * FDF field names, as pdf_form_fill_fdf_merge.go fills fields by partial name. With -nested, the fields are
+ * written as a hierarchy (Kids) of partial names, as exported by Acrobat, which is not read by
+ * pdf_form_fill_fdf_merge.go.
+ * With -template, values of checkbox and radio fields are written as names (e.g. /Yes) and entries not
+ * matching a field in the template PDF are reported. The entries are matched by partial name, or by full
+ * name with -nested.
+ *
+ * Run as: go run fdf_generate.go [-nested] [-template template.pdf] input.json|input.csv output.fdf
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+func main() {
+	var nested bool
+	var templatePath string
+	flag.BoolVar(&nested, "nested", false, "Write hierarchical field names as nested fields (Kids)")
+	flag.StringVar(&templatePath, "template", "", "Template PDF used for field types and validation")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		fmt.Printf("Generate FDF from JSON or CSV form data\n")
+		fmt.Printf("Usage: go run fdf_generate.go [options] input.json|input.csv output.fdf\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// Enable debug-level logging.
+	common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
+
+	err := generateFdf(args[0], args[1], templatePath, nested)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success, output written to %s\n", args[1])
+}
+
+// fieldValue is a form field name and value.
+type fieldValue struct {
+	name  string
+	value string
+}
+
+// generateFdf writes the form data in `inputPath` to FDF file `outputPath`.
+func generateFdf(inputPath, outputPath, templatePath string, nested bool) error {
+	var values []fieldValue
+	var err error
+	if strings.ToLower(filepath.Ext(inputPath)) == ".csv" {
+		values, err = loadCSV(inputPath)
+	} else {
+		values, err = loadJSON(inputPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	var fields map[string][]*model.PdfField
+	if templatePath != "" {
+		fields, err = loadTemplateFields(templatePath, !nested)
+		if err != nil {
+			return err
+		}
+	}
+
+	root := core.MakeArray()
+	parents := map[string]*core.PdfObjectDictionary{}
+	written := map[string]string{}
+	for _, fv := range values {
+		name := fv.name
+		if !nested {
+			name = partialName(fv.name)
+			if prev, has := written[name]; has && prev != fv.name {
+				fmt.Printf("WARNING: %s and %s have the same partial name %s\n", prev, fv.name, name)
+			}
+			written[name] = fv.name
+		}
+
+		var val core.PdfObject = core.MakeEncodedString(fv.value, !isASCII(fv.value))
+		if fields != nil {
+			matches := fields[name]
+			if len(matches) == 0 {
+				fmt.Printf("WARNING: %s does not match any field in %s\n", name, templatePath)
+			} else if button, ok := matches[0].GetContext().(*model.PdfFieldButton); ok && !button.IsPush() {
+				val = core.MakeName(fv.value)
+			}
+			if len(matches) > 1 {
+				fmt.Printf("WARNING: %s matches %d fields in %s, all are filled\n", name, len(matches), templatePath)
+			}
+		}
+
+		if !nested {
+			root.Append(makeFdfField(name, val))
+			continue
+		}
+
+		// Create the parent fields of the hierarchical name as needed.
+		parts := strings.Split(fv.name, ".")
+		kids := root
+		for i := range parts[:len(parts)-1] {
+			parentName := strings.Join(parts[:i+1], ".")
+			parent, has := parents[parentName]
+			if !has {
+				parent = makeFdfField(parts[i], nil)
+				parent.Set("Kids", core.MakeArray())
+				parents[parentName] = parent
+				kids.Append(parent)
+			}
+			kids, _ = core.GetArray(parent.Get("Kids"))
+		}
+		kids.Append(makeFdfField(parts[len(parts)-1], val))
+	}
+
+	return writeFdf(outputPath, root)
+}
+
+// partialName returns the partial name of hierarchical field name `name` (the last component).
+func partialName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// makeFdfField returns an FDF field dictionary with name `name` and value `val` (if not nil).
+func makeFdfField(name string, val core.PdfObject) *core.PdfObjectDictionary {
+	field := core.MakeDict()
+	field.Set("T", core.MakeEncodedString(name, !isASCII(name)))
+	if val != nil {
+		field.Set("V", val)
+	}
+	return field
+}
+
+// writeFdf writes an FDF file with `fields` to `outputPath`.
+func writeFdf(outputPath string, fields *core.PdfObjectArray) error {
+	fdfDict := core.MakeDict()
+	fdfDict.Set("Fields", fields)
+	catalog := core.MakeDict()
+	catalog.Set("FDF", fdfDict)
+
+	var buf bytes.Buffer
+	buf.WriteString("%FDF-1.2\n%\xe2\xe3\xcf\xd3\n")
+	buf.WriteString("1 0 obj\n")
+	buf.WriteString(catalog.WriteString())
+	buf.WriteString("\nendobj\n")
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+
+	return ioutil.WriteFile(outputPath, buf.Bytes(), 0644)
+}
+
+// loadJSON loads field values from JSON file `inputPath`.
+func loadJSON(inputPath string) ([]fieldValue, error) {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	var values []fieldValue
+	for _, entry := range entries {
+		values = append(values, fieldValue{name: entry.Name, value: entry.Value})
+	}
+	return values, nil
+}
+
+// loadCSV loads field values from CSV file `inputPath` with rows of name,value.
+func loadCSV(inputPath string) ([]fieldValue, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []fieldValue
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected name,value", i+1)
+		}
+		if i == 0 && strings.EqualFold(record[0], "name") && strings.EqualFold(record[1], "value") {
+			continue
+		}
+		values = append(values, fieldValue{name: record[0], value: record[1]})
+	}
+	return values, nil
+}
+
+// loadTemplateFields returns the terminal fields of the form in `templatePath` by full name, or by partial
+// name if `partial` is true.
+func loadTemplateFields(templatePath string, partial bool) (map[string][]*model.PdfField, error) {
+	f, err := os.Open(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pdfReader, err := model.NewPdfReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if pdfReader.AcroForm == nil {
+		return nil, fmt.Errorf("no form in %s", templatePath)
+	}
+
+	fields := map[string][]*model.PdfField{}
+	for _, field := range pdfReader.AcroForm.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+		if partial {
+			name = field.PartialName()
+		}
+		fields[name] = append(fields[name], field)
+	}
+	return fields, nil
+}
+
+// isASCII returns true if `s` only contains ASCII characters.
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+/*
+ * Validates FDF files against the form of a template PDF, so that form data exchanged with partners can be
+ * checked offline before filling. Reports:
+ * - FDF entries that do not match any field in the template,
+ * - checkbox/radio values that are not states of the field, choice values that are not options of the field,
+ * - text values longer than the field maximum length,
+ * - values for read-only fields.
+ * Exits with status 1 if any problems were found.
+ *
+ * Run as: go run fdf_validate.go template.pdf input.fdf [input2.fdf ...]
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/fdf"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf("Validate FDF files against the form fields of a template PDF\n")
+		fmt.Printf("Usage: go run fdf_validate.go template.pdf input.fdf [input2.fdf] ...\n")
+		os.Exit(1)
+	}
+
+	// Enable debug-level logging.
+	common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
+
+	fields, err := loadTemplateFields(os.Args[1])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	numProblems := 0
+	for _, fdfPath := range os.Args[2:] {
+		fmt.Printf("Input file: %s\n", fdfPath)
+
+		problems, err := validateFdf(fdfPath, fields)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			fmt.Printf(" - %s\n", problem)
+		}
+		if len(problems) == 0 {
+			fmt.Printf(" OK\n")
+		}
+		numProblems += len(problems)
+	}
+
+	if numProblems > 0 {
+		os.Exit(1)
+	}
+}
+
+// validateFdf returns the problems found when validating the fields of FDF file `fdfPath` against the
+// template `fields`.
+func validateFdf(fdfPath string, fields map[string]*model.PdfField) ([]string, error) {
+	fdfFields, err := loadFdfFields(fdfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, fdfField := range fdfFields {
+		field, has := fields[fdfField.name]
+		if !has {
+			problem := fmt.Sprintf("%s: no matching field", fdfField.name)
+			// The fill examples match by partial name, report candidates.
+			if matches := matchPartialName(fields, fdfField.name); len(matches) > 0 {
+				problem += fmt.Sprintf(" (partial name matches %s)", strings.Join(matches, ", "))
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		if fdfField.value == nil {
+			continue
+		}
+
+		if field.Flags().Has(model.FieldFlagReadOnly) {
+			problems = append(problems, fmt.Sprintf("%s: field is read-only", fdfField.name))
+		}
+		if problem := validateValue(field, fdfField.value); problem != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", fdfField.name, problem))
+		}
+	}
+	return problems, nil
+}
+
+// validateValue returns a description of the problem if `val` is not a valid value for `field`,
+// otherwise an empty string.
+func validateValue(field *model.PdfField, val core.PdfObject) string {
+	switch t := field.GetContext().(type) {
+	case *model.PdfFieldText:
+		str, ok := core.GetString(val)
+		if !ok {
+			return fmt.Sprintf("expected string value, got %s", valueString(val))
+		}
+		if maxLen, ok := core.GetIntVal(t.MaxLen); ok && len([]rune(str.Decoded())) > maxLen {
+			return fmt.Sprintf("value '%s' longer than maximum length %d", str.Decoded(), maxLen)
+		}
+	case *model.PdfFieldButton:
+		if t.IsPush() {
+			return "push button has no value"
+		}
+		state := valueString(val)
+		if name, ok := core.GetName(val); ok {
+			state = name.String()
+		}
+		states := buttonStates(field)
+		if state != "Off" && !contains(states, state) {
+			return fmt.Sprintf("value '%s' is not a state of the field (%s)", state, strings.Join(states, ", "))
+		}
+	case *model.PdfFieldChoice:
+		// Combo boxes with the Edit flag accept any value.
+		if field.Flags().Has(model.FieldFlagEdit) {
+			return ""
+		}
+		options := choiceOptions(t)
+		values := []core.PdfObject{val}
+		if arr, ok := core.GetArray(val); ok {
+			values = arr.Elements()
+		}
+		for _, v := range values {
+			str, ok := core.GetString(v)
+			if !ok {
+				return fmt.Sprintf("expected string value, got %s", valueString(v))
+			}
+			if !contains(options, str.Decoded()) {
+				return fmt.Sprintf("value '%s' is not an option of the field (%s)", str.Decoded(),
+					strings.Join(options, ", "))
+			}
+		}
+	}
+	return ""
+}
+
+// matchPartialName returns the full names of the fields in `fields` with the same partial name as `name`.
+func matchPartialName(fields map[string]*model.PdfField, name string) []string {
+	partial := name[strings.LastIndex(name, ".")+1:]
+
+	var matches []string
+	for fullname, field := range fields {
+		if field.PartialName() == partial {
+			matches = append(matches, fullname)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// buttonStates returns the on states of the widgets of button `field`.
+func buttonStates(field *model.PdfField) []string {
+	var states []string
+	for _, wa := range field.Annotations {
+		apDict, ok := core.GetDict(wa.AP)
+		if !ok {
+			continue
+		}
+		n, ok := core.GetDict(apDict.Get("N"))
+		if !ok {
+			continue
+		}
+		for _, key := range n.Keys() {
+			if key != "Off" && !contains(states, key.String()) {
+				states = append(states, key.String())
+			}
+		}
+	}
+	return states
+}
+
+// choiceOptions returns the export values of the options of choice field `ch`.
+func choiceOptions(ch *model.PdfFieldChoice) []string {
+	var options []string
+	if ch.Opt == nil {
+		return options
+	}
+	for _, opt := range ch.Opt.Elements() {
+		// Options are either strings or [export value, display text] pairs.
+		if arr, ok := core.GetArray(opt); ok && arr.Len() > 0 {
+			opt = arr.Get(0)
+		}
+		if str, ok := core.GetString(opt); ok {
+			options = append(options, str.Decoded())
+		}
+	}
+	return options
+}
+
+// contains returns true if `list` contains `s`.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadTemplateFields returns the terminal fields of the form in `templatePath` by full name.
+func loadTemplateFields(templatePath string) (map[string]*model.PdfField, error) {
+	f, err := os.Open(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pdfReader, err := model.NewPdfReader(f)
+	if err != nil {
+		return nil, err
+	}
+	if pdfReader.AcroForm == nil {
+		return nil, fmt.Errorf("no form in %s", templatePath)
+	}
+
+	fields := map[string]*model.PdfField{}
+	for _, field := range pdfReader.AcroForm.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = field
+	}
+	return fields, nil
+}
+
+// fdfField is a terminal field of an FDF file.
+type fdfField struct {
+	name  string // Full name.
+	value core.PdfObject
+}
+
+// loadFdfFields returns the terminal fields of FDF file `fdfPath`, including fields nested as Kids.
+func loadFdfFields(fdfPath string) ([]fdfField, error) {
+	fdfData, err := fdf.LoadFromPath(fdfPath)
+	if err != nil {
+		return nil, err
+	}
+	fieldMap, err := fdfData.FieldDictionaries()
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort field names alphabetically.
+	keys := []string{}
+	for key := range fieldMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []fdfField
+	for _, key := range keys {
+		fields = appendFdfFields(fields, fieldMap[key], "")
+	}
+	return fields, nil
+}
+
+// appendFdfFields appends the terminal fields of FDF field dictionary `fieldDict` to `fields`.
+// `parent` is the full name of the parent field.
+func appendFdfFields(fields []fdfField, fieldDict *core.PdfObjectDictionary, parent string) []fdfField {
+	name := parent
+	if t, ok := core.GetString(fieldDict.Get("T")); ok {
+		if name != "" {
+			name += "."
+		}
+		name += t.Decoded()
+	}
+
+	kids, ok := core.GetArray(fieldDict.Get("Kids"))
+	if !ok {
+		return append(fields, fdfField{name: name, value: core.TraceToDirectObject(fieldDict.Get("V"))})
+	}
+	for _, kid := range kids.Elements() {
+		if kidDict, ok := core.GetDict(kid); ok {
+			fields = appendFdfFields(fields, kidDict, name)
+		}
+	}
+	return fields
+}
+
+// valueString returns a printable representation of FDF field value `val`.
+func valueString(val core.PdfObject) string {
+	switch t := val.(type) {
+	case nil:
+		return ""
+	case *core.PdfObjectString:
+		return t.Decoded()
+	case *core.PdfObjectName:
+		return "/" + t.String()
+	case *core.PdfObjectArray:
+		var values []string
+		for _, v := range t.Elements() {
+			values = append(values, valueString(core.TraceToDirectObject(v)))
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+	return val.String()
+}
@@ -2,6 +2,9 @@
  * Create and apply a new form to an existing PDF.
  * The example shows how to load template1.pdf and add an interactive form to it and save it
  * as template1_with_form.pdf.
+ * The form contains single-line, multi-line, comb and password text fields, checkboxes, a combobox,
+ * a radio button group, a multi-select list box and a push button. All fields are created with
+ * appearance streams, so that they are shown in viewers which do not generate missing appearances.
  *
  * Run as: go run pdf_form_add.go
  */
@@ -9,10 +12,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"os"
 
 	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/contentstream"
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
 )
 
@@ -80,10 +87,13 @@ func addFormToPdf(inputPath string, outputPath string) error {
 }
 
 // textFieldsDef is a list of text fields to add to the form. The Rect field specifies the coordinates of the
-// field.
+// field. Flags can be used to create multi-line (FieldFlagMultiline), comb (FieldFlagComb, requires MaxLen)
+// and password (FieldFlagPassword) text fields.
 var textFieldsDef = []struct {
-	Name string
-	Rect []float64
+	Name   string
+	Rect   []float64
+	MaxLen int
+	Flags  model.FieldFlag
 }{
 	{Name: "full_name", Rect: []float64{123.97, 619.02, 343.99, 633.6}},
 	{Name: "address_line_1", Rect: []float64{142.86, 596.82, 347.3, 611.4}},
@@ -91,6 +101,9 @@ var textFieldsDef = []struct {
 	{Name: "age", Rect: []float64{95.15, 551.75, 125.3, 566.33}},
 	{Name: "city", Rect: []float64{96.47, 506.35, 168.37, 520.93}},
 	{Name: "country", Rect: []float64{114.69, 483.82, 186.59, 498.4}},
+	{Name: "postcode", Rect: []float64{72, 420, 192, 440}, MaxLen: 6, Flags: model.FieldFlagComb},
+	{Name: "pin", Rect: []float64{220, 420, 300, 440}, Flags: model.FieldFlagPassword},
+	{Name: "comments", Rect: []float64{72, 330, 300, 405}, Flags: model.FieldFlagMultiline},
 }
 
 // checkboxFieldDefs is a list of checkboxes to add to the form.
@@ -116,21 +129,86 @@ var choiceFieldDefs = []struct {
 	},
 }
 
+// radioFieldDefs is a list of radio button groups to add to the form. Only one button of a group can be
+// selected at a time. Each button has its own export value and location.
+var radioFieldDefs = []struct {
+	Name     string
+	Selected string
+	Buttons  []struct {
+		Value string
+		Rect  []float64
+	}
+}{
+	{
+		Name:     "contact_method",
+		Selected: "email",
+		Buttons: []struct {
+			Value string
+			Rect  []float64
+		}{
+			{Value: "email", Rect: []float64{372, 420, 384, 432}},
+			{Value: "phone", Rect: []float64{432, 420, 444, 432}},
+			{Value: "post", Rect: []float64{492, 420, 504, 432}},
+		},
+	},
+}
+
+// listboxFieldDefs is a list of list boxes to add to the form. Multiple options can be selected when
+// MultiSelect is set.
+var listboxFieldDefs = []struct {
+	Name        string
+	Rect        []float64
+	Options     []string
+	Selected    []string
+	MultiSelect bool
+}{
+	{
+		Name:        "languages",
+		Rect:        []float64{372, 330, 540, 405},
+		Options:     []string{"English", "French", "German", "Icelandic", "Spanish"},
+		Selected:    []string{"English"},
+		MultiSelect: true,
+	},
+}
+
+// pushButtonDefs is a list of push buttons with captions to add to the form.
+var pushButtonDefs = []struct {
+	Name    string
+	Rect    []float64
+	Caption string
+}{
+	{Name: "submit", Rect: []float64{72, 290, 172, 312}, Caption: "Submit"},
+}
+
 // createForm creates the form and fields to be placed on the `page`.
 func createForm(page *model.PdfPage) *model.PdfAcroForm {
 	form := model.NewPdfAcroForm()
 
 	// Add ZapfDingbats font.
 	zapfdb := model.NewStandard14FontMustCompile(model.ZapfDingbatsName)
+	helv := &formFont{name: "Helv", font: model.NewStandard14FontMustCompile(model.HelveticaName)}
 	form.DR = model.NewPdfPageResources()
 	form.DR.SetFontByName(`ZaDb`, zapfdb.ToPdfObject())
+	form.DR.SetFontByName(helv.name, helv.font.ToPdfObject())
+	form.DA = core.MakeString(`/Helv 0 Tf 0 g`)
 
 	for _, fdef := range textFieldsDef {
-		opt := annotator.TextFieldOptions{}
+		opt := annotator.TextFieldOptions{MaxLen: fdef.MaxLen}
 		textf, err := annotator.NewTextField(page, fdef.Name, fdef.Rect, opt)
 		if err != nil {
 			panic(err)
 		}
+		textf.DA = core.MakeString(`/Helv 0 Tf 0 g`)
+		if fdef.Flags != model.FieldFlagClear {
+			textf.SetFlag(fdef.Flags)
+		}
+
+		// Generate the appearance so that the field is shown in viewers that do not generate missing
+		// appearances.
+		err = generateAppearance(form, textf.PdfField)
+		if err != nil {
+			panic(err)
+		}
 
 		*form.Fields = append(*form.Fields, textf.PdfField)
 		page.AddAnnotation(textf.Annotations[0].PdfAnnotation)
@@ -153,10 +231,399 @@ func createForm(page *model.PdfPage) *model.PdfAcroForm {
 		if err != nil {
 			panic(err)
 		}
+		err = generateAppearance(form, comboboxf.PdfField)
+		if err != nil {
+			panic(err)
+		}
 
 		*form.Fields = append(*form.Fields, comboboxf.PdfField)
 		page.AddAnnotation(comboboxf.Annotations[0].PdfAnnotation)
 	}
 
+	for _, rdef := range radioFieldDefs {
+		radiof := newRadioField(page, rdef.Name, rdef.Selected)
+		for _, bdef := range rdef.Buttons {
+			widget := addRadioButton(page, radiof, bdef.Value, bdef.Rect)
+			page.AddAnnotation(widget.PdfAnnotation)
+		}
+
+		*form.Fields = append(*form.Fields, radiof.PdfField)
+	}
+
+	for _, lbdef := range listboxFieldDefs {
+		listboxf, err := newListboxField(page, helv, lbdef.Name, lbdef.Rect, lbdef.Options, lbdef.Selected, lbdef.MultiSelect)
+		if err != nil {
+			panic(err)
+		}
+
+		*form.Fields = append(*form.Fields, listboxf.PdfField)
+		page.AddAnnotation(listboxf.Annotations[0].PdfAnnotation)
+	}
+
+	for _, pbdef := range pushButtonDefs {
+		buttonf, err := newPushButtonField(page, helv, pbdef.Name, pbdef.Rect, pbdef.Caption)
+		if err != nil {
+			panic(err)
+		}
+
+		*form.Fields = append(*form.Fields, buttonf.PdfField)
+		page.AddAnnotation(buttonf.Annotations[0].PdfAnnotation)
+	}
+
 	return form
 }
+
+// newRadioField creates a radio button group with partial name `name` where the button with export value
+// `selected` is initially on. The buttons are added with addRadioButton.
+func newRadioField(page *model.PdfPage, name string, selected string) *model.PdfFieldButton {
+	field := model.NewPdfField()
+	radiof := &model.PdfFieldButton{}
+	field.SetContext(radiof)
+	radiof.PdfField = field
+
+	radiof.T = core.MakeString(name)
+	radiof.SetType(model.ButtonTypeRadio)
+	radiof.SetFlag(radiof.Flags().Set(model.FieldFlagNoToggleToOff))
+
+	state := "Off"
+	if selected != "" {
+		state = selected
+	}
+	radiof.V = core.MakeName(state)
+	return radiof
+}
+
+// addRadioButton adds a button with export value `value` at location `rect` to the radio group `radiof`.
+// The button appearance dictionary has an on state named `value` and an Off state. The buttons of a group
+// are mutually exclusive as only one can have the appearance state matching the field value.
+func addRadioButton(page *model.PdfPage, radiof *model.PdfFieldButton, value string, rect []float64) *model.PdfAnnotationWidget {
+	widget := model.NewPdfAnnotationWidget()
+	widget.Rect = core.MakeArrayFromFloats(rect)
+	widget.P = page.ToPdfObject()
+	widget.F = core.MakeInteger(4)
+	widget.Parent = radiof.ToPdfObject()
+
+	mk := core.MakeDict()
+	mk.Set("BC", core.MakeArrayFromFloats([]float64{0}))
+	mk.Set("CA", core.MakeString("l"))
+	widget.MK = mk
+
+	w, h := rectSize(rect)
+	dchoiceapp := core.MakeDict()
+	dchoiceapp.Set("Off", radioAppearance(w, h, false).ToPdfObject())
+	dchoiceapp.Set(core.PdfObjectName(value), radioAppearance(w, h, true).ToPdfObject())
+
+	appearance := core.MakeDict()
+	appearance.Set("N", dchoiceapp)
+	widget.AP = appearance
+
+	state := "Off"
+	if v, ok := core.GetNameVal(radiof.V); ok && v == value {
+		state = value
+	}
+	widget.AS = core.MakeName(state)
+
+	radiof.Annotations = append(radiof.Annotations, widget)
+	return widget
+}
+
+// newListboxField creates a list box field with partial name `name` at location `rect` listing `options`
+// with font `ff`, where the options in `selected` are initially selected and highlighted.
+func newListboxField(page *model.PdfPage, ff *formFont, name string, rect []float64, options, selected []string, multiSelect bool) (*model.PdfFieldChoice, error) {
+	if len(selected) > 1 && !multiSelect {
+		return nil, errors.New("multiple selections require a multi-select list box")
+	}
+
+	field := model.NewPdfField()
+	listboxf := &model.PdfFieldChoice{}
+	field.SetContext(listboxf)
+	listboxf.PdfField = field
+
+	listboxf.T = core.MakeString(name)
+	listboxf.Opt = core.MakeArray()
+	for _, opt := range options {
+		listboxf.Opt.Append(core.MakeString(opt))
+	}
+	if multiSelect {
+		listboxf.SetFlag(model.FieldFlagMultiSelect)
+	}
+
+	// Selected values (V) and the corresponding option indices (I).
+	var indices []int
+	values := core.MakeArray()
+	for _, sel := range selected {
+		for i, opt := range options {
+			if opt == sel {
+				indices = append(indices, i)
+			}
+		}
+		values.Append(core.MakeString(sel))
+	}
+	if len(indices) != len(selected) {
+		return nil, errors.New("selected value is not an option")
+	}
+	switch len(selected) {
+	case 0:
+	case 1:
+		listboxf.V = values.Get(0)
+		listboxf.I = core.MakeArrayFromIntegers(indices)
+	default:
+		listboxf.V = values
+		listboxf.I = core.MakeArrayFromIntegers(indices)
+	}
+
+	widget := model.NewPdfAnnotationWidget()
+	widget.Rect = core.MakeArrayFromFloats(rect)
+	widget.P = page.ToPdfObject()
+	widget.F = core.MakeInteger(4)
+	widget.Parent = listboxf.ToPdfObject()
+
+	w, h := rectSize(rect)
+	xform, err := listBoxAppearance(w, h, ff, 10, options, indices)
+	if err != nil {
+		return nil, err
+	}
+
+	appearance := core.MakeDict()
+	appearance.Set("N", xform.ToPdfObject())
+	widget.AP = appearance
+
+	listboxf.Annotations = append(listboxf.Annotations, widget)
+	return listboxf, nil
+}
+
+// newPushButtonField creates a push button with partial name `name` at location `rect` showing `caption`
+// with font `ff`.
+func newPushButtonField(page *model.PdfPage, ff *formFont, name string, rect []float64, caption string) (*model.PdfFieldButton, error) {
+	field := model.NewPdfField()
+	buttonf := &model.PdfFieldButton{}
+	field.SetContext(buttonf)
+	buttonf.PdfField = field
+
+	buttonf.T = core.MakeString(name)
+	buttonf.SetType(model.ButtonTypePush)
+
+	widget := model.NewPdfAnnotationWidget()
+	widget.Rect = core.MakeArrayFromFloats(rect)
+	widget.P = page.ToPdfObject()
+	widget.F = core.MakeInteger(4)
+	widget.Parent = buttonf.ToPdfObject()
+
+	// Appearance characteristics: border color, background color and caption.
+	mk := core.MakeDict()
+	mk.Set("BC", core.MakeArrayFromFloats([]float64{0}))
+	mk.Set("BG", core.MakeArrayFromFloats([]float64{0.75}))
+	mk.Set("CA", core.MakeString(caption))
+	widget.MK = mk
+
+	w, h := rectSize(rect)
+	xform, err := pushButtonAppearance(w, h, ff, 0, caption)
+	if err != nil {
+		return nil, err
+	}
+
+	appearance := core.MakeDict()
+	appearance.Set("N", xform.ToPdfObject())
+	widget.AP = appearance
+
+	buttonf.Annotations = append(buttonf.Annotations, widget)
+	return buttonf, nil
+}
+
+// generateAppearance generates the appearance streams for the widget annotations of the text or combobox
+// field `field` of `form` based on the field value. The library does not generate appearances for
+// password fields, which get an empty field appearance as their value is never shown.
+func generateAppearance(form *model.PdfAcroForm, field *model.PdfField) error {
+	fieldAppearance := annotator.FieldAppearance{}
+	style := fieldAppearance.Style()
+	style.BorderSize = 0.5
+	fieldAppearance.SetStyle(style)
+
+	for _, wa := range field.Annotations {
+		apDict, err := fieldAppearance.GenerateAppearanceDict(form, field, wa)
+		if err != nil {
+			return err
+		}
+		if apDict == nil {
+			arr, ok := core.GetArray(wa.Rect)
+			if !ok {
+				return errors.New("invalid widget rect")
+			}
+			rect, err := arr.ToFloat64Array()
+			if err != nil || len(rect) != 4 {
+				return errors.New("invalid widget rect")
+			}
+			w, h := rectSize(rect)
+			apDict = core.MakeDict()
+			apDict.Set("N", emptyTextAppearance(w, h, style.BorderSize).ToPdfObject())
+		}
+		wa.AP = apDict
+	}
+	return nil
+}
+
+// emptyTextAppearance returns the appearance of an empty text field with size `w` x `h` and a border of
+// width `borderSize`.
+func emptyTextAppearance(w, h, borderSize float64) *model.XObjectForm {
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(1).Add_G(0).Add_w(borderSize)
+	cc.Add_re(borderSize/2, borderSize/2, w-borderSize, h-borderSize)
+	cc.Add_B()
+	cc.Add_Q()
+	cc.Add_BMC("Tx")
+	cc.Add_EMC()
+
+	xform := model.NewXObjectForm()
+	xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	return xform
+}
+
+// formFont is a font registered in the form default resources (DR).
+type formFont struct {
+	name core.PdfObjectName
+	font *model.PdfFont
+}
+
+// radioAppearance returns the appearance of a radio button with size `w` x `h`. The selected state has a
+// filled dot in the center.
+func radioAppearance(w, h float64, selected bool) *model.XObjectForm {
+	r := math.Min(w, h) / 2
+	cx, cy := w/2, h/2
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(1).Add_G(0).Add_w(1)
+	drawCircle(cc, cx, cy, r-0.5)
+	cc.Add_B()
+	if selected {
+		cc.Add_g(0)
+		drawCircle(cc, cx, cy, r/2)
+		cc.Add_f()
+	}
+	cc.Add_Q()
+
+	xform := model.NewXObjectForm()
+	xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	return xform
+}
+
+// drawCircle adds a circle path with center (`cx`, `cy`) and radius `r` approximated with Bezier curves.
+func drawCircle(cc *contentstream.ContentCreator, cx, cy, r float64) {
+	// Control point distance for approximating a quarter circle.
+	k := 0.5523 * r
+	cc.Add_m(cx+r, cy)
+	cc.Add_c(cx+r, cy+k, cx+k, cy+r, cx, cy+r)
+	cc.Add_c(cx-k, cy+r, cx-r, cy+k, cx-r, cy)
+	cc.Add_c(cx-r, cy-k, cx-k, cy-r, cx, cy-r)
+	cc.Add_c(cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+	cc.Add_h()
+}
+
+// pushButtonAppearance returns the appearance of a push button with size `w` x `h` showing `caption`
+// centered using font `ff`. The caption is auto sized if `fontSize` is 0.
+func pushButtonAppearance(w, h float64, ff *formFont, fontSize float64, caption string) (*model.XObjectForm, error) {
+	unitWidth := textWidth(ff.font, caption, 1)
+	if fontSize <= 0 {
+		fontSize = 0.6 * h
+		if unitWidth > 0 && unitWidth*fontSize > 0.9*w {
+			fontSize = 0.9 * w / unitWidth
+		}
+	}
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(0.75).Add_G(0).Add_w(1)
+	cc.Add_re(0.5, 0.5, w-1, h-1)
+	cc.Add_B()
+	cc.Add_Q()
+
+	cc.Add_q()
+	cc.Add_BT()
+	cc.Add_g(0)
+	cc.Add_Tf(ff.name, fontSize)
+	cc.Add_Td((w-unitWidth*fontSize)/2, (h-0.7*fontSize)/2)
+	cc.Add_Tj(*core.MakeStringFromBytes(ff.font.Encoder().Encode(caption)))
+	cc.Add_ET()
+	cc.Add_Q()
+
+	xform := model.NewXObjectForm()
+	xform.Resources = model.NewPdfPageResources()
+	xform.Resources.SetFontByName(ff.name, ff.font.ToPdfObject())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	err := xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	return xform, err
+}
+
+// listBoxAppearance returns the appearance of a list box with size `w` x `h` listing `options`, where the
+// options with indices in `selected` are highlighted.
+func listBoxAppearance(w, h float64, ff *formFont, fontSize float64, options []string, selected []int) (*model.XObjectForm, error) {
+	if fontSize <= 0 {
+		fontSize = 10
+	}
+	lineHeight := 1.15 * fontSize
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(1).Add_G(0).Add_w(0.5)
+	cc.Add_re(0.25, 0.25, w-0.5, h-0.5)
+	cc.Add_B()
+	cc.Add_Q()
+
+	cc.Add_BMC("Tx")
+	cc.Add_q()
+	cc.Add_re(1, 1, w-2, h-2).Add_W().Add_n()
+	for i, opt := range options {
+		y := h - 1 - float64(i+1)*lineHeight
+		if y+lineHeight < 0 {
+			break
+		}
+		isSelected := false
+		for _, idx := range selected {
+			if idx == i {
+				isSelected = true
+			}
+		}
+		if isSelected {
+			// Selection highlight as used by common viewers.
+			cc.Add_rg(0.6, 0.75, 0.85)
+			cc.Add_re(1, y, w-2, lineHeight).Add_f()
+		}
+
+		cc.Add_BT()
+		cc.Add_g(0)
+		cc.Add_Tf(ff.name, fontSize)
+		cc.Add_Td(2, y+0.25*fontSize)
+		cc.Add_Tj(*core.MakeStringFromBytes(ff.font.Encoder().Encode(opt)))
+		cc.Add_ET()
+	}
+	cc.Add_Q()
+	cc.Add_EMC()
+
+	xform := model.NewXObjectForm()
+	xform.Resources = model.NewPdfPageResources()
+	xform.Resources.SetFontByName(ff.name, ff.font.ToPdfObject())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	err := xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	return xform, err
+}
+
+// textWidth returns the width of `text` drawn with `font` at `fontSize`.
+func textWidth(font *model.PdfFont, text string, fontSize float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		metrics, has := font.GetRuneMetrics(r)
+		if !has {
+			continue
+		}
+		width += metrics.Wx
+	}
+	return width * fontSize / 1000.0
+}
+
+// rectSize returns the width and height of `rect` ([llx lly urx ury]).
+func rectSize(rect []float64) (float64, float64) {
+	return math.Abs(rect[2] - rect[0]), math.Abs(rect[3] - rect[1])
+}
@@ -0,0 +1,787 @@
+/*
+ * Add form fields to an existing PDF from a JSON or YAML form definition file.
+ * The definition lists the fields with their type, page, location and options, so forms can be created
+ * without changing any code. Supported field types are: text, checkbox, radio, combobox, listbox and
+ * pushbutton.
+ *
+ * Example definition (see form_definition.json for a complete example matching template1.pdf):
+ * {
+ *   "fields": [
+ *     {"name": "full_name", "type": "text", "page": 1, "rect": [123.97, 619.02, 343.99, 633.6],
+ *      "font": "Helvetica", "fontSize": 10, "tooltip": "Your full name", "required": true},
+ *     {"name": "gender", "type": "radio", "page": 1, "default": "male",
+ *      "buttons": [{"value": "male", "rect": [113.7, 525.57, 125.96, 540.15]},
+ *                  {"value": "female", "rect": [157.44, 525.24, 169.7, 539.82]}]}
+ *   ]
+ * }
+ *
+ * YAML definitions (.yaml or .yml files) use the same entries:
+ * fields:
+ *   - name: full_name
+ *     type: text
+ *     rect: [123.97, 619.02, 343.99, 633.6]
+ *     required: true
+ *
+ * The "font" entry can be the name of a standard 14 font (e.g. Helvetica, Times-Roman, Courier) or a path
+ * to a TrueType font file (.ttf). A font size of 0 (or unset) means the text is auto sized to the field.
+ *
+ * Run as: go run pdf_form_add_definition.go input.pdf definition.json|definition.yaml output.pdf
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/contentstream"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+	"gopkg.in/yaml.v2"
+)
+
+func main() {
+	if len(os.Args) < 4 {
+		fmt.Printf("Add form fields to a PDF from a JSON or YAML form definition\n")
+		fmt.Printf("Usage: go run pdf_form_add_definition.go input.pdf definition.json|definition.yaml output.pdf\n")
+		os.Exit(1)
+	}
+
+	// Enable debug-level logging.
+	common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
+
+	inputPath := os.Args[1]
+	defPath := os.Args[2]
+	outputPath := os.Args[3]
+
+	def, err := loadFormDefinition(defPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = addFormFromDefinition(inputPath, def, outputPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Success, output written to %s\n", outputPath)
+}
+
+// formDefinition is the top level structure of the form definition file.
+type formDefinition struct {
+	Fields []fieldDefinition `json:"fields" yaml:"fields"`
+}
+
+// fieldDefinition describes a single form field.
+type fieldDefinition struct {
+	Name     string    `json:"name" yaml:"name"`
+	Type     string    `json:"type" yaml:"type"` // text, checkbox, radio, combobox, listbox or pushbutton.
+	Page     int       `json:"page" yaml:"page"` // 1-based page number, defaults to 1.
+	Rect     []float64 `json:"rect" yaml:"rect"` // [llx lly urx ury]. Not used for radio groups (see Buttons).
+	Font     string    `json:"font" yaml:"font"`
+	FontSize float64   `json:"fontSize" yaml:"fontSize"`
+	Default  string    `json:"default" yaml:"default"`
+	Tooltip  string    `json:"tooltip" yaml:"tooltip"`
+	Required bool      `json:"required" yaml:"required"`
+	ReadOnly bool      `json:"readOnly" yaml:"readOnly"`
+
+	// Text field options.
+	MaxLen    int  `json:"maxLen" yaml:"maxLen"`
+	Multiline bool `json:"multiline" yaml:"multiline"`
+
+	// Choice field (combobox and listbox) options.
+	Options     []string `json:"options" yaml:"options"`
+	MultiSelect bool     `json:"multiSelect" yaml:"multiSelect"`
+	Selected    []string `json:"selected" yaml:"selected"` // Initial selection of multi-select list boxes.
+
+	// Radio group buttons. Each button has its own export value and location.
+	Buttons []radioButtonDefinition `json:"buttons" yaml:"buttons"`
+
+	// Push button caption.
+	Caption string `json:"caption" yaml:"caption"`
+}
+
+// radioButtonDefinition describes a single button of a radio button group.
+type radioButtonDefinition struct {
+	Value string    `json:"value" yaml:"value"`
+	Page  int       `json:"page" yaml:"page"` // Defaults to the page of the group.
+	Rect  []float64 `json:"rect" yaml:"rect"`
+}
+
+// loadFormDefinition loads and validates the JSON or YAML (.yaml or .yml) form definition file in `defPath`.
+func loadFormDefinition(defPath string) (*formDefinition, error) {
+	data, err := ioutil.ReadFile(defPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var def formDefinition
+	switch strings.ToLower(filepath.Ext(defPath)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &def)
+	default:
+		err = json.Unmarshal(data, &def)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for i, fdef := range def.Fields {
+		if fdef.Name == "" {
+			return nil, fmt.Errorf("field %d: name not specified", i+1)
+		}
+		if names[fdef.Name] {
+			return nil, fmt.Errorf("field %s: defined more than once", fdef.Name)
+		}
+		names[fdef.Name] = true
+
+		if fdef.Type == "radio" {
+			if len(fdef.Buttons) == 0 {
+				return nil, fmt.Errorf("field %s: radio group without buttons", fdef.Name)
+			}
+			for _, bdef := range fdef.Buttons {
+				if bdef.Value == "" || len(bdef.Rect) != 4 {
+					return nil, fmt.Errorf("field %s: radio buttons require a value and a rect", fdef.Name)
+				}
+			}
+			continue
+		}
+		if len(fdef.Rect) != 4 {
+			return nil, fmt.Errorf("field %s: rect must have 4 values", fdef.Name)
+		}
+	}
+
+	return &def, nil
+}
+
+// addFormFromDefinition adds the fields in `def` to the PDF in `inputPath` and writes the output to
+// `outputPath`. Fields already present in the input PDF are kept.
+func addFormFromDefinition(inputPath string, def *formDefinition, outputPath string) error {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pdfReader, err := model.NewPdfReader(f)
+	if err != nil {
+		return err
+	}
+
+	form := pdfReader.AcroForm
+	if form == nil {
+		form = model.NewPdfAcroForm()
+	}
+	if form.Fields == nil {
+		form.Fields = &[]*model.PdfField{}
+	}
+	if form.DR == nil {
+		form.DR = model.NewPdfPageResources()
+	}
+
+	// ZapfDingbats is used by the checkbox appearances.
+	zapfdb := model.NewStandard14FontMustCompile(model.ZapfDingbatsName)
+	form.DR.SetFontByName("ZaDb", zapfdb.ToPdfObject())
+
+	err = checkNameCollisions(form, def)
+	if err != nil {
+		return err
+	}
+
+	builder := &formBuilder{form: form, fonts: map[string]*formFont{}}
+	for _, fdef := range def.Fields {
+		err := builder.addField(pdfReader, fdef)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", fdef.Name, err)
+		}
+	}
+
+	pdfWriter := model.NewPdfWriter()
+	for _, page := range pdfReader.PageList {
+		err := pdfWriter.AddPage(page)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = pdfWriter.SetForms(form)
+	if err != nil {
+		return err
+	}
+
+	fout, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	return pdfWriter.Write(fout)
+}
+
+// checkNameCollisions returns an error if a field in `def` has the same name as a field already in
+// `form`. The new fields are added at the top level, so a collision would create duplicate /T entries.
+func checkNameCollisions(form *model.PdfAcroForm, def *formDefinition) error {
+	existing := map[string]bool{}
+	for _, field := range *form.Fields {
+		existing[field.PartialName()] = true
+	}
+	for _, field := range form.AllFields() {
+		name, err := field.FullName()
+		if err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+
+	for _, fdef := range def.Fields {
+		if existing[fdef.Name] {
+			return fmt.Errorf("field %s: already exists in the input PDF", fdef.Name)
+		}
+	}
+	return nil
+}
+
+// formFont is a font registered in the form default resources (DR).
+type formFont struct {
+	name core.PdfObjectName
+	font *model.PdfFont
+}
+
+// formBuilder adds fields to `form` and keeps track of the fonts registered in the form resources.
+type formBuilder struct {
+	form  *model.PdfAcroForm
+	fonts map[string]*formFont
+}
+
+// standardFontNames maps standard 14 fonts to the resource names commonly used for them in forms.
+var standardFontNames = map[string]string{
+	"Helvetica":    "Helv",
+	"Times-Roman":  "TiRo",
+	"Courier":      "Cour",
+	"ZapfDingbats": "ZaDb",
+}
+
+// getFont returns the form font for `fontSpec` (standard 14 font name or TrueType file path), registering
+// it in the form resources if not already loaded. Defaults to Helvetica if `fontSpec` is empty.
+func (b *formBuilder) getFont(fontSpec string) (*formFont, error) {
+	if fontSpec == "" {
+		fontSpec = "Helvetica"
+	}
+	if ff, has := b.fonts[fontSpec]; has {
+		return ff, nil
+	}
+
+	var font *model.PdfFont
+	var err error
+	name := standardFontNames[fontSpec]
+	if strings.EqualFold(filepath.Ext(fontSpec), ".ttf") {
+		font, err = model.NewPdfFontFromTTFFile(fontSpec)
+	} else {
+		font, err = model.NewStandard14Font(model.StdFontName(fontSpec))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("F%d", len(b.fonts)+1)
+		for b.form.DR.HasFontByName(core.PdfObjectName(name)) {
+			name += "_"
+		}
+	}
+
+	ff := &formFont{name: core.PdfObjectName(name), font: font}
+	b.form.DR.SetFontByName(ff.name, font.ToPdfObject())
+	b.fonts[fontSpec] = ff
+	return ff, nil
+}
+
+// defaultAppearance returns the default appearance (DA) string for `ff` with `fontSize` (0 for auto).
+func (ff *formFont) defaultAppearance(fontSize float64) string {
+	return fmt.Sprintf("/%s %g Tf 0 g", ff.name, fontSize)
+}
+
+// addField creates the field defined by `fdef` and adds it to the form and its widget annotations to the
+// corresponding pages.
+func (b *formBuilder) addField(pdfReader *model.PdfReader, fdef fieldDefinition) error {
+	page, err := getPage(pdfReader, fdef.Page)
+	if err != nil {
+		return err
+	}
+
+	var field *model.PdfField
+	switch fdef.Type {
+	case "text", "":
+		field, err = b.newTextField(page, fdef)
+	case "checkbox":
+		field, err = b.newCheckboxField(page, fdef)
+	case "radio":
+		field, err = b.newRadioGroup(pdfReader, page, fdef)
+	case "combobox", "listbox":
+		field, err = b.newChoiceField(page, fdef)
+	case "pushbutton":
+		field, err = b.newPushButton(page, fdef)
+	default:
+		err = fmt.Errorf("unsupported field type: %s", fdef.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	if fdef.Tooltip != "" {
+		field.TU = core.MakeEncodedString(fdef.Tooltip, true)
+	}
+	if fdef.Required {
+		field.SetFlag(field.Flags().Set(model.FieldFlagRequired))
+	}
+	if fdef.ReadOnly {
+		field.SetFlag(field.Flags().Set(model.FieldFlagReadOnly))
+	}
+
+	*b.form.Fields = append(*b.form.Fields, field)
+	return nil
+}
+
+// getPage returns page number `pageNum` (1-based) of the document loaded in `pdfReader`.
+// A page number of 0 refers to the first page.
+func getPage(pdfReader *model.PdfReader, pageNum int) (*model.PdfPage, error) {
+	if pageNum == 0 {
+		pageNum = 1
+	}
+	if pageNum < 1 || pageNum > len(pdfReader.PageList) {
+		return nil, fmt.Errorf("page %d out of range (1-%d)", pageNum, len(pdfReader.PageList))
+	}
+	return pdfReader.PageList[pageNum-1], nil
+}
+
+// newTextField creates a text field and generates its appearance based on the default value.
+func (b *formBuilder) newTextField(page *model.PdfPage, fdef fieldDefinition) (*model.PdfField, error) {
+	ff, err := b.getFont(fdef.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := annotator.TextFieldOptions{MaxLen: fdef.MaxLen, Value: fdef.Default}
+	textf, err := annotator.NewTextField(page, fdef.Name, fdef.Rect, opt)
+	if err != nil {
+		return nil, err
+	}
+	textf.DA = core.MakeString(ff.defaultAppearance(fdef.FontSize))
+	if fdef.Default != "" {
+		textf.V = core.MakeEncodedString(fdef.Default, true)
+	}
+	if fdef.Multiline {
+		textf.SetFlag(textf.Flags().Set(model.FieldFlagMultiline))
+	}
+
+	err = b.generateAppearance(textf.PdfField)
+	if err != nil {
+		return nil, err
+	}
+
+	page.AddAnnotation(textf.Annotations[0].PdfAnnotation)
+	return textf.PdfField, nil
+}
+
+// newCheckboxField creates a checkbox field, checked if the default value is "Yes" (or "true").
+func (b *formBuilder) newCheckboxField(page *model.PdfPage, fdef fieldDefinition) (*model.PdfField, error) {
+	checked := fdef.Default == "Yes" || fdef.Default == "true"
+	opt := annotator.CheckboxFieldOptions{Checked: checked}
+	checkboxf, err := annotator.NewCheckboxField(page, fdef.Name, fdef.Rect, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	page.AddAnnotation(checkboxf.Annotations[0].PdfAnnotation)
+	return checkboxf.PdfField, nil
+}
+
+// newChoiceField creates a combobox or a list box field with the options in `fdef`.
+func (b *formBuilder) newChoiceField(page *model.PdfPage, fdef fieldDefinition) (*model.PdfField, error) {
+	ff, err := b.getFont(fdef.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	opt := annotator.ComboboxFieldOptions{Choices: fdef.Options}
+	chf, err := annotator.NewComboboxField(page, fdef.Name, fdef.Rect, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	// The DA entry is inherited by the widget annotation which is merged into the field dictionary.
+	da := core.MakeString(ff.defaultAppearance(fdef.FontSize))
+	wa := chf.Annotations[0]
+
+	if fdef.Type == "combobox" {
+		if fdef.Default != "" {
+			if indexOf(fdef.Options, fdef.Default) < 0 {
+				return nil, fmt.Errorf("default value not an option: %s", fdef.Default)
+			}
+			chf.V = core.MakeEncodedString(fdef.Default, true)
+		}
+
+		// Combobox appearances are generated with the form DA, use the field DA while generating.
+		formDA := b.form.DA
+		b.form.DA = da
+		err = b.generateAppearance(chf.PdfField)
+		b.form.DA = formDA
+		if err != nil {
+			return nil, err
+		}
+		setDA(chf.PdfField, da)
+		page.AddAnnotation(wa.PdfAnnotation)
+		return chf.PdfField, nil
+	}
+
+	// List box: clear the combo flag set by NewComboboxField.
+	chf.Ff = core.MakeInteger(int64(chf.Flags().Clear(model.FieldFlagCombo)))
+	selected := fdef.Selected
+	if len(selected) == 0 && fdef.Default != "" {
+		selected = []string{fdef.Default}
+	}
+	if fdef.MultiSelect {
+		chf.SetFlag(chf.Flags().Set(model.FieldFlagMultiSelect))
+	} else if len(selected) > 1 {
+		return nil, errors.New("multiple selections in a single-select list box")
+	}
+
+	var indices []int
+	for _, sel := range selected {
+		idx := indexOf(fdef.Options, sel)
+		if idx < 0 {
+			return nil, fmt.Errorf("selected value not an option: %s", sel)
+		}
+		indices = append(indices, idx)
+	}
+	switch len(selected) {
+	case 0:
+	case 1:
+		chf.V = core.MakeEncodedString(selected[0], true)
+	default:
+		values := core.MakeArray()
+		for _, sel := range selected {
+			values.Append(core.MakeEncodedString(sel, true))
+		}
+		chf.V = values
+	}
+	if len(indices) > 0 {
+		chf.I = core.MakeArrayFromIntegers(indices)
+	}
+
+	w, h := rectSize(fdef.Rect)
+	xform, err := listBoxAppearance(w, h, ff, fdef.FontSize, fdef.Options, indices)
+	if err != nil {
+		return nil, err
+	}
+	apDict := core.MakeDict()
+	apDict.Set("N", xform.ToPdfObject())
+	wa.AP = apDict
+
+	setDA(chf.PdfField, da)
+	page.AddAnnotation(wa.PdfAnnotation)
+	return chf.PdfField, nil
+}
+
+// setDA sets the default appearance string `da` on the field dictionary of `field`. The model only has
+// a DA entry for text fields, so for other field types it is set directly on the field dictionary.
+func setDA(field *model.PdfField, da *core.PdfObjectString) {
+	if container, ok := core.GetIndirect(field.GetContext().ToPdfObject()); ok {
+		if d, ok := core.GetDict(container.PdfObject); ok {
+			d.Set("DA", da)
+		}
+	}
+}
+
+// newRadioGroup creates a radio button group with one widget annotation per button. The buttons are
+// mutually exclusive: selecting one deselects the others.
+func (b *formBuilder) newRadioGroup(pdfReader *model.PdfReader, page *model.PdfPage, fdef fieldDefinition) (*model.PdfField, error) {
+	field := model.NewPdfField()
+	btnf := &model.PdfFieldButton{PdfField: field}
+	field.SetContext(btnf)
+	btnf.T = core.MakeString(fdef.Name)
+	btnf.SetType(model.ButtonTypeRadio)
+	btnf.SetFlag(btnf.Flags().Set(model.FieldFlagNoToggleToOff))
+
+	state := "Off"
+	if fdef.Default != "" {
+		state = fdef.Default
+	}
+	btnf.V = core.MakeName(state)
+
+	found := state == "Off"
+	for _, bdef := range fdef.Buttons {
+		bpage := page
+		if bdef.Page != 0 {
+			var err error
+			bpage, err = getPage(pdfReader, bdef.Page)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if bdef.Value == state {
+			found = true
+		}
+
+		widget := model.NewPdfAnnotationWidget()
+		widget.Rect = core.MakeArrayFromFloats(bdef.Rect)
+		widget.P = bpage.ToPdfObject()
+		widget.F = core.MakeInteger(4)
+		widget.Parent = btnf.ToPdfObject()
+
+		mk := core.MakeDict()
+		mk.Set("BC", core.MakeArrayFromFloats([]float64{0}))
+		mk.Set("CA", core.MakeString("l"))
+		widget.MK = mk
+
+		w, h := rectSize(bdef.Rect)
+		apDict := core.MakeDict()
+		n := core.MakeDict()
+		n.Set(core.PdfObjectName(bdef.Value), radioAppearance(w, h, true).ToPdfObject())
+		n.Set("Off", radioAppearance(w, h, false).ToPdfObject())
+		apDict.Set("N", n)
+		widget.AP = apDict
+
+		if bdef.Value == state {
+			widget.AS = core.MakeName(bdef.Value)
+		} else {
+			widget.AS = core.MakeName("Off")
+		}
+
+		btnf.Annotations = append(btnf.Annotations, widget)
+		bpage.AddAnnotation(widget.PdfAnnotation)
+	}
+	if !found {
+		return nil, fmt.Errorf("default value not a button value: %s", state)
+	}
+
+	return field, nil
+}
+
+// newPushButton creates a push button field with a caption.
+func (b *formBuilder) newPushButton(page *model.PdfPage, fdef fieldDefinition) (*model.PdfField, error) {
+	ff, err := b.getFont(fdef.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	field := model.NewPdfField()
+	btnf := &model.PdfFieldButton{PdfField: field}
+	field.SetContext(btnf)
+	btnf.T = core.MakeString(fdef.Name)
+	btnf.SetType(model.ButtonTypePush)
+
+	caption := fdef.Caption
+	if caption == "" {
+		caption = fdef.Name
+	}
+
+	widget := model.NewPdfAnnotationWidget()
+	widget.Rect = core.MakeArrayFromFloats(fdef.Rect)
+	widget.P = page.ToPdfObject()
+	widget.F = core.MakeInteger(4)
+	widget.Parent = btnf.ToPdfObject()
+
+	mk := core.MakeDict()
+	mk.Set("BC", core.MakeArrayFromFloats([]float64{0}))
+	mk.Set("BG", core.MakeArrayFromFloats([]float64{0.75}))
+	mk.Set("CA", core.MakeEncodedString(caption, true))
+	widget.MK = mk
+
+	w, h := rectSize(fdef.Rect)
+	xform, err := pushButtonAppearance(w, h, ff, fdef.FontSize, caption)
+	if err != nil {
+		return nil, err
+	}
+	apDict := core.MakeDict()
+	apDict.Set("N", xform.ToPdfObject())
+	widget.AP = apDict
+
+	btnf.Annotations = append(btnf.Annotations, widget)
+	setDA(field, core.MakeString(ff.defaultAppearance(fdef.FontSize)))
+	page.AddAnnotation(widget.PdfAnnotation)
+	return field, nil
+}
+
+// generateAppearance generates the appearance streams for the widget annotations of `field` based on
+// the field value.
+func (b *formBuilder) generateAppearance(field *model.PdfField) error {
+	fieldAppearance := annotator.FieldAppearance{}
+	style := fieldAppearance.Style()
+	style.BorderSize = 0.5
+	fieldAppearance.SetStyle(style)
+
+	for _, wa := range field.Annotations {
+		apDict, err := fieldAppearance.GenerateAppearanceDict(b.form, field, wa)
+		if err != nil {
+			return err
+		}
+		if apDict != nil {
+			wa.AP = apDict
+		}
+	}
+	return nil
+}
+
+// radioAppearance returns the appearance of a radio button with size `w` x `h`. The selected state has a
+// filled dot in the center.
+func radioAppearance(w, h float64, selected bool) *model.XObjectForm {
+	r := math.Min(w, h) / 2
+	cx, cy := w/2, h/2
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(1).Add_G(0).Add_w(1)
+	drawCircle(cc, cx, cy, r-0.5)
+	cc.Add_B()
+	if selected {
+		cc.Add_g(0)
+		drawCircle(cc, cx, cy, r/2)
+		cc.Add_f()
+	}
+	cc.Add_Q()
+
+	xform := model.NewXObjectForm()
+	xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	return xform
+}
+
+// drawCircle adds a circle path with center (`cx`, `cy`) and radius `r` approximated with Bezier curves.
+func drawCircle(cc *contentstream.ContentCreator, cx, cy, r float64) {
+	// Control point distance for approximating a quarter circle.
+	k := 0.5523 * r
+	cc.Add_m(cx+r, cy)
+	cc.Add_c(cx+r, cy+k, cx+k, cy+r, cx, cy+r)
+	cc.Add_c(cx-k, cy+r, cx-r, cy+k, cx-r, cy)
+	cc.Add_c(cx-r, cy-k, cx-k, cy-r, cx, cy-r)
+	cc.Add_c(cx+k, cy-r, cx+r, cy-k, cx+r, cy)
+	cc.Add_h()
+}
+
+// pushButtonAppearance returns the appearance of a push button with size `w` x `h` showing `caption`
+// centered using font `ff`. The caption is auto sized if `fontSize` is 0.
+func pushButtonAppearance(w, h float64, ff *formFont, fontSize float64, caption string) (*model.XObjectForm, error) {
+	unitWidth := textWidth(ff.font, caption, 1)
+	if fontSize <= 0 {
+		fontSize = 0.6 * h
+		if unitWidth > 0 && unitWidth*fontSize > 0.9*w {
+			fontSize = 0.9 * w / unitWidth
+		}
+	}
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(0.75).Add_G(0).Add_w(1)
+	cc.Add_re(0.5, 0.5, w-1, h-1)
+	cc.Add_B()
+	cc.Add_Q()
+
+	cc.Add_q()
+	cc.Add_BT()
+	cc.Add_g(0)
+	cc.Add_Tf(ff.name, fontSize)
+	cc.Add_Td((w-unitWidth*fontSize)/2, (h-0.7*fontSize)/2)
+	cc.Add_Tj(*core.MakeStringFromBytes(ff.font.Encoder().Encode(caption)))
+	cc.Add_ET()
+	cc.Add_Q()
+
+	xform := model.NewXObjectForm()
+	xform.Resources = model.NewPdfPageResources()
+	xform.Resources.SetFontByName(ff.name, ff.font.ToPdfObject())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	err := xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	return xform, err
+}
+
+// listBoxAppearance returns the appearance of a list box with size `w` x `h` listing `options`, where the
+// options with indices in `selected` are highlighted.
+func listBoxAppearance(w, h float64, ff *formFont, fontSize float64, options []string, selected []int) (*model.XObjectForm, error) {
+	if fontSize <= 0 {
+		fontSize = 10
+	}
+	lineHeight := 1.15 * fontSize
+
+	cc := contentstream.NewContentCreator()
+	cc.Add_q()
+	cc.Add_g(1).Add_G(0).Add_w(0.5)
+	cc.Add_re(0.25, 0.25, w-0.5, h-0.5)
+	cc.Add_B()
+	cc.Add_Q()
+
+	cc.Add_BMC("Tx")
+	cc.Add_q()
+	cc.Add_re(1, 1, w-2, h-2).Add_W().Add_n()
+	for i, opt := range options {
+		y := h - 1 - float64(i+1)*lineHeight
+		if y+lineHeight < 0 {
+			break
+		}
+		isSelected := false
+		for _, idx := range selected {
+			if idx == i {
+				isSelected = true
+			}
+		}
+		if isSelected {
+			// Selection highlight as used by common viewers.
+			cc.Add_rg(0.6, 0.75, 0.85)
+			cc.Add_re(1, y, w-2, lineHeight).Add_f()
+		}
+
+		cc.Add_BT()
+		cc.Add_g(0)
+		cc.Add_Tf(ff.name, fontSize)
+		cc.Add_Td(2, y+0.25*fontSize)
+		cc.Add_Tj(*core.MakeStringFromBytes(ff.font.Encoder().Encode(opt)))
+		cc.Add_ET()
+	}
+	cc.Add_Q()
+	cc.Add_EMC()
+
+	xform := model.NewXObjectForm()
+	xform.Resources = model.NewPdfPageResources()
+	xform.Resources.SetFontByName(ff.name, ff.font.ToPdfObject())
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, w, h})
+	err := xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder())
+	return xform, err
+}
+
+// textWidth returns the width of `text` drawn with `font` at `fontSize`.
+func textWidth(font *model.PdfFont, text string, fontSize float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		metrics, has := font.GetRuneMetrics(r)
+		if !has {
+			continue
+		}
+		width += metrics.Wx
+	}
+	return width * fontSize / 1000.0
+}
+
+// rectSize returns the width and height of `rect` ([llx lly urx ury]).
+func rectSize(rect []float64) (float64, float64) {
+	return math.Abs(rect[2] - rect[0]), math.Abs(rect[3] - rect[1])
+}
+
+// indexOf returns the index of `val` in `list` or -1 if not found.
+func indexOf(list []string, val string) int {
+	for i, s := range list {
+		if s == val {
+			return i
+		}
+	}
+	return -1
+}
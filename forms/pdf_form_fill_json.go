@@ -1,30 +1,58 @@
 /*
  * Fill PDF form via JSON input data and flatten the output PDF.
+ * By default all fields are flattened. Use -fields and -exclude (field names or glob patterns) to flatten only
+ * some of the fields and leave the others interactive. Selecting fields by type is supported by
+ * pdf_form_flatten.go.
+ * The appearance styles can be customized with a style configuration file (-style style.json) as described in
+ * pdf_form_flatten.go, including TrueType fonts for text fields so that non-Latin values render correctly.
+ * Simple calculations (AFSimple_Calculate) are computed without running JavaScript. Number, percent and date
+ * formats (AFNumber_Format, AFPercent_Format, AFDate_Format) are applied to the field appearances, the field
+ * values are kept unformatted.
 *
-* Run as: go run pdf_form_fill_json.go input.pdf fill.json [output.pdf].
+* Run as: go run pdf_form_fill_json.go [options] input.pdf fill.json [output.pdf].
 */
 
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/unidoc/unipdf/v3/common"
 	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/contentstream"
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/fjson"
 	"github.com/unidoc/unipdf/v3/model"
 )
 
 // Example of filling PDF formdata with a form.
 func main() {
-	if len(os.Args) < 2 {
+	var fields, exclude, stylePath string
+	flag.StringVar(&fields, "fields", "", "Comma separated list of field names or glob patterns to flatten")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated list of field names or glob patterns to keep interactive")
+	flag.StringVar(&stylePath, "style", "", "Appearance style configuration file (JSON)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
 		fmt.Printf("List and fill values in PDF form, flatten\n")
-		fmt.Printf("Usage: go run pdf_form_fill_json.go input.pdf fill.json [output.pdf]\n\n")
+		fmt.Printf("Usage: go run pdf_form_fill_json.go [options] input.pdf fill.json [output.pdf]\n\n")
 		fmt.Printf("To get a list of fields and values from a PDF file as JSON:\n")
 		fmt.Printf("  go run pdf_form_fill_json.go input.pdf > formdata.json\n\n")
 		fmt.Printf("To fill a PDF with form data from a JSON file:\n")
-		fmt.Printf("  go run pdf_form_fill_json.go input.pdf formdata.json output.pdf\n")
+		fmt.Printf("  go run pdf_form_fill_json.go input.pdf formdata.json output.pdf\n\n")
+		fmt.Printf("Options:\n")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
@@ -36,10 +64,10 @@ func main() {
 		filljsonPath string
 		outputPath   string
 	)
-	inputPath = os.Args[1]
-	if len(os.Args) > 3 {
-		filljsonPath = os.Args[2]
-		outputPath = os.Args[3]
+	inputPath = args[0]
+	if len(args) > 2 {
+		filljsonPath = args[1]
+		outputPath = args[2]
 	}
 
 	// Output path not specified: Export list of fields and data as JSON format.
@@ -62,7 +90,19 @@ func main() {
 		return
 	}
 
-	err := fillFields(inputPath, filljsonPath, outputPath)
+	selector, err := newFieldSelector(fields, exclude)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	styleConfig, err := loadStyleConfig(stylePath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = fillFields(inputPath, filljsonPath, outputPath, selector, styleConfig)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -72,8 +112,9 @@ func main() {
 }
 
 // fillFields loads field data from `jsonPath` and used to fill in form data in `inputPath` and outputs
-// as PDF in `outputPath`. The output PDF form is flattened.
-func fillFields(inputPath, jsonPath, outputPath string) error {
+// as PDF in `outputPath`. The fields matched by `selector` are flattened in the output PDF with the
+// appearance styles in `styleConfig` (optional).
+func fillFields(inputPath, jsonPath, outputPath string, selector *fieldSelector, styleConfig *styleConfig) error {
 	fdata, err := fjson.LoadFromJSONFile(jsonPath)
 	if err != nil {
 		return err
@@ -96,33 +137,35 @@ func fillFields(inputPath, jsonPath, outputPath string) error {
 		return err
 	}
 
+	// Compute calculated fields and get the formatted field values (number, percent, date).
+	formatted, err := calculateAndFormatFields(pdfReader.AcroForm)
+	if err != nil {
+		return err
+	}
+
 	// Flatten form.
 	fieldAppearance := annotator.FieldAppearance{OnlyIfMissing: true, RegenerateTextFields: true}
 
-	// NOTE: To customize certain styles try:
+	// Apply the appearance styles of the style configuration, if specified (-style).
+	// NOTE: Styles can also be customized in code, e.g.:
 	// style := fieldAppearance.Style()
-	// style.CheckmarkGlyph = "a22"
+	// style.CheckmarkRune = '✓'
 	// style.AutoFontSizeFraction = 0.70
 	// fieldAppearance.SetStyle(style)
-	//
-	// or for specifying a full set of appearance styles:
-	// fieldAppearance.SetStyle(annotator.AppearanceStyle{
-	//     CheckmarkGlyph:       "a22",
-	//     AutoFontSizeFraction: 0.70,
-	//     FillColor:            model.NewPdfColorDeviceGray(0.8),
-	//     BorderColor:          model.NewPdfColorDeviceRGB(1, 0, 0),
-	//     BorderSize:           2.0,
-	//     AllowMK:              false,
-	// })
-
-	err = pdfReader.FlattenFields(true, fieldAppearance)
+	appgen := formattedFieldAppearance{newFieldAppearanceGenerator(fieldAppearance, styleConfig), formatted}
+
+	form, err := flattenSelectedFields(pdfReader, selector, appgen)
+	if err != nil {
+		return err
+	}
+	err = updateAppearances(form, appgen)
 	if err != nil {
 		return err
 	}
 
 	// Write out.
 	pdfWriter := model.NewPdfWriter()
-	pdfWriter.SetForms(nil)
+	pdfWriter.SetForms(form)
 
 	for _, p := range pdfReader.PageList {
 		err := pdfWriter.AddPage(p)
@@ -140,3 +183,887 @@ func fillFields(inputPath, jsonPath, outputPath string) error {
 	err = pdfWriter.Write(fout)
 	return err
 }
+
+// fieldSelector selects form fields by full name or glob pattern.
+type fieldSelector struct {
+	patterns []string
+	exclude  []string
+}
+
+// newFieldSelector returns a selector for the comma separated `fields` and `exclude` patterns. An empty
+// selector matches all fields.
+func newFieldSelector(fields, exclude string) (*fieldSelector, error) {
+	selector := &fieldSelector{patterns: splitList(fields), exclude: splitList(exclude)}
+	for _, pattern := range append(selector.patterns, selector.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return selector, nil
+}
+
+// splitList splits a comma separated list into trimmed, non-empty elements.
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// isEmpty returns true if the selector matches all fields.
+func (s *fieldSelector) isEmpty() bool {
+	return s == nil || len(s.patterns) == 0 && len(s.exclude) == 0
+}
+
+// matches returns true if `field` is selected by `s`.
+func (s *fieldSelector) matches(field *model.PdfField) bool {
+	fullname, err := field.FullName()
+	if err != nil {
+		common.Log.Debug("Unable to get field name: %v", err)
+		return false
+	}
+	return (len(s.patterns) == 0 || matchesAny(s.patterns, fullname)) && !matchesAny(s.exclude, fullname)
+}
+
+// matchesAny returns true if `name` matches any of the glob `patterns`.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenSelectedFields flattens the terminal fields of the form in `pdfReader` matched by `selector`
+// using `appgen` for the field appearances. All annotations are flattened if all fields are selected.
+// Returns the form with the remaining (not flattened) fields, or nil if no fields remain.
+func flattenSelectedFields(pdfReader *model.PdfReader, selector *fieldSelector, appgen model.FieldAppearanceGenerator) (*model.PdfAcroForm, error) {
+	// Only loaded annotations are flattened, see pdf_form_flatten.go.
+	for _, page := range pdfReader.PageList {
+		if _, err := page.GetAnnotations(); err != nil {
+			return nil, err
+		}
+		page.Annots = nil
+	}
+
+	form := pdfReader.AcroForm
+	if form == nil || selector.isEmpty() {
+		return nil, pdfReader.FlattenFields(true, appgen)
+	}
+
+	flatten := map[*model.PdfField]bool{}
+	tmpForm := model.NewPdfAcroForm()
+	tmpForm.DR, tmpForm.DA, tmpForm.Q = form.DR, form.DA, form.Q
+	for _, field := range form.AllFields() {
+		if field.IsTerminal() && selector.matches(field) {
+			flatten[field] = true
+			*tmpForm.Fields = append(*tmpForm.Fields, field)
+		}
+	}
+
+	pdfReader.AcroForm = tmpForm
+	err := pdfReader.FlattenFields(false, appgen)
+	pdfReader.AcroForm = form
+	if err != nil {
+		return nil, err
+	}
+
+	*form.Fields = removeFields(*form.Fields, flatten)
+	if len(*form.Fields) == 0 {
+		return nil, nil
+	}
+	return form, nil
+}
+
+// removeFields returns `fields` without the fields in `remove` and without the non-terminal fields whose
+// descendants were all removed.
+func removeFields(fields []*model.PdfField, remove map[*model.PdfField]bool) []*model.PdfField {
+	var kept []*model.PdfField
+	for _, field := range fields {
+		if remove[field] {
+			continue
+		}
+		if len(field.Kids) > 0 {
+			field.Kids = removeFields(field.Kids, remove)
+			if len(field.Kids) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, field)
+	}
+	return kept
+}
+
+// updateAppearances regenerates the widget appearances of the fields remaining in `form` after flattening
+// with `appgen`, so that the interactive fields show the filled values.
+func updateAppearances(form *model.PdfAcroForm, appgen model.FieldAppearanceGenerator) error {
+	if form == nil {
+		return nil
+	}
+	for _, field := range form.AllFields() {
+		for _, wa := range field.Annotations {
+			apDict, err := appgen.GenerateAppearanceDict(form, field, wa)
+			if err != nil {
+				return err
+			}
+			if apDict != nil {
+				wa.AP = apDict
+			}
+		}
+	}
+	return nil
+}
+
+// styleConfig is an appearance style configuration with a default style and per-field overrides, loaded
+// from a JSON file. The format is described in pdf_form_flatten.go.
+type styleConfig struct {
+	Default fieldStyle           `json:"default"`
+	Fields  []fieldStyleOverride `json:"fields"`
+}
+
+// fieldStyle contains field appearance style settings. Unset entries are not changed.
+type fieldStyle struct {
+	// Font is a TrueType font file used for text fields, e.g. for rendering non-Latin values.
+	Font                  string    `json:"font"`
+	FontSize              *float64  `json:"fontSize"` // 0 for auto sizing.
+	AutoFontSizeFraction  *float64  `json:"autoFontSizeFraction"`
+	Checkmark             string    `json:"checkmark"` // Character used for checked checkboxes.
+	BorderSize            *float64  `json:"borderSize"`
+	BorderColor           []float64 `json:"borderColor"`
+	FillColor             []float64 `json:"fillColor"`
+	MultilineLineHeight   *float64  `json:"multilineLineHeight"`
+	MultilineVAlignMiddle *bool     `json:"multilineVAlignMiddle"`
+	AllowMK               *bool     `json:"allowMK"`
+}
+
+// fieldStyleOverride is a field style applied to the fields matching Name (full name or glob pattern).
+type fieldStyleOverride struct {
+	Name string `json:"name"`
+	fieldStyle
+}
+
+// loadStyleConfig loads the style configuration from the JSON file in `stylePath`.
+// Returns nil if `stylePath` is empty.
+func loadStyleConfig(stylePath string) (*styleConfig, error) {
+	if stylePath == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(stylePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config styleConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	styles := []fieldStyle{config.Default}
+	for _, fs := range config.Fields {
+		if _, err := path.Match(fs.Name, ""); err != nil || fs.Name == "" {
+			return nil, fmt.Errorf("invalid style field name: '%s'", fs.Name)
+		}
+		styles = append(styles, fs.fieldStyle)
+	}
+	for _, fs := range styles {
+		if _, err := makeColor(fs.BorderColor); err != nil {
+			return nil, err
+		}
+		if _, err := makeColor(fs.FillColor); err != nil {
+			return nil, err
+		}
+		if len([]rune(fs.Checkmark)) > 1 {
+			return nil, fmt.Errorf("checkmark must be a single character: '%s'", fs.Checkmark)
+		}
+	}
+
+	return &config, nil
+}
+
+// fieldStyle returns the combined style settings for the field with `fullname`.
+func (config *styleConfig) fieldStyle(fullname string) []fieldStyle {
+	styles := []fieldStyle{config.Default}
+	for _, fs := range config.Fields {
+		if match, _ := path.Match(fs.Name, fullname); match {
+			styles = append(styles, fs.fieldStyle)
+		}
+	}
+	return styles
+}
+
+// isSet returns true if any of the settings of `fs` are set.
+func (fs fieldStyle) isSet() bool {
+	return fs.Font != "" || fs.FontSize != nil || fs.AutoFontSizeFraction != nil || fs.Checkmark != "" ||
+		fs.BorderSize != nil || len(fs.BorderColor) > 0 || len(fs.FillColor) > 0 ||
+		fs.MultilineLineHeight != nil || fs.MultilineVAlignMiddle != nil || fs.AllowMK != nil
+}
+
+// apply applies the settings of `fs` to appearance `style`.
+func (fs fieldStyle) apply(style *annotator.AppearanceStyle) {
+	if fs.AutoFontSizeFraction != nil {
+		style.AutoFontSizeFraction = *fs.AutoFontSizeFraction
+	}
+	if fs.Checkmark != "" {
+		style.CheckmarkRune = []rune(fs.Checkmark)[0]
+	}
+	if fs.BorderSize != nil {
+		style.BorderSize = *fs.BorderSize
+	}
+	if color, _ := makeColor(fs.BorderColor); color != nil {
+		style.BorderColor = color
+	}
+	if color, _ := makeColor(fs.FillColor); color != nil {
+		style.FillColor = color
+	}
+	if fs.MultilineLineHeight != nil {
+		style.MultilineLineHeight = *fs.MultilineLineHeight
+	}
+	if fs.MultilineVAlignMiddle != nil {
+		style.MultilineVAlignMiddle = *fs.MultilineVAlignMiddle
+	}
+	if fs.AllowMK != nil {
+		style.AllowMK = *fs.AllowMK
+	}
+}
+
+// makeColor returns a color from gray, RGB or CMYK `components`. Returns nil if no components are set.
+func makeColor(components []float64) (model.PdfColor, error) {
+	switch len(components) {
+	case 0:
+		return nil, nil
+	case 1:
+		return model.NewPdfColorDeviceGray(components[0]), nil
+	case 3:
+		return model.NewPdfColorDeviceRGB(components[0], components[1], components[2]), nil
+	case 4:
+		return model.NewPdfColorDeviceCMYK(components[0], components[1], components[2], components[3]), nil
+	}
+	return nil, fmt.Errorf("invalid number of color components: %d", len(components))
+}
+
+// styledFieldAppearance generates field appearances with the styles of a style configuration. Existing
+// appearances of fields with a configured style are regenerated.
+// Implements interface model.FieldAppearanceGenerator.
+type styledFieldAppearance struct {
+	annotator.FieldAppearance
+	config *styleConfig
+
+	// Form resource names of the loaded TrueType fonts by font file path.
+	fonts map[string]core.PdfObjectName
+}
+
+// newFieldAppearanceGenerator returns an appearance generator applying the styles in `config` to the
+// appearances generated by `fa`. Returns `fa` if `config` is nil.
+func newFieldAppearanceGenerator(fa annotator.FieldAppearance, config *styleConfig) model.FieldAppearanceGenerator {
+	if config == nil {
+		return fa
+	}
+	return &styledFieldAppearance{
+		FieldAppearance: fa,
+		config:          config,
+		fonts:           map[string]core.PdfObjectName{},
+	}
+}
+
+// GenerateAppearanceDict generates an appearance dictionary for widget annotation `wa` of `field` in `form`
+// using the configured style of the field.
+func (sa *styledFieldAppearance) GenerateAppearanceDict(form *model.PdfAcroForm, field *model.PdfField, wa *model.PdfAnnotationWidget) (*core.PdfObjectDictionary, error) {
+	fullname, err := field.FullName()
+	if err != nil {
+		return nil, err
+	}
+
+	fa := sa.FieldAppearance
+	style := fa.Style()
+	var font string
+	var fontSize *float64
+	for _, fs := range sa.config.fieldStyle(fullname) {
+		if fs.isSet() {
+			// Regenerate the existing appearance, otherwise the style has no effect.
+			fa.OnlyIfMissing = false
+		}
+		fs.apply(&style)
+		if fs.Font != "" {
+			font = fs.Font
+		}
+		if fs.FontSize != nil {
+			fontSize = fs.FontSize
+		}
+	}
+	fa.SetStyle(style)
+
+	if ftxt, ok := field.GetContext().(*model.PdfFieldText); ok && (font != "" || fontSize != nil) {
+		err := sa.setTextFieldFont(form, ftxt, font, fontSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fa.GenerateAppearanceDict(form, field, wa)
+}
+
+// setTextFieldFont updates the default appearance (DA) of text field `ftxt` to use the TrueType font in
+// `fontPath` (if set) with `fontSize` (if set). The font is added to the form resources (DR).
+func (sa *styledFieldAppearance) setTextFieldFont(form *model.PdfAcroForm, ftxt *model.PdfFieldText, fontPath string, fontSize *float64) error {
+	fontName := core.MakeName("Helv")
+	size := 0.0
+	var color []core.PdfObject
+
+	// Keep the settings of the current default appearance, if any.
+	daObj := getTextFieldDA(ftxt)
+	if daObj == nil && form.DA != nil {
+		daObj = form.DA
+	}
+	if da, ok := core.GetString(daObj); ok {
+		ops, err := contentstream.NewContentStreamParser(da.Str()).Parse()
+		if err != nil {
+			return err
+		}
+		for _, op := range *ops {
+			switch op.Operand {
+			case "Tf":
+				if len(op.Params) == 2 {
+					if name, ok := core.GetName(op.Params[0]); ok {
+						fontName = name
+					}
+					if num, err := core.GetNumberAsFloat(op.Params[1]); err == nil {
+						size = num
+					}
+				}
+			case "g", "rg", "k":
+				color = append(append([]core.PdfObject{}, op.Params...), core.MakeName(op.Operand))
+			}
+		}
+	}
+
+	if form.DR == nil {
+		form.DR = model.NewPdfPageResources()
+	}
+	if fontPath != "" {
+		name, has := sa.fonts[fontPath]
+		if !has {
+			font, err := loadCompositeFont(fontPath)
+			if err != nil {
+				return err
+			}
+			name = core.PdfObjectName(fmt.Sprintf("StyleFont%d", len(sa.fonts)+1))
+			form.DR.SetFontByName(name, font.ToPdfObject())
+			sa.fonts[fontPath] = name
+		}
+		fontName = &name
+	} else if !form.DR.HasFontByName(*fontName) {
+		helv := model.NewStandard14FontMustCompile(model.HelveticaName)
+		form.DR.SetFontByName(*fontName, helv.ToPdfObject())
+	}
+	if fontSize != nil {
+		size = *fontSize
+	}
+
+	da := fmt.Sprintf("/%s %g Tf", *fontName, size)
+	if len(color) > 0 {
+		var parts []string
+		for _, obj := range color {
+			parts = append(parts, obj.WriteString())
+		}
+		da += " " + strings.Join(parts, " ")
+	} else {
+		da += " 0 g"
+	}
+	ftxt.DA = core.MakeString(da)
+	return nil
+}
+
+// loadCompositeFont loads a composite (Type0) font from the TrueType font file in `fontPath`.
+func loadCompositeFont(fontPath string) (font *model.PdfFont, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			font, err = nil, fmt.Errorf("unsupported font file %s: %v", fontPath, r)
+		}
+	}()
+	return model.NewCompositePdfFontFromTTFFile(fontPath)
+}
+
+// getTextFieldDA returns the default appearance (DA) string of `ftxt`, which can be inherited from its
+// ancestors.
+func getTextFieldDA(ftxt *model.PdfFieldText) core.PdfObject {
+	if ftxt.DA != nil {
+		return ftxt.DA
+	}
+	for parent := ftxt.Parent; parent != nil; parent = parent.Parent {
+		if ptxt, ok := parent.GetContext().(*model.PdfFieldText); ok && ptxt.DA != nil {
+			return ptxt.DA
+		}
+	}
+	return nil
+}
+
+// Acrobat forms commonly store field formats (AA /F) and calculations (AA /C) as JavaScript actions calling
+// functions of the Acrobat forms API (AFNumber_Format, AFDate_FormatEx, AFSimple_Calculate, ...). These
+// are not executed when filling, so the standard patterns are recognized and applied below.
+var (
+	reNumberFormat    = regexp.MustCompile(`AFNumber_Format\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)\s*,\s*\d+\s*,\s*["']([^"']*)["']\s*,\s*(true|false)\s*\)`)
+	rePercentFormat   = regexp.MustCompile(`AFPercent_Format\(\s*(\d+)\s*,\s*(\d+)`)
+	reDateFormatEx    = regexp.MustCompile(`AFDate_FormatEx\(\s*["']([^"']*)["']\s*\)`)
+	reDateFormat      = regexp.MustCompile(`AFDate_Format\(\s*(\d+)\s*\)`)
+	reSimpleCalculate = regexp.MustCompile(`AFSimple_Calculate\(\s*["'](\w+)["']\s*,\s*(?:new\s+Array\s*\(([^)]*)\)|\[([^\]]*)\])`)
+	reQuoted          = regexp.MustCompile(`["']([^"']*)["']`)
+)
+
+// afDateFormats are the predefined date formats of AFDate_Format (by index).
+var afDateFormats = []string{
+	"m/d", "m/d/yy", "mm/dd/yy", "mm/yy", "d-mmm", "d-mmm-yy", "dd-mmm-yy", "yy-mm-dd", "mmm-yy", "mmmm-yy",
+	"mmm d, yyyy", "mmmm d, yyyy", "m/d/yy h:MM tt", "m/d/yy HH:MM",
+}
+
+// dateInputLayouts are the layouts tried when parsing input date values (in addition to the field format).
+var dateInputLayouts = []string{
+	"2006-01-02", time.RFC3339, "2006-01-02 15:04", "01/02/2006", "1/2/2006", "02.01.2006", "2006/01/02",
+}
+
+// fieldFormat is a field value format.
+type fieldFormat struct {
+	kind       string // number, percent or date.
+	decimals   int
+	sepStyle   int // 0: 1,234.56, 1: 1234.56, 2: 1.234,56, 3: 1234,56.
+	negStyle   int // 0, 1: -1234.56, 2, 3: (1234.56).
+	currency   string
+	prepend    bool
+	dateFormat string
+}
+
+// fieldCalculation is a simple calculation of a field value from the values of other fields.
+type fieldCalculation struct {
+	op     string   // SUM, PRD, AVG, MIN or MAX.
+	fields []string // Full names of the input fields.
+}
+
+// calculateAndFormatFields computes the values of calculated fields in `form` in calculation order and
+// returns the formatted values of the fields with a format. The field values are kept unformatted, the
+// formatted values are only shown in the field appearances (see formattedFieldAppearance).
+func calculateAndFormatFields(form *model.PdfAcroForm) (map[*model.PdfField]string, error) {
+	if form == nil {
+		return nil, nil
+	}
+
+	var names []string
+	fieldMap := map[string]*model.PdfField{}
+	objMap := map[int64]*model.PdfField{}
+	for _, field := range form.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			common.Log.Debug("Skipping field without name: %v", err)
+			continue
+		}
+		names = append(names, name)
+		fieldMap[name] = field
+		if container, ok := field.GetContainingPdfObject().(*core.PdfIndirectObject); ok {
+			objMap[container.ObjectNumber] = field
+		}
+	}
+
+	// Calculation order is given by the CO array, followed by any other fields with calculations.
+	var order []*model.PdfField
+	ordered := map[*model.PdfField]bool{}
+	if form.CO != nil {
+		for _, obj := range form.CO.Elements() {
+			var objNum int64
+			switch t := obj.(type) {
+			case *core.PdfIndirectObject:
+				objNum = t.ObjectNumber
+			case *core.PdfObjectReference:
+				objNum = t.ObjectNumber
+			}
+			if field, has := objMap[objNum]; has && !ordered[field] {
+				order = append(order, field)
+				ordered[field] = true
+			}
+		}
+	}
+	for _, name := range names {
+		field := fieldMap[name]
+		if !ordered[field] && getFieldJS(field, "C") != "" {
+			order = append(order, field)
+			ordered[field] = true
+		}
+	}
+
+	for _, field := range order {
+		name, _ := field.FullName()
+		calc, err := parseCalculation(getFieldJS(field, "C"))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %v", name, err)
+		}
+		if calc == nil {
+			common.Log.Debug("Unsupported calculation for field %s - skipping", name)
+			continue
+		}
+
+		var values []float64
+		for _, input := range calc.fields {
+			// The input name can refer to a parent field, including all its terminal fields.
+			for _, fname := range names {
+				if fname == input || strings.HasPrefix(fname, input+".") {
+					values = append(values, getFieldNumber(fieldMap[fname]))
+				}
+			}
+		}
+
+		result := calc.compute(values)
+		field.V = core.MakeString(strconv.FormatFloat(result, 'f', -1, 64))
+		common.Log.Debug("Calculated %s = %s(%v) = %v", name, calc.op, values, result)
+	}
+
+	formatted := map[*model.PdfField]string{}
+	for _, name := range names {
+		field := fieldMap[name]
+		format := parseFormat(getFieldJS(field, "F"))
+		if format == nil {
+			continue
+		}
+		str, ok := core.GetString(field.V)
+		if !ok || strings.TrimSpace(str.Decoded()) == "" {
+			continue
+		}
+
+		display, err := format.apply(str.Decoded())
+		if err != nil {
+			common.Log.Debug("Unable to format value of field %s: %v - showing value", name, err)
+			continue
+		}
+		formatted[field] = display
+	}
+
+	return formatted, nil
+}
+
+// formattedFieldAppearance generates the field appearances showing the formatted values of the fields
+// with a format, like the Acrobat format actions. The field values are not changed.
+// Implements interface model.FieldAppearanceGenerator.
+type formattedFieldAppearance struct {
+	model.FieldAppearanceGenerator
+	formatted map[*model.PdfField]string
+}
+
+// GenerateAppearanceDict generates an appearance dictionary for widget annotation `wa` of `field` in `form`
+// showing the formatted value of the field, if any.
+func (fa formattedFieldAppearance) GenerateAppearanceDict(form *model.PdfAcroForm, field *model.PdfField, wa *model.PdfAnnotationWidget) (*core.PdfObjectDictionary, error) {
+	display, has := fa.formatted[field]
+	if !has {
+		return fa.FieldAppearanceGenerator.GenerateAppearanceDict(form, field, wa)
+	}
+
+	// The appearance is generated from the field value, which is restored afterwards.
+	value := field.V
+	field.V = core.MakeEncodedString(display, true)
+	defer func() { field.V = value }()
+	return fa.FieldAppearanceGenerator.GenerateAppearanceDict(form, field, wa)
+}
+
+// getFieldJS returns the JavaScript of the additional action `key` (e.g. F for format, C for calculate)
+// of `field`. The additional actions can be in the field or in its widget annotations.
+func getFieldJS(field *model.PdfField, key core.PdfObjectName) string {
+	aaObjs := []core.PdfObject{field.AA}
+	for _, wa := range field.Annotations {
+		aaObjs = append(aaObjs, wa.AA)
+	}
+
+	for _, aaObj := range aaObjs {
+		aa, ok := core.GetDict(aaObj)
+		if !ok {
+			continue
+		}
+		action, ok := core.GetDict(aa.Get(key))
+		if !ok {
+			continue
+		}
+		if s, _ := core.GetNameVal(action.Get("S")); s != "JavaScript" {
+			continue
+		}
+		if js, ok := core.GetString(action.Get("JS")); ok {
+			return js.Decoded()
+		}
+		if stream, ok := core.GetStream(action.Get("JS")); ok {
+			data, err := core.DecodeStream(stream)
+			if err != nil {
+				common.Log.Debug("ERROR: Unable to decode JavaScript stream: %v", err)
+				continue
+			}
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// parseFormat returns the field format represented by format action JavaScript `js` or nil if not
+// a supported format.
+func parseFormat(js string) *fieldFormat {
+	if m := reNumberFormat.FindStringSubmatch(js); m != nil {
+		format := &fieldFormat{kind: "number", currency: m[4], prepend: m[5] == "true"}
+		format.decimals, _ = strconv.Atoi(m[1])
+		format.sepStyle, _ = strconv.Atoi(m[2])
+		format.negStyle, _ = strconv.Atoi(m[3])
+		return format
+	}
+	if m := rePercentFormat.FindStringSubmatch(js); m != nil {
+		format := &fieldFormat{kind: "percent"}
+		format.decimals, _ = strconv.Atoi(m[1])
+		format.sepStyle, _ = strconv.Atoi(m[2])
+		return format
+	}
+	if m := reDateFormatEx.FindStringSubmatch(js); m != nil {
+		return &fieldFormat{kind: "date", dateFormat: m[1]}
+	}
+	if m := reDateFormat.FindStringSubmatch(js); m != nil {
+		idx, _ := strconv.Atoi(m[1])
+		if idx < len(afDateFormats) {
+			return &fieldFormat{kind: "date", dateFormat: afDateFormats[idx]}
+		}
+	}
+	return nil
+}
+
+// parseCalculation returns the calculation represented by calculate action JavaScript `js` or nil if not
+// a simple calculation. Returns an error if the operation of the simple calculation is not supported.
+func parseCalculation(js string) (*fieldCalculation, error) {
+	m := reSimpleCalculate.FindStringSubmatch(js)
+	if m == nil {
+		return nil, nil
+	}
+
+	calc := &fieldCalculation{op: strings.ToUpper(m[1])}
+	switch calc.op {
+	case "SUM", "AVG", "PRD", "MIN", "MAX":
+	default:
+		return nil, fmt.Errorf("unsupported calculation: %s", m[1])
+	}
+	for _, q := range reQuoted.FindAllStringSubmatch(m[2]+m[3], -1) {
+		calc.fields = append(calc.fields, q[1])
+	}
+	// A single comma separated string is also accepted by AFSimple_Calculate.
+	if len(calc.fields) == 1 && strings.Contains(calc.fields[0], ",") {
+		calc.fields = strings.Split(calc.fields[0], ",")
+		for i := range calc.fields {
+			calc.fields[i] = strings.TrimSpace(calc.fields[i])
+		}
+	}
+	return calc, nil
+}
+
+// compute returns the result of applying the calculation operation to `values`.
+func (calc *fieldCalculation) compute(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	result := values[0]
+	for _, v := range values[1:] {
+		switch calc.op {
+		case "SUM", "AVG":
+			result += v
+		case "PRD":
+			result *= v
+		case "MIN":
+			result = math.Min(result, v)
+		case "MAX":
+			result = math.Max(result, v)
+		}
+	}
+	if calc.op == "AVG" {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// getFieldNumber returns the numeric value of `field`, taking the separator style of its number format
+// into account. Empty or non-numeric values are treated as 0.
+func getFieldNumber(field *model.PdfField) float64 {
+	var str string
+	switch t := core.TraceToDirectObject(field.V).(type) {
+	case *core.PdfObjectString:
+		str = t.Decoded()
+	case *core.PdfObjectName:
+		str = t.String()
+	default:
+		return 0
+	}
+
+	sepStyle := 0
+	format := parseFormat(getFieldJS(field, "F"))
+	if format != nil {
+		sepStyle = format.sepStyle
+	}
+	var val float64
+	var err error
+	if format != nil && format.kind == "percent" {
+		val, err = parsePercent(str, sepStyle)
+	} else {
+		val, err = parseNumber(str, sepStyle)
+	}
+	if err != nil {
+		common.Log.Debug("Non-numeric value '%s' - using 0", str)
+		return 0
+	}
+	return val
+}
+
+// parseNumber parses the number in `str`. Plain numbers (as stored by Acrobat) are parsed directly,
+// otherwise currency symbols and thousands separators are ignored and the decimal separator is a comma
+// for separator styles 2 and 3, or a point for the others.
+func parseNumber(str string, sepStyle int) (float64, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
+	}
+	if val, err := strconv.ParseFloat(str, 64); err == nil {
+		return val, nil
+	}
+	negative := strings.HasPrefix(str, "(") && strings.HasSuffix(str, ")")
+
+	var b strings.Builder
+	for _, r := range str {
+		switch {
+		case r >= '0' && r <= '9', r == '-':
+			b.WriteRune(r)
+		case r == ',' && sepStyle >= 2, r == '.' && sepStyle < 2:
+			b.WriteRune('.')
+		}
+	}
+
+	val, err := strconv.ParseFloat(b.String(), 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		val = -val
+	}
+	return val, nil
+}
+
+// parsePercent parses the percent value in `str`. Values with a percent sign (e.g. "15%") are converted
+// to fractions (0.15), plain values are fractions already, as stored by Acrobat.
+func parsePercent(str string, sepStyle int) (float64, error) {
+	str = strings.TrimSpace(str)
+	if !strings.HasSuffix(str, "%") {
+		return parseNumber(str, sepStyle)
+	}
+	val, err := parseNumber(strings.TrimSuffix(str, "%"), sepStyle)
+	if err != nil {
+		return 0, err
+	}
+	return val / 100, nil
+}
+
+// apply returns `value` formatted with format `f`.
+func (f *fieldFormat) apply(value string) (string, error) {
+	switch f.kind {
+	case "number":
+		val, err := parseNumber(value, f.sepStyle)
+		if err != nil {
+			return "", err
+		}
+		str := formatNumber(math.Abs(val), f.decimals, f.sepStyle)
+		if f.prepend {
+			str = f.currency + str
+		} else {
+			str += f.currency
+		}
+		if val < 0 {
+			if f.negStyle >= 2 {
+				return "(" + str + ")", nil
+			}
+			return "-" + str, nil
+		}
+		return str, nil
+	case "percent":
+		val, err := parsePercent(value, f.sepStyle)
+		if err != nil {
+			return "", err
+		}
+		str := formatNumber(math.Abs(val*100), f.decimals, f.sepStyle) + "%"
+		if val < 0 {
+			str = "-" + str
+		}
+		return str, nil
+	case "date":
+		layout := afDateLayout(f.dateFormat)
+		for _, inLayout := range append([]string{layout}, dateInputLayouts...) {
+			t, err := time.Parse(inLayout, strings.TrimSpace(value))
+			if err == nil {
+				return t.Format(layout), nil
+			}
+		}
+		return "", fmt.Errorf("unrecognized date: %s", value)
+	}
+	return value, nil
+}
+
+// formatNumber formats non-negative `val` with `decimals` decimal places and the separators of `sepStyle`.
+func formatNumber(val float64, decimals, sepStyle int) string {
+	thousands, decimal := ",", "."
+	switch sepStyle {
+	case 1:
+		thousands = ""
+	case 2:
+		thousands, decimal = ".", ","
+	case 3:
+		thousands, decimal = "", ","
+	}
+
+	str := strconv.FormatFloat(val, 'f', decimals, 64)
+	intPart, fracPart := str, ""
+	if i := strings.Index(str, "."); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+
+	var b strings.Builder
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousands)
+		}
+		b.WriteRune(r)
+	}
+	if fracPart != "" {
+		b.WriteString(decimal)
+		b.WriteString(fracPart)
+	}
+	return b.String()
+}
+
+// afDateLayout converts Acrobat date format `format` (e.g. "mm/dd/yyyy") to a Go time layout.
+func afDateLayout(format string) string {
+	tokens := []struct{ af, layout string }{
+		{"mmmm", "January"}, {"mmm", "Jan"}, {"mm", "01"}, {"m", "1"},
+		{"dddd", "Monday"}, {"ddd", "Mon"}, {"dd", "02"}, {"d", "2"},
+		{"yyyy", "2006"}, {"yy", "06"},
+		{"HH", "15"}, {"H", "15"}, {"hh", "03"}, {"h", "3"},
+		{"MM", "04"}, {"M", "4"}, {"ss", "05"}, {"s", "5"}, {"tt", "PM"},
+	}
+
+	var b strings.Builder
+	for len(format) > 0 {
+		matched := false
+		for _, tok := range tokens {
+			if strings.HasPrefix(format, tok.af) {
+				b.WriteString(tok.layout)
+				format = format[len(tok.af):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[0])
+			format = format[1:]
+		}
+	}
+	return b.String()
+}
@@ -2,19 +2,36 @@
  * Flatten form data in PDF files, moving to content stream from annotations, so cannot be edited.
  * Note: Works for forms that have been filled in an editor and have the appearance streams generated.
  *
- * Run as: go run pdf_form_flatten.go <outputdir> <pdf files...>
+ * By default all fields are flattened. A subset of the fields can be selected by full field name or glob
+ * pattern (-fields), excluded (-exclude) or selected by field type (-types), in which case the other fields
+ * are left interactive. For example, to lock in the applicant data but leave signature fields editable:
+ *   go run pdf_form_flatten.go -fields 'applicant.*' -exclude 'reviewer.*' -types text,checkbox out/ in.pdf
+ *
+ * Supported field types: text, checkbox, radio, pushbutton, combobox, listbox, signature.
+ *
+ * Appearance styles (including TrueType fonts for text fields) can be customized globally and per field with
+ * a style configuration file (-style style.json), see styleConfig below.
+ *
+ * Run as: go run pdf_form_flatten.go [options] <outputdir> <pdf files...>
  */
 
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 
-	"github.com/unidoc/unipdf/v3/common"
 	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/contentstream"
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
 )
 
@@ -22,22 +39,42 @@ func main() {
 	// When debugging, enable debug-level logging via console:
 	common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
 
-	if len(os.Args) < 3 {
-		fmt.Printf("Usage: go run pdf_form_flatten.go <outputdir> <input1.pdf> [input2.pdf] ...\n")
+	var fields, exclude, types, stylePath string
+	flag.StringVar(&fields, "fields", "", "Comma separated list of field names or glob patterns to flatten")
+	flag.StringVar(&exclude, "exclude", "", "Comma separated list of field names or glob patterns to keep interactive")
+	flag.StringVar(&types, "types", "", "Comma separated list of field types to flatten")
+	flag.StringVar(&stylePath, "style", "", "Appearance style configuration file (JSON)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 2 {
+		fmt.Printf("Usage: go run pdf_form_flatten.go [options] <outputdir> <input1.pdf> [input2.pdf] ...\n")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	selector, err := newFieldSelector(fields, exclude, types)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	styleConfig, err := loadStyleConfig(stylePath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	outputDir := os.Args[1]
+	outputDir := args[0]
 
 	fails := map[string]string{}
 	failKeys := []string{}
 	processed := 0
 
-	for i := 2; i < len(os.Args); i++ {
-		inputPath := os.Args[i]
+	for _, inputPath := range args[1:] {
 		name := filepath.Base(inputPath)
 		outputPath := filepath.Join(outputDir, fmt.Sprintf("flattened_%s", name))
-		err := flattenPdf(inputPath, outputPath)
+		err := flattenPdf(inputPath, outputPath, selector, styleConfig)
 		if err != nil {
 			fmt.Printf("%s - Error: %v\n", inputPath, err)
 			fails[inputPath] = err.Error()
@@ -54,8 +91,9 @@ func main() {
 }
 
 // flattenPdf flattens annotations and forms moving the appearance stream to the page contents so cannot be
-// modified.
-func flattenPdf(inputPath, outputPath string) error {
+// modified. Only the fields matched by `selector` are flattened, the remaining fields are kept in the form.
+// The field appearances are generated with the styles in `styleConfig` (optional).
+func flattenPdf(inputPath, outputPath string, selector *fieldSelector, styleConfig *styleConfig) error {
 	f, err := os.Open(inputPath)
 	if err != nil {
 		return err
@@ -68,13 +106,14 @@ func flattenPdf(inputPath, outputPath string) error {
 	}
 
 	fieldAppearance := annotator.FieldAppearance{OnlyIfMissing: true}
-	err = pdfReader.FlattenFields(false, fieldAppearance)
+	appgen := newFieldAppearanceGenerator(fieldAppearance, styleConfig)
+	form, err := flattenSelectedFields(pdfReader, selector, false, appgen)
 	if err != nil {
 		return err
 	}
 
 	pdfWriter := model.NewPdfWriter()
-	pdfWriter.SetForms(nil)
+	pdfWriter.SetForms(form)
 
 	for _, p := range pdfReader.PageList {
 		err := pdfWriter.AddPage(p)
@@ -92,3 +131,485 @@ func flattenPdf(inputPath, outputPath string) error {
 	err = pdfWriter.Write(fout)
 	return err
 }
+
+// fieldSelector selects form fields by full name, glob pattern or type.
+type fieldSelector struct {
+	patterns []string
+	exclude  []string
+	types    map[string]bool
+}
+
+// fieldTypes lists the field type names that can be used for selecting fields.
+var fieldTypes = []string{"text", "checkbox", "radio", "pushbutton", "combobox", "listbox", "signature"}
+
+// newFieldSelector returns a selector for the comma separated `fields` and `exclude` patterns and field
+// `types`. An empty selector (no patterns or types) matches all fields.
+func newFieldSelector(fields, exclude, types string) (*fieldSelector, error) {
+	selector := &fieldSelector{
+		patterns: splitList(fields),
+		exclude:  splitList(exclude),
+		types:    map[string]bool{},
+	}
+
+	for _, pattern := range append(selector.patterns, selector.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	for _, t := range splitList(types) {
+		valid := false
+		for _, ft := range fieldTypes {
+			if t == ft {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unsupported field type %s (supported: %s)", t, strings.Join(fieldTypes, ", "))
+		}
+		selector.types[t] = true
+	}
+
+	return selector, nil
+}
+
+// splitList splits a comma separated list into trimmed, non-empty elements.
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// isEmpty returns true if the selector matches all fields.
+func (s *fieldSelector) isEmpty() bool {
+	return s == nil || len(s.patterns) == 0 && len(s.exclude) == 0 && len(s.types) == 0
+}
+
+// matches returns true if `field` is selected by `s`.
+func (s *fieldSelector) matches(field *model.PdfField) bool {
+	if s.isEmpty() {
+		return true
+	}
+
+	fullname, err := field.FullName()
+	if err != nil {
+		common.Log.Debug("Unable to get field name: %v", err)
+		return false
+	}
+
+	if len(s.patterns) > 0 && !matchesAny(s.patterns, fullname) {
+		return false
+	}
+	if matchesAny(s.exclude, fullname) {
+		return false
+	}
+	if len(s.types) > 0 && !s.types[fieldType(field)] {
+		return false
+	}
+	return true
+}
+
+// matchesAny returns true if `name` matches any of the glob `patterns`.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldType returns the type name of `field` (one of fieldTypes).
+func fieldType(field *model.PdfField) string {
+	switch t := field.GetContext().(type) {
+	case *model.PdfFieldText:
+		return "text"
+	case *model.PdfFieldButton:
+		switch {
+		case t.IsPush():
+			return "pushbutton"
+		case t.IsRadio():
+			return "radio"
+		}
+		return "checkbox"
+	case *model.PdfFieldChoice:
+		if t.Flags().Has(model.FieldFlagCombo) {
+			return "combobox"
+		}
+		return "listbox"
+	case *model.PdfFieldSignature:
+		return "signature"
+	}
+	return ""
+}
+
+// flattenSelectedFields flattens the terminal fields of the form in `pdfReader` matched by `selector`
+// using `appgen` for generating the field appearances. If `allannots` is true and all fields are selected,
+// all annotations are flattened too.
+// Returns the form with the remaining (not flattened) fields, or nil if no fields remain.
+func flattenSelectedFields(pdfReader *model.PdfReader, selector *fieldSelector, allannots bool, appgen model.FieldAppearanceGenerator) (*model.PdfAcroForm, error) {
+	// Ensure the page annotations are loaded, as only loaded annotations are flattened. The raw Annots
+	// entry is cleared so that it is not written out if all the page annotations are flattened.
+	for _, page := range pdfReader.PageList {
+		if _, err := page.GetAnnotations(); err != nil {
+			return nil, err
+		}
+		page.Annots = nil
+	}
+
+	form := pdfReader.AcroForm
+	if form == nil || selector.isEmpty() {
+		return nil, pdfReader.FlattenFields(allannots, appgen)
+	}
+
+	flatten := map[*model.PdfField]bool{}
+	var targets []*model.PdfField
+	for _, field := range form.AllFields() {
+		if field.IsTerminal() && selector.matches(field) {
+			flatten[field] = true
+			targets = append(targets, field)
+		}
+	}
+
+	// Flatten the selected fields via a form containing only the targets. The other annotations are kept.
+	tmpForm := model.NewPdfAcroForm()
+	tmpForm.DR = form.DR
+	tmpForm.DA = form.DA
+	tmpForm.Q = form.Q
+	*tmpForm.Fields = targets
+	pdfReader.AcroForm = tmpForm
+
+	err := pdfReader.FlattenFields(false, appgen)
+	pdfReader.AcroForm = form
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the flattened fields from the field hierarchy.
+	*form.Fields = removeFields(*form.Fields, flatten)
+	if len(*form.Fields) == 0 {
+		return nil, nil
+	}
+	return form, nil
+}
+
+// removeFields returns `fields` without the fields in `remove` (checking descendants recursively).
+// Non-terminal fields are removed when all their descendants were removed.
+func removeFields(fields []*model.PdfField, remove map[*model.PdfField]bool) []*model.PdfField {
+	var kept []*model.PdfField
+	for _, field := range fields {
+		if remove[field] {
+			continue
+		}
+		if len(field.Kids) > 0 {
+			field.Kids = removeFields(field.Kids, remove)
+			if len(field.Kids) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, field)
+	}
+	return kept
+}
+
+// styleConfig is an appearance style configuration for generating field appearances, loaded from a JSON
+// file. The default style applies to all fields and can be overridden for specific fields, for example:
+//
+//	{
+//	  "default": {"autoFontSizeFraction": 0.7, "borderSize": 1, "borderColor": [1, 0, 0], "fillColor": [0.9]},
+//	  "fields": [
+//	    {"name": "applicant.*", "font": "fonts/NotoSans-Regular.ttf", "fontSize": 10},
+//	    {"name": "agree", "checkmark": "✓", "allowMK": false}
+//	  ]
+//	}
+//
+// Colors are specified as arrays of 1 (gray), 3 (RGB) or 4 (CMYK) components in the range 0-1.
+// Field overrides are matched by full field name or glob pattern and applied in order.
+type styleConfig struct {
+	Default fieldStyle           `json:"default"`
+	Fields  []fieldStyleOverride `json:"fields"`
+}
+
+// fieldStyle contains field appearance style settings. Unset entries are not changed.
+type fieldStyle struct {
+	// Font is a TrueType font file used for text fields, e.g. for rendering non-Latin values.
+	Font                  string    `json:"font"`
+	FontSize              *float64  `json:"fontSize"` // 0 for auto sizing.
+	AutoFontSizeFraction  *float64  `json:"autoFontSizeFraction"`
+	Checkmark             string    `json:"checkmark"` // Character used for checked checkboxes.
+	BorderSize            *float64  `json:"borderSize"`
+	BorderColor           []float64 `json:"borderColor"`
+	FillColor             []float64 `json:"fillColor"`
+	MultilineLineHeight   *float64  `json:"multilineLineHeight"`
+	MultilineVAlignMiddle *bool     `json:"multilineVAlignMiddle"`
+	AllowMK               *bool     `json:"allowMK"`
+}
+
+// fieldStyleOverride is a field style applied to the fields matching Name (full name or glob pattern).
+type fieldStyleOverride struct {
+	Name string `json:"name"`
+	fieldStyle
+}
+
+// loadStyleConfig loads the style configuration from the JSON file in `stylePath`.
+// Returns nil if `stylePath` is empty.
+func loadStyleConfig(stylePath string) (*styleConfig, error) {
+	if stylePath == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(stylePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config styleConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	styles := []fieldStyle{config.Default}
+	for _, fs := range config.Fields {
+		if _, err := path.Match(fs.Name, ""); err != nil || fs.Name == "" {
+			return nil, fmt.Errorf("invalid style field name: '%s'", fs.Name)
+		}
+		styles = append(styles, fs.fieldStyle)
+	}
+	for _, fs := range styles {
+		if _, err := makeColor(fs.BorderColor); err != nil {
+			return nil, err
+		}
+		if _, err := makeColor(fs.FillColor); err != nil {
+			return nil, err
+		}
+		if len([]rune(fs.Checkmark)) > 1 {
+			return nil, fmt.Errorf("checkmark must be a single character: '%s'", fs.Checkmark)
+		}
+	}
+
+	return &config, nil
+}
+
+// fieldStyle returns the combined style settings for the field with `fullname`.
+func (config *styleConfig) fieldStyle(fullname string) []fieldStyle {
+	styles := []fieldStyle{config.Default}
+	for _, fs := range config.Fields {
+		if match, _ := path.Match(fs.Name, fullname); match {
+			styles = append(styles, fs.fieldStyle)
+		}
+	}
+	return styles
+}
+
+// isSet returns true if any of the settings of `fs` are set.
+func (fs fieldStyle) isSet() bool {
+	return fs.Font != "" || fs.FontSize != nil || fs.AutoFontSizeFraction != nil || fs.Checkmark != "" ||
+		fs.BorderSize != nil || len(fs.BorderColor) > 0 || len(fs.FillColor) > 0 ||
+		fs.MultilineLineHeight != nil || fs.MultilineVAlignMiddle != nil || fs.AllowMK != nil
+}
+
+// apply applies the settings of `fs` to appearance `style`.
+func (fs fieldStyle) apply(style *annotator.AppearanceStyle) {
+	if fs.AutoFontSizeFraction != nil {
+		style.AutoFontSizeFraction = *fs.AutoFontSizeFraction
+	}
+	if fs.Checkmark != "" {
+		style.CheckmarkRune = []rune(fs.Checkmark)[0]
+	}
+	if fs.BorderSize != nil {
+		style.BorderSize = *fs.BorderSize
+	}
+	if color, _ := makeColor(fs.BorderColor); color != nil {
+		style.BorderColor = color
+	}
+	if color, _ := makeColor(fs.FillColor); color != nil {
+		style.FillColor = color
+	}
+	if fs.MultilineLineHeight != nil {
+		style.MultilineLineHeight = *fs.MultilineLineHeight
+	}
+	if fs.MultilineVAlignMiddle != nil {
+		style.MultilineVAlignMiddle = *fs.MultilineVAlignMiddle
+	}
+	if fs.AllowMK != nil {
+		style.AllowMK = *fs.AllowMK
+	}
+}
+
+// makeColor returns a color from gray, RGB or CMYK `components`. Returns nil if no components are set.
+func makeColor(components []float64) (model.PdfColor, error) {
+	switch len(components) {
+	case 0:
+		return nil, nil
+	case 1:
+		return model.NewPdfColorDeviceGray(components[0]), nil
+	case 3:
+		return model.NewPdfColorDeviceRGB(components[0], components[1], components[2]), nil
+	case 4:
+		return model.NewPdfColorDeviceCMYK(components[0], components[1], components[2], components[3]), nil
+	}
+	return nil, fmt.Errorf("invalid number of color components: %d", len(components))
+}
+
+// styledFieldAppearance generates field appearances with the styles of a style configuration. Existing
+// appearances of fields with a configured style are regenerated.
+// Implements interface model.FieldAppearanceGenerator.
+type styledFieldAppearance struct {
+	annotator.FieldAppearance
+	config *styleConfig
+
+	// Form resource names of the loaded TrueType fonts by font file path.
+	fonts map[string]core.PdfObjectName
+}
+
+// newFieldAppearanceGenerator returns an appearance generator applying the styles in `config` to the
+// appearances generated by `fa`. Returns `fa` if `config` is nil.
+func newFieldAppearanceGenerator(fa annotator.FieldAppearance, config *styleConfig) model.FieldAppearanceGenerator {
+	if config == nil {
+		return fa
+	}
+	return &styledFieldAppearance{
+		FieldAppearance: fa,
+		config:          config,
+		fonts:           map[string]core.PdfObjectName{},
+	}
+}
+
+// GenerateAppearanceDict generates an appearance dictionary for widget annotation `wa` of `field` in `form`
+// using the configured style of the field.
+func (sa *styledFieldAppearance) GenerateAppearanceDict(form *model.PdfAcroForm, field *model.PdfField, wa *model.PdfAnnotationWidget) (*core.PdfObjectDictionary, error) {
+	fullname, err := field.FullName()
+	if err != nil {
+		return nil, err
+	}
+
+	fa := sa.FieldAppearance
+	style := fa.Style()
+	var font string
+	var fontSize *float64
+	for _, fs := range sa.config.fieldStyle(fullname) {
+		if fs.isSet() {
+			// Regenerate the existing appearance, otherwise the style has no effect.
+			fa.OnlyIfMissing = false
+		}
+		fs.apply(&style)
+		if fs.Font != "" {
+			font = fs.Font
+		}
+		if fs.FontSize != nil {
+			fontSize = fs.FontSize
+		}
+	}
+	fa.SetStyle(style)
+
+	if ftxt, ok := field.GetContext().(*model.PdfFieldText); ok && (font != "" || fontSize != nil) {
+		err := sa.setTextFieldFont(form, ftxt, font, fontSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fa.GenerateAppearanceDict(form, field, wa)
+}
+
+// setTextFieldFont updates the default appearance (DA) of text field `ftxt` to use the TrueType font in
+// `fontPath` (if set) with `fontSize` (if set). The font is added to the form resources (DR).
+func (sa *styledFieldAppearance) setTextFieldFont(form *model.PdfAcroForm, ftxt *model.PdfFieldText, fontPath string, fontSize *float64) error {
+	fontName := core.MakeName("Helv")
+	size := 0.0
+	var color []core.PdfObject
+
+	// Keep the settings of the current default appearance, if any.
+	daObj := getTextFieldDA(ftxt)
+	if daObj == nil && form.DA != nil {
+		daObj = form.DA
+	}
+	if da, ok := core.GetString(daObj); ok {
+		ops, err := contentstream.NewContentStreamParser(da.Str()).Parse()
+		if err != nil {
+			return err
+		}
+		for _, op := range *ops {
+			switch op.Operand {
+			case "Tf":
+				if len(op.Params) == 2 {
+					if name, ok := core.GetName(op.Params[0]); ok {
+						fontName = name
+					}
+					if num, err := core.GetNumberAsFloat(op.Params[1]); err == nil {
+						size = num
+					}
+				}
+			case "g", "rg", "k":
+				color = append(append([]core.PdfObject{}, op.Params...), core.MakeName(op.Operand))
+			}
+		}
+	}
+
+	if form.DR == nil {
+		form.DR = model.NewPdfPageResources()
+	}
+	if fontPath != "" {
+		name, has := sa.fonts[fontPath]
+		if !has {
+			font, err := loadCompositeFont(fontPath)
+			if err != nil {
+				return err
+			}
+			name = core.PdfObjectName(fmt.Sprintf("StyleFont%d", len(sa.fonts)+1))
+			form.DR.SetFontByName(name, font.ToPdfObject())
+			sa.fonts[fontPath] = name
+		}
+		fontName = &name
+	} else if !form.DR.HasFontByName(*fontName) {
+		helv := model.NewStandard14FontMustCompile(model.HelveticaName)
+		form.DR.SetFontByName(*fontName, helv.ToPdfObject())
+	}
+	if fontSize != nil {
+		size = *fontSize
+	}
+
+	da := fmt.Sprintf("/%s %g Tf", *fontName, size)
+	if len(color) > 0 {
+		var parts []string
+		for _, obj := range color {
+			parts = append(parts, obj.WriteString())
+		}
+		da += " " + strings.Join(parts, " ")
+	} else {
+		da += " 0 g"
+	}
+	ftxt.DA = core.MakeString(da)
+	return nil
+}
+
+// loadCompositeFont loads a composite (Type0) font from the TrueType font file in `fontPath`, which supports
+// characters outside of the standard encodings.
+func loadCompositeFont(fontPath string) (font *model.PdfFont, err error) {
+	// Recover from a panic in the font loading for TrueType fonts without glyph names (post table).
+	defer func() {
+		if r := recover(); r != nil {
+			font, err = nil, fmt.Errorf("unsupported font file %s: %v", fontPath, r)
+		}
+	}()
+	return model.NewCompositePdfFontFromTTFFile(fontPath)
+}
+
+// getTextFieldDA returns the default appearance (DA) string of `ftxt`, which can be inherited from its
+// ancestors.
+func getTextFieldDA(ftxt *model.PdfFieldText) core.PdfObject {
+	if ftxt.DA != nil {
+		return ftxt.DA
+	}
+	for parent := ftxt.Parent; parent != nil; parent = parent.Parent {
+		if ptxt, ok := parent.GetContext().(*model.PdfFieldText); ok && ptxt.DA != nil {
+			return ptxt.DA
+		}
+	}
+	return nil
+}
@@ -0,0 +1,622 @@
+/*
+ * XFA forms: Detect XFA, extract the XFA form data (datasets packet) as XML, fill the form data from XML
+ * and remove XFA so that the AcroForm fallback is used.
+ *
+ * XFA forms come in two flavours:
+ * - static XFA: the form has both XFA and AcroForm fields, viewers without XFA support use the AcroForm.
+ * - dynamic XFA: the form layout is generated from the XFA template by the viewer (NeedsRendering) and there
+ *   is no usable AcroForm fallback. Removing XFA from such forms is refused.
+ *
+ * Run as: go run pdf_form_xfa.go info input.pdf
+ *     or: go run pdf_form_xfa.go extract input.pdf [datasets.xml]
+ *     or: go run pdf_form_xfa.go fill input.pdf data.xml output.pdf
+ *     or: go run pdf_form_xfa.go remove input.pdf output.pdf
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+const usage = "Usage:\n" +
+	"  go run pdf_form_xfa.go info input.pdf\n" +
+	"  go run pdf_form_xfa.go extract input.pdf [datasets.xml]\n" +
+	"  go run pdf_form_xfa.go fill input.pdf data.xml output.pdf\n" +
+	"  go run pdf_form_xfa.go remove input.pdf output.pdf\n"
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf("Detect, extract, fill and remove XFA forms\n")
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+
+	// Enable debug-level logging.
+	// common.SetLogger(common.NewConsoleLogger(common.LogLevelDebug))
+
+	command, args := os.Args[1], os.Args[2:]
+
+	var err error
+	switch {
+	case command == "info":
+		err = xfaInfo(args[0])
+	case command == "extract":
+		outputPath := ""
+		if len(args) > 1 {
+			outputPath = args[1]
+		}
+		err = xfaExtract(args[0], outputPath)
+	case command == "fill" && len(args) == 3:
+		err = xfaFill(args[0], args[1], args[2])
+	case command == "remove" && len(args) == 2:
+		err = xfaRemove(args[0], args[1])
+	default:
+		fmt.Print(usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// xfaPacket is a named part of the XFA form (e.g. template, datasets).
+type xfaPacket struct {
+	name  string
+	index int // Index of the packet stream in the XFA array, -1 if XFA is a single stream.
+	data  []byte
+}
+
+// xfaForm represents the XFA form of a PDF document.
+type xfaForm struct {
+	acroForm       *model.PdfAcroForm
+	packets        []xfaPacket
+	numFields      int
+	needsRendering bool
+}
+
+// isDynamic returns true if the form is a dynamic XFA form without a usable AcroForm fallback.
+func (x *xfaForm) isDynamic() bool {
+	return x.needsRendering || x.numFields == 0
+}
+
+// packet returns the packet named `name` or nil if not present.
+func (x *xfaForm) packet(name string) *xfaPacket {
+	for i := range x.packets {
+		if x.packets[i].name == name {
+			return &x.packets[i]
+		}
+	}
+	return nil
+}
+
+// datasets returns the XML of the datasets packet.
+// If the XFA is a single stream, the datasets element is extracted from the full XDP document.
+func (x *xfaForm) datasets() ([]byte, error) {
+	if p := x.packet("datasets"); p != nil {
+		return p.data, nil
+	}
+	if p := x.packet("xdp"); p != nil {
+		if loc := reDatasets.FindIndex(p.data); loc != nil {
+			return p.data[loc[0]:loc[1]], nil
+		}
+	}
+	return nil, errors.New("no XFA datasets packet")
+}
+
+// reDatasets matches the datasets element of an XDP document.
+var reDatasets = regexp.MustCompile(`(?s)<xfa:datasets[\s>].*?</xfa:datasets>`)
+
+// openPdf opens the PDF at `inputPath`, decrypting with an empty password if encrypted.
+func openPdf(inputPath string) (*model.PdfReader, *os.File, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pdfReader, err := model.NewPdfReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	isEncrypted, err := pdfReader.IsEncrypted()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if isEncrypted {
+		auth, err := pdfReader.Decrypt([]byte(""))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if !auth {
+			f.Close()
+			return nil, nil, errors.New("encrypted - unable to decrypt with empty password")
+		}
+	}
+	return pdfReader, f, nil
+}
+
+// loadXFA returns the XFA form of the document in `pdfReader` or nil if the document has no XFA form.
+func loadXFA(pdfReader *model.PdfReader) (*xfaForm, error) {
+	acroForm := pdfReader.AcroForm
+	if acroForm == nil || acroForm.XFA == nil {
+		return nil, nil
+	}
+
+	x := &xfaForm{acroForm: acroForm}
+	for _, field := range acroForm.AllFields() {
+		if field.IsTerminal() {
+			x.numFields++
+		}
+	}
+
+	trailer, err := pdfReader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	if catalog, ok := core.GetDict(trailer.Get("Root")); ok {
+		if needsRendering, ok := core.GetBool(catalog.Get("NeedsRendering")); ok {
+			x.needsRendering = bool(*needsRendering)
+		}
+	}
+
+	// XFA is either a single stream with the full XDP document or an array of name/stream pairs.
+	switch t := core.TraceToDirectObject(acroForm.XFA).(type) {
+	case *core.PdfObjectStream:
+		data, err := core.DecodeStream(t)
+		if err != nil {
+			return nil, err
+		}
+		x.packets = append(x.packets, xfaPacket{name: "xdp", index: -1, data: data})
+	case *core.PdfObjectArray:
+		for i := 0; i+1 < t.Len(); i += 2 {
+			name, ok := core.GetString(t.Get(i))
+			if !ok {
+				return nil, fmt.Errorf("invalid XFA packet name (%T)", t.Get(i))
+			}
+			stream, ok := core.GetStream(t.Get(i + 1))
+			if !ok {
+				return nil, fmt.Errorf("invalid XFA packet %s (%T)", name, t.Get(i+1))
+			}
+			data, err := core.DecodeStream(stream)
+			if err != nil {
+				return nil, err
+			}
+			x.packets = append(x.packets, xfaPacket{name: name.Str(), index: i + 1, data: data})
+		}
+	default:
+		return nil, fmt.Errorf("invalid XFA object (%T)", t)
+	}
+
+	return x, nil
+}
+
+// xfaInfo prints information about the XFA form of `inputPath`.
+func xfaInfo(inputPath string) error {
+	pdfReader, f, err := openPdf(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Printf("Input file: %s\n", inputPath)
+	x, err := loadXFA(pdfReader)
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		fmt.Printf(" No XFA form\n")
+		return nil
+	}
+
+	fmt.Printf(" XFA packets: %d\n", len(x.packets))
+	for _, p := range x.packets {
+		fmt.Printf(" - %s (%d bytes)\n", p.name, len(p.data))
+	}
+	fmt.Printf(" NeedsRendering: %v\n", x.needsRendering)
+	fmt.Printf(" AcroForm fields: %d\n", x.numFields)
+	if x.isDynamic() {
+		fmt.Printf(" Type: dynamic XFA only - no AcroForm fallback, XFA cannot be removed\n")
+	} else {
+		fmt.Printf(" Type: static XFA - AcroForm fallback available\n")
+	}
+	return nil
+}
+
+// xfaExtract writes the XFA datasets packet of `inputPath` to `outputPath` (stdout if empty).
+func xfaExtract(inputPath, outputPath string) error {
+	pdfReader, f, err := openPdf(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	x, err := loadXFA(pdfReader)
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		return errors.New("no XFA form")
+	}
+	data, err := x.datasets()
+	if err != nil {
+		return err
+	}
+
+	if outputPath == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(outputPath, data, 0644)
+}
+
+// xfaFill replaces the XFA form data of `inputPath` with the XML data in `xmlPath` and writes the result
+// to `outputPath` as an incremental update. The XML can be a full datasets packet (xfa:datasets), the
+// xfa:data element or the form data root element.
+func xfaFill(inputPath, xmlPath, outputPath string) error {
+	xmlData, err := ioutil.ReadFile(xmlPath)
+	if err != nil {
+		return err
+	}
+	datasets, err := makeDatasets(xmlData)
+	if err != nil {
+		return err
+	}
+
+	pdfReader, f, err := openPdf(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	x, err := loadXFA(pdfReader)
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		return errors.New("no XFA form")
+	}
+
+	stream, err := core.MakeStream(datasets, core.NewFlateEncoder())
+	if err != nil {
+		return err
+	}
+
+	if p := x.packet("datasets"); p != nil {
+		xfa, _ := core.GetArray(x.acroForm.XFA)
+		if err := xfa.Set(p.index, stream); err != nil {
+			return err
+		}
+	} else if p := x.packet("xdp"); p != nil {
+		// Single stream: replace or insert the datasets element in the XDP document.
+		var xdp []byte
+		if loc := reDatasets.FindIndex(p.data); loc != nil {
+			xdp = append(append(append([]byte{}, p.data[:loc[0]]...), datasets...), p.data[loc[1]:]...)
+		} else {
+			i := bytes.LastIndex(p.data, []byte("</xdp:xdp>"))
+			if i < 0 {
+				return errors.New("invalid XDP document")
+			}
+			xdp = append(append(append([]byte{}, p.data[:i]...), datasets...), p.data[i:]...)
+		}
+		if stream, err = core.MakeStream(xdp, core.NewFlateEncoder()); err != nil {
+			return err
+		}
+		x.acroForm.XFA = stream
+	} else {
+		// Insert the datasets packet before the postamble (or at the end).
+		xfa, _ := core.GetArray(x.acroForm.XFA)
+		elements := xfa.Elements()
+		i := len(elements)
+		if p := x.packet("postamble"); p != nil {
+			i = p.index - 1
+		}
+		packets := append([]core.PdfObject{}, elements[:i]...)
+		packets = append(packets, core.MakeString("datasets"), stream)
+		packets = append(packets, elements[i:]...)
+		x.acroForm.XFA = core.MakeArray(packets...)
+	}
+
+	// Keep the original document intact (including the NeedsRendering flag of dynamic forms) by
+	// appending the changes as an incremental update.
+	appender, err := model.NewPdfAppender(pdfReader)
+	if err != nil {
+		return err
+	}
+	appender.ReplaceAcroForm(x.acroForm)
+
+	err = appender.WriteToFile(outputPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Success, output written to %s\n", outputPath)
+	return nil
+}
+
+// makeDatasets returns the XFA datasets packet for the form data in `xmlData`.
+func makeDatasets(xmlData []byte) ([]byte, error) {
+	if err := checkXML(xmlData); err != nil {
+		return nil, fmt.Errorf("invalid XML data: %v", err)
+	}
+
+	// Strip the XML declaration, the packets are parts of the XDP document.
+	data := bytes.TrimSpace(xmlData)
+	if bytes.HasPrefix(data, []byte("<?xml")) {
+		if i := bytes.Index(data, []byte("?>")); i >= 0 {
+			data = bytes.TrimSpace(data[i+2:])
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("<xfa:datasets")):
+		return data, nil
+	case bytes.HasPrefix(data, []byte("<xfa:data")):
+		return []byte(`<xfa:datasets xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/">` +
+			string(data) + `</xfa:datasets>`), nil
+	}
+	return []byte(`<xfa:datasets xmlns:xfa="http://www.xfa.org/schema/xfa-data/1.0/"><xfa:data>` +
+		string(data) + `</xfa:data></xfa:datasets>`), nil
+}
+
+// checkXML returns an error if `data` is not well-formed XML.
+func checkXML(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// xfaRemove removes the XFA form from `inputPath` and writes the result to `outputPath`. The AcroForm
+// field values are updated from the XFA form data so that the AcroForm fallback shows the same data.
+func xfaRemove(inputPath, outputPath string) error {
+	pdfReader, f, err := openPdf(inputPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	x, err := loadXFA(pdfReader)
+	if err != nil {
+		return err
+	}
+	if x == nil {
+		return errors.New("no XFA form")
+	}
+	if x.isDynamic() {
+		return fmt.Errorf("dynamic XFA only form (NeedsRendering: %v, AcroForm fields: %d) - no AcroForm "+
+			"fallback, removing XFA would leave an empty form", x.needsRendering, x.numFields)
+	}
+
+	if datasets, err := x.datasets(); err == nil {
+		synced, err := syncFieldValues(x.acroForm, datasets)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Updated %d field(s) from XFA data\n", synced)
+	}
+
+	x.acroForm.XFA = nil
+	// Have the viewer regenerate the field appearances for the updated values.
+	x.acroForm.NeedAppearances = core.MakeBool(true)
+
+	pdfWriter := model.NewPdfWriter()
+	pdfWriter.SetForms(x.acroForm)
+
+	for _, p := range pdfReader.PageList {
+		err := pdfWriter.AddPage(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	fout, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+
+	err = pdfWriter.Write(fout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Success, output written to %s\n", outputPath)
+	return nil
+}
+
+// xfaValue is a value in the XFA form data.
+type xfaValue struct {
+	path  string // Element names from the data root, separated by dots.
+	value string
+}
+
+// parseXFAData returns the values of the leaf elements in the xfa:data element of `datasets`.
+func parseXFAData(datasets []byte) ([]xfaValue, error) {
+	var values []xfaValue
+	var stack []string
+	var text strings.Builder
+	inData := false
+	hasChildren := false
+
+	decoder := xml.NewDecoder(bytes.NewReader(datasets))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if !inData {
+				inData = t.Name.Local == "data"
+				continue
+			}
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+			hasChildren = false
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if !inData {
+				continue
+			}
+			if len(stack) == 0 {
+				inData = false
+				continue
+			}
+			if !hasChildren {
+				values = append(values, xfaValue{path: strings.Join(stack, "."), value: strings.TrimSpace(text.String())})
+			}
+			stack = stack[:len(stack)-1]
+			hasChildren = true
+		}
+	}
+	return values, nil
+}
+
+// reXFAIndex matches the occurrence index in XFA based field names, e.g. the [0] in form1[0].Name[0].
+var reXFAIndex = regexp.MustCompile(`\[\d+\]`)
+
+// xfaFieldPath returns the data path corresponding to the full AcroForm field name `name` of a static
+// XFA form, e.g. form1.Name for form1[0].#subform[0].Name[0].
+func xfaFieldPath(name string) string {
+	var parts []string
+	for _, part := range strings.Split(reXFAIndex.ReplaceAllString(name, ""), ".") {
+		if part != "" && !strings.HasPrefix(part, "#") {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// syncFieldValues sets the values of the AcroForm fields in `form` from the XFA form data in `datasets`.
+// Fields are matched to data values by path or, failing that, by a unique element name.
+// Returns the number of fields updated.
+func syncFieldValues(form *model.PdfAcroForm, datasets []byte) (int, error) {
+	values, err := parseXFAData(datasets)
+	if err != nil {
+		return 0, err
+	}
+	byPath := map[string]string{}
+	byName := map[string][]string{}
+	for _, v := range values {
+		byPath[v.path] = v.value
+		name := v.path[strings.LastIndex(v.path, ".")+1:]
+		byName[name] = append(byName[name], v.value)
+	}
+
+	synced := 0
+	for _, field := range form.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		fullname, err := field.FullName()
+		if err != nil {
+			continue
+		}
+
+		path := xfaFieldPath(fullname)
+		value, has := byPath[path]
+		if !has {
+			name := path[strings.LastIndex(path, ".")+1:]
+			if len(byName[name]) != 1 {
+				common.Log.Debug("No XFA data for field %s", fullname)
+				continue
+			}
+			value = byName[name][0]
+		}
+
+		switch t := field.GetContext().(type) {
+		case *model.PdfFieldText, *model.PdfFieldChoice:
+			field.V = core.MakeEncodedString(value, true)
+		case *model.PdfFieldButton:
+			if t.IsPush() {
+				continue
+			}
+			state := buttonState(field, value)
+			field.V = core.MakeName(state)
+			for _, wa := range field.Annotations {
+				wa.AS = core.MakeName(stateOrOff(wa, state))
+			}
+		default:
+			continue
+		}
+		synced++
+	}
+	return synced, nil
+}
+
+// buttonState returns the appearance state of checkbox/radio `field` for XFA data value `value`.
+// The value is used as is if it matches a widget state, otherwise non-empty values other than 0 map
+// to the first on state.
+func buttonState(field *model.PdfField, value string) string {
+	var onState string
+	for _, wa := range field.Annotations {
+		for _, state := range widgetStates(wa) {
+			if state == value {
+				return state
+			}
+			if onState == "" {
+				onState = state
+			}
+		}
+	}
+	if value == "" || value == "0" || onState == "" {
+		return "Off"
+	}
+	return onState
+}
+
+// stateOrOff returns `state` if widget `wa` has an appearance for it, otherwise Off.
+func stateOrOff(wa *model.PdfAnnotationWidget, state string) string {
+	for _, s := range widgetStates(wa) {
+		if s == state {
+			return state
+		}
+	}
+	return "Off"
+}
+
+// widgetStates returns the names of the on appearance states of widget `wa`.
+func widgetStates(wa *model.PdfAnnotationWidget) []string {
+	apDict, ok := core.GetDict(wa.AP)
+	if !ok {
+		return nil
+	}
+	n, ok := core.GetDict(apDict.Get("N"))
+	if !ok {
+		return nil
+	}
+
+	var states []string
+	for _, key := range n.Keys() {
+		if key != "Off" {
+			states = append(states, key.String())
+		}
+	}
+	return states
+}
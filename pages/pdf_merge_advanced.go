@@ -2,14 +2,29 @@
  * Merge PDF files, including form field data (AcroForms).
  * For a more basic merging of PDF page contents, see pdf_merge.go.
  *
- * Run as: go run pdf_merge_advanced.go output.pdf input1.pdf input2.pdf input3.pdf ...
+ * Fields with the same name in different inputs are handled according to the -strategy option:
+ * - doc: the fields of each input after the first are nested under a field named docN (default).
+ * - share: fields with the same name are merged into one field with a shared value, e.g. for a "Name" field
+ *   repeated across forms.
+ * - prefix: the top-level field names of each input are prefixed with the string given for the input
+ *   in -prefix (comma separated, one per input). Fails if the names still collide.
+ * - fail: fails if any field names collide.
+ *
+ * The default resources (DR) of the forms are merged, fonts with the same name but different definitions are
+ * renamed and the default appearance (DA) strings of the fields are updated accordingly. Fields relying on the
+ * form default appearance of their input get it set explicitly if the forms' defaults differ.
+ *
+ * Run as: go run pdf_merge_advanced.go [-strategy doc|share|prefix|fail] [-prefix a_,b_,...] output.pdf input1.pdf input2.pdf ...
  */
 
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 
 	unicommon "github.com/unidoc/unipdf/v3/common"
 	"github.com/unidoc/unipdf/v3/core"
@@ -22,29 +37,47 @@ func init() {
 	//unicommon.SetLogger(unicommon.NewConsoleLogger(unicommon.LogLevelTrace))
 }
 
+// Field name collision strategies.
+const (
+	strategyDoc    = "doc"
+	strategyShare  = "share"
+	strategyPrefix = "prefix"
+	strategyFail   = "fail"
+)
+
 func main() {
-	if len(os.Args) < 4 {
+	var strategy, prefixes string
+	flag.StringVar(&strategy, "strategy", strategyDoc, "Field name collision strategy: doc, share, prefix or fail")
+	flag.StringVar(&prefixes, "prefix", "", "Comma separated field name prefixes, one per input (prefix strategy)")
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 3 {
 		fmt.Printf("Requires at least 3 arguments: output_path and 2 input paths\n")
-		fmt.Printf("Usage: go run pdf_merge.go output.pdf input1.pdf input2.pdf input3.pdf ...\n")
+		fmt.Printf("Usage: go run pdf_merge_advanced.go [options] output.pdf input1.pdf input2.pdf input3.pdf ...\n")
+		flag.PrintDefaults()
 		os.Exit(0)
 	}
 
-	outputPath := ""
-	inputPaths := []string{}
-
-	// Sanity check the input arguments.
-	for i, arg := range os.Args {
-		if i == 0 {
-			continue
-		} else if i == 1 {
-			outputPath = arg
-			continue
+	outputPath := args[0]
+	inputPaths := args[1:]
+
+	// Sanity check the options.
+	var prefixList []string
+	switch strategy {
+	case strategyDoc, strategyShare, strategyFail:
+	case strategyPrefix:
+		prefixList = strings.Split(prefixes, ",")
+		if len(prefixList) != len(inputPaths) {
+			fmt.Printf("Error: -prefix requires one prefix per input (%d), got %d\n", len(inputPaths), len(prefixList))
+			os.Exit(1)
 		}
-
-		inputPaths = append(inputPaths, arg)
+	default:
+		fmt.Printf("Error: unsupported strategy: %s\n", strategy)
+		os.Exit(1)
 	}
 
-	err := mergePdf(inputPaths, outputPath)
+	err := mergePdf(inputPaths, outputPath, strategy, prefixList)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -195,8 +228,10 @@ func mergeResources(r, r2 *pdf.PdfPageResources) (*pdf.PdfPageResources, error)
 	return r, nil
 }
 
-// Merge two interactive forms.
-func mergeForms(form, form2 *pdf.PdfAcroForm, docNum int) (*pdf.PdfAcroForm, error) {
+// Merge two interactive forms. Field name collisions are handled according to `strategy`.
+// Widget annotations replaced when sharing fields are recorded in `replaced` and need to be replaced on the pages.
+func mergeForms(form, form2 *pdf.PdfAcroForm, docNum int, strategy string,
+	replaced map[*pdf.PdfAnnotation]*pdf.PdfAnnotation) (*pdf.PdfAcroForm, error) {
 	// Use whatever value comes first..
 	// TODO: Consider adding a more intelligent, preferential handling based on actual values.  If needed.
 
@@ -212,9 +247,18 @@ func mergeForms(form, form2 *pdf.PdfAcroForm, docNum int) (*pdf.PdfAcroForm, err
 		form.CO = form2.CO
 	}
 
+	// Fields of form2 relying on the form default appearance need it set if the defaults differ.
+	if form.DA != nil && form2.DA != nil && form.DA.Str() != form2.DA.Str() {
+		setDefaultAppearance(form2)
+	}
+
 	if form.DR == nil {
 		form.DR = form2.DR
 	} else if form2.DR != nil {
+		err := renameFontCollisions(form, form2)
+		if err != nil {
+			return nil, err
+		}
 		dr, err := mergeResources(form.DR, form2.DR)
 		if err != nil {
 			return nil, err
@@ -242,7 +286,7 @@ func mergeForms(form, form2 *pdf.PdfAcroForm, docNum int) (*pdf.PdfAcroForm, err
 	// Fields.
 	if form.Fields == nil {
 		form.Fields = form2.Fields
-	} else {
+	} else if strategy == strategyDoc {
 		// Make a top-level field for the doc (non-terminal field).
 		docfield := pdf.NewPdfField()
 		docfield.T = core.MakeString(fmt.Sprintf("doc%d", docNum))
@@ -254,15 +298,294 @@ func mergeForms(form, form2 *pdf.PdfAcroForm, docNum int) (*pdf.PdfAcroForm, err
 			}
 		}
 		*form.Fields = append(*form.Fields, docfield)
+	} else if form2.Fields != nil {
+		fields, err := mergeFields(*form.Fields, nil, *form2.Fields, strategy == strategyShare, replaced)
+		if err != nil {
+			return nil, err
+		}
+		*form.Fields = fields
 	}
 
 	return form, nil
 }
 
-func mergePdf(inputPaths []string, outputPath string) error {
+// mergeFields merges `fields2` into `fields`, the kids of `parent` (nil for top-level fields).
+// Fields with the same name are merged if `share` is true (groups by merging their kids, terminal fields by
+// sharing the value), otherwise an error is returned. Widget annotations replaced while sharing fields are
+// recorded in `replaced`.
+func mergeFields(fields []*pdf.PdfField, parent *pdf.PdfField, fields2 []*pdf.PdfField, share bool,
+	replaced map[*pdf.PdfAnnotation]*pdf.PdfAnnotation) ([]*pdf.PdfField, error) {
+	for _, field2 := range fields2 {
+		var field *pdf.PdfField
+		for _, f := range fields {
+			if f.T != nil && field2.T != nil && f.T.Decoded() == field2.T.Decoded() {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			field2.Parent = parent
+			fields = append(fields, field2)
+			continue
+		}
+
+		name, _ := field.FullName()
+		if !share {
+			return nil, fmt.Errorf("field name collision: %s", name)
+		}
+
+		switch {
+		case !field.IsTerminal() && !field2.IsTerminal():
+			kids, err := mergeFields(field.Kids, field, field2.Kids, share, replaced)
+			if err != nil {
+				return nil, err
+			}
+			field.Kids = kids
+		case field.IsTerminal() && field2.IsTerminal():
+			err := shareField(field, field2, replaced)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", name, err)
+			}
+		default:
+			return nil, fmt.Errorf("field name collision: %s is a field in one form and a group in another", name)
+		}
+	}
+	return fields, nil
+}
+
+// shareField merges terminal field `field2` into `field` with the same name, so that the widgets of both
+// show the same (shared) value. The widgets of `field2` are replaced by widgets of `field`.
+func shareField(field, field2 *pdf.PdfField, replaced map[*pdf.PdfAnnotation]*pdf.PdfAnnotation) error {
+	if fieldKind(field) != fieldKind(field2) {
+		return fmt.Errorf("incompatible field types: %s and %s", fieldKind(field), fieldKind(field2))
+	}
+
+	if field.V == nil {
+		field.V = field2.V
+	} else if field2.V != nil && field.V.WriteString() != field2.V.WriteString() {
+		unicommon.Log.Debug("Shared field values differ (%s, %s) - using the first", field.V, field2.V)
+	}
+
+	// A field with a merged-in widget cannot have widget kids, split the widget from the field dictionary.
+	container := field.GetContainingPdfObject()
+	var annotations []*pdf.PdfAnnotationWidget
+	for _, wa := range field.Annotations {
+		if wa.GetContainingPdfObject() != container {
+			annotations = append(annotations, wa)
+			continue
+		}
+		annotations = append(annotations, copyWidget(wa, container, replaced))
+		if dict, ok := core.GetDict(container); ok {
+			for _, key := range widgetKeys {
+				dict.Remove(key)
+			}
+		}
+	}
+	for _, wa := range field2.Annotations {
+		annotations = append(annotations, copyWidget(wa, container, replaced))
+	}
+	field.Annotations = annotations
+
+	return nil
+}
+
+// widgetKeys are the keys of widget annotation dictionaries that are not field keys.
+var widgetKeys = []core.PdfObjectName{
+	"Type", "Subtype", "Rect", "Contents", "P", "NM", "M", "F", "AP", "AS", "Border", "C", "StructParent", "OC",
+	"H", "MK", "A", "BS",
+}
+
+// copyWidget returns a copy of widget annotation `wa` with parent field `parent`, recording the replacement of
+// the original annotation in `replaced`.
+func copyWidget(wa *pdf.PdfAnnotationWidget, parent core.PdfObject,
+	replaced map[*pdf.PdfAnnotation]*pdf.PdfAnnotation) *pdf.PdfAnnotationWidget {
+	widget := pdf.NewPdfAnnotationWidget()
+	widget.Rect = wa.Rect
+	widget.Contents = wa.Contents
+	widget.P = wa.P
+	widget.NM = wa.NM
+	widget.M = wa.M
+	widget.F = wa.F
+	widget.AP = wa.AP
+	widget.AS = wa.AS
+	widget.Border = wa.Border
+	widget.C = wa.C
+	widget.StructParent = wa.StructParent
+	widget.OC = wa.OC
+	widget.H = wa.H
+	widget.MK = wa.MK
+	widget.A = wa.A
+	widget.BS = wa.BS
+	widget.Parent = parent
+
+	replaced[wa.PdfAnnotation] = widget.PdfAnnotation
+	return widget
+}
+
+// fieldKind returns a description of the type of `field` for checking the compatibility of fields.
+func fieldKind(field *pdf.PdfField) string {
+	switch t := field.GetContext().(type) {
+	case *pdf.PdfFieldText:
+		return "text"
+	case *pdf.PdfFieldChoice:
+		return "choice"
+	case *pdf.PdfFieldSignature:
+		return "signature"
+	case *pdf.PdfFieldButton:
+		switch {
+		case t.IsRadio():
+			return "radio"
+		case t.IsPush():
+			return "pushbutton"
+		}
+		return "checkbox"
+	}
+	if field.FT != nil {
+		return field.FT.String()
+	}
+	return "unknown"
+}
+
+// prefixFields prefixes the names of the top-level fields of `form` with `prefix`.
+func prefixFields(form *pdf.PdfAcroForm, prefix string) {
+	if form.Fields == nil || prefix == "" {
+		return
+	}
+	for _, field := range *form.Fields {
+		if field.T == nil {
+			continue
+		}
+		name := prefix + field.T.Decoded()
+		field.T = core.MakeEncodedString(name, !isASCII(name))
+	}
+}
+
+// isASCII returns true if `s` only contains ASCII characters.
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// reDAFont matches the font selection (Tf operator) in a default appearance string.
+var reDAFont = regexp.MustCompile(`/([^\s/]+)(\s+[-\d.]+\s+Tf)`)
+
+// renameFontCollisions renames the fonts in the DR of `form2` that have the same name as a different font in
+// the DR of `form`, and updates the DA strings of `form2` to use the new names.
+func renameFontCollisions(form, form2 *pdf.PdfAcroForm) error {
+	fonts := getDict(form.DR.Font)
+	fonts2 := getDict(form2.DR.Font)
+	if fonts == nil || fonts2 == nil {
+		return nil
+	}
+
+	renamed := map[string]string{}
+	newFonts2 := core.MakeDict()
+	for _, key := range fonts2.Keys() {
+		val := fonts2.Get(key)
+		name := key
+		if existing := fonts.Get(key); existing != nil && !sameObject(existing, val) {
+			// Find an unused name.
+			for i := 2; ; i++ {
+				name = core.PdfObjectName(fmt.Sprintf("%s_%d", key, i))
+				if fonts.Get(name) == nil && fonts2.Get(name) == nil {
+					break
+				}
+			}
+			renamed[string(key)] = string(name)
+			unicommon.Log.Debug("Font name collision: renaming %s -> %s", key, name)
+		}
+		newFonts2.Set(name, val)
+	}
+	if len(renamed) == 0 {
+		return nil
+	}
+	form2.DR.Font = newFonts2
+
+	renameDA := func(da string) string {
+		return reDAFont.ReplaceAllStringFunc(da, func(match string) string {
+			m := reDAFont.FindStringSubmatch(match)
+			if name, has := renamed[m[1]]; has {
+				return "/" + name + m[2]
+			}
+			return match
+		})
+	}
+
+	if form2.DA != nil {
+		form2.DA = core.MakeString(renameDA(form2.DA.Str()))
+	}
+	for _, field := range form2.AllFields() {
+		if ft, ok := field.GetContext().(*pdf.PdfFieldText); ok && ft.DA != nil {
+			ft.DA = core.MakeString(renameDA(ft.DA.Str()))
+		}
+		// DA can also be set in the field or widget dictionaries without being represented in the model.
+		objs := []core.PdfObject{field.GetContainingPdfObject()}
+		for _, wa := range field.Annotations {
+			objs = append(objs, wa.GetContainingPdfObject())
+		}
+		for _, obj := range objs {
+			dict, ok := core.GetDict(obj)
+			if !ok {
+				continue
+			}
+			if da, ok := core.GetString(dict.Get("DA")); ok {
+				dict.Set("DA", core.MakeString(renameDA(da.Str())))
+			}
+		}
+	}
+	return nil
+}
+
+// sameObject returns true if `obj1` and `obj2` are the same object or have the same definition.
+func sameObject(obj1, obj2 core.PdfObject) bool {
+	if obj1 == obj2 {
+		return true
+	}
+	return core.TraceToDirectObject(obj1).WriteString() == core.TraceToDirectObject(obj2).WriteString()
+}
+
+// setDefaultAppearance sets the form default appearance string (DA) on the variable text fields of `form`
+// that have no DA of their own (or inherited from a parent field).
+func setDefaultAppearance(form *pdf.PdfAcroForm) {
+	for _, field := range form.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		switch field.GetContext().(type) {
+		case *pdf.PdfFieldText, *pdf.PdfFieldChoice:
+		default:
+			continue
+		}
+
+		hasDA := false
+		for f := field; f != nil && !hasDA; f = f.Parent {
+			if dict, ok := core.GetDict(f.GetContainingPdfObject()); ok {
+				hasDA = dict.Get("DA") != nil
+			}
+		}
+		if hasDA {
+			continue
+		}
+
+		if ft, ok := field.GetContext().(*pdf.PdfFieldText); ok {
+			ft.DA = form.DA
+		}
+		if dict, ok := core.GetDict(field.GetContainingPdfObject()); ok {
+			dict.Set("DA", form.DA)
+		}
+	}
+}
+
+func mergePdf(inputPaths []string, outputPath string, strategy string, prefixes []string) error {
 	pdfWriter := pdf.NewPdfWriter()
 
 	var forms *pdf.PdfAcroForm
+	var pages []*pdf.PdfPage
+	replaced := map[*pdf.PdfAnnotation]*pdf.PdfAnnotation{}
 
 	for docIdx, inputPath := range inputPaths {
 		f, err := os.Open(inputPath)
@@ -302,18 +625,19 @@ func mergePdf(inputPaths []string, outputPath string) error {
 				return err
 			}
 
-			err = pdfWriter.AddPage(page)
-			if err != nil {
-				return err
-			}
+			// Pages are added once the forms are merged, as merging can replace widget annotations.
+			pages = append(pages, page)
 		}
 
 		// Handle forms.
 		if pdfReader.AcroForm != nil {
+			if strategy == strategyPrefix {
+				prefixFields(pdfReader.AcroForm, prefixes[docIdx])
+			}
 			if forms == nil {
 				forms = pdfReader.AcroForm
 			} else {
-				forms, err = mergeForms(forms, pdfReader.AcroForm, docIdx+1)
+				forms, err = mergeForms(forms, pdfReader.AcroForm, docIdx+1, strategy, replaced)
 				if err != nil {
 					return err
 				}
@@ -321,6 +645,25 @@ func mergePdf(inputPaths []string, outputPath string) error {
 		}
 	}
 
+	for _, page := range pages {
+		if len(replaced) > 0 {
+			annotations, err := page.GetAnnotations()
+			if err != nil {
+				return err
+			}
+			for i, annot := range annotations {
+				if replacement, has := replaced[annot]; has {
+					annotations[i] = replacement
+				}
+			}
+		}
+
+		err := pdfWriter.AddPage(page)
+		if err != nil {
+			return err
+		}
+	}
+
 	fWrite, err := os.Create(outputPath)
 	if err != nil {
 		return err
@@ -0,0 +1,790 @@
+/*
+ * Changes the passwords, the encryption algorithm or the permissions of a PDF file protected with the standard
+ * security handler, in a single step and without losing anything from the document.
+ *
+ * Unlocking the document with pdf_unlock.go and protecting it again with pdf_protect.go copies the pages into a new
+ * document and drops the forms, outlines, attachments and structure tree. Here the owner password is checked and the
+ * file encryption key is computed with the security handler, then every object of the document is decrypted and
+ * encrypted again with the new key, and written as is. The objects in object streams are written as plain objects.
+ *
+ * Changing the security needs the owner password. Unless they are specified, the algorithm and the permissions of
+ * the document are kept, as well as whether the metadata or only the embedded files are encrypted. The permissions
+ * are set as in pdf_protect.go with -preset, -allow and -deny; -allow and -deny alone change the current
+ * permissions. The file identifier is kept. An unencrypted document is simply protected (AES 256 bit by default).
+ *
+ * Note that the document is fully rewritten, so that its signatures, if any, are no longer valid.
+ *
+ * Run as: go run pdf_change_password.go [options] input.pdf <owner-pass> <new-user-pass> <new-owner-pass> output.pdf
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/core/security"
+	"github.com/unidoc/unipdf/v3/core/security/crypt"
+	pdf "github.com/unidoc/unipdf/v3/model"
+)
+
+// permissionFlags maps the command line names of the permission flags to their bits.
+var permissionFlags = map[string]security.Permissions{
+	"print":         security.PermPrinting,          // Allow printing with low quality.
+	"print-high":    security.PermFullPrintQuality,  // Allow printing with full quality.
+	"modify":        security.PermModify,            // Allow modifications.
+	"copy":          security.PermExtractGraphics,   // Allow copying text and graphics.
+	"annotate":      security.PermAnnotate,          // Allow annotations.
+	"fill-forms":    security.PermFillForms,         // Allow filling in form fields and signing.
+	"accessibility": security.PermDisabilityExtract, // Allow extracting content for accessibility.
+	"assemble":      security.PermRotateInsert,      // Allow modifying page order, rotating pages etc.
+}
+
+// permissionPresets maps the names of the permission presets to their permission flags.
+var permissionPresets = map[string]security.Permissions{
+	"all": security.PermPrinting | security.PermFullPrintQuality | security.PermModify | security.PermExtractGraphics |
+		security.PermAnnotate | security.PermFillForms | security.PermDisabilityExtract | security.PermRotateInsert,
+	"read-only":  security.PermDisabilityExtract,
+	"print-only": security.PermPrinting | security.PermFullPrintQuality | security.PermDisabilityExtract,
+	"forms-only": security.PermFillForms | security.PermDisabilityExtract,
+	"none":       0,
+}
+
+// encryptionAlgorithms maps the command line names of the encryption algorithms to the writer algorithms.
+var encryptionAlgorithms = map[string]pdf.EncryptionAlgorithm{
+	"rc4-128": pdf.RC4_128bit,
+	"aes-128": pdf.AES_128bit,
+	"aes-256": pdf.AES_256bit,
+}
+
+// protectOptions represents the protection options of a PDF.
+type protectOptions struct {
+	permissions         security.Permissions
+	algorithm           pdf.EncryptionAlgorithm
+	embeddedFilesOnly   bool
+	unencryptedMetadata bool
+}
+
+// changeOptions represents the changes of the protection options given on the command line. Empty values keep the
+// current options.
+type changeOptions struct {
+	preset    string
+	allow     string
+	deny      string
+	algorithm string
+}
+
+func main() {
+	var changes changeOptions
+	flag.StringVar(&changes.preset, "preset", "", "Permission preset: all, read-only, print-only, forms-only or none")
+	flag.StringVar(&changes.allow, "allow", "", "Comma-separated permission flags to grant")
+	flag.StringVar(&changes.deny, "deny", "", "Comma-separated permission flags to revoke")
+	flag.StringVar(&changes.algorithm, "algorithm", "", "Encryption algorithm: rc4-128, aes-128 or aes-256")
+	flag.Usage = func() {
+		fmt.Println("Usage: go run pdf_change_password.go [options] input.pdf <owner-pass> <new-user-pass> " +
+			"<new-owner-pass> output.pdf")
+		fmt.Printf("Permission flags: %s\n", strings.Join(sortedNames(permissionFlags), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 5 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	inputPath := flag.Arg(0)
+	password := flag.Arg(1)
+	userPassword := flag.Arg(2)
+	ownerPassword := flag.Arg(3)
+	outputPath := flag.Arg(4)
+
+	err := changePassword(inputPath, outputPath, []byte(password), []byte(userPassword), []byte(ownerPassword),
+		changes)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Complete, see output file: %s\n", outputPath)
+}
+
+// changePassword decrypts the PDF file in `inputPath` with the owner password `password` and writes it to
+// `outputPath` encrypted with the new passwords `userPassword` and `ownerPassword` and the protection options
+// changed by `changes`.
+func changePassword(inputPath, outputPath string, password, userPassword, ownerPassword []byte,
+	changes changeOptions) error {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	// The parser decrypts nothing as long as the encryption is not checked, the objects are decrypted here.
+	parser, err := core.NewParser(f)
+	if err != nil {
+		return err
+	}
+	trailer := parser.GetTrailer()
+	if trailer == nil {
+		return errors.New("trailer not found")
+	}
+
+	// The first part of the file identifier is permanent and used by the key of RC4 and AES 128 bit.
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	id0 := string(id)
+	if ids, ok := core.GetArray(trailer.Get("ID")); ok && ids.Len() == 2 {
+		if s, ok := core.GetString(ids.Get(0)); ok {
+			id0 = s.Str()
+		}
+	}
+
+	var dec *decrypter
+	encryptNum := -1
+	opts := protectOptions{permissions: permissionPresets["all"] | 0xfffff0c0, algorithm: pdf.AES_256bit}
+	if obj := trailer.Get("Encrypt"); obj != nil {
+		if ref, ok := obj.(*core.PdfObjectReference); ok {
+			encryptNum = int(ref.ObjectNumber)
+		}
+		obj, err = parser.Resolve(obj)
+		if err != nil {
+			return err
+		}
+		encryptDict, ok := core.GetDict(obj)
+		if !ok {
+			return errors.New("invalid encryption dictionary")
+		}
+		dec, opts, err = newDecrypter(encryptDict, id0, password)
+		if err != nil {
+			return err
+		}
+	}
+	current := opts
+
+	if err := applyChanges(&opts, changes); err != nil {
+		return err
+	}
+	fmt.Printf("Algorithm: %s -> %s\n", algorithmName(current.algorithm), algorithmName(opts.algorithm))
+	fmt.Printf("Permissions: %s -> %s\n", permissionNames(current.permissions), permissionNames(opts.permissions))
+
+	var cf crypt.Filter
+	switch opts.algorithm {
+	case pdf.RC4_128bit:
+		if opts.embeddedFilesOnly || opts.unencryptedMetadata {
+			return errors.New("encrypting only the embedded files and leaving the metadata unencrypted require AES")
+		}
+		cf = crypt.NewFilterV2(16)
+	case pdf.AES_128bit:
+		cf = crypt.NewFilterAESV2()
+	case pdf.AES_256bit:
+		cf = crypt.NewFilterAESV3()
+	}
+	encryptDict, key, err := standardEncryption(cf, id0, userPassword, ownerPassword, opts)
+	if err != nil {
+		return err
+	}
+
+	if dec != nil {
+		if err := decryptObjects(parser, dec, encryptNum); err != nil {
+			return err
+		}
+	}
+
+	enc := &encrypter{
+		filter:   cf,
+		key:      key,
+		all:      !opts.embeddedFilesOnly,
+		metadata: !opts.unencryptedMetadata,
+	}
+	version := parser.PdfVersion()
+	if v := cf.PDFVersion(); v[0] > version.Major || v[0] == version.Major && v[1] > version.Minor {
+		version.Major, version.Minor = v[0], v[1]
+	}
+
+	fWrite, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	defer fWrite.Close()
+
+	return writeEncrypted(fWrite, parser, enc, encryptDict, encryptNum, id0, version)
+}
+
+// applyChanges applies the command line changes `changes` to the protection options `opts`.
+func applyChanges(opts *protectOptions, changes changeOptions) error {
+	if changes.algorithm != "" {
+		algo, ok := encryptionAlgorithms[changes.algorithm]
+		if !ok {
+			return fmt.Errorf("unsupported algorithm %s", changes.algorithm)
+		}
+		opts.algorithm = algo
+	}
+
+	permissions := opts.permissions
+	if changes.preset != "" {
+		preset, ok := permissionPresets[changes.preset]
+		if !ok {
+			return fmt.Errorf("unknown preset %s", changes.preset)
+		}
+		permissions = preset
+	}
+	for _, name := range splitList(changes.allow) {
+		perm, ok := permissionFlags[name]
+		if !ok {
+			return fmt.Errorf("unknown permission flag %s", name)
+		}
+		permissions |= perm
+	}
+	for _, name := range splitList(changes.deny) {
+		perm, ok := permissionFlags[name]
+		if !ok {
+			return fmt.Errorf("unknown permission flag %s", name)
+		}
+		permissions &^= perm
+	}
+	if permissions.Allowed(security.PermFullPrintQuality) && !permissions.Allowed(security.PermPrinting) {
+		fmt.Println("Warning: print-high has no effect without print")
+	}
+
+	// The reserved bits 7-8 and 13-32 must be set.
+	opts.permissions = permissions | 0xfffff0c0
+	return nil
+}
+
+// newDecrypter authenticates the owner password `password` with the standard security handler of the encryption
+// dictionary `encryptDict` and the first part of the file identifier `id0`. It returns the decrypter of the
+// document and its protection options.
+func newDecrypter(encryptDict *core.PdfObjectDictionary, id0 string, password []byte) (*decrypter, protectOptions,
+	error) {
+	var opts protectOptions
+	if filter, _ := core.GetNameVal(encryptDict.Get("Filter")); filter != "Standard" {
+		return nil, opts, fmt.Errorf("unsupported security handler %s", filter)
+	}
+	V, _ := core.GetIntVal(encryptDict.Get("V"))
+	R, _ := core.GetIntVal(encryptDict.Get("R"))
+	P, _ := core.GetIntVal(encryptDict.Get("P"))
+	length, ok := core.GetIntVal(encryptDict.Get("Length"))
+	if !ok {
+		length = 40
+	}
+
+	stdDict := &security.StdEncryptDict{
+		R:               R,
+		P:               security.Permissions(uint32(P)),
+		EncryptMetadata: true,
+	}
+	if b, ok := encryptDict.Get("EncryptMetadata").(*core.PdfObjectBool); ok {
+		stdDict.EncryptMetadata = bool(*b)
+	}
+	for key, field := range map[core.PdfObjectName]*[]byte{
+		"O": &stdDict.O, "U": &stdDict.U, "OE": &stdDict.OE, "UE": &stdDict.UE, "Perms": &stdDict.Perms,
+	} {
+		if s, ok := core.GetString(encryptDict.Get(key)); ok {
+			*field = s.Bytes()
+		}
+	}
+
+	var handler security.StdHandler
+	if R >= 5 {
+		handler = security.NewHandlerR6()
+	} else {
+		handler = security.NewHandlerR4(id0, length)
+	}
+	key, permissions, err := handler.Authenticate(stdDict, password)
+	if err != nil {
+		return nil, opts, err
+	}
+	if key == nil {
+		return nil, opts, errors.New("wrong password")
+	}
+	if permissions != security.PermOwner {
+		return nil, opts, errors.New("changing the security of the document needs the owner password")
+	}
+
+	dec := &decrypter{
+		filters:  map[string]crypt.Filter{"Identity": crypt.NewIdentity()},
+		metadata: stdDict.EncryptMetadata,
+		key:      key,
+	}
+	if V < 4 {
+		// RC4 with the key length of the encryption dictionary for everything.
+		dec.filters["StdCF"] = crypt.NewFilterV2(length / 8)
+		dec.streamFilter, dec.stringFilter, dec.embeddedFile = "StdCF", "StdCF", "StdCF"
+	} else {
+		filters, _ := core.GetDict(encryptDict.Get("CF"))
+		if filters == nil {
+			return nil, opts, errors.New("crypt filters not found")
+		}
+		for _, name := range filters.Keys() {
+			filterDict, ok := core.GetDict(filters.Get(name))
+			if !ok {
+				continue
+			}
+			var cf crypt.Filter
+			switch cfm, _ := core.GetNameVal(filterDict.Get("CFM")); cfm {
+			case "V2":
+				cf = crypt.NewFilterV2(16)
+			case "AESV2":
+				cf = crypt.NewFilterAESV2()
+			case "AESV3":
+				cf = crypt.NewFilterAESV3()
+			case "None":
+				cf = crypt.NewIdentity()
+			default:
+				return nil, opts, fmt.Errorf("unsupported crypt filter method %s", cfm)
+			}
+			dec.filters[string(name)] = cf
+		}
+		dec.streamFilter, _ = core.GetNameVal(encryptDict.Get("StmF"))
+		dec.stringFilter, _ = core.GetNameVal(encryptDict.Get("StrF"))
+		dec.embeddedFile, _ = core.GetNameVal(encryptDict.Get("EFF"))
+		if dec.streamFilter == "" {
+			dec.streamFilter = "Identity"
+		}
+		if dec.stringFilter == "" {
+			dec.stringFilter = "Identity"
+		}
+		if dec.embeddedFile == "" {
+			dec.embeddedFile = dec.streamFilter
+		}
+		for _, name := range []string{dec.streamFilter, dec.stringFilter, dec.embeddedFile} {
+			if _, ok := dec.filters[name]; !ok {
+				return nil, opts, fmt.Errorf("crypt filter %s not found", name)
+			}
+		}
+	}
+
+	// The current protection options, with the algorithm of the embedded files (the same as the one of the
+	// streams unless only the embedded files are encrypted).
+	opts.permissions = stdDict.P
+	opts.embeddedFilesOnly = dec.streamFilter == "Identity" && dec.embeddedFile != "Identity"
+	opts.unencryptedMetadata = !stdDict.EncryptMetadata
+	switch dec.filters[dec.embeddedFile].Name() {
+	case "AESV2":
+		opts.algorithm = pdf.AES_128bit
+	case "AESV3":
+		opts.algorithm = pdf.AES_256bit
+	default:
+		opts.algorithm = pdf.RC4_128bit
+	}
+	return dec, opts, nil
+}
+
+// decrypter decrypts the strings and streams of PDF objects encrypted with the standard security handler.
+type decrypter struct {
+	filters      map[string]crypt.Filter // Crypt filters by name.
+	stringFilter string
+	streamFilter string
+	embeddedFile string // Crypt filter of the embedded files.
+	metadata     bool   // The metadata streams are encrypted.
+	key          []byte // File encryption key.
+}
+
+// decryptObjects decrypts in place the objects of the PDF file read by `parser`, except the encryption dictionary
+// object `encryptNum`. The object streams are decrypted first, so that the objects they contain are then read
+// decrypted.
+func decryptObjects(parser *core.PdfParser, dec *decrypter, encryptNum int) error {
+	xrefs := parser.GetXrefTable()
+	for _, num := range parser.GetObjectNums() {
+		if num == encryptNum || xrefs.ObjectMap[num].XType != core.XrefTypeTableEntry {
+			continue
+		}
+		obj, err := parser.LookupByNumber(num)
+		if err != nil {
+			return fmt.Errorf("object %d: %v", num, err)
+		}
+		// The cross-reference streams are not encrypted.
+		if stream, ok := obj.(*core.PdfObjectStream); ok {
+			if streamType, _ := core.GetNameVal(stream.Get("Type")); streamType == "XRef" {
+				continue
+			}
+		}
+		if err := dec.decryptObject(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decryptObject decrypts the strings and stream data of the indirect object or stream `obj`.
+func (d *decrypter) decryptObject(obj core.PdfObject) error {
+	switch t := obj.(type) {
+	case *core.PdfIndirectObject:
+		o, err := d.decryptStrings(t.PdfObject, t.ObjectNumber, t.GenerationNumber)
+		if err != nil {
+			return err
+		}
+		t.PdfObject = o
+	case *core.PdfObjectStream:
+		num, gen := t.ObjectNumber, t.GenerationNumber
+		if _, err := d.decryptStrings(t.PdfObjectDictionary, num, gen); err != nil {
+			return err
+		}
+
+		filter := d.streamFilter
+		streamType, _ := core.GetNameVal(t.Get("Type"))
+		switch {
+		case streamType == "Metadata" && !d.metadata:
+			return nil
+		case streamType == "EmbeddedFile":
+			filter = d.embeddedFile
+		}
+		data, err := d.decryptBytes(t.Stream, filter, num, gen)
+		if err != nil {
+			return fmt.Errorf("object %d: %v", num, err)
+		}
+		t.Stream = data
+		t.Set("Length", core.MakeInteger(int64(len(data))))
+	}
+	return nil
+}
+
+// decryptStrings decrypts the strings of direct object `obj` of object `num` `gen` and returns the decrypted
+// object.
+func (d *decrypter) decryptStrings(obj core.PdfObject, num, gen int64) (core.PdfObject, error) {
+	switch t := obj.(type) {
+	case *core.PdfObjectString:
+		data, err := d.decryptBytes(t.Bytes(), d.stringFilter, num, gen)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %v", num, err)
+		}
+		return core.MakeStringFromBytes(data), nil
+	case *core.PdfObjectArray:
+		for i, o := range t.Elements() {
+			o, err := d.decryptStrings(o, num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(i, o)
+		}
+	case *core.PdfObjectDictionary:
+		// The Contents of signature dictionaries are not encrypted.
+		sigType, _ := core.GetNameVal(t.Get("Type"))
+		for _, k := range t.Keys() {
+			if k == "Contents" && (sigType == "Sig" || sigType == "DocTimeStamp") {
+				continue
+			}
+			o, err := d.decryptStrings(t.Get(k), num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(k, o)
+		}
+	}
+	return obj, nil
+}
+
+// decryptBytes decrypts `data` of object `num` `gen` with the crypt filter `filter`.
+func (d *decrypter) decryptBytes(data []byte, filter string, num, gen int64) ([]byte, error) {
+	cf := d.filters[filter]
+	key, err := cf.MakeKey(uint32(num), uint32(gen), d.key)
+	if err != nil {
+		return nil, err
+	}
+	return cf.DecryptBytes(append([]byte{}, data...), key)
+}
+
+// standardEncryption returns the encryption dictionary of the standard security handler with crypt filter `cf`
+// for the file identifier `id` and the passwords `userPassword` and `ownerPassword`, and the file encryption key.
+func standardEncryption(cf crypt.Filter, id string, userPassword, ownerPassword []byte,
+	opts protectOptions) (*core.PdfObjectDictionary, []byte, error) {
+	V, R := cf.HandlerVersion()
+	stdDict := &security.StdEncryptDict{
+		R:               R,
+		P:               opts.permissions,
+		EncryptMetadata: !opts.unencryptedMetadata,
+	}
+	var handler security.StdHandler
+	if R >= 5 {
+		handler = security.NewHandlerR6()
+	} else {
+		handler = security.NewHandlerR4(id, cf.KeyLength()*8)
+	}
+	key, err := handler.GenerateParams(stdDict, ownerPassword, userPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptDict := core.MakeDict()
+	encryptDict.Set("Filter", core.MakeName("Standard"))
+	encryptDict.Set("V", core.MakeInteger(int64(V)))
+	encryptDict.Set("Length", core.MakeInteger(int64(cf.KeyLength()*8)))
+	encryptDict.Set("R", core.MakeInteger(int64(R)))
+	encryptDict.Set("P", core.MakeInteger(int64(int32(stdDict.P))))
+	encryptDict.Set("O", core.MakeHexString(string(stdDict.O)))
+	encryptDict.Set("U", core.MakeHexString(string(stdDict.U)))
+	if R >= 5 {
+		encryptDict.Set("OE", core.MakeHexString(string(stdDict.OE)))
+		encryptDict.Set("UE", core.MakeHexString(string(stdDict.UE)))
+		encryptDict.Set("Perms", core.MakeHexString(string(stdDict.Perms)))
+	}
+	if V >= 4 {
+		// RC4 (V 2) has no crypt filters.
+		encryptDict.Set("EncryptMetadata", core.MakeBool(stdDict.EncryptMetadata))
+		setCryptFilter(encryptDict, "StdCF", cryptFilterDict(cf, opts), opts.embeddedFilesOnly)
+	}
+	return encryptDict, key, nil
+}
+
+// cryptFilterDict returns the crypt filter dictionary of `cf`.
+func cryptFilterDict(cf crypt.Filter, opts protectOptions) *core.PdfObjectDictionary {
+	authEvent := "DocOpen"
+	if opts.embeddedFilesOnly {
+		authEvent = "EFOpen"
+	}
+	filterDict := core.MakeDict()
+	filterDict.Set("Type", core.MakeName("CryptFilter"))
+	filterDict.Set("CFM", core.MakeName(cf.Name()))
+	filterDict.Set("AuthEvent", core.MakeName(authEvent))
+	filterDict.Set("Length", core.MakeInteger(int64(cf.KeyLength())))
+	return filterDict
+}
+
+// setCryptFilter sets the crypt filter `filterDict` named `name` in the encryption dictionary `encryptDict`, as
+// the filter of the strings and streams or, if `embeddedFilesOnly` is set, of the embedded files only.
+func setCryptFilter(encryptDict *core.PdfObjectDictionary, name string, filterDict *core.PdfObjectDictionary,
+	embeddedFilesOnly bool) {
+	filters := core.MakeDict()
+	filters.Set(core.PdfObjectName(name), filterDict)
+	encryptDict.Set("CF", filters)
+
+	defaultFilter := name
+	if embeddedFilesOnly {
+		defaultFilter = "Identity"
+		encryptDict.Set("EFF", core.MakeName(name))
+	}
+	encryptDict.Set("StmF", core.MakeName(defaultFilter))
+	encryptDict.Set("StrF", core.MakeName(defaultFilter))
+}
+
+// encrypter encrypts the strings and streams of PDF objects with a crypt filter.
+type encrypter struct {
+	filter   crypt.Filter
+	key      []byte // File encryption key.
+	all      bool   // Encrypt all strings and streams, otherwise only the embedded files.
+	metadata bool   // Encrypt the metadata streams.
+}
+
+// encryptObject encrypts the strings and stream data of the indirect object or stream `obj`.
+func (e *encrypter) encryptObject(obj core.PdfObject) error {
+	switch t := obj.(type) {
+	case *core.PdfIndirectObject:
+		if !e.all {
+			return nil
+		}
+		o, err := e.encryptStrings(t.PdfObject, t.ObjectNumber, t.GenerationNumber)
+		if err != nil {
+			return err
+		}
+		t.PdfObject = o
+	case *core.PdfObjectStream:
+		num, gen := t.ObjectNumber, t.GenerationNumber
+		if e.all {
+			if _, err := e.encryptStrings(t.PdfObjectDictionary, num, gen); err != nil {
+				return err
+			}
+		}
+
+		streamType, _ := core.GetNameVal(t.Get("Type"))
+		switch {
+		case streamType == "Metadata" && !e.metadata:
+			return nil
+		case !e.all && streamType != "EmbeddedFile":
+			return nil
+		}
+		data, err := e.encryptBytes(t.Stream, num, gen)
+		if err != nil {
+			return err
+		}
+		t.Stream = data
+		t.Set("Length", core.MakeInteger(int64(len(data))))
+	}
+	return nil
+}
+
+// encryptStrings encrypts the strings of direct object `obj` of object `num` `gen` and returns the encrypted
+// object.
+func (e *encrypter) encryptStrings(obj core.PdfObject, num, gen int64) (core.PdfObject, error) {
+	switch t := obj.(type) {
+	case *core.PdfObjectString:
+		data, err := e.encryptBytes(t.Bytes(), num, gen)
+		if err != nil {
+			return nil, err
+		}
+		return core.MakeHexString(string(data)), nil
+	case *core.PdfObjectArray:
+		for i, o := range t.Elements() {
+			o, err := e.encryptStrings(o, num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(i, o)
+		}
+	case *core.PdfObjectDictionary:
+		// The Contents of signature dictionaries are not encrypted.
+		sigType, _ := core.GetNameVal(t.Get("Type"))
+		for _, k := range t.Keys() {
+			if k == "Contents" && (sigType == "Sig" || sigType == "DocTimeStamp") {
+				continue
+			}
+			o, err := e.encryptStrings(t.Get(k), num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(k, o)
+		}
+	}
+	return obj, nil
+}
+
+// encryptBytes encrypts `data` of object `num` `gen`.
+func (e *encrypter) encryptBytes(data []byte, num, gen int64) ([]byte, error) {
+	key, err := e.filter.MakeKey(uint32(num), uint32(gen), e.key)
+	if err != nil {
+		return nil, err
+	}
+	return e.filter.EncryptBytes(append([]byte{}, data...), key)
+}
+
+// writeEncrypted writes the objects of the PDF file read by `parser`, encrypted by `enc`, to `w` as a PDF file of
+// version `version` with the encryption dictionary `encryptDict`, replacing the one in object `encryptNum`, and the
+// file identifier `id0` followed by a new one for this version of the file.
+func writeEncrypted(w io.Writer, parser *core.PdfParser, enc *encrypter, encryptDict *core.PdfObjectDictionary,
+	encryptNum int, id0 string, version core.Version) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-%d.%d\n%%\xe2\xe3\xcf\xd3\n", version.Major, version.Minor)
+
+	offsets := map[int64]int{}
+	generations := map[int64]int64{}
+	size := int64(1)
+	for _, num := range parser.GetObjectNums() {
+		if num == encryptNum {
+			continue
+		}
+		obj, err := parser.LookupByNumber(num)
+		if err != nil {
+			return fmt.Errorf("object %d: %v", num, err)
+		}
+
+		var gen int64
+		var body string
+		switch t := obj.(type) {
+		case *core.PdfIndirectObject:
+			gen = t.GenerationNumber
+			if err := enc.encryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObject.WriteString()
+		case *core.PdfObjectStream:
+			// The cross-reference and object streams are replaced by the cross-reference table.
+			if streamType, _ := core.GetNameVal(t.Get("Type")); streamType == "XRef" || streamType == "ObjStm" {
+				continue
+			}
+			gen = t.GenerationNumber
+			if err := enc.encryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObjectDictionary.WriteString() + "\nstream\n" + string(t.Stream) + "\nendstream"
+		default:
+			continue
+		}
+
+		offsets[int64(num)] = buf.Len()
+		generations[int64(num)] = gen
+		fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", num, gen, body)
+		if int64(num) >= size {
+			size = int64(num) + 1
+		}
+	}
+
+	// The encryption dictionary itself is not encrypted.
+	newEncryptNum := size
+	size++
+	offsets[newEncryptNum] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", newEncryptNum, encryptDict.WriteString())
+
+	id1 := make([]byte, 16)
+	if _, err := rand.Read(id1); err != nil {
+		return err
+	}
+	trailer := core.MakeDict()
+	trailer.Set("Size", core.MakeInteger(size))
+	old := parser.GetTrailer()
+	trailer.Set("Root", old.Get("Root"))
+	if info := old.Get("Info"); info != nil {
+		trailer.Set("Info", info)
+	}
+	trailer.Set("Encrypt", &core.PdfObjectReference{ObjectNumber: newEncryptNum})
+	trailer.Set("ID", core.MakeArray(core.MakeHexString(id0), core.MakeHexString(string(id1))))
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", size)
+	for num := int64(1); num < size; num++ {
+		if offset, ok := offsets[num]; ok {
+			fmt.Fprintf(&buf, "%010d %05d n\r\n", offset, generations[num])
+		} else {
+			fmt.Fprintf(&buf, "0000000000 00001 f\r\n")
+		}
+	}
+	fmt.Fprintf(&buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer.WriteString(), xrefOffset)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// algorithmName returns the command line name of the encryption algorithm `algorithm`.
+func algorithmName(algorithm pdf.EncryptionAlgorithm) string {
+	for name, algo := range encryptionAlgorithms {
+		if algo == algorithm {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// permissionNames returns the comma-separated names of the permission flags allowed by `permissions`.
+func permissionNames(permissions security.Permissions) string {
+	var names []string
+	for _, name := range sortedNames(permissionFlags) {
+		if permissions.Allowed(permissionFlags[name]) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// splitList returns the non-empty elements of comma-separated list `list`.
+func splitList(list string) []string {
+	var elements []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			elements = append(elements, s)
+		}
+	}
+	return elements
+}
+
+// sortedNames returns the sorted names of `m`.
+func sortedNames(m map[string]security.Permissions) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,42 +1,192 @@
 /*
- * Protects PDF files by setting a password on it. This example both sets user
- * and opening password and hard-codes the protection bits here, but easily adjusted
- * in the code here although not on the command line.
+ * Protects PDF files by setting a password on it. This example sets both the user
+ * (opening) password and the owner password, with the permissions and the encryption
+ * algorithm selected on the command line.
  *
- * The user-pass is a password required to view the file with the access specified by certain permission flags (specified
- * in the code example below), whereas the owner pass is needed to have full access to the file.
+ * The user-pass is a password required to view the file with the access specified by the permission flags, whereas
+ * the owner pass is needed to have full access to the file.
  * See pdf_check_permissions.go for an example about checking the permissions for a given PDF file.
  *
  * If anyone is supposed to be able to read the PDF under the given access restrictions, then the user password should
  * be left empty ("").
  *
- * Run as: go run pdf_protect.go input.pdf <user-pass> <owner-pass> output.pdf
+ * The permissions start from a preset:
+ * - all: all permissions (default),
+ * - read-only: viewing only, no printing, copying or changes,
+ * - print-only: viewing and printing in high quality,
+ * - forms-only: viewing, filling in form fields and signing,
+ * - none: no permissions at all.
+ * The restrictive presets keep content extraction for accessibility, so that screen readers keep working (PDF 2.0
+ * deprecates restricting it). The individual permission flags are then granted with -allow and revoked with -deny,
+ * e.g. `-preset read-only -allow print`. The flag names are: print, print-high, modify, copy, annotate, fill-forms,
+ * accessibility, assemble.
+ *
+ * The encryption algorithm is RC4 128 bit, AES 128 bit (PDF 1.6) or AES 256 bit (PDF 2.0, default).
+ *
+ * With -embedded-files-only, only the embedded files (attachments) are encrypted: the document content stays
+ * readable and viewers ask for the user password when an attachment is opened. With -unencrypted-metadata, the XMP
+ * metadata stream is left unencrypted so that it can be indexed. Both options need an AES algorithm and are not
+ * supported by the encryption of the PDF writer, so with them (and with public-key encryption below) the objects of
+ * the document are encrypted one by one and written as is, keeping the whole document (the metadata and the
+ * document attachments in particular).
+ *
+ * Instead of passwords, the document can be encrypted for known recipients with the public-key security handler
+ * (adbe.pkcs7.s5): the file encryption key is derived from a random seed, which is encrypted for each recipient
+ * certificate (RSA) in a PKCS#7 enveloped data object together with the permissions of the recipient. Only the
+ * holders of the private keys of the recipients can open the document (see pdf_unlock.go). The permissions of a
+ * recipient are set after its certificate file as a comma-separated list of presets and flags, e.g.
+ * `-recipient bob.pem=print-only,copy`, and default to the permissions of -preset, -allow and -deny. Public-key
+ * encryption needs an AES algorithm.
+ *
+ * Run as: go run pdf_protect.go [options] input.pdf <user-pass> <owner-pass> output.pdf
  * Sets a user and owner password for the PDF.
+ *
+ * Or: go run pdf_protect.go [options] -recipient CERT_FILE[=PERMISSIONS] [-recipient ...] input.pdf output.pdf
+ * Encrypts the PDF for the recipient certificates.
  */
 
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/core/security"
+	"github.com/unidoc/unipdf/v3/core/security/crypt"
 	pdf "github.com/unidoc/unipdf/v3/model"
 )
 
+// permissionFlags maps the command line names of the permission flags to their bits.
+var permissionFlags = map[string]security.Permissions{
+	"print":         security.PermPrinting,          // Allow printing with low quality.
+	"print-high":    security.PermFullPrintQuality,  // Allow printing with full quality.
+	"modify":        security.PermModify,            // Allow modifications.
+	"copy":          security.PermExtractGraphics,   // Allow copying text and graphics.
+	"annotate":      security.PermAnnotate,          // Allow annotations.
+	"fill-forms":    security.PermFillForms,         // Allow filling in form fields and signing.
+	"accessibility": security.PermDisabilityExtract, // Allow extracting content for accessibility.
+	"assemble":      security.PermRotateInsert,      // Allow modifying page order, rotating pages etc.
+}
+
+// permissionPresets maps the names of the permission presets to their permission flags.
+var permissionPresets = map[string]security.Permissions{
+	"all": security.PermPrinting | security.PermFullPrintQuality | security.PermModify | security.PermExtractGraphics |
+		security.PermAnnotate | security.PermFillForms | security.PermDisabilityExtract | security.PermRotateInsert,
+	"read-only":  security.PermDisabilityExtract,
+	"print-only": security.PermPrinting | security.PermFullPrintQuality | security.PermDisabilityExtract,
+	"forms-only": security.PermFillForms | security.PermDisabilityExtract,
+	"none":       0,
+}
+
+// encryptionAlgorithms maps the command line names of the encryption algorithms to the writer algorithms.
+var encryptionAlgorithms = map[string]pdf.EncryptionAlgorithm{
+	"rc4-128": pdf.RC4_128bit,
+	"aes-128": pdf.AES_128bit,
+	"aes-256": pdf.AES_256bit,
+}
+
+// protectOptions represents the protection options of an output PDF.
+type protectOptions struct {
+	permissions         security.Permissions
+	algorithm           pdf.EncryptionAlgorithm
+	embeddedFilesOnly   bool
+	unencryptedMetadata bool
+	recipients          []recipient
+}
+
+// recipient represents a recipient of a document encrypted with the public-key security handler.
+type recipient struct {
+	cert        *x509.Certificate
+	permissions security.Permissions
+}
+
+// recipientList is the list of -recipient values: certificate files with optional permissions.
+type recipientList []string
+
+func (l *recipientList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *recipientList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 5 {
-		fmt.Println("Usage: go run pdf_protect.go input.pdf <user-pass> <owner-pass> output.pdf")
+	var preset, allow, deny, algorithm string
+	var recipients recipientList
+	var opts protectOptions
+	flag.StringVar(&preset, "preset", "all", "Permission preset: all, read-only, print-only, forms-only or none")
+	flag.StringVar(&allow, "allow", "", "Comma-separated permission flags to grant in addition to the preset")
+	flag.StringVar(&deny, "deny", "", "Comma-separated permission flags to revoke from the preset")
+	flag.StringVar(&algorithm, "algorithm", "aes-256", "Encryption algorithm: rc4-128, aes-128 or aes-256")
+	flag.BoolVar(&opts.embeddedFilesOnly, "embedded-files-only", false, "Only encrypt the embedded files")
+	flag.BoolVar(&opts.unencryptedMetadata, "unencrypted-metadata", false, "Leave the XMP metadata unencrypted")
+	flag.Var(&recipients, "recipient", "Recipient certificate file (PEM or DER) with optional permissions, repeatable")
+	flag.Usage = func() {
+		fmt.Println("Usage: go run pdf_protect.go [options] input.pdf <user-pass> <owner-pass> output.pdf")
 		fmt.Println("Sets a user and owner password for the PDF.")
+		fmt.Println("   or: go run pdf_protect.go [options] -recipient CERT_FILE[=PERMISSIONS] input.pdf output.pdf")
+		fmt.Println("Encrypts the PDF for the recipient certificates.")
+		fmt.Printf("Permission flags: %s\n", strings.Join(sortedNames(permissionFlags), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if len(recipients) == 0 && flag.NArg() < 4 || len(recipients) > 0 && flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	inputPath := flag.Arg(0)
+	var userPassword, ownerPassword, outputPath string
+	if len(recipients) > 0 {
+		outputPath = flag.Arg(1)
+	} else {
+		userPassword = flag.Arg(1)
+		ownerPassword = flag.Arg(2)
+		outputPath = flag.Arg(3)
+	}
+
+	permissions, err := parsePermissions(preset, allow, deny)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	opts.permissions = permissions
 
-	inputPath := os.Args[1]
-	userPassword := os.Args[2]
-	ownerPassword := os.Args[3]
-	outputPath := os.Args[4]
+	for _, value := range recipients {
+		r, err := parseRecipient(value, permissions)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.recipients = append(opts.recipients, r)
+	}
+
+	algo, ok := encryptionAlgorithms[algorithm]
+	if !ok {
+		fmt.Printf("Error: unsupported algorithm %s\n", algorithm)
+		os.Exit(1)
+	}
+	opts.algorithm = algo
 
-	err := protectPdf(inputPath, outputPath, userPassword, ownerPassword)
+	err = protectPdf(inputPath, outputPath, userPassword, ownerPassword, opts)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -45,20 +195,93 @@ func main() {
 	fmt.Printf("Complete, see output file: %s\n", outputPath)
 }
 
-func protectPdf(inputPath string, outputPath string, userPassword, ownerPassword string) error {
-	pdfWriter := pdf.NewPdfWriter()
+// parsePermissions returns the permissions of the preset `preset` with the comma-separated permission flags
+// `allow` granted and `deny` revoked.
+func parsePermissions(preset, allow, deny string) (security.Permissions, error) {
+	permissions, ok := permissionPresets[preset]
+	if !ok {
+		return 0, fmt.Errorf("unknown preset %s", preset)
+	}
+	for _, name := range splitList(allow) {
+		perm, ok := permissionFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission flag %s", name)
+		}
+		permissions |= perm
+	}
+	for _, name := range splitList(deny) {
+		perm, ok := permissionFlags[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission flag %s", name)
+		}
+		permissions &^= perm
+	}
+	if permissions.Allowed(security.PermFullPrintQuality) && !permissions.Allowed(security.PermPrinting) {
+		fmt.Println("Warning: print-high has no effect without print")
+	}
+
+	// The reserved bits 7-8 and 13-32 must be set.
+	return permissions | 0xfffff0c0, nil
+}
+
+// parseRecipient parses the -recipient value `value`, a certificate file optionally followed by `=` and
+// comma-separated permission presets and flags. The recipient gets `permissions` if none are specified.
+func parseRecipient(value string, permissions security.Permissions) (recipient, error) {
+	certPath := value
+	if i := strings.LastIndex(value, "="); i >= 0 {
+		certPath = value[:i]
+		permissions = 0
+		for _, name := range splitList(value[i+1:]) {
+			if perm, ok := permissionPresets[name]; ok {
+				permissions |= perm
+			} else if perm, ok := permissionFlags[name]; ok {
+				permissions |= perm
+			} else {
+				return recipient{}, fmt.Errorf("unknown permission preset or flag %s", name)
+			}
+		}
+		permissions |= 0xfffff0c0
+	}
+
+	cert, err := loadCertificate(certPath)
+	if err != nil {
+		return recipient{}, err
+	}
+	if _, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+		return recipient{}, fmt.Errorf("recipient %s: only RSA keys are supported", certPath)
+	}
+	return recipient{cert: cert, permissions: permissions}, nil
+}
 
-	permissions := security.PermPrinting | // Allow printing with low quality
-		security.PermFullPrintQuality |
-		security.PermModify | // Allow modifications.
-		security.PermAnnotate | // Allow annotations.
-		security.PermFillForms |
-		security.PermRotateInsert | // Allow modifying page order, rotating pages etc.
-		security.PermExtractGraphics | // Allow extracting graphics.
-		security.PermDisabilityExtract // Allow extracting graphics (accessibility)
+// loadCertificate loads the PEM or DER encoded certificate in `path`.
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+	return x509.ParseCertificate(data)
+}
+
+func protectPdf(inputPath string, outputPath string, userPassword, ownerPassword string, opts protectOptions) error {
+	if opts.embeddedFilesOnly || opts.unencryptedMetadata || len(opts.recipients) > 0 {
+		return encryptPdf(inputPath, outputPath, []byte(userPassword), []byte(ownerPassword), opts)
+	}
+
+	pdfWriter := pdf.NewPdfWriter()
 
 	encryptOptions := &pdf.EncryptOptions{
-		Permissions: permissions,
+		Permissions: opts.permissions,
+		Algorithm:   opts.algorithm,
 	}
 
 	err := pdfWriter.Encrypt([]byte(userPassword), []byte(ownerPassword), encryptOptions)
@@ -119,3 +342,394 @@ func protectPdf(inputPath string, outputPath string, userPassword, ownerPassword
 
 	return nil
 }
+
+// encryptPdf encrypts all the objects of the PDF file in `inputPath` with the standard security handler, or the
+// public-key security handler if `opts` has recipients, and writes them to `outputPath`.
+func encryptPdf(inputPath, outputPath string, userPassword, ownerPassword []byte, opts protectOptions) error {
+	var cf crypt.Filter
+	switch opts.algorithm {
+	case pdf.AES_128bit:
+		cf = crypt.NewFilterAESV2()
+	case pdf.AES_256bit:
+		cf = crypt.NewFilterAESV3()
+	default:
+		return errors.New("public-key encryption, encrypting only the embedded files and leaving the metadata " +
+			"unencrypted require AES")
+	}
+
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	parser, err := core.NewParser(f)
+	if err != nil {
+		return err
+	}
+	isEncrypted, err := parser.IsEncrypted()
+	if err != nil {
+		return err
+	}
+	if isEncrypted {
+		return fmt.Errorf("The PDF is already locked (need to unlock first)")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	var encryptDict *core.PdfObjectDictionary
+	var key []byte
+	if len(opts.recipients) > 0 {
+		encryptDict, key, err = publicKeyEncryption(cf, opts)
+	} else {
+		encryptDict, key, err = standardEncryption(cf, string(id), userPassword, ownerPassword, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	enc := &encrypter{
+		filter:   cf,
+		key:      key,
+		all:      !opts.embeddedFilesOnly,
+		metadata: !opts.unencryptedMetadata,
+	}
+	version := parser.PdfVersion()
+	if v := cf.PDFVersion(); v[0] > version.Major || v[0] == version.Major && v[1] > version.Minor {
+		version.Major, version.Minor = v[0], v[1]
+	}
+
+	fWrite, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	defer fWrite.Close()
+
+	return writeEncrypted(fWrite, parser, enc, encryptDict, string(id), version)
+}
+
+// standardEncryption returns the encryption dictionary of the standard security handler with crypt filter `cf`
+// for the file identifier `id` and the passwords `userPassword` and `ownerPassword`, and the file encryption key.
+func standardEncryption(cf crypt.Filter, id string, userPassword, ownerPassword []byte,
+	opts protectOptions) (*core.PdfObjectDictionary, []byte, error) {
+	V, R := cf.HandlerVersion()
+	stdDict := &security.StdEncryptDict{
+		R:               R,
+		P:               opts.permissions,
+		EncryptMetadata: !opts.unencryptedMetadata,
+	}
+	var handler security.StdHandler
+	if R >= 5 {
+		handler = security.NewHandlerR6()
+	} else {
+		handler = security.NewHandlerR4(id, cf.KeyLength()*8)
+	}
+	key, err := handler.GenerateParams(stdDict, ownerPassword, userPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptDict := core.MakeDict()
+	encryptDict.Set("Filter", core.MakeName("Standard"))
+	encryptDict.Set("V", core.MakeInteger(int64(V)))
+	encryptDict.Set("Length", core.MakeInteger(int64(cf.KeyLength()*8)))
+	encryptDict.Set("R", core.MakeInteger(int64(R)))
+	encryptDict.Set("P", core.MakeInteger(int64(int32(stdDict.P))))
+	encryptDict.Set("O", core.MakeHexString(string(stdDict.O)))
+	encryptDict.Set("U", core.MakeHexString(string(stdDict.U)))
+	if R >= 5 {
+		encryptDict.Set("OE", core.MakeHexString(string(stdDict.OE)))
+		encryptDict.Set("UE", core.MakeHexString(string(stdDict.UE)))
+		encryptDict.Set("Perms", core.MakeHexString(string(stdDict.Perms)))
+	}
+	encryptDict.Set("EncryptMetadata", core.MakeBool(stdDict.EncryptMetadata))
+	setCryptFilter(encryptDict, "StdCF", cryptFilterDict(cf, opts), opts.embeddedFilesOnly)
+	return encryptDict, key, nil
+}
+
+// publicKeyEncryption returns the encryption dictionary of the public-key security handler (adbe.pkcs7.s5) with
+// crypt filter `cf` for the recipients of `opts`, and the file encryption key.
+func publicKeyEncryption(cf crypt.Filter, opts protectOptions) (*core.PdfObjectDictionary, []byte, error) {
+	seed := make([]byte, 20)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, nil, err
+	}
+
+	// The recipients with the same permissions share a PKCS#7 enveloped data object, containing the seed and the
+	// permissions (big-endian), which only they can decrypt.
+	var groups []security.Permissions
+	certs := map[security.Permissions][]*x509.Certificate{}
+	for _, r := range opts.recipients {
+		if _, ok := certs[r.permissions]; !ok {
+			groups = append(groups, r.permissions)
+		}
+		certs[r.permissions] = append(certs[r.permissions], r.cert)
+	}
+	pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES256CBC
+	var envelopes [][]byte
+	for _, perms := range groups {
+		content := make([]byte, 24)
+		copy(content, seed)
+		binary.BigEndian.PutUint32(content[20:], uint32(perms))
+		envelope, err := pkcs7.Encrypt(content, certs[perms])
+		if err != nil {
+			return nil, nil, err
+		}
+		envelopes = append(envelopes, envelope)
+	}
+
+	// The file encryption key is the hash (SHA-256 for AES-256, SHA-1 otherwise) of the seed and the enveloped data
+	// objects.
+	h := sha1.New()
+	if V, _ := cf.HandlerVersion(); V >= 5 {
+		h = sha256.New()
+	}
+	h.Write(seed)
+	recipients := core.MakeArray()
+	for _, envelope := range envelopes {
+		h.Write(envelope)
+		recipients.Append(core.MakeHexString(string(envelope)))
+	}
+	if opts.unencryptedMetadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	key := h.Sum(nil)[:cf.KeyLength()]
+
+	filterDict := cryptFilterDict(cf, opts)
+	filterDict.Set("Recipients", recipients)
+	filterDict.Set("EncryptMetadata", core.MakeBool(!opts.unencryptedMetadata))
+
+	V, _ := cf.HandlerVersion()
+	encryptDict := core.MakeDict()
+	encryptDict.Set("Filter", core.MakeName("Adobe.PubSec"))
+	encryptDict.Set("SubFilter", core.MakeName("adbe.pkcs7.s5"))
+	encryptDict.Set("V", core.MakeInteger(int64(V)))
+	encryptDict.Set("Length", core.MakeInteger(int64(cf.KeyLength()*8)))
+	setCryptFilter(encryptDict, "DefaultCryptFilter", filterDict, opts.embeddedFilesOnly)
+	return encryptDict, key, nil
+}
+
+// cryptFilterDict returns the crypt filter dictionary of `cf`.
+func cryptFilterDict(cf crypt.Filter, opts protectOptions) *core.PdfObjectDictionary {
+	authEvent := "DocOpen"
+	if opts.embeddedFilesOnly {
+		authEvent = "EFOpen"
+	}
+	filterDict := core.MakeDict()
+	filterDict.Set("Type", core.MakeName("CryptFilter"))
+	filterDict.Set("CFM", core.MakeName(cf.Name()))
+	filterDict.Set("AuthEvent", core.MakeName(authEvent))
+	filterDict.Set("Length", core.MakeInteger(int64(cf.KeyLength())))
+	return filterDict
+}
+
+// setCryptFilter sets the crypt filter `filterDict` named `name` in the encryption dictionary `encryptDict`, as
+// the filter of the strings and streams or, if `embeddedFilesOnly` is set, of the embedded files only (strings and
+// streams use the Identity filter, i.e. are not encrypted).
+func setCryptFilter(encryptDict *core.PdfObjectDictionary, name string, filterDict *core.PdfObjectDictionary,
+	embeddedFilesOnly bool) {
+	filters := core.MakeDict()
+	filters.Set(core.PdfObjectName(name), filterDict)
+	encryptDict.Set("CF", filters)
+
+	defaultFilter := name
+	if embeddedFilesOnly {
+		defaultFilter = "Identity"
+		encryptDict.Set("EFF", core.MakeName(name))
+	}
+	encryptDict.Set("StmF", core.MakeName(defaultFilter))
+	encryptDict.Set("StrF", core.MakeName(defaultFilter))
+}
+
+// encrypter encrypts the strings and streams of PDF objects with a crypt filter.
+type encrypter struct {
+	filter   crypt.Filter
+	key      []byte // File encryption key.
+	all      bool   // Encrypt all strings and streams, otherwise only the embedded files.
+	metadata bool   // Encrypt the metadata streams.
+}
+
+// encryptObject encrypts the strings and stream data of the indirect object or stream `obj`.
+func (e *encrypter) encryptObject(obj core.PdfObject) error {
+	switch t := obj.(type) {
+	case *core.PdfIndirectObject:
+		if !e.all {
+			return nil
+		}
+		o, err := e.encryptStrings(t.PdfObject, t.ObjectNumber, t.GenerationNumber)
+		if err != nil {
+			return err
+		}
+		t.PdfObject = o
+	case *core.PdfObjectStream:
+		num, gen := t.ObjectNumber, t.GenerationNumber
+		if e.all {
+			if _, err := e.encryptStrings(t.PdfObjectDictionary, num, gen); err != nil {
+				return err
+			}
+		}
+
+		streamType, _ := core.GetNameVal(t.Get("Type"))
+		switch {
+		case streamType == "Metadata" && !e.metadata:
+			return nil
+		case !e.all && streamType != "EmbeddedFile":
+			return nil
+		}
+		data, err := e.encryptBytes(t.Stream, num, gen)
+		if err != nil {
+			return err
+		}
+		t.Stream = data
+		t.Set("Length", core.MakeInteger(int64(len(data))))
+	}
+	return nil
+}
+
+// encryptStrings encrypts the strings of direct object `obj` of object `num` `gen` and returns the encrypted
+// object.
+func (e *encrypter) encryptStrings(obj core.PdfObject, num, gen int64) (core.PdfObject, error) {
+	switch t := obj.(type) {
+	case *core.PdfObjectString:
+		data, err := e.encryptBytes(t.Bytes(), num, gen)
+		if err != nil {
+			return nil, err
+		}
+		return core.MakeHexString(string(data)), nil
+	case *core.PdfObjectArray:
+		for i, o := range t.Elements() {
+			o, err := e.encryptStrings(o, num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(i, o)
+		}
+	case *core.PdfObjectDictionary:
+		// The Contents of signature dictionaries are not encrypted.
+		sigType, _ := core.GetNameVal(t.Get("Type"))
+		for _, k := range t.Keys() {
+			if k == "Contents" && (sigType == "Sig" || sigType == "DocTimeStamp") {
+				continue
+			}
+			o, err := e.encryptStrings(t.Get(k), num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(k, o)
+		}
+	}
+	return obj, nil
+}
+
+// encryptBytes encrypts `data` of object `num` `gen`.
+func (e *encrypter) encryptBytes(data []byte, num, gen int64) ([]byte, error) {
+	key, err := e.filter.MakeKey(uint32(num), uint32(gen), e.key)
+	if err != nil {
+		return nil, err
+	}
+	return e.filter.EncryptBytes(append([]byte{}, data...), key)
+}
+
+// writeEncrypted writes the objects of the PDF file read by `parser`, encrypted by `enc`, to `w` as a PDF file of
+// version `version` with the encryption dictionary `encryptDict` and file identifier `id`.
+func writeEncrypted(w io.Writer, parser *core.PdfParser, enc *encrypter, encryptDict *core.PdfObjectDictionary,
+	id string, version core.Version) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-%d.%d\n%%\xe2\xe3\xcf\xd3\n", version.Major, version.Minor)
+
+	offsets := map[int64]int{}
+	generations := map[int64]int64{}
+	size := int64(1)
+	for _, num := range parser.GetObjectNums() {
+		obj, err := parser.LookupByNumber(num)
+		if err != nil {
+			return err
+		}
+
+		var gen int64
+		var body string
+		switch t := obj.(type) {
+		case *core.PdfIndirectObject:
+			gen = t.GenerationNumber
+			if err := enc.encryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObject.WriteString()
+		case *core.PdfObjectStream:
+			// The cross-reference and object streams are replaced by the cross-reference table.
+			if streamType, _ := core.GetNameVal(t.Get("Type")); streamType == "XRef" || streamType == "ObjStm" {
+				continue
+			}
+			gen = t.GenerationNumber
+			if err := enc.encryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObjectDictionary.WriteString() + "\nstream\n" + string(t.Stream) + "\nendstream"
+		default:
+			continue
+		}
+
+		offsets[int64(num)] = buf.Len()
+		generations[int64(num)] = gen
+		fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", num, gen, body)
+		if int64(num) >= size {
+			size = int64(num) + 1
+		}
+	}
+
+	// The encryption dictionary itself is not encrypted.
+	encryptNum := size
+	size++
+	offsets[encryptNum] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", encryptNum, encryptDict.WriteString())
+
+	trailer := core.MakeDict()
+	trailer.Set("Size", core.MakeInteger(size))
+	if old := parser.GetTrailer(); old != nil {
+		trailer.Set("Root", old.Get("Root"))
+		if info := old.Get("Info"); info != nil {
+			trailer.Set("Info", info)
+		}
+	}
+	trailer.Set("Encrypt", &core.PdfObjectReference{ObjectNumber: encryptNum})
+	trailer.Set("ID", core.MakeArray(core.MakeHexString(id), core.MakeHexString(id)))
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", size)
+	for num := int64(1); num < size; num++ {
+		if offset, ok := offsets[num]; ok {
+			fmt.Fprintf(&buf, "%010d %05d n\r\n", offset, generations[num])
+		} else {
+			fmt.Fprintf(&buf, "0000000000 00001 f\r\n")
+		}
+	}
+	fmt.Fprintf(&buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer.WriteString(), xrefOffset)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// splitList returns the non-empty elements of comma-separated list `list`.
+func splitList(list string) []string {
+	var elements []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			elements = append(elements, s)
+		}
+	}
+	return elements
+}
+
+// sortedNames returns the sorted names of `m`.
+func sortedNames(m map[string]security.Permissions) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,450 @@
+/*
+ * Audits the security of the PDF files of directories, e.g. for document intake checks, and writes a JSON or CSV
+ * report with one entry per file.
+ *
+ * For each file the report gives:
+ * - the encryption: security handler (Standard for passwords, Adobe.PubSec for certificates), algorithm and key
+ *   length, and permissions (of the standard security handler, the ones of public-key encryption depend on the
+ *   recipient),
+ * - whether the file opens without a password (empty user password),
+ * - the active and external content: JavaScript actions, launch actions (which start applications), embedded files,
+ *   external URIs of link actions, and signatures.
+ *
+ * The objects of encrypted files are decrypted when they open without a password, otherwise only the names and
+ * structure of the objects are inspected (they are not encrypted) and the objects in object streams are skipped, so
+ * that the content is reported as partially inspected and the external URIs are not listed. Files whose embedded
+ * files only are encrypted (the streams and strings use the Identity crypt filter) open without a password and are
+ * fully inspected: only their attachments are reported as encrypted (embeddedFilesOnly).
+ *
+ * Each file gets a risk score from 0 to 100, the sum of:
+ * - launch actions: 40,
+ * - JavaScript: 30,
+ * - content not fully inspected (encrypted with a password or for recipients): 20,
+ * - embedded files: 15,
+ * - weak encryption (RC4 or keys shorter than 128 bits): 10,
+ * - external URIs: 5,
+ * and unreadable files score 50. The risk level is low below 20, medium below 50 and high from 50.
+ *
+ * Run as: go run pdf_security_audit.go [-format json|csv] [-o report_file] <DIR|FILE.pdf>...
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/core/security"
+)
+
+// permissionFlags maps the names of the permission flags to their bits.
+var permissionFlags = map[string]security.Permissions{
+	"print":         security.PermPrinting,          // Allow printing with low quality.
+	"print-high":    security.PermFullPrintQuality,  // Allow printing with full quality.
+	"modify":        security.PermModify,            // Allow modifications.
+	"copy":          security.PermExtractGraphics,   // Allow copying text and graphics.
+	"annotate":      security.PermAnnotate,          // Allow annotations.
+	"fill-forms":    security.PermFillForms,         // Allow filling in form fields and signing.
+	"accessibility": security.PermDisabilityExtract, // Allow extracting content for accessibility.
+	"assemble":      security.PermRotateInsert,      // Allow modifying page order, rotating pages etc.
+}
+
+// auditReport represents the security audit of a PDF file.
+type auditReport struct {
+	File                 string   `json:"file"`
+	Error                string   `json:"error,omitempty"`
+	Encrypted            bool     `json:"encrypted"`
+	Handler              string   `json:"handler,omitempty"`
+	SubFilter            string   `json:"subFilter,omitempty"`
+	Algorithm            string   `json:"algorithm,omitempty"`
+	KeyLength            int      `json:"keyLength,omitempty"` // In bits.
+	EmbeddedFilesOnly    bool     `json:"embeddedFilesOnly,omitempty"`
+	Permissions          []string `json:"permissions"`
+	OpensWithoutPassword bool     `json:"opensWithoutPassword"`
+	FullyInspected       bool     `json:"fullyInspected"`
+	JavaScript           int      `json:"javaScript"`
+	LaunchActions        int      `json:"launchActions"`
+	EmbeddedFiles        int      `json:"embeddedFiles"`
+	ExternalURIs         []string `json:"externalURIs"`
+	Signatures           int      `json:"signatures"`
+	RiskScore            int      `json:"riskScore"`
+	RiskLevel            string   `json:"riskLevel"`
+	RiskReasons          []string `json:"riskReasons"`
+}
+
+func main() {
+	var format, outputPath string
+	flag.StringVar(&format, "format", "json", "Report format: json or csv")
+	flag.StringVar(&outputPath, "o", "", "Report file (default: standard output)")
+	flag.Usage = func() {
+		fmt.Printf("Usage: go run pdf_security_audit.go [-format json|csv] [-o report_file] <DIR|FILE.pdf>...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if format != "json" && format != "csv" {
+		fmt.Printf("Error: unsupported format %s\n", format)
+		os.Exit(1)
+	}
+
+	paths, err := findPdfFiles(flag.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var reports []*auditReport
+	for _, path := range paths {
+		reports = append(reports, auditPdf(path))
+	}
+
+	w := os.Stdout
+	if outputPath != "" {
+		w, err = os.Create(outputPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+	}
+	if format == "csv" {
+		err = writeCSV(w, reports)
+	} else {
+		err = writeJSON(w, reports)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// findPdfFiles returns the files of `args` and the PDF files of the directories of `args` and their
+// subdirectories, sorted.
+func findPdfFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		err := filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == arg && !info.IsDir() || info.Mode().IsRegular() && strings.EqualFold(filepath.Ext(path), ".pdf") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// auditPdf returns the security audit of the PDF file in `path`.
+func auditPdf(path string) *auditReport {
+	report := &auditReport{File: path, Permissions: []string{}, ExternalURIs: []string{}}
+	if err := audit(path, report); err != nil {
+		report.Error = err.Error()
+	}
+	scoreRisk(report)
+	return report
+}
+
+// audit fills `report` with the encryption and the content of the PDF file in `path`.
+func audit(path string, report *auditReport) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	parser, err := core.NewParser(f)
+	if err != nil {
+		return err
+	}
+	trailer := parser.GetTrailer()
+	if trailer == nil {
+		return fmt.Errorf("trailer not found")
+	}
+
+	report.Permissions = permissionNames(security.PermOwner)
+	report.OpensWithoutPassword = true
+	if obj := trailer.Get("Encrypt"); obj != nil {
+		obj, err := parser.Resolve(obj)
+		if err != nil {
+			return err
+		}
+		encryptDict, ok := core.GetDict(obj)
+		if !ok {
+			return fmt.Errorf("invalid encryption dictionary")
+		}
+		auditEncryption(encryptDict, report)
+		if report.EmbeddedFilesOnly {
+			// The document opens without a password and only the embedded file streams are encrypted: the
+			// objects are inspected as they are.
+			auditObjects(parser, report)
+			return nil
+		}
+
+		// The parser only decrypts the objects once the encryption is checked and the password authenticated,
+		// which the library supports for the standard security handler.
+		report.OpensWithoutPassword = false
+		if report.Handler == "Standard" {
+			if _, err := parser.IsEncrypted(); err != nil {
+				return err
+			}
+			report.OpensWithoutPassword, err = parser.Decrypt([]byte(""))
+			if err != nil {
+				return err
+			}
+		}
+		if !report.OpensWithoutPassword {
+			// Inspect the objects as they are, without decryption.
+			if parser, err = core.NewParser(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	auditObjects(parser, report)
+	return nil
+}
+
+// auditEncryption fills `report` with the security handler, algorithm, key length and permissions of the
+// encryption dictionary `encryptDict`.
+func auditEncryption(encryptDict *core.PdfObjectDictionary, report *auditReport) {
+	report.Encrypted = true
+	report.Handler, _ = core.GetNameVal(encryptDict.Get("Filter"))
+	report.SubFilter, _ = core.GetNameVal(encryptDict.Get("SubFilter"))
+	V, _ := core.GetIntVal(encryptDict.Get("V"))
+	length, ok := core.GetIntVal(encryptDict.Get("Length"))
+	if !ok {
+		length = 40
+	}
+
+	report.Algorithm = "RC4"
+	report.KeyLength = length
+	if V == 1 {
+		report.KeyLength = 40
+	}
+	if V >= 4 {
+		// The algorithm of the crypt filter of the streams, or of the embedded files if only they are encrypted
+		// (the streams and strings use the Identity filter, which is the default).
+		stmF, strF := "Identity", "Identity"
+		if name, isName := core.GetNameVal(encryptDict.Get("StmF")); isName {
+			stmF = name
+		}
+		if name, isName := core.GetNameVal(encryptDict.Get("StrF")); isName {
+			strF = name
+		}
+		eff, isName := core.GetNameVal(encryptDict.Get("EFF"))
+		if isName && stmF == "Identity" && strF == "Identity" && eff != "Identity" {
+			report.EmbeddedFilesOnly = true
+			stmF = eff
+		}
+		var cfm string
+		if filters, ok := core.GetDict(encryptDict.Get("CF")); ok {
+			if filterDict, ok := core.GetDict(filters.Get(core.PdfObjectName(stmF))); ok {
+				cfm, _ = core.GetNameVal(filterDict.Get("CFM"))
+			}
+		}
+		switch cfm {
+		case "AESV2":
+			report.Algorithm, report.KeyLength = "AES", 128
+		case "AESV3":
+			report.Algorithm, report.KeyLength = "AES", 256
+		case "V2":
+			if !ok {
+				report.KeyLength = 128
+			}
+		default:
+			report.Algorithm, report.KeyLength = "None", 0
+		}
+	}
+
+	// The permissions of the public-key security handler are in the envelopes of the recipients.
+	report.Permissions = []string{}
+	if report.Handler == "Standard" {
+		P, _ := core.GetIntVal(encryptDict.Get("P"))
+		report.Permissions = permissionNames(security.Permissions(uint32(P)))
+	}
+}
+
+// auditObjects fills `report` with the JavaScript, launch actions, embedded files, external URIs and signatures
+// of the objects read by `parser`.
+func auditObjects(parser *core.PdfParser, report *auditReport) {
+	report.FullyInspected = true
+	uris := map[string]bool{}
+	for _, num := range parser.GetObjectNums() {
+		obj, err := parser.LookupByNumber(num)
+		if err != nil {
+			// Objects in encrypted object streams.
+			report.FullyInspected = false
+			continue
+		}
+		switch t := obj.(type) {
+		case *core.PdfIndirectObject:
+			auditObject(t.PdfObject, report, uris)
+		case *core.PdfObjectStream:
+			if streamType, _ := core.GetNameVal(t.Get("Type")); streamType == "EmbeddedFile" {
+				report.EmbeddedFiles++
+			}
+			auditObject(t.PdfObjectDictionary, report, uris)
+		}
+	}
+	if report.Encrypted && !report.OpensWithoutPassword {
+		// The strings, and the URIs in particular, are encrypted.
+		report.FullyInspected = false
+		uris = map[string]bool{}
+	}
+
+	for uri := range uris {
+		report.ExternalURIs = append(report.ExternalURIs, uri)
+	}
+	sort.Strings(report.ExternalURIs)
+}
+
+// auditObject adds the actions and signatures of direct object `obj` to `report` and the URIs to `uris`.
+func auditObject(obj core.PdfObject, report *auditReport, uris map[string]bool) {
+	switch t := obj.(type) {
+	case *core.PdfObjectArray:
+		for _, o := range t.Elements() {
+			auditObject(o, report, uris)
+		}
+	case *core.PdfObjectDictionary:
+		action, _ := core.GetNameVal(t.Get("S"))
+		switch action {
+		case "JavaScript":
+			report.JavaScript++
+		case "Launch":
+			report.LaunchActions++
+		case "URI":
+			if uri, ok := core.GetString(t.Get("URI")); ok {
+				uris[uri.Str()] = true
+			}
+		}
+		dictType, _ := core.GetNameVal(t.Get("Type"))
+		if (dictType == "Sig" || dictType == "DocTimeStamp") && t.Get("ByteRange") != nil {
+			report.Signatures++
+		}
+		for _, key := range t.Keys() {
+			auditObject(t.Get(key), report, uris)
+		}
+	}
+}
+
+// scoreRisk sets the risk score, level and reasons of `report`.
+func scoreRisk(report *auditReport) {
+	report.RiskReasons = []string{}
+	add := func(score int, reason string) {
+		report.RiskScore += score
+		report.RiskReasons = append(report.RiskReasons, reason)
+	}
+
+	if report.Error != "" {
+		add(50, "unreadable file")
+	} else {
+		if report.LaunchActions > 0 {
+			add(40, "launch actions")
+		}
+		if report.JavaScript > 0 {
+			add(30, "JavaScript")
+		}
+		if !report.FullyInspected {
+			add(20, "content not fully inspected")
+		}
+		if report.EmbeddedFiles > 0 {
+			add(15, "embedded files")
+		}
+		if report.Encrypted && (report.Algorithm == "RC4" || report.KeyLength < 128) {
+			add(10, "weak encryption")
+		}
+		if len(report.ExternalURIs) > 0 {
+			add(5, "external URIs")
+		}
+	}
+	if report.RiskScore > 100 {
+		report.RiskScore = 100
+	}
+
+	switch {
+	case report.RiskScore < 20:
+		report.RiskLevel = "low"
+	case report.RiskScore < 50:
+		report.RiskLevel = "medium"
+	default:
+		report.RiskLevel = "high"
+	}
+}
+
+// permissionNames returns the sorted names of the permission flags allowed by `permissions`.
+func permissionNames(permissions security.Permissions) []string {
+	names := []string{}
+	for name, perm := range permissionFlags {
+		if permissions.Allowed(perm) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeJSON writes `reports` to `w` as a JSON array.
+func writeJSON(w io.Writer, reports []*auditReport) error {
+	if reports == nil {
+		reports = []*auditReport{}
+	}
+	data, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeCSV writes `reports` to `w` as CSV records with a header, the lists being separated by semicolons.
+func writeCSV(w io.Writer, reports []*auditReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"file", "encrypted", "handler", "algorithm", "key_length", "embedded_files_only", "permissions",
+		"opens_without_password", "fully_inspected", "javascript", "launch_actions", "embedded_files", "external_uris", "signatures",
+		"risk_score", "risk_level", "risk_reasons", "error",
+	})
+	for _, r := range reports {
+		cw.Write([]string{
+			r.File,
+			strconv.FormatBool(r.Encrypted),
+			r.Handler,
+			r.Algorithm,
+			strconv.Itoa(r.KeyLength),
+			strconv.FormatBool(r.EmbeddedFilesOnly),
+			strings.Join(r.Permissions, ";"),
+			strconv.FormatBool(r.OpensWithoutPassword),
+			strconv.FormatBool(r.FullyInspected),
+			strconv.Itoa(r.JavaScript),
+			strconv.Itoa(r.LaunchActions),
+			strconv.Itoa(r.EmbeddedFiles),
+			strings.Join(r.ExternalURIs, ";"),
+			strconv.Itoa(r.Signatures),
+			strconv.Itoa(r.RiskScore),
+			r.RiskLevel,
+			strings.Join(r.RiskReasons, ";"),
+			r.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
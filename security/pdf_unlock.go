@@ -2,29 +2,68 @@
  * Unlocks PDF files, tries to decrypt encrypted documents with the given password,
  * if that fails it tries an empty password as best effort.
  *
+ * Documents encrypted for recipient certificates with the public-key security handler (adbe.pkcs7.s5, see
+ * pdf_protect.go) are decrypted with the private key of a recipient, from a PKCS#12 file or a PEM file (with the
+ * certificate in the same file or in the -cert file). The password is then the password of the key file. The file
+ * encryption key is derived from the seed of the PKCS#7 enveloped data object of the recipient, and the objects of
+ * the document are decrypted one by one and written as is, keeping the whole document. Documents with object
+ * streams are not supported as the object streams themselves are encrypted.
+ *
  * Run as: go run pdf_unlock.go input.pdf <password> output.pdf
+ *
+ * Or: go run pdf_unlock.go -key <key.p12|key.pem> [-cert cert.pem] input.pdf <key-password> output.pdf
  */
 
 package main
 
 import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/core/security"
+	"github.com/unidoc/unipdf/v3/core/security/crypt"
 	pdf "github.com/unidoc/unipdf/v3/model"
+	"golang.org/x/crypto/pkcs12"
 )
 
 func main() {
-	if len(os.Args) < 4 {
+	var keyPath, certPath string
+	flag.StringVar(&keyPath, "key", "", "Recipient private key file (PKCS#12 or PEM) for public-key encrypted files")
+	flag.StringVar(&certPath, "cert", "", "Recipient certificate file (PEM or DER), if not in the key file")
+	flag.Usage = func() {
 		fmt.Printf("Usage: go run pdf_unlock.go input.pdf <password> output.pdf\n")
+		fmt.Printf("   or: go run pdf_unlock.go -key <key.p12|key.pem> [-cert cert.pem] input.pdf <key-password> output.pdf\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	inputPath := os.Args[1]
-	password := os.Args[2]
-	outputPath := os.Args[3]
+	inputPath := flag.Arg(0)
+	password := flag.Arg(1)
+	outputPath := flag.Arg(2)
 
-	err := unlockPdf(inputPath, outputPath, password)
+	var err error
+	if keyPath != "" {
+		err = unlockPdfWithKey(inputPath, outputPath, keyPath, certPath, password)
+	} else {
+		err = unlockPdf(inputPath, outputPath, password)
+	}
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -97,3 +136,414 @@ func unlockPdf(inputPath string, outputPath string, password string) error {
 
 	return nil
 }
+
+// unlockPdfWithKey decrypts the PDF file in `inputPath`, encrypted with the public-key security handler, with the
+// recipient key in `keyPath` protected by `password` and writes it to `outputPath`.
+func unlockPdfWithKey(inputPath, outputPath, keyPath, certPath, password string) error {
+	key, cert, err := loadRecipientKey(keyPath, certPath, password)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	// The parser decrypts nothing as long as the encryption is not checked.
+	parser, err := core.NewParser(f)
+	if err != nil {
+		return err
+	}
+	trailer := parser.GetTrailer()
+	if trailer == nil {
+		return errors.New("trailer not found")
+	}
+	encryptRef, ok := trailer.Get("Encrypt").(*core.PdfObjectReference)
+	if !ok {
+		return errors.New("the PDF is not encrypted")
+	}
+	obj, err := parser.LookupByReference(*encryptRef)
+	if err != nil {
+		return err
+	}
+	encryptDict, ok := core.GetDict(obj)
+	if !ok {
+		return errors.New("invalid encryption dictionary")
+	}
+	if filter, _ := core.GetNameVal(encryptDict.Get("Filter")); filter != "Adobe.PubSec" {
+		return fmt.Errorf("unsupported security handler %s, unlock with a password", filter)
+	}
+	if subFilter, _ := core.GetNameVal(encryptDict.Get("SubFilter")); subFilter != "adbe.pkcs7.s5" {
+		return fmt.Errorf("unsupported public-key security handler %s", subFilter)
+	}
+
+	dec, err := newDecrypter(encryptDict, key, cert)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recipient %s, permissions 0x%08x\n", cert.Subject.CommonName, uint32(dec.permissions))
+
+	fWrite, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+
+	defer fWrite.Close()
+
+	return writeDecrypted(fWrite, parser, dec, encryptRef.ObjectNumber)
+}
+
+// decrypter decrypts the strings and streams of PDF objects encrypted with the public-key security handler.
+type decrypter struct {
+	filters      map[string]crypt.Filter // Crypt filters by name.
+	stringFilter string
+	streamFilter string
+	embeddedFile string // Crypt filter of the embedded files.
+	metadata     bool   // The metadata streams are encrypted.
+	key          []byte // File encryption key.
+	permissions  security.Permissions
+	seed         []byte
+}
+
+// newDecrypter returns the decrypter of the public-key encryption dictionary `encryptDict` for the recipient
+// private key `key` and certificate `cert`.
+func newDecrypter(encryptDict *core.PdfObjectDictionary, key *rsa.PrivateKey,
+	cert *x509.Certificate) (*decrypter, error) {
+	V, _ := core.GetIntVal(encryptDict.Get("V"))
+	if V != 4 && V != 5 {
+		return nil, fmt.Errorf("unsupported encryption version %d", V)
+	}
+
+	dec := &decrypter{
+		filters:  map[string]crypt.Filter{"Identity": crypt.NewIdentity()},
+		metadata: true,
+	}
+	dec.streamFilter, _ = core.GetNameVal(encryptDict.Get("StmF"))
+	dec.stringFilter, _ = core.GetNameVal(encryptDict.Get("StrF"))
+	dec.embeddedFile, _ = core.GetNameVal(encryptDict.Get("EFF"))
+	if dec.embeddedFile == "" {
+		dec.embeddedFile = dec.streamFilter
+	}
+
+	// The recipients and the key length are in the crypt filters.
+	var recipients [][]byte
+	keyLength := 0
+	filters, _ := core.GetDict(encryptDict.Get("CF"))
+	if filters == nil {
+		return nil, errors.New("crypt filters not found")
+	}
+	for _, name := range filters.Keys() {
+		filterDict, ok := core.GetDict(filters.Get(name))
+		if !ok {
+			continue
+		}
+		var cf crypt.Filter
+		switch cfm, _ := core.GetNameVal(filterDict.Get("CFM")); cfm {
+		case "AESV2":
+			cf = crypt.NewFilterAESV2()
+		case "AESV3":
+			cf = crypt.NewFilterAESV3()
+		case "None":
+			cf = crypt.NewIdentity()
+		default:
+			return nil, fmt.Errorf("unsupported crypt filter method %s", cfm)
+		}
+		dec.filters[string(name)] = cf
+		if cf.KeyLength() > keyLength {
+			keyLength = cf.KeyLength()
+		}
+
+		if arr, ok := core.GetArray(filterDict.Get("Recipients")); ok && recipients == nil {
+			for _, obj := range arr.Elements() {
+				if str, ok := core.GetString(obj); ok {
+					recipients = append(recipients, str.Bytes())
+				}
+			}
+			if b, ok := filterDict.Get("EncryptMetadata").(*core.PdfObjectBool); ok {
+				dec.metadata = bool(*b)
+			}
+		}
+	}
+	for _, name := range []string{dec.streamFilter, dec.stringFilter, dec.embeddedFile} {
+		if _, ok := dec.filters[name]; !ok {
+			return nil, fmt.Errorf("crypt filter %s not found", name)
+		}
+	}
+
+	// The seed and the permissions are in the PKCS#7 enveloped data object of the recipient.
+	for _, data := range recipients {
+		p7, err := pkcs7.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		content, err := p7.Decrypt(cert, key)
+		if err != nil {
+			continue
+		}
+		if len(content) < 24 {
+			return nil, errors.New("invalid recipient seed")
+		}
+		dec.seed = content[:20]
+		dec.permissions = security.Permissions(binary.BigEndian.Uint32(content[20:24]))
+		break
+	}
+	if dec.seed == nil {
+		return nil, errors.New("the key is not one of the recipients of the document")
+	}
+
+	// The file encryption key is the hash (SHA-256 for AES-256, SHA-1 otherwise) of the seed and the enveloped
+	// data objects.
+	h := sha1.New()
+	if V == 5 {
+		h = sha256.New()
+	}
+	h.Write(dec.seed)
+	for _, data := range recipients {
+		h.Write(data)
+	}
+	if !dec.metadata {
+		h.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	}
+	dec.key = h.Sum(nil)[:keyLength]
+	return dec, nil
+}
+
+// decryptObject decrypts the strings and stream data of the indirect object or stream `obj`.
+func (d *decrypter) decryptObject(obj core.PdfObject) error {
+	switch t := obj.(type) {
+	case *core.PdfIndirectObject:
+		o, err := d.decryptStrings(t.PdfObject, t.ObjectNumber, t.GenerationNumber)
+		if err != nil {
+			return err
+		}
+		t.PdfObject = o
+	case *core.PdfObjectStream:
+		num, gen := t.ObjectNumber, t.GenerationNumber
+		if _, err := d.decryptStrings(t.PdfObjectDictionary, num, gen); err != nil {
+			return err
+		}
+
+		filter := d.streamFilter
+		streamType, _ := core.GetNameVal(t.Get("Type"))
+		switch {
+		case streamType == "Metadata" && !d.metadata:
+			return nil
+		case streamType == "EmbeddedFile":
+			filter = d.embeddedFile
+		}
+		data, err := d.decryptBytes(t.Stream, filter, num, gen)
+		if err != nil {
+			return fmt.Errorf("object %d: %v", num, err)
+		}
+		t.Stream = data
+		t.Set("Length", core.MakeInteger(int64(len(data))))
+	}
+	return nil
+}
+
+// decryptStrings decrypts the strings of direct object `obj` of object `num` `gen` and returns the decrypted
+// object.
+func (d *decrypter) decryptStrings(obj core.PdfObject, num, gen int64) (core.PdfObject, error) {
+	switch t := obj.(type) {
+	case *core.PdfObjectString:
+		data, err := d.decryptBytes(t.Bytes(), d.stringFilter, num, gen)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %v", num, err)
+		}
+		return core.MakeStringFromBytes(data), nil
+	case *core.PdfObjectArray:
+		for i, o := range t.Elements() {
+			o, err := d.decryptStrings(o, num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(i, o)
+		}
+	case *core.PdfObjectDictionary:
+		// The Contents of signature dictionaries are not encrypted.
+		sigType, _ := core.GetNameVal(t.Get("Type"))
+		for _, k := range t.Keys() {
+			if k == "Contents" && (sigType == "Sig" || sigType == "DocTimeStamp") {
+				continue
+			}
+			o, err := d.decryptStrings(t.Get(k), num, gen)
+			if err != nil {
+				return nil, err
+			}
+			t.Set(k, o)
+		}
+	}
+	return obj, nil
+}
+
+// decryptBytes decrypts `data` of object `num` `gen` with the crypt filter `filter`.
+func (d *decrypter) decryptBytes(data []byte, filter string, num, gen int64) ([]byte, error) {
+	cf := d.filters[filter]
+	key, err := cf.MakeKey(uint32(num), uint32(gen), d.key)
+	if err != nil {
+		return nil, err
+	}
+	return cf.DecryptBytes(append([]byte{}, data...), key)
+}
+
+// writeDecrypted writes the objects of the PDF file read by `parser`, decrypted by `dec`, to `w` without the
+// encryption dictionary object `encryptNum`.
+func writeDecrypted(w io.Writer, parser *core.PdfParser, dec *decrypter, encryptNum int64) error {
+	var buf bytes.Buffer
+	version := parser.PdfVersion()
+	fmt.Fprintf(&buf, "%%PDF-%d.%d\n%%\xe2\xe3\xcf\xd3\n", version.Major, version.Minor)
+
+	offsets := map[int64]int{}
+	generations := map[int64]int64{}
+	size := int64(1)
+	for _, num := range parser.GetObjectNums() {
+		if int64(num) == encryptNum {
+			continue
+		}
+		obj, err := parser.LookupByNumber(num)
+		if err != nil {
+			return fmt.Errorf("object %d: %v", num, err)
+		}
+
+		var gen int64
+		var body string
+		switch t := obj.(type) {
+		case *core.PdfIndirectObject:
+			gen = t.GenerationNumber
+			if err := dec.decryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObject.WriteString()
+		case *core.PdfObjectStream:
+			// The cross-reference streams are replaced by the cross-reference table.
+			streamType, _ := core.GetNameVal(t.Get("Type"))
+			if streamType == "XRef" {
+				continue
+			}
+			if streamType == "ObjStm" {
+				return errors.New("object streams are not supported")
+			}
+			gen = t.GenerationNumber
+			if err := dec.decryptObject(t); err != nil {
+				return err
+			}
+			body = t.PdfObjectDictionary.WriteString() + "\nstream\n" + string(t.Stream) + "\nendstream"
+		default:
+			continue
+		}
+
+		offsets[int64(num)] = buf.Len()
+		generations[int64(num)] = gen
+		fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", num, gen, body)
+		if int64(num) >= size {
+			size = int64(num) + 1
+		}
+	}
+
+	trailer := core.MakeDict()
+	trailer.Set("Size", core.MakeInteger(size))
+	old := parser.GetTrailer()
+	for _, key := range []core.PdfObjectName{"Root", "Info", "ID"} {
+		if obj := old.Get(key); obj != nil {
+			trailer.Set(key, obj)
+		}
+	}
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", size)
+	for num := int64(1); num < size; num++ {
+		if offset, ok := offsets[num]; ok {
+			fmt.Fprintf(&buf, "%010d %05d n\r\n", offset, generations[num])
+		} else {
+			fmt.Fprintf(&buf, "0000000000 00001 f\r\n")
+		}
+	}
+	fmt.Fprintf(&buf, "trailer\n%s\nstartxref\n%d\n%%%%EOF\n", trailer.WriteString(), xrefOffset)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// loadRecipientKey loads the RSA private key and the certificate of a recipient from the PKCS#12 or PEM file in
+// `keyPath` protected by `password`, and the certificate file in `certPath` if set.
+func loadRecipientKey(keyPath, certPath, password string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var blocks []*pem.Block
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			blocks = append(blocks, block)
+		}
+	} else {
+		blocks, err = pkcs12.ToPEM(data, password)
+		if err == pkcs12.ErrIncorrectPassword {
+			return nil, nil, fmt.Errorf("%s: wrong password", keyPath)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", keyPath, err)
+		}
+	}
+	if certPath != "" {
+		certData, err := ioutil.ReadFile(certPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if block, _ := pem.Decode(certData); block != nil {
+			blocks = append(blocks, block)
+		} else {
+			blocks = append(blocks, &pem.Block{Type: "CERTIFICATE", Bytes: certData})
+		}
+	}
+
+	var key *rsa.PrivateKey
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "RSA PRIVATE KEY", "PRIVATE KEY":
+			der := block.Bytes
+			if x509.IsEncryptedPEMBlock(block) {
+				der, err = x509.DecryptPEMBlock(block, []byte(password))
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", keyPath, err)
+				}
+			}
+			var parsed interface{}
+			parsed, err = x509.ParsePKCS1PrivateKey(der)
+			if err != nil {
+				if parsed, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+					return nil, nil, fmt.Errorf("%s: invalid private key", keyPath)
+				}
+			}
+			rsaKey, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("%s: only RSA keys are supported", keyPath)
+			}
+			key = rsaKey
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, nil, fmt.Errorf("%s: encrypted PKCS#8 keys are not supported, use a PKCS#12 file", keyPath)
+		}
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("%s: no private key found", keyPath)
+	}
+
+	// The certificate of the key identifies the recipient (issuer and serial number).
+	for _, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && pub.N.Cmp(key.N) == 0 && pub.E == key.E {
+			return key, cert, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%s: no certificate of the private key found, specify it with -cert", keyPath)
+}
@@ -0,0 +1,647 @@
+/*
+ * This example showcases how to create a visible signature appearance with a handwritten signature image
+ * (PNG with transparency), a company logo and the signature details text.
+ *
+ * The layout places the signature image left of the text (image-left), above the text (image-top), or
+ * shows only the image or only the text. The logo is drawn faded in the background. The font size is
+ * automatically fitted to the signature rectangle unless specified.
+ *
+ * The signature is placed at, in order of precedence:
+ * - the existing empty signature field named by -field (the field is signed in place),
+ * - the signature line ("_____" text) detected in the document (-detect),
+ * - the rectangle -rect on page -page.
+ *
+ * The file is signed using a generated private/public key pair.
+ *
+ * $ ./pdf_sign_appearance_image [options] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ */
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unipdf/v3/common"
+	"github.com/unidoc/unipdf/v3/contentstream"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+	"github.com/unidoc/unipdf/v3/model/sighandler"
+)
+
+var now = time.Now()
+
+const usage = "Usage: %s [options] INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+
+// Signature appearance layouts.
+const (
+	layoutImageLeft = "image-left"
+	layoutImageTop  = "image-top"
+	layoutImageOnly = "image-only"
+	layoutTextOnly  = "text-only"
+)
+
+// appearanceOpts represents the options of the signature appearance.
+type appearanceOpts struct {
+	Layout      string
+	Image       *model.Image // Handwritten signature image.
+	Logo        *model.Image // Company logo, drawn in the background.
+	LogoOpacity float64
+	Lines       []string
+	Font        *model.PdfFont
+	FontSize    float64 // 0 for auto size.
+	TextColor   model.PdfColor
+	BorderSize  float64
+	BorderColor model.PdfColor
+	FillColor   model.PdfColor
+}
+
+func main() {
+	var (
+		imagePath, logoPath, layout, fontPath, rectStr, fieldName string
+		name, reason, location                                    string
+		fontSize, logoOpacity, borderSize                         float64
+		pageNum                                                   int
+		detect                                                    bool
+	)
+	flag.StringVar(&imagePath, "image", "", "Handwritten signature image (PNG with transparency or JPEG)")
+	flag.StringVar(&logoPath, "logo", "", "Company logo image drawn in the background")
+	flag.Float64Var(&logoOpacity, "logo-opacity", 0.3, "Opacity of the logo (0-1)")
+	flag.StringVar(&layout, "layout", layoutImageLeft, "Layout: image-left, image-top, image-only or text-only")
+	flag.StringVar(&fontPath, "font", "", "TrueType font file for the text (default Helvetica)")
+	flag.Float64Var(&fontSize, "font-size", 0, "Font size (0 to fit the text to the rectangle)")
+	flag.Float64Var(&borderSize, "border", 0, "Border size")
+	flag.StringVar(&rectStr, "rect", "50,50,250,110", "Signature rectangle: llx,lly,urx,ury")
+	flag.IntVar(&pageNum, "page", 1, "Page number of the signature rectangle")
+	flag.BoolVar(&detect, "detect", false, "Place the signature at the detected signature line")
+	flag.StringVar(&fieldName, "field", "", "Name of the empty signature field to sign")
+	flag.StringVar(&name, "name", "Jane Doe", "Signer name")
+	flag.StringVar(&reason, "reason", "", "Signing reason")
+	flag.StringVar(&location, "location", "", "Signing location")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flag.PrintDefaults()
+		return
+	}
+	inputPath := args[0]
+	outputPath := args[1]
+
+	// Prepare the appearance options.
+	var err error
+	opts := &appearanceOpts{
+		Layout:      layout,
+		LogoOpacity: logoOpacity,
+		FontSize:    fontSize,
+		TextColor:   model.NewPdfColorDeviceGray(0),
+		BorderSize:  borderSize,
+		BorderColor: model.NewPdfColorDeviceGray(0),
+	}
+	if imagePath != "" {
+		if opts.Image, err = loadImage(imagePath); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+	}
+	if logoPath != "" {
+		if opts.Logo, err = loadImage(logoPath); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+	}
+	if fontPath != "" {
+		opts.Font, err = loadCompositeFont(fontPath)
+	} else {
+		opts.Font, err = model.NewStandard14Font(model.HelveticaName)
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	opts.Lines = []string{"Digitally signed by " + name, "Date: " + now.Format("2006.01.02 15:04:05 -07:00")}
+	if reason != "" {
+		opts.Lines = append(opts.Lines, "Reason: "+reason)
+	}
+	if location != "" {
+		opts.Lines = append(opts.Lines, "Location: "+location)
+	}
+
+	rect, err := parseRect(rectStr)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Generate key pair.
+	priv, cert, err := generateKeys()
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Create reader.
+	file, err := os.Open(inputPath)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Determine the signature placement.
+	var field *model.PdfField
+	var wa *model.PdfAnnotationWidget
+	switch {
+	case fieldName != "":
+		var num int
+		field, wa, num, err = findEmptySignatureField(reader, fieldName)
+		if err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+		if rect, err = wa.Rect.(*core.PdfObjectArray).ToFloat64Array(); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+		pageNum = num
+	case detect:
+		found := false
+		for i, page := range reader.PageList {
+			lineRect, ok, err := locateSignatureLine(page)
+			if err != nil {
+				log.Fatalf("Fail: %v\n", err)
+			}
+			if ok {
+				// Place the signature above the line, with the height of the requested rectangle.
+				height := rect[3] - rect[1]
+				rect = []float64{lineRect[0], lineRect[1], lineRect[2], lineRect[1] + height}
+				pageNum = i + 1
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("Fail: unable to find the signature line\n")
+		}
+	}
+	log.Printf("Signature placement: page %d, rect %v\n", pageNum, rect)
+
+	// Create appender.
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Create signature handler.
+	handler, err := sighandler.NewAdobePKCS7Detached(priv, cert)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Create signature.
+	signature := model.NewPdfSignature(handler)
+	signature.SetName(name)
+	signature.SetReason(reason)
+	signature.SetLocation(location)
+	signature.SetDate(now, "")
+
+	if err := signature.Initialize(); err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Create the signature field with the appearance.
+	apDict, err := genSignatureAppearance(rect[2]-rect[0], rect[3]-rect[1], opts)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+	if field != nil {
+		err = signEmptyField(appender, reader, field, wa, signature, apDict)
+	} else {
+		sigField := model.NewPdfFieldSignature(signature)
+		sigField.Rect = core.MakeArrayFromFloats(rect)
+		sigField.AP = apDict
+		sigField.F = core.MakeInteger(4) // Print.
+		sigField.T = core.MakeString(fmt.Sprintf("Signature %d", pageNum))
+		err = appender.Sign(pageNum, sigField)
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	// Write output PDF file.
+	err = appender.WriteToFile(outputPath)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+}
+
+// genSignatureAppearance returns the appearance dictionary of a signature with a `width` x `height`
+// rectangle, laid out according to `opts`.
+func genSignatureAppearance(width, height float64, opts *appearanceOpts) (*core.PdfObjectDictionary, error) {
+	const padding = 2.0
+
+	resources := model.NewPdfPageResources()
+	cc := contentstream.NewContentCreator()
+
+	// Background and border.
+	if opts.FillColor != nil || opts.BorderSize > 0 {
+		cc.Add_q().Add_re(0, 0, width, height)
+		switch {
+		case opts.FillColor != nil && opts.BorderSize > 0:
+			cc.Add_w(opts.BorderSize).SetStrokingColor(opts.BorderColor).SetNonStrokingColor(opts.FillColor).Add_B()
+		case opts.FillColor != nil:
+			cc.SetNonStrokingColor(opts.FillColor).Add_f()
+		default:
+			cc.Add_w(opts.BorderSize).SetStrokingColor(opts.BorderColor).Add_S()
+		}
+		cc.Add_Q()
+	}
+
+	// Logo, faded in the background.
+	if opts.Logo != nil {
+		gs := core.MakeDict()
+		gs.Set("ca", core.MakeFloat(opts.LogoOpacity))
+		gs.Set("CA", core.MakeFloat(opts.LogoOpacity))
+		if err := resources.AddExtGState("GS0", gs); err != nil {
+			return nil, err
+		}
+		if err := drawImage(cc, resources, "Logo", opts.Logo, "GS0",
+			padding, padding, width-2*padding, height-2*padding); err != nil {
+			return nil, err
+		}
+	}
+
+	// Split the area between the signature image and the text.
+	imgX, imgY, imgW, imgH := padding, padding, width-2*padding, height-2*padding
+	txtX, txtY, txtW, txtH := imgX, imgY, imgW, imgH
+	switch {
+	case opts.Image == nil || opts.Layout == layoutTextOnly:
+		imgW = 0
+	case opts.Layout == layoutImageOnly:
+		txtW = 0
+	case opts.Layout == layoutImageTop:
+		imgH = (height - 2*padding) * 0.6
+		imgY = height - padding - imgH
+		txtH = imgY - padding
+	case opts.Layout == layoutImageLeft:
+		imgW = (width - 2*padding) * 0.45
+		txtX = imgX + imgW + padding
+		txtW = width - padding - txtX
+	default:
+		return nil, fmt.Errorf("unsupported layout: %s", opts.Layout)
+	}
+
+	if imgW > 0 {
+		if err := drawImage(cc, resources, "Sig", opts.Image, "", imgX, imgY, imgW, imgH); err != nil {
+			return nil, err
+		}
+	}
+	if txtW > 0 && len(opts.Lines) > 0 {
+		if err := drawText(cc, resources, opts, txtX, txtY, txtW, txtH); err != nil {
+			return nil, err
+		}
+	}
+
+	xform := model.NewXObjectForm()
+	xform.Resources = resources
+	xform.BBox = core.MakeArrayFromFloats([]float64{0, 0, width, height})
+	if err := xform.SetContentStream(cc.Bytes(), core.NewFlateEncoder()); err != nil {
+		return nil, err
+	}
+
+	apDict := core.MakeDict()
+	apDict.Set("N", xform.ToPdfObject())
+	return apDict, nil
+}
+
+// drawImage draws image `img` as XObject `name`, fitted (keeping the aspect ratio) and centered in the
+// area at (x, y) of size w x h. The graphics state `gsName` is applied if not empty.
+func drawImage(cc *contentstream.ContentCreator, resources *model.PdfPageResources, name string,
+	img *model.Image, gsName string, x, y, w, h float64) error {
+	ximg, err := model.NewXObjectImageFromImage(img, nil, core.NewFlateEncoder())
+	if err != nil {
+		return err
+	}
+	if err := resources.SetXObjectImageByName(core.PdfObjectName(name), ximg); err != nil {
+		return err
+	}
+
+	scale := math.Min(w/float64(img.Width), h/float64(img.Height))
+	imgW, imgH := float64(img.Width)*scale, float64(img.Height)*scale
+
+	cc.Add_q()
+	if gsName != "" {
+		cc.Add_gs(core.PdfObjectName(gsName))
+	}
+	cc.Add_cm(imgW, 0, 0, imgH, x+(w-imgW)/2, y+(h-imgH)/2).
+		Add_Do(core.PdfObjectName(name)).
+		Add_Q()
+	return nil
+}
+
+// drawText draws the text lines of `opts` in the area at (x, y) of size w x h, vertically centered.
+// If no font size is set, the largest size (up to 12) fitting the area is used.
+func drawText(cc *contentstream.ContentCreator, resources *model.PdfPageResources, opts *appearanceOpts,
+	x, y, w, h float64) error {
+	const lineHeight = 1.2
+	fontName := core.PdfObjectName("F1")
+	resources.SetFontByName(fontName, opts.Font.ToPdfObject())
+
+	// Widest line for a font size of 1.
+	var maxWidth float64
+	for _, line := range opts.Lines {
+		maxWidth = math.Max(maxWidth, textWidth(opts.Font, line))
+	}
+
+	fontSize := opts.FontSize
+	if fontSize <= 0 {
+		fontSize = math.Min(12, h/(float64(len(opts.Lines))*lineHeight))
+		if maxWidth > 0 {
+			fontSize = math.Min(fontSize, w/maxWidth)
+		}
+	}
+	leading := fontSize * lineHeight
+	top := y + (h+float64(len(opts.Lines))*leading)/2
+
+	encoder := opts.Font.Encoder()
+	cc.Add_q().Add_BT().
+		SetNonStrokingColor(opts.TextColor).
+		Add_Tf(fontName, fontSize).
+		Add_TL(leading).
+		Add_Td(x, top-fontSize)
+	for i, line := range opts.Lines {
+		if i > 0 {
+			cc.Add_Tstar()
+		}
+		cc.Add_Tj(*core.MakeStringFromBytes(encoder.Encode(line)))
+	}
+	cc.Add_ET().Add_Q()
+	return nil
+}
+
+// textWidth returns the width of `text` in `font` for a font size of 1.
+func textWidth(font *model.PdfFont, text string) float64 {
+	var width float64
+	for _, r := range text {
+		metrics, has := font.GetRuneMetrics(r)
+		if !has {
+			common.Log.Debug("Missing metrics for rune %c", r)
+			continue
+		}
+		width += metrics.Wx
+	}
+	return width / 1000.0
+}
+
+// loadImage loads the image in `imagePath`. The alpha channel of PNG images is preserved.
+func loadImage(imagePath string) (*model.Image, error) {
+	f, err := os.Open(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return model.ImageHandling.Read(f)
+}
+
+// loadCompositeFont loads the TrueType font in `fontPath` as a composite font, supporting any
+// characters in the font. Fonts that cannot be loaded result in an error.
+func loadCompositeFont(fontPath string) (font *model.PdfFont, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to load font %s: %v", fontPath, r)
+		}
+	}()
+	return model.NewCompositePdfFontFromTTFFile(fontPath)
+}
+
+// parseRect parses rectangle `str` of format llx,lly,urx,ury.
+func parseRect(str string) ([]float64, error) {
+	parts := strings.Split(str, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid rectangle: %s", str)
+	}
+
+	rect := make([]float64, 4)
+	for i, part := range parts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rectangle: %s", str)
+		}
+		rect[i] = val
+	}
+	if rect[2] <= rect[0] || rect[3] <= rect[1] {
+		return nil, fmt.Errorf("invalid rectangle: %s", str)
+	}
+	return rect, nil
+}
+
+// locateSignatureLine returns the rectangle of the first signature line on `page`, i.e. text consisting of
+// a run of underscores ("_____"). The rectangle spans the line horizontally, starting at its baseline.
+func locateSignatureLine(page *model.PdfPage) ([]float64, bool, error) {
+	contents, err := page.GetAllContentStreams()
+	if err != nil {
+		return nil, false, err
+	}
+	operations, err := contentstream.NewContentStreamParser(contents).Parse()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Track the text matrix and font size to find the position of the underscores.
+	var tm, tlm textMatrix
+	var fontSize, leading float64
+	var rect []float64
+
+	processor := contentstream.NewContentStreamProcessor(*operations)
+	processor.AddHandler(contentstream.HandlerConditionEnumAllOperands, "",
+		func(op *contentstream.ContentStreamOperation, gs contentstream.GraphicsState, resources *model.PdfPageResources) error {
+			if rect != nil {
+				return nil
+			}
+			params, err := core.GetNumbersAsFloat(op.Params)
+			switch op.Operand {
+			case "BT":
+				tm = textMatrix{1, 0, 0, 1, 0, 0}
+				tlm = tm
+			case "Tm":
+				if err == nil && len(params) == 6 {
+					copy(tm[:], params)
+					tlm = tm
+				}
+			case "Td", "TD":
+				if err == nil && len(params) == 2 {
+					tlm = tlm.translate(params[0], params[1])
+					tm = tlm
+					if op.Operand == "TD" {
+						leading = -params[1]
+					}
+				}
+			case "TL":
+				if err == nil && len(params) == 1 {
+					leading = params[0]
+				}
+			case "T*":
+				tlm = tlm.translate(0, -leading)
+				tm = tlm
+			case "Tf":
+				if len(op.Params) == 2 {
+					fontSize, _ = core.GetNumberAsFloat(op.Params[1])
+				}
+			case "Tj", "'", "\"", "TJ":
+				text := ""
+				for _, param := range op.Params {
+					if str, ok := core.GetStringVal(param); ok {
+						text += str
+					} else if arr, ok := core.GetArray(param); ok {
+						for _, obj := range arr.Elements() {
+							if str, ok := core.GetStringVal(obj); ok {
+								text += str
+							}
+						}
+					}
+				}
+				idx := strings.Index(text, "_____")
+				if idx < 0 {
+					return nil
+				}
+				n := len(text[idx:]) - len(strings.TrimLeft(text[idx:], "_"))
+
+				// Underscores are about half an em wide, characters before the line likewise approximated.
+				ctm := gs.CTM
+				x0, y0 := ctm.Transform(tm.transform(float64(idx)*0.5*fontSize, 0))
+				x1, _ := ctm.Transform(tm.transform(float64(idx+n)*0.5*fontSize, 0))
+				rect = []float64{x0, y0, x1, y0}
+			}
+			return nil
+		})
+	if err := processor.Process(page.Resources); err != nil {
+		return nil, false, err
+	}
+	return rect, rect != nil, nil
+}
+
+// textMatrix is a text matrix [a b c d e f].
+type textMatrix [6]float64
+
+// translate returns the matrix translated by (`tx`, `ty`) in text space.
+func (m textMatrix) translate(tx, ty float64) textMatrix {
+	m[4], m[5] = tx*m[0]+ty*m[2]+m[4], tx*m[1]+ty*m[3]+m[5]
+	return m
+}
+
+// transform returns the position of text space point (`x`, `y`).
+func (m textMatrix) transform(x, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// findEmptySignatureField returns the unsigned signature field named `name` in `reader`, its widget
+// annotation and page number.
+func findEmptySignatureField(reader *model.PdfReader, name string) (*model.PdfField, *model.PdfAnnotationWidget, int, error) {
+	if reader.AcroForm == nil {
+		return nil, nil, 0, errors.New("no form in document")
+	}
+
+	for _, field := range reader.AcroForm.AllFields() {
+		fullname, err := field.FullName()
+		if err != nil || fullname != name {
+			continue
+		}
+		sig, ok := field.GetContext().(*model.PdfFieldSignature)
+		if !ok {
+			return nil, nil, 0, fmt.Errorf("field %s is not a signature field", name)
+		}
+		if sig.V != nil {
+			return nil, nil, 0, fmt.Errorf("field %s is already signed", name)
+		}
+		if len(field.Annotations) == 0 {
+			return nil, nil, 0, fmt.Errorf("field %s has no widget annotation", name)
+		}
+
+		wa := field.Annotations[0]
+		for i, page := range reader.PageList {
+			annotations, err := page.GetAnnotations()
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			for _, annot := range annotations {
+				if annot == wa.PdfAnnotation {
+					return field, wa, i + 1, nil
+				}
+			}
+		}
+		return nil, nil, 0, fmt.Errorf("page of field %s not found", name)
+	}
+	return nil, nil, 0, fmt.Errorf("signature field %s not found", name)
+}
+
+// signEmptyField signs the empty signature field `field` in place with `signature`, using `ap` as the
+// appearance of its widget annotation `wa`. The field keeps its place in the field hierarchy and its
+// entries, such as the partial name (/T), lock (/Lock) and seed value (/SV) dictionaries.
+func signEmptyField(appender *model.PdfAppender, reader *model.PdfReader, field *model.PdfField, wa *model.PdfAnnotationWidget, signature *model.PdfSignature, ap core.PdfObject) error {
+	sig, ok := field.GetContext().(*model.PdfFieldSignature)
+	if !ok {
+		return errors.New("not a signature field")
+	}
+	sig.V = signature
+	sig.ToPdfObject()
+
+	wa.AP = ap
+	if wa.F == nil {
+		wa.F = core.MakeInteger(4) // Print.
+	}
+	wa.ToPdfObject()
+
+	// The changed field and widget dictionaries are written with the form in the incremental update.
+	reader.AcroForm.SigFlags = core.MakeInteger(3)
+	appender.ReplaceAcroForm(reader.AcroForm)
+	return nil
+}
+
+func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
+	// Generate private key.
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Initialize X509 certificate template.
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test Company"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour * 24 * 365),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// Generate X509 certificate.
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, cert, nil
+}
@@ -0,0 +1,653 @@
+/*
+ * This example showcases how to create a local test certification authority for signing workflows:
+ * a root CA, an intermediate CA and document signing end-entity certificates, with the key usages
+ * expected by signature validators (digitalSignature and nonRepudiation, document signing extended key
+ * usage), CRL distribution points, OCSP responder and CA issuer URLs. The revocation data is served by
+ * a local OCSP responder and CRL server, for end-to-end offline validation testing.
+ *
+ * The keys, certificates, CRLs and the CA database (ca.json) are stored in the CA directory. The URLs
+ * embedded in the certificates must be those of the serve command.
+ *
+ * Create the root and intermediate CAs:
+ * $ ./pdf_sign_ca init [-dir ca] [-url http://localhost:8080] [-key rsa|ecdsa-p256|ecdsa-p384]
+ *
+ * Issue a document signing certificate (NAME.pem) and its private key (NAME.key), or certify the key of a
+ * certificate request (e.g. of a key stored on a HSM):
+ * $ ./pdf_sign_ca issue [-dir ca] [-key rsa|ecdsa-p256|ecdsa-p384] [-days 365] [-email EMAIL] [-csr request.pem] <NAME>
+ *
+ * Revoke a certificate and update the CRLs:
+ * $ ./pdf_sign_ca revoke [-dir ca] [-reason keyCompromise] <NAME>
+ *
+ * Regenerate the CRLs (root.crl and intermediate.crl, valid for 7 days):
+ * $ ./pdf_sign_ca crl [-dir ca]
+ *
+ * Serve the OCSP responder, the CRLs and the CA certificates:
+ * $ ./pdf_sign_ca serve [-dir ca] [-addr localhost:8080]
+ *
+ * Sign and validate with the issued certificate:
+ * $ ./pdf_sign_pkcs12 -revocation -cert ca/alice.pem,ca/chain.pem ca/alice.key "" input.pdf output.pdf
+ * $ ./pdf_sign_validate -trust ca/root.pem output.pdf
+ */
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s init [-dir DIR] [-url URL] [-key rsa|ecdsa-p256|ecdsa-p384]\n" +
+	"  %[1]s issue [-dir DIR] [-key rsa|ecdsa-p256|ecdsa-p384] [-days DAYS] [-email EMAIL] [-csr CSR_FILE] NAME\n" +
+	"  %[1]s revoke [-dir DIR] [-reason REASON] NAME\n" +
+	"  %[1]s crl [-dir DIR]\n" +
+	"  %[1]s serve [-dir DIR] [-addr ADDRESS]\n"
+
+// Names of the CA certificates.
+const (
+	rootName         = "root"
+	intermediateName = "intermediate"
+)
+
+// Validity of the CRLs and OCSP responses.
+const revocationValidity = 7 * 24 * time.Hour
+
+var (
+	oidKeyPurposeDocSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 36}
+	oidCRLReason            = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// CRL revocation reasons (RFC 5280), by name.
+var revocationReasons = map[string]int{
+	"unspecified":          ocsp.Unspecified,
+	"keyCompromise":        ocsp.KeyCompromise,
+	"cACompromise":         ocsp.CACompromise,
+	"affiliationChanged":   ocsp.AffiliationChanged,
+	"superseded":           ocsp.Superseded,
+	"cessationOfOperation": ocsp.CessationOfOperation,
+	"certificateHold":      ocsp.CertificateHold,
+}
+
+// caDatabase is the list of certificates issued by the CA, stored in ca.json.
+type caDatabase struct {
+	URL          string        `json:"url"`
+	Serial       int64         `json:"serial"`
+	Certificates []*issuedCert `json:"certificates"`
+}
+
+// issuedCert is a certificate issued by the CA.
+type issuedCert struct {
+	Name      string    `json:"name"`
+	Serial    int64     `json:"serial"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	NotAfter  time.Time `json:"notAfter"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	RevokedAt time.Time `json:"revokedAt,omitempty"`
+	Reason    int       `json:"reason,omitempty"`
+}
+
+// testCA is the local test CA stored in `dir`.
+type testCA struct {
+	dir          string
+	db           caDatabase
+	root         *caKeyPair
+	intermediate *caKeyPair
+}
+
+// caKeyPair is the private key and certificate of a CA.
+type caKeyPair struct {
+	name        string
+	privateKey  crypto.Signer
+	certificate *x509.Certificate
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var dir, serviceURL, keyType, email, csrPath, reason, addr string
+	var days int
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	flags.StringVar(&dir, "dir", "ca", "CA directory")
+	nargs := 0
+	switch os.Args[1] {
+	case "init":
+		flags.StringVar(&serviceURL, "url", "http://localhost:8080", "Base URL of the OCSP responder and CRLs")
+		flags.StringVar(&keyType, "key", "rsa", "CA key type: rsa, ecdsa-p256 or ecdsa-p384")
+	case "issue":
+		flags.StringVar(&keyType, "key", "rsa", "Key type: rsa, ecdsa-p256 or ecdsa-p384")
+		flags.IntVar(&days, "days", 365, "Validity of the certificate in days")
+		flags.StringVar(&email, "email", "", "Email address of the signer")
+		flags.StringVar(&csrPath, "csr", "", "Certify the key of this PEM certificate request instead of generating one")
+		nargs = 1
+	case "revoke":
+		flags.StringVar(&reason, "reason", "unspecified", "Revocation reason, e.g. keyCompromise or superseded")
+		nargs = 1
+	case "crl":
+	case "serve":
+		flags.StringVar(&addr, "addr", "localhost:8080", "Listening address")
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	flags.Parse(os.Args[2:])
+	if flags.NArg() < nargs {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = initCA(dir, strings.TrimSuffix(serviceURL, "/"), keyType)
+	default:
+		var ca *testCA
+		if ca, err = loadCA(dir); err != nil {
+			break
+		}
+		switch os.Args[1] {
+		case "issue":
+			err = ca.issue(flags.Arg(0), email, keyType, csrPath, days)
+		case "revoke":
+			err = ca.revoke(flags.Arg(0), reason)
+		case "crl":
+			err = ca.writeCRLs()
+		case "serve":
+			log.Printf("Serving OCSP responder and CRLs of %s on http://%s\n", dir, addr)
+			err = http.ListenAndServe(addr, &caServer{dir: dir})
+		}
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// initCA creates the root and intermediate CAs with keys of type `keyType` in `dir`, referencing the
+// OCSP responder and CRLs at `serviceURL`.
+func initCA(dir, serviceURL, keyType string) error {
+	if _, err := os.Stat(filepath.Join(dir, "ca.json")); err == nil {
+		return fmt.Errorf("%s already contains a CA", dir)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ca := &testCA{dir: dir, db: caDatabase{URL: serviceURL}}
+
+	// Self-signed root certificate.
+	rootKey, err := generateKey(keyType)
+	if err != nil {
+		return err
+	}
+	rootTemplate := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "Test Root CA",
+			Organization: []string{"Test Company"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(20, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	ca.root = &caKeyPair{name: rootName, privateKey: rootKey}
+	if ca.root.certificate, err = ca.createCertificate(rootName, rootTemplate, rootKey.Public(), ca.root); err != nil {
+		return err
+	}
+
+	// Intermediate CA certificate, issuing the end-entity certificates.
+	intermediateKey, err := generateKey(keyType)
+	if err != nil {
+		return err
+	}
+	intermediateTemplate := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "Test Intermediate CA",
+			Organization: []string{"Test Company"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	ca.intermediate = &caKeyPair{name: intermediateName, privateKey: intermediateKey}
+	ca.intermediate.certificate, err = ca.createCertificate(intermediateName, intermediateTemplate,
+		intermediateKey.Public(), ca.root)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range []*caKeyPair{ca.root, ca.intermediate} {
+		if err := writeKeyPair(dir, pair.name, pair.privateKey, pair.certificate); err != nil {
+			return err
+		}
+	}
+	chain := append(pemCertificate(ca.intermediate.certificate), pemCertificate(ca.root.certificate)...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "chain.pem"), chain, 0644); err != nil {
+		return err
+	}
+	if err := ca.save(); err != nil {
+		return err
+	}
+	if err := ca.writeCRLs(); err != nil {
+		return err
+	}
+
+	log.Printf("CA created in %s. Root certificate: %s\n", dir, filepath.Join(dir, "root.pem"))
+	return nil
+}
+
+// loadCA loads the CA stored in `dir`.
+func loadCA(dir string) (*testCA, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "ca.json"))
+	if err != nil {
+		return nil, fmt.Errorf("%s does not contain a CA, create it with the init command: %v", dir, err)
+	}
+	ca := &testCA{dir: dir}
+	if err := json.Unmarshal(data, &ca.db); err != nil {
+		return nil, err
+	}
+	if ca.root, err = loadKeyPair(dir, rootName); err != nil {
+		return nil, err
+	}
+	if ca.intermediate, err = loadKeyPair(dir, intermediateName); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// save saves the CA database.
+func (ca *testCA) save() error {
+	data, err := json.MarshalIndent(ca.db, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(ca.dir, "ca.json"), data, 0600)
+}
+
+// issue issues a document signing certificate valid for `days`, for signer `name` with optional email
+// address `email`. The key of the certificate request in `csrPath` is certified if set, otherwise a key of
+// type `keyType` is generated.
+func (ca *testCA) issue(name, email, keyType, csrPath string, days int) error {
+	if name == rootName || name == intermediateName || name == "chain" || name == "ca" {
+		return fmt.Errorf("reserved name %s", name)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name %s", name)
+	}
+	for _, issued := range ca.db.Certificates {
+		if issued.Name == name {
+			return fmt.Errorf("a certificate was already issued for %s", name)
+		}
+	}
+
+	var priv crypto.Signer
+	var pub crypto.PublicKey
+	if csrPath != "" {
+		csr, err := loadCertificateRequest(csrPath)
+		if err != nil {
+			return err
+		}
+		pub = csr.PublicKey
+	} else {
+		var err error
+		if priv, err = generateKey(keyType); err != nil {
+			return err
+		}
+		pub = priv.Public()
+	}
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{"Test Company"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.AddDate(0, 0, days),
+
+		// Document signing key usages and extended key usages (RFC 9336), with email protection for the
+		// validators not supporting document signing.
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		UnknownExtKeyUsage:    []asn1.ObjectIdentifier{oidKeyPurposeDocSigning},
+		BasicConstraintsValid: true,
+	}
+	if email != "" {
+		template.EmailAddresses = []string{email}
+	}
+	cert, err := ca.createCertificate(name, template, pub, ca.intermediate)
+	if err != nil {
+		return err
+	}
+	if priv != nil {
+		err = writeKeyPair(ca.dir, name, priv, cert)
+	} else {
+		err = ioutil.WriteFile(filepath.Join(ca.dir, name+".pem"), pemCertificate(cert), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if err := ca.save(); err != nil {
+		return err
+	}
+
+	log.Printf("Certificate %s (serial %d) issued: %s\n", cert.Subject, cert.SerialNumber,
+		filepath.Join(ca.dir, name+".pem"))
+	return nil
+}
+
+// loadCertificateRequest loads and verifies the PEM certificate request in `path`.
+func loadCertificateRequest(path string) (*x509.CertificateRequest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%s: no PEM certificate request found", path)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%s: invalid signature: %v", path, err)
+	}
+	return csr, nil
+}
+
+// createCertificate creates the certificate of `template` for `pub`, issued by `issuer` (self-signed if
+// `issuer` has no certificate yet), and records it as `name` in the CA database.
+func (ca *testCA) createCertificate(name string, template *x509.Certificate, pub crypto.PublicKey,
+	issuer *caKeyPair) (*x509.Certificate, error) {
+	ca.db.Serial++
+	template.SerialNumber = big.NewInt(ca.db.Serial)
+
+	parent := issuer.certificate
+	if parent == nil {
+		parent = template
+	} else {
+		// Revocation information and issuer certificate location.
+		template.OCSPServer = []string{ca.db.URL + "/ocsp"}
+		template.CRLDistributionPoints = []string{ca.db.URL + "/" + issuer.name + ".crl"}
+		template.IssuingCertificateURL = []string{ca.db.URL + "/" + issuer.name + ".crt"}
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, parent, pub, issuer.privateKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.db.Certificates = append(ca.db.Certificates, &issuedCert{
+		Name:     name,
+		Serial:   ca.db.Serial,
+		Issuer:   issuer.name,
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter,
+	})
+	return cert, nil
+}
+
+// revoke revokes the certificate of `name` for reason `reasonName` and updates the CRLs.
+func (ca *testCA) revoke(name, reasonName string) error {
+	reason, ok := revocationReasons[reasonName]
+	if !ok {
+		return fmt.Errorf("unknown revocation reason %s", reasonName)
+	}
+	for _, issued := range ca.db.Certificates {
+		if issued.Name != name || name == rootName {
+			continue
+		}
+		if issued.Revoked {
+			return fmt.Errorf("certificate %s is already revoked", name)
+		}
+		issued.Revoked = true
+		issued.RevokedAt = time.Now().UTC().Truncate(time.Second)
+		issued.Reason = reason
+		if err := ca.save(); err != nil {
+			return err
+		}
+		log.Printf("Certificate %s (serial %d) revoked\n", issued.Subject, issued.Serial)
+		return ca.writeCRLs()
+	}
+	return fmt.Errorf("no revocable certificate issued for %s", name)
+}
+
+// writeCRLs writes the CRLs of the root and intermediate CAs.
+func (ca *testCA) writeCRLs() error {
+	for _, pair := range []*caKeyPair{ca.root, ca.intermediate} {
+		crl, err := ca.createCRL(pair)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(ca.dir, pair.name+".crl"), crl, 0644); err != nil {
+			return err
+		}
+	}
+	log.Printf("CRLs written to %s, next update %s\n", ca.dir, time.Now().Add(revocationValidity).Format(time.RFC3339))
+	return nil
+}
+
+// createCRL returns the DER encoded CRL of the certificates issued by `issuer`.
+func (ca *testCA) createCRL(issuer *caKeyPair) ([]byte, error) {
+	var revoked []pkix.RevokedCertificate
+	for _, issued := range ca.db.Certificates {
+		if !issued.Revoked || issued.Issuer != issuer.name {
+			continue
+		}
+		reason, err := asn1.Marshal(asn1.Enumerated(issued.Reason))
+		if err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(issued.Serial),
+			RevocationTime: issued.RevokedAt,
+			Extensions:     []pkix.Extension{{Id: oidCRLReason, Value: reason}},
+		})
+	}
+	thisUpdate := time.Now().Add(-time.Minute)
+	return issuer.certificate.CreateCRL(rand.Reader, issuer.privateKey, revoked, thisUpdate,
+		thisUpdate.Add(revocationValidity))
+}
+
+// ocspResponse returns the OCSP response to the DER encoded OCSP request `body`, signed by the CA that
+// issued the requested certificate.
+func (ca *testCA) ocspResponse(body []byte) []byte {
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse
+	}
+
+	var issuer *caKeyPair
+	for _, pair := range []*caKeyPair{ca.root, ca.intermediate} {
+		if !req.HashAlgorithm.Available() {
+			break
+		}
+		h := req.HashAlgorithm.New()
+		h.Write(pair.certificate.RawSubject)
+		if string(h.Sum(nil)) == string(req.IssuerNameHash) {
+			issuer = pair
+		}
+	}
+	if issuer == nil {
+		return ocsp.UnauthorizedErrorResponse
+	}
+
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(revocationValidity),
+	}
+	for _, issued := range ca.db.Certificates {
+		if issued.Issuer != issuer.name || big.NewInt(issued.Serial).Cmp(req.SerialNumber) != 0 {
+			continue
+		}
+		template.Status = ocsp.Good
+		if issued.Revoked {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = issued.RevokedAt
+			template.RevocationReason = issued.Reason
+		}
+	}
+
+	resp, err := ocsp.CreateResponse(issuer.certificate, issuer.certificate, template, issuer.privateKey)
+	if err != nil {
+		log.Printf("OCSP error: %v\n", err)
+		return ocsp.InternalErrorErrorResponse
+	}
+	log.Printf("OCSP request for serial %s of %s\n", req.SerialNumber, issuer.certificate.Subject)
+	return resp
+}
+
+// caServer serves the OCSP responder, the CRLs and the CA certificates of the CA in `dir`. The CA is
+// reloaded for each request, so that revocations done while serving are taken into account.
+type caServer struct {
+	dir string
+}
+
+// ServeHTTP handles the OCSP (POST or GET), CRL and CA certificate requests.
+func (s *caServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca, err := loadCA(s.dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case path == "ocsp" && r.Method == http.MethodPost:
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(ca.ocspResponse(body))
+	case strings.HasPrefix(path, "ocsp/") && r.Method == http.MethodGet:
+		body, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(path, "ocsp/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(ca.ocspResponse(body))
+	case path == rootName+".crl" || path == intermediateName+".crl":
+		issuer := ca.root
+		if path == intermediateName+".crl" {
+			issuer = ca.intermediate
+		}
+		crl, err := ca.createCRL(issuer)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(crl)
+	case path == rootName+".crt":
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Write(ca.root.certificate.Raw)
+	case path == intermediateName+".crt":
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Write(ca.intermediate.certificate.Raw)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// generateKey generates a private key of type `keyType`.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "rsa":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported key type %s", keyType)
+}
+
+// writeKeyPair writes the PKCS #8 private key `priv` and the certificate `cert` to NAME.key and NAME.pem
+// in `dir`.
+func writeKeyPair(dir, name string, priv crypto.Signer, cert *x509.Certificate) error {
+	keyData, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyData})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name+".pem"), pemCertificate(cert), 0644)
+}
+
+// loadKeyPair loads the private key and certificate of `name` from `dir`.
+func loadKeyPair(dir, name string) (*caKeyPair, error) {
+	keyData, err := ioutil.ReadFile(filepath.Join(dir, name+".key"))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, fmt.Errorf("%s.key: invalid PEM data", name)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s.key: %v", name, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s.key: unsupported private key %T", name, key)
+	}
+
+	certData, err := ioutil.ReadFile(filepath.Join(dir, name+".pem"))
+	if err != nil {
+		return nil, err
+	}
+	if block, _ = pem.Decode(certData); block == nil {
+		return nil, errors.New(name + ".pem: invalid PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s.pem: %v", name, err)
+	}
+	return &caKeyPair{name: name, privateKey: signer, certificate: cert}, nil
+}
+
+// pemCertificate returns the PEM encoding of `cert`.
+func pemCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -0,0 +1,1258 @@
+/*
+ * This example showcases how to digitally sign a PDF file with a key held by a remote signing service
+ * implementing the Cloud Signature Consortium (CSC) API v1: the service lists the credentials (key and
+ * certificate chain) of the user, authorizes the signature of document hashes with the PIN of the
+ * credential and returns their signature values.
+ *
+ * The remote key is exposed as a crypto.Signer (cscSigner), used by the CMS signature handler of this
+ * example. Remote signing is limited to this example: the other signing examples sign with local keys or
+ * a PKCS #11 token and have no option to use a CSC service. The signer uses the service through the
+ * signingService interface, implemented by cscClient over HTTP: other transports (e.g. gRPC) can be
+ * plugged in by implementing it. RSA (PKCS #1 v1.5 or PSS with -pss) and ECDSA credentials are supported.
+ *
+ * If no service URL is specified, a local mock CSC server is started, with an RSA and an ECDSA credential
+ * issued by a local test CA, which is useful for offline testing. The mock server can also be run with
+ * the csc-server command. The default user, password and PIN are those of the mock server.
+ *
+ * List the credentials:
+ * $ ./pdf_sign_csc credentials [-url URL] [-user USER] [-password PASSWORD]
+ *
+ * Sign with a credential (default: the first one):
+ * $ ./pdf_sign_csc sign [-url URL] [-user USER] [-password PASSWORD] [-credential ID] [-pin PIN] [-hash sha256|sha384|sha512] [-pss] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Run the mock CSC server:
+ * $ ./pdf_sign_csc csc-server [-addr localhost:8080]
+ */
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s credentials [-url URL] [-user USER] [-password PASSWORD]\n" +
+	"  %[1]s sign [-url URL] [-user USER] [-password PASSWORD] [-credential ID] [-pin PIN] [-hash sha256|sha384|sha512] [-pss] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s csc-server [-addr ADDRESS]\n"
+
+// Credentials of the mock CSC server.
+const (
+	mockUser     = "test"
+	mockPassword = "test"
+	mockPIN      = "12345"
+)
+
+// Size of the signature Contents.
+const signatureLen = 16384
+
+// Digest algorithms, by name.
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// CMS (RFC 5652) structures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// RSASSA-PSS parameters (RFC 4055).
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// CSC API v1 messages.
+type cscError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type loginResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+}
+
+type listRequest struct {
+	MaxResults int `json:"maxResults,omitempty"`
+}
+
+type listResponse struct {
+	CredentialIDs []string `json:"credentialIDs"`
+}
+
+type infoRequest struct {
+	CredentialID string `json:"credentialID"`
+	Certificates string `json:"certificates,omitempty"`
+	CertInfo     bool   `json:"certInfo,omitempty"`
+	AuthInfo     bool   `json:"authInfo,omitempty"`
+}
+
+type credentialInfo struct {
+	Key struct {
+		Status string   `json:"status"`
+		Algo   []string `json:"algo"`
+		Len    int      `json:"len"`
+	} `json:"key"`
+	Cert struct {
+		Status       string   `json:"status,omitempty"`
+		Certificates []string `json:"certificates"`
+		SubjectDN    string   `json:"subjectDN,omitempty"`
+	} `json:"cert"`
+	AuthMode  string `json:"authMode"`
+	Multisign int    `json:"multisign"`
+}
+
+type authorizeRequest struct {
+	CredentialID  string   `json:"credentialID"`
+	NumSignatures int      `json:"numSignatures"`
+	Hash          []string `json:"hash,omitempty"`
+	PIN           string   `json:"PIN,omitempty"`
+}
+
+type authorizeResponse struct {
+	SAD       string `json:"SAD"`
+	ExpiresIn int    `json:"expiresIn,omitempty"`
+}
+
+type signHashRequest struct {
+	CredentialID   string   `json:"credentialID"`
+	SAD            string   `json:"SAD"`
+	Hash           []string `json:"hash"`
+	HashAlgo       string   `json:"hashAlgo,omitempty"`
+	SignAlgo       string   `json:"signAlgo"`
+	SignAlgoParams string   `json:"signAlgoParams,omitempty"`
+}
+
+type signHashResponse struct {
+	Signatures []string `json:"signatures"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "credentials", "sign":
+		err = clientCommand(os.Args[1], os.Args[2:])
+	case "csc-server":
+		var addr string
+		flags := flag.NewFlagSet("csc-server", flag.ExitOnError)
+		flags.StringVar(&addr, "addr", "localhost:8080", "Listening address")
+		flags.Parse(os.Args[2:])
+
+		var server *mockCSCServer
+		if server, err = newMockCSCServer(); err == nil {
+			log.Printf("CSC server listening on http://%s (user %s, password %s, PIN %s)\n",
+				addr, mockUser, mockPassword, mockPIN)
+			err = http.ListenAndServe(addr, server)
+		}
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// clientCommand lists the credentials (`command` credentials) or signs the PDF file (`command` sign) with
+// the options in `args`.
+func clientCommand(command string, args []string) error {
+	var serviceURL, user, password, credentialID, pin, hashName string
+	var pss bool
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&serviceURL, "url", "", "Base URL of the CSC service (default: start a local mock server)")
+	flags.StringVar(&user, "user", mockUser, "User name")
+	flags.StringVar(&password, "password", mockPassword, "Password")
+	if command == "sign" {
+		flags.StringVar(&credentialID, "credential", "", "Credential ID (default: the first credential)")
+		flags.StringVar(&pin, "pin", mockPIN, "PIN of the credential")
+		flags.StringVar(&hashName, "hash", "sha256", "Digest algorithm: sha256, sha384 or sha512")
+		flags.BoolVar(&pss, "pss", false, "Use the RSASSA-PSS signature scheme for RSA keys")
+	}
+	flags.Parse(args)
+	if command == "sign" && flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	// Start the local mock server.
+	if serviceURL == "" {
+		server, err := newMockCSCServer()
+		if err != nil {
+			return err
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go http.Serve(listener, server)
+		serviceURL = "http://" + listener.Addr().String()
+	}
+
+	client := &cscClient{baseURL: strings.TrimSuffix(serviceURL, "/")}
+	if err := client.login(user, password); err != nil {
+		return err
+	}
+	credentialIDs, err := client.ListCredentials()
+	if err != nil {
+		return err
+	}
+
+	if command == "credentials" {
+		for _, id := range credentialIDs {
+			signer, err := newCSCSigner(client, id, "")
+			if err != nil {
+				return err
+			}
+			info, err := client.CredentialInfo(id)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s (%s %d), algorithms %s\n", id, signer.chain[0].Subject, info.Key.Status,
+				info.Key.Len, strings.Join(info.Key.Algo, ", "))
+		}
+		return nil
+	}
+
+	hash, ok := hashes[hashName]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %s", hashName)
+	}
+	if credentialID == "" {
+		if len(credentialIDs) == 0 {
+			return errors.New("no credentials")
+		}
+		credentialID = credentialIDs[0]
+	}
+	signer, err := newCSCSigner(client, credentialID, pin)
+	if err != nil {
+		return err
+	}
+	if _, isRSA := signer.Public().(*rsa.PublicKey); pss && !isRSA {
+		return fmt.Errorf("-pss requires an RSA key, credential %s has a %T key", credentialID, signer.Public())
+	}
+
+	inputPath := flags.Arg(0)
+	outputPath := flags.Arg(1)
+	handler := &cmsHandler{signer: signer, chain: signer.chain, hash: hash, pss: pss}
+	if err := sign(handler, inputPath, outputPath); err != nil {
+		return err
+	}
+	log.Printf("PDF file successfully signed with credential %s. Output path: %s\n", credentialID, outputPath)
+
+	// Validate the signature of the output PDF file.
+	if err := validate(outputPath); err != nil {
+		return err
+	}
+	log.Printf("Signature successfully validated\n")
+	return nil
+}
+
+// sign signs the specified input PDF file using the specified signature handler
+// and saves the result at the destination specified by the outputPath parameter.
+func sign(handler model.SignatureHandler, inputPath, outputPath string) error {
+	// Create reader.
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	// Create appender.
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		return err
+	}
+
+	// Create signature.
+	signature := model.NewPdfSignature(handler)
+	signature.SetName("Test Remote Signature")
+	signature.SetReason("TestRemoteSignature")
+	signature.SetDate(now, "")
+
+	if err := signature.Initialize(); err != nil {
+		return err
+	}
+
+	// Create signature field and appearance.
+	opts := annotator.NewSignatureFieldOpts()
+	opts.FontSize = 10
+	opts.Rect = []float64{10, 25, 75, 60}
+
+	field, err := annotator.NewSignatureField(
+		signature,
+		[]*annotator.SignatureLine{
+			annotator.NewSignatureLine("Name", "John Doe"),
+			annotator.NewSignatureLine("Date", now.Format("2006.01.02")),
+			annotator.NewSignatureLine("Reason", "Remote signature test"),
+		},
+		opts,
+	)
+	if err != nil {
+		return err
+	}
+	field.T = core.MakeString("Remote signature")
+
+	if err = appender.Sign(1, field); err != nil {
+		return err
+	}
+
+	// Write output PDF file.
+	return appender.WriteToFile(outputPath)
+}
+
+// validate validates the signatures of the PDF file in `inputPath`.
+func validate(inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	results, err := reader.ValidateSignatures([]model.SignatureHandler{&cmsHandler{}})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if !res.IsVerified {
+			return fmt.Errorf("signature not verified: %s", res.String())
+		}
+	}
+	return nil
+}
+
+// signingService is the remote signing API used by cscSigner.
+type signingService interface {
+	// ListCredentials returns the IDs of the credentials of the user.
+	ListCredentials() ([]string, error)
+	// CredentialInfo returns the key and certificate chain information of credential `credentialID`.
+	CredentialInfo(credentialID string) (*credentialInfo, error)
+	// Authorize authorizes the signature of `hashes` by credential `credentialID` with `pin`, returning the
+	// Signature Activation Data.
+	Authorize(credentialID, pin string, hashes [][]byte) (string, error)
+	// SignHash returns the signature values of `hashes` computed with algorithm `hash` by credential
+	// `credentialID` with signature algorithm `signAlgo` and parameters `signAlgoParams`.
+	SignHash(credentialID, sad string, hashes [][]byte, hash crypto.Hash, signAlgo asn1.ObjectIdentifier,
+		signAlgoParams []byte) ([][]byte, error)
+}
+
+// cscClient is a CSC API v1 client over HTTP.
+type cscClient struct {
+	baseURL string
+	token   string
+}
+
+// login authenticates with `user` and `password` (HTTP Basic authentication).
+func (c *cscClient) login(user, password string) error {
+	var resp loginResponse
+	if err := c.call("auth/login", user+":"+password, struct{}{}, &resp); err != nil {
+		return err
+	}
+	c.token = resp.AccessToken
+	return nil
+}
+
+// ListCredentials returns the IDs of the credentials of the user.
+func (c *cscClient) ListCredentials() ([]string, error) {
+	var resp listResponse
+	if err := c.call("credentials/list", "", listRequest{MaxResults: 100}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CredentialIDs, nil
+}
+
+// CredentialInfo returns the key and certificate chain information of credential `credentialID`.
+func (c *cscClient) CredentialInfo(credentialID string) (*credentialInfo, error) {
+	var resp credentialInfo
+	req := infoRequest{CredentialID: credentialID, Certificates: "chain", CertInfo: true, AuthInfo: true}
+	if err := c.call("credentials/info", "", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Authorize authorizes the signature of `hashes` by credential `credentialID` with `pin`.
+func (c *cscClient) Authorize(credentialID, pin string, hashes [][]byte) (string, error) {
+	req := authorizeRequest{CredentialID: credentialID, NumSignatures: len(hashes), PIN: pin}
+	for _, hash := range hashes {
+		req.Hash = append(req.Hash, base64.StdEncoding.EncodeToString(hash))
+	}
+	var resp authorizeResponse
+	if err := c.call("credentials/authorize", "", req, &resp); err != nil {
+		return "", err
+	}
+	return resp.SAD, nil
+}
+
+// SignHash returns the signature values of `hashes` by credential `credentialID`.
+func (c *cscClient) SignHash(credentialID, sad string, hashes [][]byte, hash crypto.Hash,
+	signAlgo asn1.ObjectIdentifier, signAlgoParams []byte) ([][]byte, error) {
+	hashAlgo, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	req := signHashRequest{
+		CredentialID: credentialID,
+		SAD:          sad,
+		HashAlgo:     hashAlgo.String(),
+		SignAlgo:     signAlgo.String(),
+	}
+	for _, hash := range hashes {
+		req.Hash = append(req.Hash, base64.StdEncoding.EncodeToString(hash))
+	}
+	if signAlgoParams != nil {
+		req.SignAlgoParams = base64.StdEncoding.EncodeToString(signAlgoParams)
+	}
+
+	var resp signHashResponse
+	if err := c.call("signatures/signHash", "", req, &resp); err != nil {
+		return nil, err
+	}
+	var signatures [][]byte
+	for _, sig := range resp.Signatures {
+		data, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, data)
+	}
+	if len(signatures) != len(hashes) {
+		return nil, fmt.Errorf("%d signatures returned for %d hashes", len(signatures), len(hashes))
+	}
+	return signatures, nil
+}
+
+// call calls CSC API method `method` with request `req`, decoding the response into `resp`. The request
+// is authenticated with `basicAuth` (user:password) if set, with the access token otherwise.
+func (c *cscClient) call(method, basicAuth string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", c.baseURL+"/csc/v1/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if basicAuth != "" {
+		httpReq.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(basicAuth)))
+	} else if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		var cscErr cscError
+		if json.NewDecoder(httpResp.Body).Decode(&cscErr) == nil && cscErr.Error != "" {
+			return fmt.Errorf("%s: %s: %s", method, cscErr.Error, cscErr.ErrorDescription)
+		}
+		return fmt.Errorf("%s: %s", method, httpResp.Status)
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// cscSigner is a crypto.Signer whose private key is held by a remote signing service.
+type cscSigner struct {
+	service      signingService
+	credentialID string
+	pin          string
+	algorithms   []string
+	chain        []*x509.Certificate // Signer certificate first.
+}
+
+// newCSCSigner returns the signer of credential `credentialID` of `service`, authorized with `pin`.
+func newCSCSigner(service signingService, credentialID, pin string) (*cscSigner, error) {
+	info, err := service.CredentialInfo(credentialID)
+	if err != nil {
+		return nil, err
+	}
+	if info.Key.Status != "enabled" {
+		return nil, fmt.Errorf("credential %s key is %s", credentialID, info.Key.Status)
+	}
+
+	signer := &cscSigner{service: service, credentialID: credentialID, pin: pin, algorithms: info.Key.Algo}
+	for _, data := range info.Cert.Certificates {
+		der, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		signer.chain = append(signer.chain, cert)
+	}
+	if len(signer.chain) == 0 {
+		return nil, fmt.Errorf("credential %s has no certificate", credentialID)
+	}
+	return signer, nil
+}
+
+// Public returns the public key of the signer certificate.
+func (s *cscSigner) Public() crypto.PublicKey {
+	return s.chain[0].PublicKey
+}
+
+// Sign signs `digest` with the remote key. The random source is not used.
+func (s *cscSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	var signAlgo asn1.ObjectIdentifier
+	var signAlgoParams []byte
+	switch s.Public().(type) {
+	case *rsa.PublicKey:
+		signAlgo = oidRSAEncryption
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			if pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash {
+				return nil, errors.New("only PSS salts of the hash length are supported")
+			}
+			alg, err := pssAlgorithm(hash)
+			if err != nil {
+				return nil, err
+			}
+			signAlgo, signAlgoParams = alg.Algorithm, alg.Parameters.FullBytes
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			signAlgo = oidECDSASHA256
+		case crypto.SHA384:
+			signAlgo = oidECDSASHA384
+		case crypto.SHA512:
+			signAlgo = oidECDSASHA512
+		default:
+			return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", s.Public())
+	}
+
+	supported := false
+	for _, algo := range s.algorithms {
+		supported = supported || algo == signAlgo.String()
+	}
+	if !supported {
+		return nil, fmt.Errorf("signature algorithm %s not supported by credential %s", signAlgo, s.credentialID)
+	}
+
+	// The Signature Activation Data authorizes the signature of this digest only.
+	sad, err := s.service.Authorize(s.credentialID, s.pin, [][]byte{digest})
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := s.service.SignHash(s.credentialID, sad, [][]byte{digest}, hash, signAlgo, signAlgoParams)
+	if err != nil {
+		return nil, err
+	}
+	return signatures[0], nil
+}
+
+// cmsHandler is an adbe.pkcs7.detached signature handler signing with a crypto.Signer, supporting RSA
+// (PKCS #1 v1.5 or PSS) and ECDSA keys.
+type cmsHandler struct {
+	signer crypto.Signer
+	chain  []*x509.Certificate // Signer certificate first.
+	hash   crypto.Hash
+	pss    bool
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *cmsHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "adbe.pkcs7.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *cmsHandler) InitSignature(sig *model.PdfSignature) error {
+	if h.signer == nil || len(h.chain) == 0 {
+		return errors.New("signer and certificate must not be nil")
+	}
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("adbe.pkcs7.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *cmsHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *cmsHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	data, err := signCMS(digest.(*bytes.Buffer).Bytes(), h.signer, h.chain, h.hash, h.pss)
+	if err != nil {
+		return err
+	}
+	if len(data) > signatureLen {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, signatureLen)
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// Validate validates PdfSignature.
+func (h *cmsHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if err := verifyCMS(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// signCMS returns the detached CMS signature of `data` by `signer` with certificate chain `chain`, using
+// digest algorithm `hash` and the RSASSA-PSS scheme for RSA keys if `pss` is true.
+func signCMS(data []byte, signer crypto.Signer, chain []*x509.Certificate, hash crypto.Hash, pss bool) ([]byte, error) {
+	digestAlg, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	var sigAlg pkix.AlgorithmIdentifier
+	var opts crypto.SignerOpts = hash
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if !pss {
+			sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+			break
+		}
+		sigAlg, err = pssAlgorithm(hash)
+		if err != nil {
+			return nil, err
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSASHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSASHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSASHA512
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// Signed attributes, DER encoded as a SET OF sorted by encoding.
+	h := hash.New()
+	h.Write(data)
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{attr.oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the signed attributes.
+	h = hash.New()
+	h.Write(set)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw...)
+	}
+	cert := chain[0]
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: digestAlg},
+			AuthenticatedAttributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// verifyCMS verifies the detached CMS signature `contents` of `data`.
+func verifyCMS(contents, data []byte) error {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	if len(p7.Signers) != 1 {
+		return errors.New("signature must have a single signer")
+	}
+	signer := p7.Signers[0]
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		// RSA PKCS #1 v1.5 and ECDSA signatures are verified by the pkcs7 package.
+		return p7.Verify()
+	}
+
+	// Verify the message digest of the RSASSA-PSS signature.
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return errors.New("signer certificate not found")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signer certificate key is not RSA")
+	}
+	hash, err := hashAlgorithm(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if err := p7.UnmarshalSignedAttribute(oidMessageDigest, &digest); err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("message digest mismatch")
+	}
+
+	// Verify the signature of the signed attributes.
+	var attrs [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return err
+	}
+
+	var params pssParameters
+	if _, err := asn1.Unmarshal(signer.DigestEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	pssHash, err := hashAlgorithm(params.Hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	h = pssHash.New()
+	h.Write(set)
+	opts := &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: pssHash}
+	return rsa.VerifyPSS(pub, pssHash, h.Sum(nil), signer.EncryptedDigest, opts)
+}
+
+// pssAlgorithm returns the RSASSA-PSS algorithm identifier with digest algorithm `hash`.
+func pssAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	oid, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	hashAlg := pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(hashAlg)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	params, err := asn1.Marshal(pssParameters{
+		Hash:         hashAlg,
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		SaltLength:   hash.Size(),
+		TrailerField: 1,
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
+
+// hashAlgorithmOID returns the object identifier of hash algorithm `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
+}
+
+// mockCSCServer is a local stand-in of a CSC signing service, with an RSA and an ECDSA credential issued
+// by a local test CA.
+type mockCSCServer struct {
+	credentials map[string]*mockCredential
+	mux         *http.ServeMux
+
+	mu             sync.Mutex
+	tokens         map[string]bool
+	authorizations map[string]*mockAuthorization // By Signature Activation Data.
+}
+
+// mockCredential is a credential of the mock CSC server.
+type mockCredential struct {
+	key        crypto.Signer
+	chain      []*x509.Certificate
+	algorithms []asn1.ObjectIdentifier
+}
+
+// mockAuthorization is the authorization of the signature of hashes by a credential.
+type mockAuthorization struct {
+	credentialID string
+	hashes       map[string]bool
+}
+
+// newMockCSCServer generates the keys and certificates of the mock CSC server.
+func newMockCSCServer() (*mockCSCServer, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:   "Mock CSC CA",
+			Organization: []string{"Test Company"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caData, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(caData)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &mockCSCServer{
+		credentials:    map[string]*mockCredential{},
+		tokens:         map[string]bool{},
+		authorizations: map[string]*mockAuthorization{},
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	for i, cred := range []struct {
+		id         string
+		key        crypto.Signer
+		algorithms []asn1.ObjectIdentifier
+	}{
+		{"rsa-2048", rsaKey, []asn1.ObjectIdentifier{oidRSAEncryption, oidRSASSAPSS}},
+		{"ecdsa-p256", ecdsaKey, []asn1.ObjectIdentifier{oidECDSASHA256, oidECDSASHA384, oidECDSASHA512}},
+	} {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject: pkix.Name{
+				CommonName:   "Remote Signer " + cred.id,
+				Organization: []string{"Test Company"},
+			},
+			NotBefore: now.Add(-time.Hour),
+			NotAfter:  now.Add(time.Hour * 24 * 365),
+			KeyUsage:  x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		}
+		certData, err := x509.CreateCertificate(rand.Reader, template, caCert, cred.key.Public(), caKey)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(certData)
+		if err != nil {
+			return nil, err
+		}
+		server.credentials[cred.id] = &mockCredential{
+			key:        cred.key,
+			chain:      []*x509.Certificate{cert, caCert},
+			algorithms: cred.algorithms,
+		}
+	}
+
+	server.mux = http.NewServeMux()
+	server.mux.HandleFunc("/csc/v1/info", server.serveInfo)
+	server.mux.HandleFunc("/csc/v1/auth/login", server.serveLogin)
+	server.mux.HandleFunc("/csc/v1/credentials/list", server.serveList)
+	server.mux.HandleFunc("/csc/v1/credentials/info", server.serveCredentialInfo)
+	server.mux.HandleFunc("/csc/v1/credentials/authorize", server.serveAuthorize)
+	server.mux.HandleFunc("/csc/v1/signatures/signHash", server.serveSignHash)
+	return server, nil
+}
+
+// ServeHTTP serves the CSC API requests.
+func (s *mockCSCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if r.URL.Path != "/csc/v1/info" && r.URL.Path != "/csc/v1/auth/login" {
+		// Other methods require an access token.
+		s.mu.Lock()
+		authorized := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		s.mu.Unlock()
+		if !authorized {
+			writeError(w, http.StatusUnauthorized, "invalid_token", "Invalid or missing access token")
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *mockCSCServer) serveInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"specs":       "1.0.4.0",
+		"name":        "Mock CSC server",
+		"authType":    []string{"basic"},
+		"methods":     []string{"auth/login", "credentials/list", "credentials/info", "credentials/authorize", "signatures/signHash"},
+		"description": "Local stand-in of a remote signing service",
+	})
+}
+
+func (s *mockCSCServer) serveLogin(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != mockUser || password != mockPassword {
+		writeError(w, http.StatusUnauthorized, "invalid_request", "Invalid user or password")
+		return
+	}
+	token := randomHex()
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJSON(w, loginResponse{AccessToken: token, ExpiresIn: 3600})
+}
+
+func (s *mockCSCServer) serveList(w http.ResponseWriter, r *http.Request) {
+	var resp listResponse
+	for id := range s.credentials {
+		resp.CredentialIDs = append(resp.CredentialIDs, id)
+	}
+	sort.Strings(resp.CredentialIDs)
+	writeJSON(w, resp)
+}
+
+func (s *mockCSCServer) serveCredentialInfo(w http.ResponseWriter, r *http.Request) {
+	var req infoRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	cred, ok := s.credentials[req.CredentialID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid parameter credentialID")
+		return
+	}
+
+	var resp credentialInfo
+	resp.Key.Status = "enabled"
+	for _, algo := range cred.algorithms {
+		resp.Key.Algo = append(resp.Key.Algo, algo.String())
+	}
+	switch pub := cred.key.Public().(type) {
+	case *rsa.PublicKey:
+		resp.Key.Len = pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		resp.Key.Len = pub.Curve.Params().BitSize
+	}
+	resp.Cert.Status = "valid"
+	resp.Cert.SubjectDN = cred.chain[0].Subject.String()
+	for i, cert := range cred.chain {
+		if i > 0 && req.Certificates != "chain" {
+			break
+		}
+		resp.Cert.Certificates = append(resp.Cert.Certificates, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	resp.AuthMode = "explicit"
+	resp.Multisign = 1
+	writeJSON(w, resp)
+}
+
+func (s *mockCSCServer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	var req authorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if _, ok := s.credentials[req.CredentialID]; !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid parameter credentialID")
+		return
+	}
+	if req.PIN != mockPIN {
+		writeError(w, http.StatusBadRequest, "invalid_pin", "The PIN is invalid")
+		return
+	}
+	if req.NumSignatures != len(req.Hash) || len(req.Hash) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid parameter numSignatures")
+		return
+	}
+
+	auth := &mockAuthorization{credentialID: req.CredentialID, hashes: map[string]bool{}}
+	for _, hash := range req.Hash {
+		auth.hashes[hash] = true
+	}
+	sad := randomHex()
+	s.mu.Lock()
+	s.authorizations[sad] = auth
+	s.mu.Unlock()
+	writeJSON(w, authorizeResponse{SAD: sad, ExpiresIn: 300})
+}
+
+func (s *mockCSCServer) serveSignHash(w http.ResponseWriter, r *http.Request) {
+	var req signHashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// The Signature Activation Data can only be used once, for the authorized hashes.
+	s.mu.Lock()
+	auth := s.authorizations[req.SAD]
+	delete(s.authorizations, req.SAD)
+	s.mu.Unlock()
+	if auth == nil || auth.credentialID != req.CredentialID {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid SAD")
+		return
+	}
+	for _, hash := range req.Hash {
+		if !auth.hashes[hash] {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Hash not authorized by the SAD")
+			return
+		}
+	}
+
+	cred := s.credentials[req.CredentialID]
+	opts, err := signerOpts(cred, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	var resp signHashResponse
+	for _, hash := range req.Hash {
+		digest, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil || len(digest) != opts.HashFunc().Size() {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Invalid parameter hash")
+			return
+		}
+		signature, err := cred.key.Sign(rand.Reader, digest, opts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		resp.Signatures = append(resp.Signatures, base64.StdEncoding.EncodeToString(signature))
+	}
+	writeJSON(w, resp)
+}
+
+// signerOpts returns the signer options of signature request `req` by credential `cred`.
+func signerOpts(cred *mockCredential, req signHashRequest) (crypto.SignerOpts, error) {
+	supported := false
+	for _, algo := range cred.algorithms {
+		supported = supported || algo.String() == req.SignAlgo
+	}
+	if !supported {
+		return nil, fmt.Errorf("unsupported signAlgo %s", req.SignAlgo)
+	}
+
+	var hash crypto.Hash
+	switch req.SignAlgo {
+	case oidECDSASHA256.String():
+		hash = crypto.SHA256
+	case oidECDSASHA384.String():
+		hash = crypto.SHA384
+	case oidECDSASHA512.String():
+		hash = crypto.SHA512
+	case oidRSASSAPSS.String():
+		der, err := base64.StdEncoding.DecodeString(req.SignAlgoParams)
+		if err != nil {
+			return nil, err
+		}
+		var params pssParameters
+		if _, err := asn1.Unmarshal(der, &params); err != nil {
+			return nil, fmt.Errorf("invalid signAlgoParams: %v", err)
+		}
+		if hash, err = hashAlgorithm(params.Hash.Algorithm); err != nil {
+			return nil, err
+		}
+		return &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: hash}, nil
+	default:
+		// RSA PKCS #1 v1.5: the digest algorithm is specified by hashAlgo.
+		for _, h := range hashes {
+			if oid, _ := hashAlgorithmOID(h); oid.String() == req.HashAlgo {
+				hash = h
+			}
+		}
+		if hash == 0 {
+			return nil, fmt.Errorf("unsupported hashAlgo %s", req.HashAlgo)
+		}
+	}
+	return hash, nil
+}
+
+// writeJSON writes the JSON response `resp`.
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeError writes a CSC error response.
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(cscError{Error: code, ErrorDescription: description})
+}
+
+// randomHex returns a random hexadecimal token.
+func randomHex() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
@@ -1,26 +1,56 @@
 /*
  * This example showcases how to digitally sign a PDF file using an external
- * signing service which returns PKCS7 package. The external service is
- * is simulated by signing the file with UniDoc.
+ * signer (remote HSM, mobile app, smart card...) in two steps:
  *
- * $ ./pdf_sign_external <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ * 1. prepare: the PDF file is written with a signature field whose /Contents is
+ *    an empty placeholder of reserved size, and a signing request (JSON) with the
+ *    byte range and the digest to sign is written. By default the external signer
+ *    returns a detached CMS (PKCS7) signature of the byte range digest. With the
+ *    signer certificate (-cert), the CMS signed attributes are built here and the
+ *    external signer only returns the raw signature value of their digest.
+ * 2. inject: the signature blob produced elsewhere is verified against the
+ *    prepared file (byte range, digest and reserved size) and written into the
+ *    placeholder.
+ *
+ * The external signer is simulated by the keys and external-sign commands.
+ *
+ * CMS signature returned by the external signer:
+ * $ ./pdf_sign_external keys signer.key signer.crt
+ * $ ./pdf_sign_external prepare input.pdf prepared.pdf request.json
+ * $ ./pdf_sign_external external-sign signer.key signer.crt request.json signature.p7s
+ * $ ./pdf_sign_external inject prepared.pdf request.json signature.p7s output.pdf
+ *
+ * Raw signature value returned by the external signer:
+ * $ ./pdf_sign_external prepare -cert signer.crt input.pdf prepared.pdf request.json
+ * $ ./pdf_sign_external external-sign signer.key signer.crt request.json signature.bin
+ * $ ./pdf_sign_external inject prepared.pdf request.json signature.bin output.pdf
  */
 package main
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"os"
+	"sort"
 	"time"
 
+	"github.com/gunnsth/pkcs7"
 	"github.com/unidoc/unipdf/v3/annotator"
 	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
@@ -29,57 +59,357 @@ import (
 
 var now = time.Now()
 
-const usage = "Usage: %s INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+const usage = "Usage:\n" +
+	"  %[1]s prepare [-hash sha256|sha384|sha512] [-size BYTES] [-cert SIGNER_CERT] INPUT_PDF_PATH PREPARED_PDF_PATH REQUEST_PATH\n" +
+	"  %[1]s inject PREPARED_PDF_PATH REQUEST_PATH SIGNATURE_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s keys [-key rsa|ecdsa-p256|ecdsa-p384] SIGNER_KEY SIGNER_CERT\n" +
+	"  %[1]s external-sign SIGNER_KEY SIGNER_CERT REQUEST_PATH SIGNATURE_PATH\n"
+
+// Digest algorithms, by name.
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// CMS (RFC 5652) structures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// signingRequest is the output of the prepare step, describing the data to sign.
+type signingRequest struct {
+	// Digest algorithm name.
+	HashAlgorithm string `json:"hashAlgorithm"`
+	// Signed byte range of the prepared PDF file.
+	ByteRange []int64 `json:"byteRange"`
+	// Reserved size of the signature in bytes.
+	ContentsSize int `json:"contentsSize"`
+	// Hex encoded digest of the byte range.
+	DocumentDigest string `json:"documentDigest"`
+	// DER encoded CMS signed attributes and signer certificate, if the signature value is computed
+	// externally.
+	SignedAttributes []byte `json:"signedAttributes,omitempty"`
+	Certificate      []byte `json:"certificate,omitempty"`
+	// Hex encoded digest to sign: the digest of the signed attributes if set, the document digest otherwise.
+	Digest string `json:"digest"`
+}
 
 func main() {
-	args := os.Args
-	if len(args) < 3 {
+	if len(os.Args) < 3 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "prepare":
+		err = prepareCommand(os.Args[2:])
+	case "inject":
+		err = injectCommand(os.Args[2:])
+	case "keys":
+		err = keysCommand(os.Args[2:])
+	case "external-sign":
+		err = externalSignCommand(os.Args[2:])
+	default:
 		fmt.Printf(usage, os.Args[0])
 		return
 	}
-	inputPath := args[1]
-	outputPath := args[2]
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// prepareCommand prepares the PDF file for external signing with the options in `args`.
+func prepareCommand(args []string) error {
+	var hashName, certPath string
+	var size int
+	flags := flag.NewFlagSet("prepare", flag.ExitOnError)
+	flags.StringVar(&hashName, "hash", "sha256", "Digest algorithm: sha256, sha384 or sha512")
+	flags.IntVar(&size, "size", 8192, "Reserved size of the signature in bytes")
+	flags.StringVar(&certPath, "cert", "", "Signer certificate (PEM or DER), to sign the signed attributes digest only")
+	flags.Parse(args)
+	if flags.NArg() < 3 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	inputPath := flags.Arg(0)
+	preparedPath := flags.Arg(1)
+	requestPath := flags.Arg(2)
+
+	hash, ok := hashes[hashName]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %s", hashName)
+	}
 
 	// Generate PDF file signed with empty signature.
-	handler, err := sighandler.NewEmptyAdobePKCS7Detached(8192)
+	handler, err := sighandler.NewEmptyAdobePKCS7Detached(size)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return err
 	}
 
 	pdfData, signature, err := generateSignedFile(inputPath, handler)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return err
 	}
 
 	// Parse signature byte range.
 	byteRange, err := parseByteRange(signature.ByteRange)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return err
+	}
+
+	request := &signingRequest{
+		HashAlgorithm: hashName,
+		ByteRange:     []int64{byteRange[0], byteRange[1] - byteRange[0], byteRange[2], byteRange[3] - byteRange[2]},
+		ContentsSize:  size,
+	}
+	documentDigest := digestByteRange(pdfData, byteRange, hash)
+	request.DocumentDigest = hex.EncodeToString(documentDigest)
+	request.Digest = request.DocumentDigest
+
+	// Build the signed attributes, so that the external signer only has to sign their digest.
+	if certPath != "" {
+		cert, err := loadCertificate(certPath)
+		if err != nil {
+			return err
+		}
+		attrs, err := signedAttributes(documentDigest)
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(attrs)
+		request.SignedAttributes = attrs
+		request.Certificate = cert.Raw
+		request.Digest = hex.EncodeToString(h.Sum(nil))
 	}
 
-	// This would be the time to send the PDF buffer to a signing device or
-	// signing web service and get back the signature. We will simulate this by
-	// signing the PDF using UniDoc and returning the signature data.
-	signatureData, err := getExternalSignature(inputPath)
+	requestData, err := json.MarshalIndent(request, "", "  ")
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return err
+	}
+	if err := ioutil.WriteFile(preparedPath, pdfData, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(requestPath, requestData, 0644); err != nil {
+		return err
 	}
 
-	// Apply external signature to the PDF data buffer.
-	// Overwrite the generated empty signature with the signature
-	// bytes retrieved from the external service.
-	sigBytes := make([]byte, 8192)
-	copy(sigBytes, signatureData)
+	log.Printf("PDF file successfully prepared. Output path: %s\n", preparedPath)
+	log.Printf("Digest to sign (%s): %s\n", hashName, request.Digest)
+	return nil
+}
 
+// injectCommand injects the external signature into the prepared PDF file with the options in `args`.
+func injectCommand(args []string) error {
+	flags := flag.NewFlagSet("inject", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() < 4 {
+		fmt.Printf(usage, os.Args[0])
+		os.Exit(1)
+	}
+	preparedPath := flags.Arg(0)
+	requestPath := flags.Arg(1)
+	signaturePath := flags.Arg(2)
+	outputPath := flags.Arg(3)
+
+	pdfData, err := ioutil.ReadFile(preparedPath)
+	if err != nil {
+		return err
+	}
+	request, err := loadRequest(requestPath)
+	if err != nil {
+		return err
+	}
+	blob, err := ioutil.ReadFile(signaturePath)
+	if err != nil {
+		return err
+	}
+
+	// Check that the prepared file matches the signing request.
+	br := request.ByteRange
+	byteRange := []int64{br[0], br[0] + br[1], br[2], br[2] + br[3]}
+	if br[0] != 0 || byteRange[3] != int64(len(pdfData)) {
+		return errors.New("byte range does not cover the prepared file")
+	}
+	placeholder := append(append([]byte("<"), bytes.Repeat([]byte("0"), 2*request.ContentsSize)...), '>')
+	if !bytes.Equal(pdfData[byteRange[1]:byteRange[2]], placeholder) {
+		return errors.New("byte range gap is not an empty signature placeholder of the reserved size")
+	}
+	hash := hashes[request.HashAlgorithm]
+	if hex.EncodeToString(digestByteRange(pdfData, byteRange, hash)) != request.DocumentDigest {
+		return errors.New("prepared file modified after the signing request")
+	}
+
+	// Build the CMS signature from the raw signature value, or verify the CMS signature.
+	var cms []byte
+	if request.SignedAttributes != nil {
+		cert, err := x509.ParseCertificate(request.Certificate)
+		if err != nil {
+			return err
+		}
+		cms, err = buildCMS(request.SignedAttributes, blob, cert, hash)
+		if err != nil {
+			return err
+		}
+	} else {
+		cms = trimDER(blob)
+	}
+	signed := append(append([]byte{}, pdfData[byteRange[0]:byteRange[1]]...), pdfData[byteRange[2]:byteRange[3]]...)
+	if err := verifyCMS(cms, signed); err != nil {
+		return fmt.Errorf("external signature does not match the byte range: %v", err)
+	}
+	if len(cms) > request.ContentsSize {
+		return fmt.Errorf("signature of %d bytes exceeds the reserved size of %d bytes", len(cms), request.ContentsSize)
+	}
+
+	// Overwrite the empty signature placeholder with the signature.
+	sigBytes := make([]byte, request.ContentsSize)
+	copy(sigBytes, cms)
 	sig := core.MakeHexString(string(sigBytes)).WriteString()
 	copy(pdfData[byteRange[1]:byteRange[2]], []byte(sig))
 
 	// Write output file.
-	if err := ioutil.WriteFile(outputPath, pdfData, os.ModePerm); err != nil {
-		log.Fatal("Fail: %v\n", err)
+	if err := ioutil.WriteFile(outputPath, pdfData, 0644); err != nil {
+		return err
 	}
 
 	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+	return nil
+}
+
+// keysCommand generates the key pair of the simulated external signer with the options in `args`.
+func keysCommand(args []string) error {
+	var keyType string
+	flags := flag.NewFlagSet("keys", flag.ExitOnError)
+	flags.StringVar(&keyType, "key", "rsa", "Key type: rsa, ecdsa-p256 or ecdsa-p384")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	priv, cert, err := generateKeys(keyType)
+	if err != nil {
+		return err
+	}
+	keyData, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(flags.Arg(0), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyData}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(flags.Arg(1), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+}
+
+// externalSignCommand simulates the external signer, signing the request with the options in `args`.
+func externalSignCommand(args []string) error {
+	flags := flag.NewFlagSet("external-sign", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() < 4 {
+		fmt.Printf(usage, os.Args[0])
+		os.Exit(1)
+	}
+
+	keyData, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return errors.New("invalid signer key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key %T", key)
+	}
+	cert, err := loadCertificate(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	request, err := loadRequest(flags.Arg(2))
+	if err != nil {
+		return err
+	}
+	hash := hashes[request.HashAlgorithm]
+
+	// Raw signature value of the signed attributes digest.
+	if request.SignedAttributes != nil {
+		digest, err := hex.DecodeString(request.Digest)
+		if err != nil {
+			return err
+		}
+		signature, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(flags.Arg(3), signature, 0644)
+	}
+
+	// CMS signature of the document digest.
+	documentDigest, err := hex.DecodeString(request.DocumentDigest)
+	if err != nil {
+		return err
+	}
+	attrs, err := signedAttributes(documentDigest)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(attrs)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return err
+	}
+	cms, err := buildCMS(attrs, signature, cert, hash)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(flags.Arg(3), cms, 0644)
 }
 
 // generateSignedFile generates a signed version of the input PDF file using the
@@ -127,6 +457,9 @@ func generateSignedFile(inputPath string, handler model.SignatureHandler) ([]byt
 		},
 		opts,
 	)
+	if err != nil {
+		return nil, nil, err
+	}
 	field.T = core.MakeString("External signature")
 
 	if err = appender.Sign(1, field); err != nil {
@@ -142,13 +475,23 @@ func generateSignedFile(inputPath string, handler model.SignatureHandler) ([]byt
 	return pdfBuf.Bytes(), signature, nil
 }
 
-// getExternalSignature simulates an external service which signs the specified
-// PDF file and returns its signature.
-func getExternalSignature(inputPath string) ([]byte, error) {
+// generateKeys generates a private key of type `keyType` and a self-signed certificate.
+func generateKeys(keyType string) (crypto.Signer, *x509.Certificate, error) {
 	// Generate private key.
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	var priv crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p256":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Initialize X509 certificate template.
@@ -168,26 +511,162 @@ func getExternalSignature(inputPath string) ([]byte, error) {
 	// Generate X509 certificate.
 	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, cert, nil
+}
+
+// loadCertificate loads the PEM or DER encoded certificate in `path`.
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
 
-	// Sign input file.
-	handler, err := sighandler.NewAdobePKCS7Detached(priv, cert)
+// loadRequest loads the signing request in `path`.
+func loadRequest(path string) (*signingRequest, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	var request signingRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	if _, ok := hashes[request.HashAlgorithm]; !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", request.HashAlgorithm)
+	}
+	if len(request.ByteRange) != 4 {
+		return nil, errors.New("invalid byte range")
+	}
+	return &request, nil
+}
 
-	_, signature, err := generateSignedFile(inputPath, handler)
+// digestByteRange returns the digest of `data` in `byteRange` (start and end offsets) with `hash`.
+func digestByteRange(data []byte, byteRange []int64, hash crypto.Hash) []byte {
+	h := hash.New()
+	h.Write(data[byteRange[0]:byteRange[1]])
+	h.Write(data[byteRange[2]:byteRange[3]])
+	return h.Sum(nil)
+}
+
+// signedAttributes returns the DER encoded CMS signed attributes of document digest `digest`.
+func signedAttributes(digest []byte) ([]byte, error) {
+	// The signed attributes are DER encoded as a SET OF, sorted by encoding.
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, digest},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{attr.oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+}
+
+// buildCMS returns the detached CMS signature with the DER encoded signed attributes `attrs`, their
+// signature value `signature` by the key of certificate `cert` and digest algorithm `hash`.
+func buildCMS(attrs, signature []byte, cert *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	digestAlg, err := hashAlgorithmOID(hash)
 	if err != nil {
 		return nil, err
 	}
 
-	return signature.Contents.Bytes(), nil
+	// Check the signature value.
+	h := hash.New()
+	h.Write(attrs)
+	var sigAlg pkix.AlgorithmIdentifier
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature); err != nil {
+			return nil, fmt.Errorf("invalid signature value: %v", err)
+		}
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, h.Sum(nil), signature) {
+			return nil, errors.New("invalid signature value")
+		}
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSASHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSASHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSASHA512
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// The signed attributes are [0] IMPLICIT in the signer info.
+	var set asn1.RawValue
+	if _, err := asn1.Unmarshal(attrs, &set); err != nil {
+		return nil, err
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: digestAlg},
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: set.Bytes},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// verifyCMS verifies the detached CMS signature `cms` of `data`.
+func verifyCMS(cms, data []byte) error {
+	p7, err := pkcs7.Parse(cms)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	return p7.Verify()
 }
 
 // parseByteRange parses the ByteRange value of the signature field.
@@ -219,3 +698,25 @@ func parseByteRange(byteRange *core.PdfObjectArray) ([]int64, error) {
 
 	return []int64{s1, s1 + l1, s2, s2 + l2}, nil
 }
+
+// trimDER returns the DER encoded element at the start of `data`, removing any trailing padding.
+func trimDER(data []byte) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return data
+	}
+	return raw.FullBytes
+}
+
+// hashAlgorithmOID returns the object identifier of hash algorithm `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+}
@@ -0,0 +1,684 @@
+/*
+ * This example showcases how to list the signature fields of a PDF file and sign an existing empty
+ * signature field by name. The signature is placed at the rectangle and page of the field.
+ *
+ * The field lock dictionary (/Lock) and seed values (/SV) of the field are honoured:
+ * - the fields locked by the signature are made read-only and recorded in a FieldMDP signature reference,
+ * - the signature handler is selected according to the /Filter and /SubFilter seed values,
+ * - the reason must be one of the /Reasons seed values (the first one is used if none is provided),
+ * - the signing certificate must match the /Cert seed value /Subject and /Issuer certificates,
+ * - seed values marked as required in /Ff which cannot be satisfied (digest method, timestamp,
+ *   revocation information) result in an error, other unsupported seed values are reported.
+ *
+ * The file is signed using a PKCS12 (.p12/.pfx) file if specified, or a generated private/public key pair.
+ *
+ * List signature fields:
+ * $ ./pdf_sign_field list <INPUT_PDF_PATH>
+ *
+ * Sign an empty signature field:
+ * $ ./pdf_sign_field sign [options] <INPUT_PDF_PATH> <FIELD_NAME> <OUTPUT_PDF_PATH>
+ */
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+	"github.com/unidoc/unipdf/v3/model/sighandler"
+	"golang.org/x/crypto/pkcs12"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s list INPUT_PDF_PATH\n" +
+	"  %[1]s sign [options] INPUT_PDF_PATH FIELD_NAME OUTPUT_PDF_PATH\n"
+
+// Seed value dictionary flags (Section 12.7.4.5, Table 234 - Entries in a signature field seed value
+// dictionary p. 457 in PDF32000_2008).
+const (
+	svFlagFilter           = 1
+	svFlagSubFilter        = 1 << 1
+	svFlagV                = 1 << 2
+	svFlagReasons          = 1 << 3
+	svFlagLegalAttestation = 1 << 4
+	svFlagAddRevInfo       = 1 << 5
+	svFlagDigestMethod     = 1 << 6
+)
+
+// Certificate seed value dictionary flags (Table 235).
+const (
+	certFlagSubject = 1
+	certFlagIssuer  = 1 << 1
+)
+
+// Signature handlers supported by the example, by SubFilter.
+var supportedSubFilters = []string{"adbe.pkcs7.detached", "adbe.x509.rsa_sha1"}
+
+// signatureField represents a signature field with its widget annotation and page number.
+type signatureField struct {
+	name    string
+	field   *model.PdfField
+	sig     *model.PdfFieldSignature
+	widget  *model.PdfAnnotationWidget
+	pageNum int
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = listFields(os.Args[2])
+	case "sign":
+		err = signCommand(os.Args[2:])
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// listFields prints the signature fields of the PDF file in `inputPath`.
+func listFields(inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	fields, err := getSignatureFields(reader)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		fmt.Println("No signature fields")
+		return nil
+	}
+
+	for _, sf := range fields {
+		status := "empty"
+		if sf.sig.V != nil {
+			status = "signed"
+			if sf.sig.V.Name != nil {
+				status += " by " + sf.sig.V.Name.Decoded()
+			}
+		}
+		fmt.Printf("%s: %s\n", sf.name, status)
+		if sf.widget != nil {
+			fmt.Printf("  Page %d, rect %s\n", sf.pageNum, sf.widget.Rect)
+		}
+		if lock := getDict(sf.sig.Lock); lock != nil {
+			action, _ := core.GetNameVal(lock.Get("Action"))
+			fmt.Printf("  Lock: %s %s\n", action, strings.Join(getStrings(lock.Get("Fields")), ", "))
+		}
+		if sv := getDict(sf.sig.SV); sv != nil {
+			fmt.Printf("  Seed values: %s\n", sv.WriteString())
+		}
+	}
+	return nil
+}
+
+// signCommand signs an empty signature field with the options in `args`.
+func signCommand(args []string) error {
+	var p12Path, password, name, reason, location string
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	flags.StringVar(&p12Path, "p12", "", "PKCS12 (.p12/.pfx) file (default: generated key pair)")
+	flags.StringVar(&password, "password", "", "Password of the PKCS12 file")
+	flags.StringVar(&name, "name", "Jane Doe", "Signer name")
+	flags.StringVar(&reason, "reason", "", "Signing reason (default: first seed value reason)")
+	flags.StringVar(&location, "location", "", "Signing location")
+	flags.Parse(args)
+	if flags.NArg() < 3 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	inputPath := flags.Arg(0)
+	fieldName := flags.Arg(1)
+	outputPath := flags.Arg(2)
+
+	// Get private key and X509 certificate.
+	var priv *rsa.PrivateKey
+	var cert *x509.Certificate
+	var err error
+	if p12Path != "" {
+		priv, cert, err = loadPKCS12(p12Path, password)
+	} else {
+		priv, cert, err = generateKeys()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Create reader.
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	// Find the empty signature field.
+	fields, err := getSignatureFields(reader)
+	if err != nil {
+		return err
+	}
+	var target *signatureField
+	for _, sf := range fields {
+		if sf.name == fieldName {
+			target = sf
+			break
+		}
+	}
+	switch {
+	case target == nil:
+		return fmt.Errorf("signature field %s not found", fieldName)
+	case target.sig.V != nil:
+		return fmt.Errorf("signature field %s is already signed", fieldName)
+	case target.widget == nil || target.pageNum == 0:
+		return fmt.Errorf("signature field %s has no widget annotation on a page", fieldName)
+	}
+	rect, err := core.GetNumbersAsFloat(target.widget.Rect.(*core.PdfObjectArray).Elements())
+	if err != nil {
+		return err
+	}
+
+	// Apply the seed values.
+	subFilter, reason, err := applySeedValues(getDict(target.sig.SV), cert, reason)
+	if err != nil {
+		return err
+	}
+
+	// Create signature handler.
+	var handler model.SignatureHandler
+	if subFilter == "adbe.x509.rsa_sha1" {
+		handler, err = sighandler.NewAdobeX509RSASHA1(priv, cert)
+	} else {
+		handler, err = sighandler.NewAdobePKCS7Detached(priv, cert)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Create signature.
+	signature := model.NewPdfSignature(handler)
+	signature.SetName(name)
+	signature.SetReason(reason)
+	signature.SetLocation(location)
+	signature.SetDate(now, "")
+
+	if err := signature.Initialize(); err != nil {
+		return err
+	}
+
+	// Lock the fields specified by the lock dictionary.
+	if lock := getDict(target.sig.Lock); lock != nil {
+		locked, err := lockFields(reader, lock, target.field)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Locked fields: %s\n", strings.Join(locked, ", "))
+		data, err := fieldMDPData(reader)
+		if err != nil {
+			return err
+		}
+		signature.Reference = core.MakeArray(makeFieldMDPReference(lock, data))
+	}
+
+	lines := []*annotator.SignatureLine{
+		annotator.NewSignatureLine("Name", name),
+		annotator.NewSignatureLine("Date", now.Format("2006.01.02")),
+	}
+	if reason != "" {
+		lines = append(lines, annotator.NewSignatureLine("Reason", reason))
+	}
+	if location != "" {
+		lines = append(lines, annotator.NewSignatureLine("Location", location))
+	}
+
+	opts := annotator.NewSignatureFieldOpts()
+	opts.Rect = rect
+	sigField, err := annotator.NewSignatureField(signature, lines, opts)
+	if err != nil {
+		return err
+	}
+
+	// Create appender and sign.
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		return err
+	}
+	if err = signEmptyField(appender, reader, target.field, target.widget, signature, sigField.AP); err != nil {
+		return err
+	}
+
+	// Write output PDF file.
+	if err = appender.WriteToFile(outputPath); err != nil {
+		return err
+	}
+
+	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+	return nil
+}
+
+// applySeedValues checks the signing options against the seed value dictionary `sv` (if any). It returns
+// the SubFilter of the signature handler to use and the signing reason.
+func applySeedValues(sv *core.PdfObjectDictionary, cert *x509.Certificate, reason string) (string, string, error) {
+	subFilter := supportedSubFilters[0]
+	if sv == nil {
+		return subFilter, reason, nil
+	}
+	flags, _ := core.GetIntVal(sv.Get("Ff"))
+
+	// Filter: only the Adobe.PPKLite handler is available.
+	if filter, ok := core.GetNameVal(sv.Get("Filter")); ok && filter != "Adobe.PPKLite" {
+		if flags&svFlagFilter != 0 {
+			return "", "", fmt.Errorf("required filter %s is not supported", filter)
+		}
+		fmt.Printf("WARNING: filter %s is not supported, using Adobe.PPKLite\n", filter)
+	}
+
+	// SubFilter: use the first supported entry.
+	if subFilters := getNames(sv.Get("SubFilter")); len(subFilters) > 0 {
+		found := false
+		for _, sf := range subFilters {
+			if contains(supportedSubFilters, sf) {
+				subFilter = sf
+				found = true
+				break
+			}
+		}
+		if !found {
+			if flags&svFlagSubFilter != 0 {
+				return "", "", fmt.Errorf("none of the required subfilters %v is supported", subFilters)
+			}
+			fmt.Printf("WARNING: subfilters %v are not supported, using %s\n", subFilters, subFilter)
+		}
+	}
+
+	// DigestMethod: the signature handlers digest with SHA1.
+	if methods := getNames(sv.Get("DigestMethod")); len(methods) > 0 && !contains(methods, "SHA1") {
+		if flags&svFlagDigestMethod != 0 {
+			return "", "", fmt.Errorf("none of the required digest methods %v is supported", methods)
+		}
+		fmt.Printf("WARNING: digest methods %v are not supported, using SHA1\n", methods)
+	}
+
+	// Reasons: a single "." entry means no reason may be specified.
+	if reasons := getStrings(sv.Get("Reasons")); len(reasons) > 0 {
+		switch {
+		case len(reasons) == 1 && reasons[0] == ".":
+			if reason != "" && flags&svFlagReasons != 0 {
+				return "", "", errors.New("no signing reason allowed")
+			}
+		case reason == "":
+			reason = reasons[0]
+		case !contains(reasons, reason) && flags&svFlagReasons != 0:
+			return "", "", fmt.Errorf("reason must be one of %v", reasons)
+		}
+	}
+
+	// AddRevInfo: revocation information is not embedded.
+	if addRevInfo, ok := core.GetBoolVal(sv.Get("AddRevInfo")); ok && addRevInfo {
+		if flags&svFlagAddRevInfo != 0 {
+			return "", "", errors.New("embedding revocation information is not supported")
+		}
+		fmt.Println("WARNING: revocation information is not embedded")
+	}
+
+	if attestations := getStrings(sv.Get("LegalAttestation")); len(attestations) > 0 {
+		fmt.Printf("WARNING: legal attestations %v are not supported\n", attestations)
+	}
+	if mdp := getDict(sv.Get("MDP")); mdp != nil {
+		if p, _ := core.GetIntVal(mdp.Get("P")); p != 0 {
+			fmt.Printf("WARNING: certification (MDP P=%d) is not supported, signing as approval signature\n", p)
+		}
+	}
+
+	// TimeStamp: timestamps are not supported.
+	if ts := getDict(sv.Get("TimeStamp")); ts != nil {
+		url, _ := core.GetStringVal(ts.Get("URL"))
+		if tsFlags, _ := core.GetIntVal(ts.Get("Ff")); tsFlags&1 != 0 {
+			return "", "", fmt.Errorf("required timestamp from %s is not supported", url)
+		}
+		fmt.Printf("WARNING: timestamp from %s is not supported\n", url)
+	}
+
+	// Cert: the signing certificate must be one of the Subject certificates and issued by one of the
+	// Issuer certificates.
+	if certSV := getDict(sv.Get("Cert")); certSV != nil {
+		if err := checkCertSeedValue(certSV, cert); err != nil {
+			return "", "", err
+		}
+	}
+
+	return subFilter, reason, nil
+}
+
+// checkCertSeedValue checks `cert` against the certificate seed value dictionary `certSV`.
+func checkCertSeedValue(certSV *core.PdfObjectDictionary, cert *x509.Certificate) error {
+	flags, _ := core.GetIntVal(certSV.Get("Ff"))
+
+	if subjects := getStrings(certSV.Get("Subject")); len(subjects) > 0 {
+		match := false
+		for _, subject := range subjects {
+			if bytes.Equal([]byte(subject), cert.Raw) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			if flags&certFlagSubject != 0 {
+				return errors.New("signing certificate is not one of the required subject certificates")
+			}
+			fmt.Println("WARNING: signing certificate is not one of the subject certificates")
+		}
+	}
+
+	if issuers := getStrings(certSV.Get("Issuer")); len(issuers) > 0 {
+		match := false
+		for _, issuer := range issuers {
+			issuerCert, err := x509.ParseCertificate([]byte(issuer))
+			if err == nil && cert.CheckSignatureFrom(issuerCert) == nil {
+				match = true
+				break
+			}
+		}
+		if !match {
+			if flags&certFlagIssuer != 0 {
+				return errors.New("signing certificate is not issued by one of the required issuers")
+			}
+			fmt.Println("WARNING: signing certificate is not issued by one of the issuers")
+		}
+	}
+
+	for _, key := range []core.PdfObjectName{"OID", "SubjectDN", "KeyUsage", "URL"} {
+		if certSV.Get(key) != nil {
+			fmt.Printf("WARNING: certificate seed value %s is not checked\n", key)
+		}
+	}
+	return nil
+}
+
+// lockFields makes the fields specified by lock dictionary `lock` read-only, excluding the signature
+// field `sigField`. Returns the names of the locked fields.
+func lockFields(reader *model.PdfReader, lock *core.PdfObjectDictionary, sigField *model.PdfField) ([]string, error) {
+	action, _ := core.GetNameVal(lock.Get("Action"))
+	names := getStrings(lock.Get("Fields"))
+
+	// A field is listed if its name or the name of one of its parents is in the lock dictionary.
+	listed := func(fullname string) bool {
+		for _, name := range names {
+			if fullname == name || strings.HasPrefix(fullname, name+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var locked []string
+	for _, field := range reader.AcroForm.AllFields() {
+		if field == sigField || !field.IsTerminal() {
+			continue
+		}
+		fullname, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		switch action {
+		case "All":
+		case "Include":
+			if !listed(fullname) {
+				continue
+			}
+		case "Exclude":
+			if listed(fullname) {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("invalid lock action: %s", action)
+		}
+
+		// Keep the other flags of the field, which may be inherited from its parents.
+		var flags model.FieldFlag
+		for node := field; node != nil; node = node.Parent {
+			if node.Ff != nil {
+				flags = model.FieldFlag(*node.Ff)
+				break
+			}
+		}
+		field.SetFlag(flags.Set(model.FieldFlagReadOnly))
+		locked = append(locked, fullname)
+	}
+	return locked, nil
+}
+
+// makeFieldMDPReference returns a signature reference dictionary for the FieldMDP transform method
+// with the parameters of lock dictionary `lock`. `data` is the indirect object on which the modification
+// analysis is performed.
+func makeFieldMDPReference(lock *core.PdfObjectDictionary, data core.PdfObject) *core.PdfObjectDictionary {
+	params := core.MakeDict()
+	params.Set("Type", core.MakeName("TransformParams"))
+	params.Set("Action", lock.Get("Action"))
+	if fields := lock.Get("Fields"); fields != nil {
+		params.Set("Fields", fields)
+	}
+	params.Set("V", core.MakeName("1.2"))
+
+	ref := core.MakeDict()
+	ref.Set("Type", core.MakeName("SigRef"))
+	ref.Set("TransformMethod", core.MakeName("FieldMDP"))
+	ref.Set("TransformParams", params)
+	ref.Set("Data", data)
+	return ref
+}
+
+// fieldMDPData returns the Data entry of FieldMDP signature references (required by ISO 32000-1 Table 253):
+// the form of `reader` if it is an indirect object, otherwise the document catalog.
+func fieldMDPData(reader *model.PdfReader) (core.PdfObject, error) {
+	if ind, ok := reader.AcroForm.GetContainingPdfObject().(*core.PdfIndirectObject); ok {
+		return ind, nil
+	}
+
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root, ok := trailer.Get("Root").(*core.PdfObjectReference)
+	if !ok {
+		return nil, errors.New("catalog is not an indirect object")
+	}
+	return root, nil
+}
+
+// getSignatureFields returns the signature fields of the form of `reader`.
+func getSignatureFields(reader *model.PdfReader) ([]*signatureField, error) {
+	if reader.AcroForm == nil {
+		return nil, nil
+	}
+
+	// Page numbers of the annotations.
+	pageNums := map[*model.PdfAnnotation]int{}
+	for i, page := range reader.PageList {
+		annotations, err := page.GetAnnotations()
+		if err != nil {
+			return nil, err
+		}
+		for _, annot := range annotations {
+			pageNums[annot] = i + 1
+		}
+	}
+
+	var fields []*signatureField
+	for _, field := range reader.AcroForm.AllFields() {
+		sig, ok := field.GetContext().(*model.PdfFieldSignature)
+		if !ok {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		sf := &signatureField{name: name, field: field, sig: sig}
+		if len(field.Annotations) > 0 {
+			sf.widget = field.Annotations[0]
+			sf.pageNum = pageNums[sf.widget.PdfAnnotation]
+		}
+		fields = append(fields, sf)
+	}
+	return fields, nil
+}
+
+// signEmptyField signs the empty signature field `field` in place with `signature`, using `ap` as the
+// appearance of its widget annotation `wa`. The field keeps its place in the field hierarchy and its
+// entries, such as the partial name (/T), lock (/Lock) and seed value (/SV) dictionaries.
+func signEmptyField(appender *model.PdfAppender, reader *model.PdfReader, field *model.PdfField, wa *model.PdfAnnotationWidget, signature *model.PdfSignature, ap core.PdfObject) error {
+	sig, ok := field.GetContext().(*model.PdfFieldSignature)
+	if !ok {
+		return errors.New("not a signature field")
+	}
+	sig.V = signature
+	sig.ToPdfObject()
+
+	wa.AP = ap
+	if wa.F == nil {
+		wa.F = core.MakeInteger(4) // Print.
+	}
+	wa.ToPdfObject()
+
+	// The changed field and widget dictionaries are written with the form in the incremental update.
+	reader.AcroForm.SigFlags = core.MakeInteger(3)
+	appender.ReplaceAcroForm(reader.AcroForm)
+	return nil
+}
+
+func getDict(obj core.PdfObject) *core.PdfObjectDictionary {
+	if ind, ok := obj.(*core.PdfIndirectObject); ok && ind == nil {
+		return nil
+	}
+	dict, _ := core.GetDict(obj)
+	return dict
+}
+
+// getStrings returns the string values of array `obj`.
+func getStrings(obj core.PdfObject) []string {
+	var strs []string
+	if arr, ok := core.GetArray(obj); ok {
+		for _, o := range arr.Elements() {
+			if str, ok := core.GetString(o); ok {
+				strs = append(strs, str.Decoded())
+			}
+		}
+	}
+	return strs
+}
+
+// getNames returns the name values of array `obj`.
+func getNames(obj core.PdfObject) []string {
+	var names []string
+	if arr, ok := core.GetArray(obj); ok {
+		for _, o := range arr.Elements() {
+			if name, ok := core.GetNameVal(o); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// contains returns true if `list` contains `s`.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPKCS12 returns the private key and X509 certificate of the PKCS12 file in `p12Path`.
+func loadPKCS12(p12Path, password string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	pfxData, err := ioutil.ReadFile(p12Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, cert, err := pkcs12.Decode(pfxData, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rsaPriv, ok := priv.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("only RSA keys are supported")
+	}
+	return rsaPriv, cert, nil
+}
+
+func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
+	// Generate private key.
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Initialize X509 certificate template.
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test Company"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour * 24 * 365),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// Generate X509 certificate.
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, cert, nil
+}
@@ -1,69 +1,153 @@
 /*
  * This example showcases how to digitally sign a PDF file using a generated
- * private/public key pair.
+ * private/public key pair. RSA (PKCS #1 v1.5 or PSS) and ECDSA (P-256 or P-384)
+ * keys are supported, with SHA-256, SHA-384 or SHA-512 digests. The signature
+ * is validated after signing.
  *
- * $ ./pdf_sign_generate_keys <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ * $ ./pdf_sign_generate_keys [-key rsa|rsa-pss|ecdsa-p256|ecdsa-p384] [-hash sha256|sha384|sha512] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
  */
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
+	"sort"
 	"time"
 
+	"github.com/gunnsth/pkcs7"
 	"github.com/unidoc/unipdf/v3/annotator"
 	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
-	"github.com/unidoc/unipdf/v3/model/sighandler"
 )
 
 var now = time.Now()
 
-const usage = "Usage: %s INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+const usage = "Usage: %s [-key rsa|rsa-pss|ecdsa-p256|ecdsa-p384] [-hash sha256|sha384|sha512] INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+
+// Size of the signature Contents.
+const signatureLen = 8192
+
+// Digest algorithms, by name.
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// CMS (RFC 5652) structures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// RSASSA-PSS parameters (RFC 4055).
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
 
 func main() {
-	args := os.Args
-	if len(args) < 3 {
+	var keyType, hashName string
+	flag.StringVar(&keyType, "key", "rsa", "Key type: rsa, rsa-pss, ecdsa-p256 or ecdsa-p384")
+	flag.StringVar(&hashName, "hash", "sha256", "Digest algorithm: sha256, sha384 or sha512")
+	flag.Usage = func() {
 		fmt.Printf(usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
 		return
 	}
-	inputPath := args[1]
-	outputPath := args[2]
+	inputPath := flag.Arg(0)
+	outputPath := flag.Arg(1)
+
+	hash, ok := hashes[hashName]
+	if !ok {
+		log.Fatalf("Fail: unsupported digest algorithm %s\n", hashName)
+	}
 
 	// Generate key pair.
-	priv, cert, err := generateKeys()
+	priv, cert, err := generateKeys(keyType)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create reader.
 	file, err := os.Open(inputPath)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 	defer file.Close()
 
 	reader, err := model.NewPdfReader(file)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create appender.
 	appender, err := model.NewPdfAppender(reader)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create signature handler.
-	handler, err := sighandler.NewAdobePKCS7Detached(priv, cert)
-	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+	handler := &cmsHandler{
+		signer:      priv,
+		certificate: cert,
+		hash:        hash,
+		pss:         keyType == "rsa-pss",
 	}
 
 	// Create signature.
@@ -73,7 +157,7 @@ func main() {
 	signature.SetDate(now, "")
 
 	if err := signature.Initialize(); err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create signature field and appearance.
@@ -90,24 +174,45 @@ func main() {
 		},
 		opts,
 	)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
 	field.T = core.MakeString("Self signed PDF")
 
 	if err = appender.Sign(1, field); err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Write output PDF file.
 	err = appender.WriteToFile(outputPath)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+
+	// Validate the signature of the output PDF file.
+	if err := validate(outputPath); err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+	log.Printf("Signature successfully validated (%s, %s)\n", keyType, hashName)
 }
 
-func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
+// generateKeys generates a private key of type `keyType` and a self-signed certificate.
+func generateKeys(keyType string) (crypto.Signer, *x509.Certificate, error) {
 	// Generate private key.
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	var priv crypto.Signer
+	var err error
+	switch keyType {
+	case "rsa", "rsa-pss":
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa-p256":
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -125,6 +230,9 @@ func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
+	if keyType == "rsa-pss" {
+		template.SignatureAlgorithm = x509.SHA256WithRSAPSS
+	}
 
 	// Generate X509 certificate.
 	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
@@ -139,3 +247,306 @@ func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
 
 	return priv, cert, nil
 }
+
+// validate validates the signatures of the PDF file in `inputPath`.
+func validate(inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	results, err := reader.ValidateSignatures([]model.SignatureHandler{&cmsHandler{}})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if !res.IsVerified {
+			return fmt.Errorf("signature not verified: %s", res.String())
+		}
+	}
+	return nil
+}
+
+// cmsHandler is an adbe.pkcs7.detached signature handler signing with a crypto.Signer, supporting RSA
+// (PKCS #1 v1.5 or PSS) and ECDSA keys.
+type cmsHandler struct {
+	signer      crypto.Signer
+	certificate *x509.Certificate
+	hash        crypto.Hash
+	pss         bool
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *cmsHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "adbe.pkcs7.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *cmsHandler) InitSignature(sig *model.PdfSignature) error {
+	if h.signer == nil || h.certificate == nil {
+		return errors.New("signer and certificate must not be nil")
+	}
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("adbe.pkcs7.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *cmsHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *cmsHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	data, err := signCMS(digest.(*bytes.Buffer).Bytes(), h.signer, h.certificate, h.hash, h.pss)
+	if err != nil {
+		return err
+	}
+	if len(data) > signatureLen {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, signatureLen)
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// Validate validates PdfSignature.
+func (h *cmsHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if err := verifyCMS(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// signCMS returns the detached CMS signature of `data` by `signer` with certificate `cert`, using digest
+// algorithm `hash` and the RSASSA-PSS scheme for RSA keys if `pss` is true.
+func signCMS(data []byte, signer crypto.Signer, cert *x509.Certificate, hash crypto.Hash, pss bool) ([]byte, error) {
+	digestAlg, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	var sigAlg pkix.AlgorithmIdentifier
+	var opts crypto.SignerOpts = hash
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if !pss {
+			sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+			break
+		}
+		sigAlg, err = pssAlgorithm(hash)
+		if err != nil {
+			return nil, err
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSASHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSASHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSASHA512
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// Signed attributes, DER encoded as a SET OF sorted by encoding.
+	h := hash.New()
+	h.Write(data)
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{attr.oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the signed attributes.
+	h = hash.New()
+	h.Write(set)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: digestAlg},
+			AuthenticatedAttributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// verifyCMS verifies the detached CMS signature `contents` of `data`.
+func verifyCMS(contents, data []byte) error {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	if len(p7.Signers) != 1 {
+		return errors.New("signature must have a single signer")
+	}
+	signer := p7.Signers[0]
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		// RSA PKCS #1 v1.5 and ECDSA signatures are verified by the pkcs7 package.
+		return p7.Verify()
+	}
+
+	// Verify the message digest of the RSASSA-PSS signature.
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return errors.New("signer certificate not found")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signer certificate key is not RSA")
+	}
+	hash, err := hashAlgorithm(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if err := p7.UnmarshalSignedAttribute(oidMessageDigest, &digest); err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("message digest mismatch")
+	}
+
+	// Verify the signature of the signed attributes.
+	var attrs [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return err
+	}
+
+	var params pssParameters
+	if _, err := asn1.Unmarshal(signer.DigestEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	pssHash, err := hashAlgorithm(params.Hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	h = pssHash.New()
+	h.Write(set)
+	opts := &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: pssHash}
+	return rsa.VerifyPSS(pub, pssHash, h.Sum(nil), signer.EncryptedDigest, opts)
+}
+
+// pssAlgorithm returns the RSASSA-PSS algorithm identifier with digest algorithm `hash`.
+func pssAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	oid, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	hashAlg := pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(hashAlg)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	params, err := asn1.Marshal(pssParameters{
+		Hash:         hashAlg,
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		SaltLength:   hash.Size(),
+		TrailerField: 1,
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
+
+// hashAlgorithmOID returns the object identifier of hash algorithm `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
+}
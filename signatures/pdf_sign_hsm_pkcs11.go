@@ -1,168 +1,842 @@
 /*
  * This example showcases how to digitally sign a PDF file using HSM via PKCS11.
- * with UniDoc.
+ * with UniDoc. RSA (PKCS #1 v1.5 or PSS with -pss) and ECDSA (P-256 or P-384)
+ * key pairs are supported, with SHA-256, SHA-384 or SHA-512 digests. The
+ * signature is validated after signing.
+ *
+ * The PKCS11 module is set with -module or the PKCS11_MODULE environment
+ * variable (default: PathSoftHSM). The token is selected by slot ID (-slot) or
+ * label (-token), the first initialized token being used by default. The user
+ * PIN is read from the PKCS11_PIN environment variable, or prompted for.
+ *
+ * The signature embeds the certificate of the key stored on the token and its
+ * chain, built from the other certificates of the token. The certificates (e.g.
+ * issued with pdf_sign_ca.go) are stored on the token with import-cert. A
+ * throwaway self-signed certificate can be used instead with -self-signed.
+ *
+ * To list the slots and their tokens:
+ * $ ./pdf_sign_hsm_pkcs11 slots [-module PATH]
+ *
+ * To list the keys and certificates of a token:
+ * $ ./pdf_sign_hsm_pkcs11 list [-module PATH] [-slot ID] [-token LABEL]
  *
  * To create a key pair:
- * $ ./pdf_sign_hsm_pkcs11 add test <PIN> <keypair_label>
+ * $ ./pdf_sign_hsm_pkcs11 add [-module PATH] [-slot ID] [-token LABEL] [-key rsa|ecdsa-p256|ecdsa-p384] [-csr request.pem] <keypair_label>
+ *
+ * To store the certificate of a key pair and its chain on the token:
+ * $ ./pdf_sign_hsm_pkcs11 import-cert [-module PATH] [-slot ID] [-token LABEL] <keypair_label> <cert.pem> [chain.pem...]
  *
  * To sign a PDF:
- * $ ./pdf_sign_hsm_pkcs11 sign test <PIN> <keypair_label> input.pdf input_signed.pdf
+ * $ ./pdf_sign_hsm_pkcs11 sign [-module PATH] [-slot ID] [-token LABEL] [-hash sha256|sha384|sha512] [-pss] [-self-signed] <keypair_label> input.pdf input_signed.pdf
  *
  * See instructions for testing via SoftHSM in README.md.
  */
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
-	"hash"
+	"io/ioutil"
 	"log"
 	"math/big"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ThalesIgnite/crypto11"
+	"github.com/gunnsth/pkcs7"
 	"github.com/miekg/pkcs11"
 
 	"github.com/unidoc/unipdf/v3/annotator"
 	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
-	"github.com/unidoc/unipdf/v3/model/sighandler"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-// Library path might be different on different operating systems.
+var now = time.Now()
+
+// Default library path, which might be different on different operating systems.
 const PathSoftHSM = "/usr/local/lib/softhsm/libsofthsm2.so"
 
 const (
-	usage     = "Usage: %s add|sign PARAMETERS...\n"
-	usageAdd  = "Usage: %s add TOKEN_LABEL TOKEN_PIN KEYPAIR_LABEL\n"
-	usageSign = "Usage: %s sign TOKEN_LABEL TOKEN_PIN KEYPAIR_LABEL INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+	usage       = "Usage: %s slots|list|add|import-cert|sign PARAMETERS...\n"
+	usageSlots  = "Usage: %s slots [-module PATH]\n"
+	usageList   = "Usage: %s list [-module PATH] [-slot ID] [-token LABEL]\n"
+	usageAdd    = "Usage: %s add [-module PATH] [-slot ID] [-token LABEL] [-key rsa|ecdsa-p256|ecdsa-p384] [-csr CSR_FILE] KEYPAIR_LABEL\n"
+	usageImport = "Usage: %s import-cert [-module PATH] [-slot ID] [-token LABEL] KEYPAIR_LABEL CERT_FILE [CHAIN_FILE...]\n"
+	usageSign   = "Usage: %s sign [-module PATH] [-slot ID] [-token LABEL] [-hash sha256|sha384|sha512] [-pss] [-self-signed] KEYPAIR_LABEL INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
 )
 
+// Size of the signature Contents, without the certificates.
+const signatureLen = 8192
+
+// Digest algorithms, by name.
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// CMS (RFC 5652) structures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// RSASSA-PSS parameters (RFC 4055).
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
 func main() {
 	// Check specified action.
 	args := os.Args
-
-	lenArgs := len(args)
-	if lenArgs < 2 {
+	if len(args) < 2 {
 		fmt.Printf(usage, os.Args[0])
 		return
 	}
 
+	var opts tokenOptions
+	var keyType, hashName, csrPath string
+	var pss, selfSigned bool
 	action := args[1]
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	fs.StringVar(&opts.module, "module", defaultModule(), "Path of the PKCS11 module (env PKCS11_MODULE)")
+	nargs := 0
 	switch action {
+	case "slots":
+		fs.Usage = func() {
+			fmt.Printf(usageSlots, os.Args[0])
+			fs.PrintDefaults()
+		}
+	case "list":
+		addTokenFlags(fs, &opts)
+		fs.Usage = func() {
+			fmt.Printf(usageList, os.Args[0])
+			fs.PrintDefaults()
+		}
 	case "add":
-		if lenArgs != 5 {
+		addTokenFlags(fs, &opts)
+		fs.StringVar(&keyType, "key", "rsa", "Key type: rsa, ecdsa-p256 or ecdsa-p384")
+		fs.StringVar(&csrPath, "csr", "", "Write a certificate request of the key pair to this file")
+		fs.Usage = func() {
 			fmt.Printf(usageAdd, os.Args[0])
-			return
+			fs.PrintDefaults()
 		}
+		nargs = 1
+	case "import-cert":
+		addTokenFlags(fs, &opts)
+		fs.Usage = func() {
+			fmt.Printf(usageImport, os.Args[0])
+			fs.PrintDefaults()
+		}
+		nargs = 2
 	case "sign":
-		if lenArgs != 7 {
+		addTokenFlags(fs, &opts)
+		fs.StringVar(&hashName, "hash", "sha256", "Digest algorithm: sha256, sha384 or sha512")
+		fs.BoolVar(&pss, "pss", false, "Use the RSASSA-PSS signature scheme for RSA keys")
+		fs.BoolVar(&selfSigned, "self-signed", false, "Sign with a generated self-signed certificate if the token has no certificate for the key")
+		fs.Usage = func() {
 			fmt.Printf(usageSign, os.Args[0])
-			return
+			fs.PrintDefaults()
 		}
+		nargs = 3
 	default:
 		fmt.Printf(usage, os.Args[0])
 		return
 	}
+	fs.Parse(args[2:])
+	if fs.NArg() < nargs || (action != "import-cert" && fs.NArg() != nargs) {
+		fs.Usage()
+		return
+	}
 
-	tokenLabel := args[2]
-	tokenPin := args[3]
-	keypairLabel := args[4]
+	if action == "slots" {
+		if err := listSlots(opts.module); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+		return
+	}
 
 	// Initialize PKCS11 session.
 	// The PKCS11 store only exposes a crypto.Signer interface.
 	// The signing process takes place inside the signer and it is only
 	// possible while a session is open.
-	ctx, err := initPKCS11Session(tokenLabel, tokenPin)
+	ctx, slot, err := initPKCS11Session(opts)
 	if err != nil {
 		log.Fatalf("Fail: %v\n", err)
 	}
 	defer crypto11.Close()
 
+	keypairLabel := fs.Arg(0)
 	switch action {
+	case "list":
+		if err := listObjects(ctx, slot); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
 	case "add":
-		if _, err := addKeyPair(ctx, keypairLabel); err != nil {
+		priv, err := addKeyPair(slot, keypairLabel, keyType)
+		if err != nil {
 			log.Fatalf("Fail: %v\n", err)
 		}
 
-		log.Printf("Key pair successfully added for token %s\n", tokenLabel)
+		log.Printf("Key pair %s successfully added to the token in slot %d\n", keypairLabel, slot)
+
+		if csrPath != "" {
+			if err := writeCertificateRequest(priv.(crypto.Signer), keypairLabel, csrPath); err != nil {
+				log.Fatalf("Fail: %v\n", err)
+			}
+			log.Printf("Certificate request written to %s\n", csrPath)
+		}
+	case "import-cert":
+		if err := importCertificates(ctx, slot, keypairLabel, fs.Args()[1:]); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
 	case "sign":
-		// Get private key.
-		priv, err := getKeyPair(keypairLabel)
+		hash, ok := hashes[hashName]
+		if !ok {
+			log.Fatalf("Fail: unsupported digest algorithm %s\n", hashName)
+		}
+
+		// Get private key. The RSA and ECDSA keys of the token implement crypto.Signer.
+		priv, err := getKeyPair(slot, keypairLabel)
 		if err != nil {
 			log.Fatalf("Fail: %v\n", err)
 		}
-		rsaPriv, ok := priv.(*crypto11.PKCS11PrivateKeyRSA)
+		signer, ok := priv.(crypto.Signer)
 		if !ok {
-			log.Fatal("Fail: unsupported private key\n")
+			log.Fatalf("Fail: unsupported private key %T\n", priv)
 		}
 
-		cert, err := generateCertificate(rsaPriv)
+		// Get the certificate of the key and its chain from the token.
+		chain, err := getCertificateChain(ctx, slot, signer)
 		if err != nil {
 			log.Fatalf("Fail: %v\n", err)
 		}
+		if chain == nil {
+			if !selfSigned {
+				log.Fatalf("Fail: no certificate for key %s on the token: store one with import-cert "+
+					"or use -self-signed\n", keypairLabel)
+			}
+			cert, err := generateCertificate(signer, pss)
+			if err != nil {
+				log.Fatalf("Fail: %v\n", err)
+			}
+			chain = []*x509.Certificate{cert}
+		}
+		for i, cert := range chain {
+			log.Printf("Certificate %d: %s (issuer %s)\n", i, cert.Subject, cert.Issuer)
+		}
 
-		inputPath := args[5]
-		outputPath := args[6]
+		inputPath := fs.Arg(1)
+		outputPath := fs.Arg(2)
 
-		if err := sign(rsaPriv, cert, inputPath, outputPath); err != nil {
+		handler := &cmsHandler{signer: signer, chain: chain, hash: hash, pss: pss}
+		if err := sign(handler, inputPath, outputPath); err != nil {
 			log.Fatalf("Fail: %v\n", err)
 		}
 
 		log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+
+		// Validate the signature of the output PDF file.
+		if err := validate(outputPath); err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+		log.Printf("Signature successfully validated\n")
 	}
 }
 
-// initPKCS11Session initializes a PKCS11 store and creates a new session.
-func initPKCS11Session(tokenLabel, tokenPin string) (*pkcs11.Ctx, error) {
+// tokenOptions are the PKCS11 module and token selection options.
+type tokenOptions struct {
+	module string
+	slot   int
+	token  string
+}
+
+// addTokenFlags adds the token selection flags of `opts` to `fs`.
+func addTokenFlags(fs *flag.FlagSet, opts *tokenOptions) {
+	fs.IntVar(&opts.slot, "slot", -1, "Slot ID of the token (default: the first initialized token)")
+	fs.StringVar(&opts.token, "token", "", "Label of the token")
+}
+
+// defaultModule returns the path of the PKCS11 module set in the environment, or the SoftHSM path.
+func defaultModule() string {
+	if path := os.Getenv("PKCS11_MODULE"); path != "" {
+		return path
+	}
+	return PathSoftHSM
+}
+
+// loadModule loads and initializes the PKCS11 module in `path`.
+func loadModule(path string) (*pkcs11.Ctx, error) {
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("cannot load PKCS11 module %s", path)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("cannot initialize PKCS11 module %s: %v", path, err)
+	}
+	return ctx, nil
+}
+
+// listSlots lists the slots with a token of the PKCS11 module in `path`.
+func listSlots(path string) error {
+	ctx, err := loadModule(path)
+	if err != nil {
+		return err
+	}
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return err
+		}
+		state := "initialized"
+		if info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0 {
+			state = "not initialized"
+		}
+		fmt.Printf("Slot %d: token %q (%s), serial %s, %s %s\n", slot, info.Label, state, info.SerialNumber,
+			info.ManufacturerID, info.Model)
+	}
+	return nil
+}
+
+// findToken returns the slot and information of the token selected by `opts`.
+func findToken(opts tokenOptions) (uint, pkcs11.TokenInfo, error) {
+	ctx, err := loadModule(opts.module)
+	if err != nil {
+		return 0, pkcs11.TokenInfo{}, err
+	}
+	defer ctx.Destroy()
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, pkcs11.TokenInfo{}, err
+	}
+	for _, slot := range slots {
+		if opts.slot >= 0 && uint(opts.slot) != slot {
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, pkcs11.TokenInfo{}, err
+		}
+		if info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0 || (opts.token != "" && info.Label != opts.token) {
+			continue
+		}
+		return slot, info, nil
+	}
+	if opts.slot >= 0 {
+		return 0, pkcs11.TokenInfo{}, fmt.Errorf("no initialized token in slot %d", opts.slot)
+	}
+	if opts.token != "" {
+		return 0, pkcs11.TokenInfo{}, fmt.Errorf("token %q not found", opts.token)
+	}
+	return 0, pkcs11.TokenInfo{}, errors.New("no initialized token found")
+}
+
+// initPKCS11Session initializes a PKCS11 store for the token selected by `opts` and creates a new session,
+// logged in with the user PIN. It returns the context and the slot of the token.
+func initPKCS11Session(opts tokenOptions) (*pkcs11.Ctx, uint, error) {
+	slot, info, err := findToken(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	pin, err := readPIN(info.Label)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// crypto11 selects the first token matching the serial number or the label: only the serial number,
+	// unique per token, is set so that it selects the same slot (tokens may share a label).
 	conf := crypto11.PKCS11Config{
-		Path:       PathSoftHSM,
-		TokenLabel: tokenLabel,
-		Pin:        tokenPin,
+		Path:        opts.module,
+		TokenSerial: info.SerialNumber,
+		Pin:         pin,
+	}
+	ctx, err := crypto11.Configure(&conf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ctx, slot, nil
+}
+
+// readPIN returns the user PIN of token `label`, read from the PKCS11_PIN environment variable or prompted
+// for.
+func readPIN(label string) (string, error) {
+	if pin, ok := os.LookupEnv("PKCS11_PIN"); ok {
+		return pin, nil
+	}
+
+	// The PIN is read without echo from a terminal, or as a line from a redirected standard input.
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		pin, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && pin == "" {
+			return "", fmt.Errorf("cannot read PIN: %v", err)
+		}
+		return strings.TrimRight(pin, "\r\n"), nil
 	}
 
-	return crypto11.Configure(&conf)
+	fmt.Fprintf(os.Stderr, "PIN of token %s: ", label)
+	pin, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("cannot read PIN: %v", err)
+	}
+	return string(pin), nil
 }
 
 // getKeyPair retrieves the key pair with the specified label.
-func getKeyPair(keypairLabel string) (crypto.PrivateKey, error) {
-	return crypto11.FindKeyPair(nil, []byte(keypairLabel))
+func getKeyPair(slot uint, keypairLabel string) (crypto.PrivateKey, error) {
+	priv, err := crypto11.FindKeyPairOnSlot(slot, nil, []byte(keypairLabel))
+	if err == crypto11.ErrKeyNotFound {
+		return nil, fmt.Errorf("key pair %s not found", keypairLabel)
+	}
+	return priv, err
 }
 
-// addKeyPair adds a new public/private key pair with the specified label
-// to the PKCS11 store.
-func addKeyPair(ctx *pkcs11.Ctx, keypairLabel string) (crypto.PrivateKey, error) {
-	// Get slot list.
-	slots, err := ctx.GetSlotList(true)
+// addKeyPair adds a new public/private key pair of type keyType with the
+// specified label to the PKCS11 store.
+func addKeyPair(slot uint, keypairLabel, keyType string) (crypto.PrivateKey, error) {
+	if _, err := getKeyPair(slot, keypairLabel); err == nil {
+		return nil, fmt.Errorf("key pair %s already exists", keypairLabel)
+	}
+
+	// Generate key pair.
+	switch keyType {
+	case "rsa":
+		return crypto11.GenerateRSAKeyPairOnSlot(slot, nil, []byte(keypairLabel), 2048)
+	case "ecdsa-p256":
+		return crypto11.GenerateECDSAKeyPairOnSlot(slot, nil, []byte(keypairLabel), elliptic.P256())
+	case "ecdsa-p384":
+		return crypto11.GenerateECDSAKeyPairOnSlot(slot, nil, []byte(keypairLabel), elliptic.P384())
+	}
+	return nil, fmt.Errorf("unsupported key type %s", keyType)
+}
+
+// writeCertificateRequest writes the PEM encoded certificate request of `signer`, with common name
+// `name`, to `path`.
+func writeCertificateRequest(signer crypto.Signer, name, path string) error {
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{"Test Company"},
+		},
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), 0644)
+}
+
+// tokenObject is a private key or certificate stored on the token.
+type tokenObject struct {
+	handle  pkcs11.ObjectHandle
+	label   string
+	id      []byte
+	keyType uint
+	cert    *x509.Certificate
+}
+
+// findObjects returns the objects of class `class` of the token in `slot`.
+func findObjects(ctx *pkcs11.Ctx, slot uint, class uint) ([]*tokenObject, error) {
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
 	if err != nil {
 		return nil, err
 	}
+	defer ctx.CloseSession(session)
 
-	// Generate key pair.
-	return crypto11.GenerateRSAKeyPairOnSlot(slots[0], nil, []byte(keypairLabel), 2048)
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if class == pkcs11.CKO_CERTIFICATE {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509))
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return nil, err
+	}
+	var handles []pkcs11.ObjectHandle
+	for {
+		found, _, err := ctx.FindObjects(session, 100)
+		if err != nil {
+			ctx.FindObjectsFinal(session)
+			return nil, err
+		}
+		if len(found) == 0 {
+			break
+		}
+		handles = append(handles, found...)
+	}
+	if err := ctx.FindObjectsFinal(session); err != nil {
+		return nil, err
+	}
+
+	var objects []*tokenObject
+	for _, handle := range handles {
+		attrs := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		}
+		if class == pkcs11.CKO_CERTIFICATE {
+			attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil))
+		} else {
+			attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil))
+		}
+		if attrs, err = ctx.GetAttributeValue(session, handle, attrs); err != nil {
+			return nil, err
+		}
+
+		obj := &tokenObject{handle: handle, label: string(attrs[0].Value), id: attrs[1].Value}
+		if class == pkcs11.CKO_CERTIFICATE {
+			if obj.cert, err = x509.ParseCertificate(attrs[2].Value); err != nil {
+				log.Printf("Skipping invalid certificate %q: %v\n", obj.label, err)
+				continue
+			}
+		} else {
+			obj.keyType = bytesToUint(attrs[2].Value)
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// listObjects lists the private keys and certificates of the token in `slot`.
+func listObjects(ctx *pkcs11.Ctx, slot uint) error {
+	keys, err := findObjects(ctx, slot, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return err
+	}
+	certs, err := findObjects(ctx, slot, pkcs11.CKO_CERTIFICATE)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Private keys (%d):\n", len(keys))
+	for _, key := range keys {
+		keyType := fmt.Sprintf("type 0x%x", key.keyType)
+		switch key.keyType {
+		case pkcs11.CKK_RSA:
+			keyType = "RSA"
+		case pkcs11.CKK_EC:
+			keyType = "EC"
+		}
+		fmt.Printf("  %q id=%x %s\n", key.label, key.id, keyType)
+	}
+	fmt.Printf("Certificates (%d):\n", len(certs))
+	for _, cert := range certs {
+		fmt.Printf("  %q id=%x %s, issuer %s, expires %s\n", cert.label, cert.id, cert.cert.Subject,
+			cert.cert.Issuer, cert.cert.NotAfter.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// importCertificates stores the certificate of key pair `keypairLabel` and its chain, loaded from the PEM
+// or DER files `paths`, on the token in `slot`. The certificate of the key pair gets the label and ID of
+// the key.
+func importCertificates(ctx *pkcs11.Ctx, slot uint, keypairLabel string, paths []string) error {
+	priv, err := getKeyPair(slot, keypairLabel)
+	if err != nil {
+		return err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported private key %T", priv)
+	}
+	keys, err := findObjects(ctx, slot, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return err
+	}
+	var keyID []byte
+	for _, key := range keys {
+		if key.label == keypairLabel {
+			keyID = key.id
+		}
+	}
+
+	var certs []*x509.Certificate
+	for _, path := range paths {
+		pathCerts, err := loadCertificates(path)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, pathCerts...)
+	}
+	chain, err := buildChain(signer.Public(), certs)
+	if err != nil {
+		return err
+	}
+
+	existing, err := findObjects(ctx, slot, pkcs11.CKO_CERTIFICATE)
+	if err != nil {
+		return err
+	}
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return err
+	}
+	defer ctx.CloseSession(session)
+
+	for i, cert := range chain {
+		stored := false
+		for _, obj := range existing {
+			stored = stored || obj.cert.Equal(cert)
+		}
+		if stored {
+			log.Printf("Certificate %s already on the token\n", cert.Subject)
+			continue
+		}
+
+		label, id := cert.Subject.CommonName, cert.SubjectKeyId
+		if i == 0 {
+			label, id = keypairLabel, keyID
+		}
+		serial, err := asn1.Marshal(cert.SerialNumber)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.CreateObject(session, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+			pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+			pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, cert.RawSubject),
+			pkcs11.NewAttribute(pkcs11.CKA_ISSUER, cert.RawIssuer),
+			pkcs11.NewAttribute(pkcs11.CKA_SERIAL_NUMBER, serial),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert.Raw),
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Certificate %s stored on the token as %q\n", cert.Subject, label)
+	}
+	return nil
 }
 
-// generateCertificate generates a X509 certificate based on the specified private key.
-func generateCertificate(priv *crypto11.PKCS11PrivateKeyRSA) (*x509.Certificate, error) {
+// getCertificateChain returns the certificate of the key of `signer` stored on the token in `slot` and its
+// chain, built from the other certificates of the token, or nil if the token has no certificate for the
+// key.
+func getCertificateChain(ctx *pkcs11.Ctx, slot uint, signer crypto.Signer) ([]*x509.Certificate, error) {
+	objects, err := findObjects(ctx, slot, pkcs11.CKO_CERTIFICATE)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, obj := range objects {
+		certs = append(certs, obj.cert)
+	}
+
+	chain, err := buildChain(signer.Public(), certs)
+	if err == errNoCertificate {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChain(chain); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// loadCertificates loads the PEM or DER certificates in `path`.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		certs, err = x509.ParseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+	return certs, nil
+}
+
+// errNoCertificate is returned by buildChain when no certificate matches the public key.
+var errNoCertificate = errors.New("no certificate matches the private key")
+
+// buildChain returns the certificate chain of the certificate of public key `pub` among `certs`, from the
+// signer certificate to the root or the last issuer found.
+func buildChain(pub crypto.PublicKey, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	pubData, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawSubjectPublicKeyInfo, pubData) {
+			chain = append(chain, cert)
+			break
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errNoCertificate
+	}
+
+	for {
+		cert := chain[len(chain)-1]
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return chain, nil
+		}
+
+		var issuer *x509.Certificate
+		var issuerErr error
+		for _, candidate := range certs {
+			if !bytes.Equal(candidate.RawSubject, cert.RawIssuer) || inChain(chain, candidate) {
+				continue
+			}
+			if issuerErr = cert.CheckSignatureFrom(candidate); issuerErr == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuerErr != nil {
+			return nil, fmt.Errorf("certificate %q: invalid signature of issuer: %v", cert.Subject, issuerErr)
+		}
+		if issuer == nil {
+			log.Printf("Warning: issuer %q of certificate %q not found, the chain is incomplete\n",
+				cert.Issuer, cert.Subject)
+			return chain, nil
+		}
+		chain = append(chain, issuer)
+	}
+}
+
+// inChain returns true if `cert` is in `chain`.
+func inChain(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChain checks that the certificates of `chain` are valid now and that the signer certificate can be
+// used for signing.
+func checkChain(chain []*x509.Certificate) error {
+	for _, cert := range chain {
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate %q is not valid before %s", cert.Subject, cert.NotBefore)
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("certificate %q expired on %s", cert.Subject, cert.NotAfter)
+		}
+	}
+
+	usage := chain[0].KeyUsage
+	if usage != 0 && usage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+		return fmt.Errorf("certificate %q key usage does not allow signing", chain[0].Subject)
+	}
+	return nil
+}
+
+// bytesToUint converts the native-endian PKCS11 CK_ULONG `data` to uint.
+func bytesToUint(data []byte) uint {
+	var value uint
+	for i := len(data) - 1; i >= 0; i-- {
+		value = value<<8 | uint(data[i])
+	}
+	return value
+}
+
+// generateCertificate generates a X509 certificate based on the specified private key,
+// signed with the RSASSA-PSS scheme if pss is true.
+func generateCertificate(priv crypto.Signer, pss bool) (*x509.Certificate, error) {
 	// Initialize X509 certificate template.
-	now := time.Now()
 	template := x509.Certificate{
 		SerialNumber: big.NewInt(1),
 		Subject: pkix.Name{
 			Organization: []string{"Test Company"},
 		},
-		NotBefore: now,
+		NotBefore: now.Add(-time.Hour),
 		NotAfter:  now.Add(time.Hour * 24 * 365),
 
 		KeyUsage:              x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 	}
+	if _, isRSA := priv.Public().(*rsa.PublicKey); isRSA && pss {
+		template.SignatureAlgorithm = x509.SHA256WithRSAPSS
+	}
 
 	// Generate X509 certificate.
 	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
@@ -173,13 +847,13 @@ func generateCertificate(priv *crypto11.PKCS11PrivateKeyRSA) (*x509.Certificate,
 	return x509.ParseCertificate(certData)
 }
 
-// sign signs the specified input PDF file using an adobeX509RSASHA1 signature handler
+// sign signs the specified input PDF file using the specified signature handler
 // and saves the result at the destination specified by the outputPath parameter.
-func sign(priv *crypto11.PKCS11PrivateKeyRSA, certificate *x509.Certificate, inputPath, outputPath string) error {
+func sign(handler model.SignatureHandler, inputPath, outputPath string) error {
 	// Open input file.
 	file, err := os.Open(inputPath)
 	if err != nil {
-		log.Fatalf("Fail: %v\n", err)
+		return err
 	}
 	defer file.Close()
 
@@ -195,27 +869,11 @@ func sign(priv *crypto11.PKCS11PrivateKeyRSA, certificate *x509.Certificate, inp
 		return err
 	}
 
-	// Create signature function.
-	signFunc := func(sig *model.PdfSignature, digest model.Hasher) ([]byte, error) {
-		h, ok := digest.(hash.Hash)
-		if !ok {
-			return nil, errors.New("hash type error")
-		}
-
-		return priv.Sign(rand.Reader, h.Sum(nil), crypto.SHA1)
-	}
-
-	// Create custom signature handler.
-	handler, err := sighandler.NewAdobeX509RSASHA1Custom(certificate, signFunc)
-	if err != nil {
-		return err
-	}
-
 	// Create signature.
 	signature := model.NewPdfSignature(handler)
 	signature.SetName("Test SoftHSM2 Signature")
 	signature.SetReason("TestSoftHSM2Signature")
-	signature.SetDate(time.Now(), "")
+	signature.SetDate(now, "")
 
 	if err := signature.Initialize(); err != nil {
 		return err
@@ -235,6 +893,9 @@ func sign(priv *crypto11.PKCS11PrivateKeyRSA, certificate *x509.Certificate, inp
 		},
 		opts,
 	)
+	if err != nil {
+		return err
+	}
 	sigField.T = core.MakeString("External signature")
 
 	// Sign PDF.
@@ -245,3 +906,320 @@ func sign(priv *crypto11.PKCS11PrivateKeyRSA, certificate *x509.Certificate, inp
 	// Write output file.
 	return appender.WriteToFile(outputPath)
 }
+
+// validate validates the signatures of the PDF file in `inputPath`.
+func validate(inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	results, err := reader.ValidateSignatures([]model.SignatureHandler{&cmsHandler{}})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if !res.IsVerified {
+			return fmt.Errorf("signature not verified: %s", res.String())
+		}
+	}
+	return nil
+}
+
+// cmsHandler is an adbe.pkcs7.detached signature handler signing with a crypto.Signer, supporting RSA
+// (PKCS #1 v1.5 or PSS) and ECDSA keys.
+type cmsHandler struct {
+	signer crypto.Signer
+	chain  []*x509.Certificate // Signer certificate first.
+	hash   crypto.Hash
+	pss    bool
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *cmsHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "adbe.pkcs7.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *cmsHandler) InitSignature(sig *model.PdfSignature) error {
+	if h.signer == nil || len(h.chain) == 0 {
+		return errors.New("signer and certificate must not be nil")
+	}
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("adbe.pkcs7.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, h.contentsLen())))
+	return nil
+}
+
+// contentsLen returns the size of the signature Contents, which embed the certificate chain.
+func (h *cmsHandler) contentsLen() int {
+	size := signatureLen
+	for _, cert := range h.chain {
+		size += len(cert.Raw)
+	}
+	return size
+}
+
+// NewDigest creates a new digest.
+func (h *cmsHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *cmsHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	data, err := signCMS(digest.(*bytes.Buffer).Bytes(), h.signer, h.chain, h.hash, h.pss)
+	if err != nil {
+		return err
+	}
+	if len(data) > h.contentsLen() {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, h.contentsLen())
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// Validate validates PdfSignature.
+func (h *cmsHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if err := verifyCMS(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// signCMS returns the detached CMS signature of `data` by `signer` with certificate chain `chain`, using
+// digest algorithm `hash` and the RSASSA-PSS scheme for RSA keys if `pss` is true.
+func signCMS(data []byte, signer crypto.Signer, chain []*x509.Certificate, hash crypto.Hash, pss bool) ([]byte, error) {
+	digestAlg, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	var sigAlg pkix.AlgorithmIdentifier
+	var opts crypto.SignerOpts = hash
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if !pss {
+			sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+			break
+		}
+		sigAlg, err = pssAlgorithm(hash)
+		if err != nil {
+			return nil, err
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSASHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSASHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSASHA512
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// Signed attributes, DER encoded as a SET OF sorted by encoding.
+	h := hash.New()
+	h.Write(data)
+	var attrs [][]byte
+	for _, attr := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{attr.oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the signed attributes.
+	h = hash.New()
+	h.Write(set)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw...)
+	}
+	cert := chain[0]
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: digestAlg},
+			AuthenticatedAttributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// verifyCMS verifies the detached CMS signature `contents` of `data`.
+func verifyCMS(contents, data []byte) error {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	if len(p7.Signers) != 1 {
+		return errors.New("signature must have a single signer")
+	}
+	signer := p7.Signers[0]
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		// RSA PKCS #1 v1.5 and ECDSA signatures are verified by the pkcs7 package.
+		return p7.Verify()
+	}
+
+	// Verify the message digest of the RSASSA-PSS signature.
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return errors.New("signer certificate not found")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signer certificate key is not RSA")
+	}
+	hash, err := hashAlgorithm(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if err := p7.UnmarshalSignedAttribute(oidMessageDigest, &digest); err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("message digest mismatch")
+	}
+
+	// Verify the signature of the signed attributes.
+	var attrs [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return err
+	}
+
+	var params pssParameters
+	if _, err := asn1.Unmarshal(signer.DigestEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	pssHash, err := hashAlgorithm(params.Hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	h = pssHash.New()
+	h.Write(set)
+	opts := &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: pssHash}
+	return rsa.VerifyPSS(pub, pssHash, h.Sum(nil), signer.EncryptedDigest, opts)
+}
+
+// pssAlgorithm returns the RSASSA-PSS algorithm identifier with digest algorithm `hash`.
+func pssAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	oid, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	hashAlg := pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(hashAlg)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	params, err := asn1.Marshal(pssParameters{
+		Hash:         hashAlg,
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		SaltLength:   hash.Size(),
+		TrailerField: 1,
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
+
+// hashAlgorithmOID returns the object identifier of hash algorithm `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
+}
@@ -0,0 +1,1860 @@
+/*
+ * This example showcases how to create PAdES baseline signatures (ETSI EN 319 142-1):
+ * - B-B: ETSI.CAdES.detached signature with the signing-certificate-v2 attribute and no signing-time
+ *   attribute (the signing time is the /M entry of the signature dictionary),
+ * - B-T: B-B with an RFC 3161 timestamp token of the signature value,
+ * - B-LT: B-T with the validation data (certificates, OCSP responses and CRLs) of the signatures and
+ *   timestamps stored in the Document Security Store (DSS) dictionary, with a VRI entry per signature,
+ * - B-LTA: B-LT with a document timestamp (/DocTimeStamp) over the validation data, followed by the
+ *   validation data of the timestamp.
+ *
+ * The sign command signs a PDF file with the selected profile. The extend command adds the validation
+ * data (B-LT) and an archive timestamp (B-LTA) to all the signatures of a signed PDF file. Signatures
+ * without a signature timestamp (B-B) first get a document timestamp, which establishes their signing time
+ * (B-T). Running extend on a B-LTA file adds a new archive timestamp, renewing the protection of the
+ * previous ones.
+ * The revocation services of the certificates must be reachable when adding the validation data.
+ *
+ * The verify command validates the signatures and reports the baseline profile reached by each.
+ *
+ * The file is signed with a certificate issued by a local test CA. The CA is served for the duration
+ * of the command, with an OCSP responder, a CRL and a Time Stamping Authority (TSA), so that the
+ * whole workflow can be tested offline. A different TSA can be specified with -tsa. The CA is created
+ * in the directory -dir (pades-ca by default) on first use and reused by later commands, on the same
+ * local port, so that extend can add the validation data of files signed by previous sign commands.
+ *
+ * Sign:
+ * $ ./pdf_sign_pades sign [-profile B-B|B-T|B-LT|B-LTA] [-tsa URL] [-dir DIR] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Add validation data and archive timestamps:
+ * $ ./pdf_sign_pades extend [-profile B-LT|B-LTA] [-tsa URL] [-dir DIR] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Verify:
+ * $ ./pdf_sign_pades verify <INPUT_PDF_PATH>
+ */
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+	"golang.org/x/crypto/ocsp"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s sign [-profile PROFILE] [-tsa URL] [-dir DIR] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s extend [-profile PROFILE] [-tsa URL] [-dir DIR] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s verify INPUT_PDF_PATH\n"
+
+// Size of the signature Contents.
+const signatureLen = 16384
+
+// Baseline profiles, by level.
+var profiles = []string{"B-B", "B-T", "B-LT", "B-LTA"}
+
+const (
+	levelB = iota
+	levelT
+	levelLT
+	levelLTA
+)
+
+var (
+	oidSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidExtKeyUsage          = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidKeyPurposeTimeStamp  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+	// Policy of the timestamps issued by the local TSA.
+	oidLocalTSAPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// RFC 3161 structures.
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional,default:false"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// ESS signing certificate (RFC 5035), identifying the signer certificate.
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"` // SHA256 if absent.
+	CertHash      []byte
+	IssuerSerial  asn1.RawValue `asn1:"optional"`
+}
+
+type signingCertificateV2 struct {
+	Certs    []essCertIDv2
+	Policies asn1.RawValue `asn1:"optional"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "sign", "extend":
+		err = signCommand(os.Args[1], os.Args[2:])
+	case "verify":
+		if len(os.Args) < 3 {
+			fmt.Printf(usage, os.Args[0])
+			return
+		}
+		err = verify(os.Args[2])
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// signCommand signs (`command` sign) or extends (`command` extend) the PDF file with the options in `args`.
+func signCommand(command string, args []string) error {
+	var profile, tsaURL, dir string
+	defaultProfile := "B-B"
+	if command == "extend" {
+		defaultProfile = "B-LTA"
+	}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&profile, "profile", defaultProfile, "PAdES baseline profile: "+strings.Join(profiles, ", "))
+	flags.StringVar(&tsaURL, "tsa", "", "URL of the RFC 3161 TSA (default: the local TSA)")
+	flags.StringVar(&dir, "dir", "pades-ca", "Directory of the local CA")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	inputPath := flags.Arg(0)
+	outputPath := flags.Arg(1)
+
+	level := -1
+	for i, p := range profiles {
+		if strings.EqualFold(p, profile) {
+			level = i
+		}
+	}
+	if level < 0 {
+		return fmt.Errorf("unsupported profile %s", profile)
+	}
+	if command == "extend" && level < levelLT {
+		return fmt.Errorf("profile %s cannot be added to signed documents", profile)
+	}
+
+	// Start the local CA and TSA.
+	pki, err := startLocalPKI(dir)
+	if err != nil {
+		return err
+	}
+	defer pki.Close()
+	if tsaURL == "" {
+		tsaURL = pki.url + "/tsa"
+	}
+
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	if command == "sign" {
+		priv, cert, err := pki.issueCertificate(&x509.Certificate{
+			Subject: pkix.Name{
+				CommonName:   "Test Signer",
+				Organization: []string{"Test Company"},
+			},
+			KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		})
+		if err != nil {
+			return err
+		}
+		if err := pki.save(); err != nil {
+			return err
+		}
+
+		handler := &cadesHandler{privateKey: priv, certificate: cert, chain: []*x509.Certificate{pki.certificate}}
+		if level >= levelT {
+			handler.tsaURL = tsaURL
+		}
+		if data, err = sign(data, handler); err != nil {
+			return err
+		}
+		log.Printf("Signed with profile %s\n", profiles[level])
+	}
+
+	if command == "extend" {
+		// Signatures without signature timestamp (B-B) reach B-T with a document timestamp.
+		untimed, err := untimedSignatures(data)
+		if err != nil {
+			return err
+		}
+		if len(untimed) > 0 {
+			if data, err = sign(data, &docTimeStampHandler{tsaURL: tsaURL}); err != nil {
+				return err
+			}
+			log.Printf("Added document timestamp for signatures without signature timestamp: %s\n",
+				strings.Join(untimed, ", "))
+		}
+	}
+	if level >= levelLT {
+		if data, err = addValidationData(data); err != nil {
+			return err
+		}
+		log.Println("Added validation data")
+	}
+	if level >= levelLTA {
+		if data, err = sign(data, &docTimeStampHandler{tsaURL: tsaURL}); err != nil {
+			return err
+		}
+		// Add the validation data of the archive timestamp, for its renewal by a later timestamp.
+		if data, err = addValidationData(data); err != nil {
+			return err
+		}
+		log.Println("Added archive timestamp")
+	}
+
+	if err := ioutil.WriteFile(outputPath, data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+	return nil
+}
+
+// untimedSignatures returns the names of the signatures of the PDF file `data` that have neither a signature
+// timestamp nor a later document timestamp.
+func untimedSignatures(data []byte) ([]string, error) {
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := getSignatures(data, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i := len(sigs) - 1; i >= 0 && sigs[i].sigType != "DocTimeStamp"; i-- {
+		info, err := sigs[i].verifyContents()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sigs[i].name, err)
+		}
+		if info.timestamp == nil {
+			names = append(names, sigs[i].name)
+		}
+	}
+	return names, nil
+}
+
+// sign appends a signature created by `handler` to the PDF file `data`, in an incremental update.
+func sign(data []byte, handler model.SignatureHandler) ([]byte, error) {
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := model.NewPdfSignature(handler)
+	if _, ok := handler.(*cadesHandler); ok {
+		signature.SetName("Test PAdES Signature")
+		signature.SetReason("TestPAdES")
+		signature.SetDate(now, "")
+	}
+	if err := signature.Initialize(); err != nil {
+		return nil, err
+	}
+
+	// Create an invisible signature field.
+	sigField := model.NewPdfFieldSignature(signature)
+	sigField.Rect = core.MakeArray(core.MakeInteger(0), core.MakeInteger(0), core.MakeInteger(0), core.MakeInteger(0))
+	if _, ok := handler.(*docTimeStampHandler); ok {
+		sigField.T = core.MakeString(fmt.Sprintf("DocTimeStamp %d", time.Now().UnixNano()))
+	}
+	if err := appender.Sign(1, sigField); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := appender.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cadesHandler is an ETSI.CAdES.detached signature handler, embedding a timestamp token of the signature
+// value if a TSA is set.
+type cadesHandler struct {
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+	chain       []*x509.Certificate
+	tsaURL      string
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *cadesHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "ETSI.CAdES.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *cadesHandler) InitSignature(sig *model.PdfSignature) error {
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("ETSI.CAdES.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *cadesHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *cadesHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	signedData, err := pkcs7.NewSignedData(digest.(*bytes.Buffer).Bytes())
+	if err != nil {
+		return err
+	}
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	certHash := crypto.SHA256.New()
+	certHash.Write(h.certificate.Raw)
+	signingCert := pkcs7.Attribute{
+		Type:  oidSigningCertificateV2,
+		Value: signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash.Sum(nil)}}},
+	}
+	config := pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{signingCert}}
+	if err := signedData.AddSignerChain(h.certificate, h.privateKey, h.chain, config); err != nil {
+		return err
+	}
+
+	// PAdES signatures must not contain the signing-time attribute added by the pkcs7 package, so the
+	// remaining signed attributes are signed again.
+	signer := &signedData.GetSignedData().SignerInfos[0]
+	attrs := signer.AuthenticatedAttributes[:0]
+	var encoded [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		if attr.Type.Equal(oidSigningTime) {
+			continue
+		}
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, attr)
+		encoded = append(encoded, der)
+	}
+	signer.AuthenticatedAttributes = attrs
+
+	// The signed attributes are DER encoded as a SET OF, sorted by encoding.
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+	if err != nil {
+		return err
+	}
+	hash := crypto.SHA256.New()
+	hash.Write(set)
+	signer.EncryptedDigest, err = h.privateKey.Sign(rand.Reader, hash.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return err
+	}
+
+	// Timestamp the signature value.
+	if h.tsaURL != "" {
+		hash := crypto.SHA256.New()
+		hash.Write(signer.EncryptedDigest)
+		token, err := requestTimestamp(h.tsaURL, hash.Sum(nil), crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		err = signer.SetUnauthenticatedAttributes([]pkcs7.Attribute{
+			{Type: oidTimeStampToken, Value: asn1.RawValue{FullBytes: token}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	signedData.Detach()
+	data, err := signedData.Finish()
+	if err != nil {
+		return err
+	}
+	return setContents(sig, data)
+}
+
+// Validate validates PdfSignature.
+func (h *cadesHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if _, err := verifyCMS(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// docTimeStampHandler is an ETSI.RFC3161 document timestamp handler.
+type docTimeStampHandler struct {
+	tsaURL string
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *docTimeStampHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "ETSI.RFC3161"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *docTimeStampHandler) InitSignature(sig *model.PdfSignature) error {
+	sig.Handler = h
+	sig.Type = core.MakeName("DocTimeStamp")
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("ETSI.RFC3161")
+	sig.Reference = nil
+
+	// Reserve the space for the timestamp token, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *docTimeStampHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *docTimeStampHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	hash := crypto.SHA256.New()
+	hash.Write(digest.(*bytes.Buffer).Bytes())
+	token, err := requestTimestamp(h.tsaURL, hash.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	return setContents(sig, token)
+}
+
+// Validate validates PdfSignature.
+func (h *docTimeStampHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if _, _, err := verifyTimestampToken(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// setContents sets the Contents of `sig` to `data`, padded to the reserved size.
+func setContents(sig *model.PdfSignature, data []byte) error {
+	if len(data) > signatureLen {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, signatureLen)
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// requestTimestamp requests a timestamp token for `digest` computed with `hash` from the TSA at `tsaURL`.
+func requestTimestamp(tsaURL string, digest []byte, hash crypto.Hash) ([]byte, error) {
+	hashOID, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: hashOID, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := http.Post(tsaURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA error: %s", httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid TSA response: %v", err)
+	}
+	// Status granted (0) or granted with modifications (1).
+	if resp.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected: status %d", resp.Status.Status)
+	}
+
+	token := resp.TimeStampToken.FullBytes
+	info, _, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("timestamp message imprint mismatch")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp nonce mismatch")
+	}
+	return token, nil
+}
+
+// parseTimestampToken verifies the signature of timestamp token `token` and returns its timestamp
+// information and certificates.
+func parseTimestampToken(token []byte) (*tstInfo, []*x509.Certificate, error) {
+	p7, err := pkcs7.Parse(trimDER(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, nil, fmt.Errorf("invalid timestamp token signature: %v", err)
+	}
+
+	var info tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &info); err != nil {
+		return nil, nil, fmt.Errorf("invalid timestamp info: %v", err)
+	}
+	return &info, p7.Certificates, nil
+}
+
+// verifyTimestampToken verifies the signature of timestamp token `token` and that it timestamps `data`.
+// Returns the timestamp information and certificates.
+func verifyTimestampToken(token []byte, data []byte) (*tstInfo, []*x509.Certificate, error) {
+	info, certs, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := hashAlgorithm(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(info.MessageImprint.HashedMessage, h.Sum(nil)) {
+		return nil, nil, errors.New("timestamp message imprint mismatch")
+	}
+	return info, certs, nil
+}
+
+// cmsInfo represents the information of a verified CMS signature.
+type cmsInfo struct {
+	signer    *x509.Certificate
+	certs     []*x509.Certificate // Certificates of the signature and of its timestamp token.
+	timestamp *tstInfo
+
+	// The signing-certificate-v2 attribute identifies the signer certificate.
+	signingCertificate bool
+	// The signing-time attribute is present.
+	signingTime bool
+}
+
+// verifyCMS verifies the detached CMS signature `contents` of `data` and its timestamp token, if any.
+func verifyCMS(contents, data []byte) (*cmsInfo, error) {
+	p7, err := pkcs7.Parse(trimDER(contents))
+	if err != nil {
+		return nil, err
+	}
+	p7.Content = data
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	info := &cmsInfo{signer: p7.GetOnlySigner(), certs: p7.Certificates}
+	if info.signer == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+
+	var signingCert signingCertificateV2
+	if err := p7.UnmarshalSignedAttribute(oidSigningCertificateV2, &signingCert); err == nil && len(signingCert.Certs) > 0 {
+		certID := signingCert.Certs[0]
+		hash := crypto.SHA256
+		if len(certID.HashAlgorithm.Algorithm) > 0 {
+			hash, err = hashAlgorithm(certID.HashAlgorithm.Algorithm)
+		}
+		if err == nil {
+			h := hash.New()
+			h.Write(info.signer.Raw)
+			info.signingCertificate = bytes.Equal(certID.CertHash, h.Sum(nil))
+		}
+	}
+	var signingTime time.Time
+	info.signingTime = p7.UnmarshalSignedAttribute(oidSigningTime, &signingTime) == nil
+
+	for _, attr := range p7.Signers[0].UnauthenticatedAttributes {
+		if !attr.Type.Equal(oidTimeStampToken) {
+			continue
+		}
+		// The timestamp token timestamps the signature value.
+		tsInfo, tsCerts, err := verifyTimestampToken(attr.Value.Bytes, p7.Signers[0].EncryptedDigest)
+		if err != nil {
+			return nil, err
+		}
+		info.timestamp = tsInfo
+		info.certs = append(info.certs, tsCerts...)
+	}
+	return info, nil
+}
+
+// signatureData represents a signature or document timestamp of a PDF file.
+type signatureData struct {
+	name      string
+	sigType   string
+	subFilter string
+	contents  []byte
+	signed    []byte // Data of the byte range.
+	end       int64  // End offset of the signed revision.
+}
+
+// verifyContents verifies the signature or document timestamp `sig`. Returns the information of the
+// signature, with only the timestamp and certificates set for document timestamps.
+func (sig *signatureData) verifyContents() (*cmsInfo, error) {
+	switch sig.subFilter {
+	case "ETSI.CAdES.detached", "adbe.pkcs7.detached":
+		return verifyCMS(sig.contents, sig.signed)
+	case "ETSI.RFC3161":
+		tsInfo, certs, err := verifyTimestampToken(sig.contents, sig.signed)
+		if err != nil {
+			return nil, err
+		}
+		return &cmsInfo{certs: certs, timestamp: tsInfo}, nil
+	}
+	return nil, fmt.Errorf("unsupported subfilter %s", sig.subFilter)
+}
+
+// vriKey returns the key of the VRI entry of `sig`, the uppercase hexadecimal SHA1 hash of its Contents.
+func (sig *signatureData) vriKey() string {
+	hash := sha1.Sum(sig.contents)
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// getSignatures returns the signatures and document timestamps of the PDF file `data` read by `reader`,
+// sorted by revision.
+func getSignatures(data []byte, reader *model.PdfReader) ([]*signatureData, error) {
+	if reader.AcroForm == nil {
+		return nil, nil
+	}
+
+	var sigs []*signatureData
+	for _, field := range reader.AcroForm.AllFields() {
+		sigDict, ok := core.GetDict(field.V)
+		if !ok {
+			continue
+		}
+		sigType, _ := core.GetNameVal(sigDict.Get("Type"))
+		if sigType != "Sig" && sigType != "DocTimeStamp" {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+		sig := &signatureData{name: name, sigType: sigType}
+		sig.subFilter, _ = core.GetNameVal(sigDict.Get("SubFilter"))
+		sig.contents, _ = core.GetStringBytes(sigDict.Get("Contents"))
+
+		byteRange, ok := core.GetArray(sigDict.Get("ByteRange"))
+		if !ok {
+			return nil, fmt.Errorf("%s: missing byte range", name)
+		}
+		br, err := byteRange.ToInt64Slice()
+		if err != nil || len(br) != 4 || br[0] < 0 || br[1] < 0 || br[2] < br[0]+br[1] || br[3] < 0 ||
+			br[2]+br[3] > int64(len(data)) {
+			return nil, fmt.Errorf("%s: invalid byte range %s", name, byteRange)
+		}
+		sig.signed = append(append(sig.signed, data[br[0]:br[0]+br[1]]...), data[br[2]:br[2]+br[3]]...)
+		sig.end = br[2] + br[3]
+		sigs = append(sigs, sig)
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].end < sigs[j].end
+	})
+	return sigs, nil
+}
+
+// dssData represents the validation data of a Document Security Store.
+type dssData struct {
+	certs []*x509.Certificate
+	ocsps [][]byte
+	crls  []*pkix.CertificateList
+	vri   map[string]bool
+}
+
+// getDSS returns the validation data of the DSS of the document read by `reader`. Returns nil if the
+// document has no DSS.
+func getDSS(reader *model.PdfReader) (*dssData, error) {
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, err
+	}
+	dssDict, ok := core.GetDict(catalog.Get("DSS"))
+	if !ok {
+		return nil, nil
+	}
+
+	dss := &dssData{vri: map[string]bool{}}
+	for _, key := range []core.PdfObjectName{"Certs", "OCSPs", "CRLs"} {
+		arr, ok := core.GetArray(dssDict.Get(key))
+		if !ok {
+			continue
+		}
+		for _, obj := range arr.Elements() {
+			stream, ok := core.GetStream(obj)
+			if !ok {
+				return nil, fmt.Errorf("invalid DSS %s entry", key)
+			}
+			data, err := core.DecodeStream(stream)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "Certs":
+				cert, err := x509.ParseCertificate(data)
+				if err != nil {
+					return nil, err
+				}
+				dss.certs = append(dss.certs, cert)
+			case "OCSPs":
+				dss.ocsps = append(dss.ocsps, data)
+			case "CRLs":
+				crl, err := x509.ParseCRL(data)
+				if err != nil {
+					return nil, err
+				}
+				dss.crls = append(dss.crls, crl)
+			}
+		}
+	}
+	if vri, ok := core.GetDict(dssDict.Get("VRI")); ok {
+		for _, key := range vri.Keys() {
+			dss.vri[strings.ToUpper(string(key))] = true
+		}
+	}
+	return dss, nil
+}
+
+// checkRevocation checks that the validation data `dss` contains revocation data of `cert` issued by
+// `issuer`, and that the certificate is not revoked.
+func (dss *dssData) checkRevocation(cert, issuer *x509.Certificate) error {
+	for _, data := range dss.ocsps {
+		resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+		if err != nil {
+			continue
+		}
+		if resp.Status != ocsp.Good {
+			return fmt.Errorf("certificate %s is revoked or unknown", cert.Subject)
+		}
+		return nil
+	}
+	for _, crl := range dss.crls {
+		if issuer.CheckCRLSignature(crl) != nil {
+			continue
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s is revoked", cert.Subject)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no revocation data for %s", cert.Subject)
+}
+
+// addValidationData appends an incremental update to the PDF file `data` with the DSS dictionary,
+// adding the validation data of all the signatures and document timestamps.
+func addValidationData(data []byte) ([]byte, error) {
+	update, err := newIncrementalUpdate(data)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := getSignatures(data, update.reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(sigs) == 0 {
+		return nil, errors.New("no signatures found")
+	}
+
+	catalog, err := getCatalog(update.reader)
+	if err != nil {
+		return nil, err
+	}
+	oldDSS, err := getDSS(update.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the entries of the existing DSS.
+	certs := core.MakeArray()
+	ocsps := core.MakeArray()
+	crls := core.MakeArray()
+	vri := core.MakeDict()
+	seen := map[string]core.PdfObject{}
+	if dssDict, ok := core.GetDict(catalog.Get("DSS")); ok {
+		for key, arr := range map[core.PdfObjectName]*core.PdfObjectArray{"Certs": certs, "OCSPs": ocsps, "CRLs": crls} {
+			if old, ok := core.GetArray(dssDict.Get(key)); ok {
+				for _, obj := range old.Elements() {
+					arr.Append(obj)
+					if stream, ok := core.GetStream(obj); ok {
+						if content, err := core.DecodeStream(stream); err == nil {
+							seen[string(content)] = obj
+						}
+					}
+				}
+			}
+		}
+		if oldVRI, ok := core.GetDict(dssDict.Get("VRI")); ok {
+			vri.Merge(oldVRI)
+		}
+	}
+
+	// addStream returns the stream object of `content`, adding it to `arr` if new.
+	addStream := func(arr *core.PdfObjectArray, content []byte) (core.PdfObject, error) {
+		if obj, ok := seen[string(content)]; ok {
+			return obj, nil
+		}
+		stream, err := core.MakeStream(content, core.NewFlateEncoder())
+		if err != nil {
+			return nil, err
+		}
+		obj := update.addObject(stream)
+		seen[string(content)] = obj
+		arr.Append(obj)
+		return obj, nil
+	}
+
+	revocations := map[string][2][]byte{}
+	for _, sig := range sigs {
+		if oldDSS != nil && oldDSS.vri[sig.vriKey()] {
+			continue
+		}
+		info, err := sig.verifyContents()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", sig.name, err)
+		}
+
+		// Certificates and their revocation data, following the issuers up to self-signed certificates.
+		vriCerts := core.MakeArray()
+		vriOCSPs := core.MakeArray()
+		vriCRLs := core.MakeArray()
+		pool := info.certs
+		for i := 0; i < len(pool); i++ {
+			cert := pool[i]
+			if containsCertificate(pool[:i], cert) {
+				continue
+			}
+			obj, err := addStream(certs, cert.Raw)
+			if err != nil {
+				return nil, err
+			}
+			vriCerts.Append(obj)
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+				continue
+			}
+
+			issuer, err := findIssuer(cert, pool)
+			if err != nil {
+				return nil, err
+			}
+			if !containsCertificate(pool, issuer) {
+				pool = append(pool, issuer)
+			}
+
+			// The revocation data already in the DSS is kept, the services of earlier signers may no
+			// longer be available.
+			if oldDSS != nil && oldDSS.checkRevocation(cert, issuer) == nil {
+				continue
+			}
+			revocation, ok := revocations[string(cert.Raw)]
+			if !ok {
+				ocspResp, crl, err := fetchRevocationData(cert, issuer)
+				if err != nil {
+					return nil, err
+				}
+				revocation = [2][]byte{ocspResp, crl}
+				revocations[string(cert.Raw)] = revocation
+			}
+			if revocation[0] != nil {
+				obj, err := addStream(ocsps, revocation[0])
+				if err != nil {
+					return nil, err
+				}
+				vriOCSPs.Append(obj)
+			}
+			if revocation[1] != nil {
+				obj, err := addStream(crls, revocation[1])
+				if err != nil {
+					return nil, err
+				}
+				vriCRLs.Append(obj)
+			}
+		}
+
+		entry := core.MakeDict()
+		entry.Set("Type", core.MakeName("VRI"))
+		entry.Set("Cert", vriCerts)
+		if vriOCSPs.Len() > 0 {
+			entry.Set("OCSP", vriOCSPs)
+		}
+		if vriCRLs.Len() > 0 {
+			entry.Set("CRL", vriCRLs)
+		}
+		date, err := model.NewPdfDateFromTime(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		entry.Set("TU", date.ToPdfObject())
+		vri.Set(core.PdfObjectName(sig.vriKey()), update.addObject(entry))
+	}
+
+	dss := core.MakeDict()
+	dss.Set("Type", core.MakeName("DSS"))
+	dss.Set("VRI", update.addObject(vri))
+	dss.Set("Certs", certs)
+	if ocsps.Len() > 0 {
+		dss.Set("OCSPs", ocsps)
+	}
+	if crls.Len() > 0 {
+		dss.Set("CRLs", crls)
+	}
+
+	newCatalog := core.MakeDict()
+	newCatalog.Merge(catalog)
+	newCatalog.Set("DSS", update.addObject(dss))
+	return update.write(data, newCatalog)
+}
+
+// findIssuer returns the issuer of `cert` from the certificates `pool`, or downloaded from the Authority
+// Information Access URLs of the certificate.
+func findIssuer(cert *x509.Certificate, pool []*x509.Certificate) (*x509.Certificate, error) {
+	for _, issuer := range pool {
+		if bytes.Equal(cert.RawIssuer, issuer.RawSubject) && cert.CheckSignatureFrom(issuer) == nil {
+			return issuer, nil
+		}
+	}
+	for _, url := range cert.IssuingCertificateURL {
+		data, err := httpRequest("GET", url, "", nil)
+		if err != nil {
+			continue
+		}
+		issuer, err := x509.ParseCertificate(data)
+		if err == nil && cert.CheckSignatureFrom(issuer) == nil {
+			return issuer, nil
+		}
+	}
+	return nil, fmt.Errorf("issuer of %s not found", cert.Subject)
+}
+
+// containsCertificate returns true if `certs` contains `cert`.
+func containsCertificate(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchRevocationData returns the OCSP response of the responder of `cert` issued by `issuer` or, if
+// not available, the CRL of its distribution points.
+func fetchRevocationData(cert, issuer *x509.Certificate) (ocspResp []byte, crl []byte, err error) {
+	err = fmt.Errorf("no revocation information for %s", cert.Subject)
+	for _, url := range cert.OCSPServer {
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := httpRequest("POST", url, "application/ocsp-request", req)
+		if err != nil {
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+		if err != nil {
+			continue
+		}
+		if resp.Status != ocsp.Good {
+			return nil, nil, fmt.Errorf("certificate %s is revoked or unknown", cert.Subject)
+		}
+		return data, nil, nil
+	}
+
+	for _, url := range cert.CRLDistributionPoints {
+		data, err := httpRequest("GET", url, "", nil)
+		if err != nil {
+			continue
+		}
+		list, err := x509.ParseCRL(data)
+		if err != nil || issuer.CheckCRLSignature(list) != nil {
+			continue
+		}
+		for _, revoked := range list.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return nil, nil, fmt.Errorf("certificate %s is revoked", cert.Subject)
+			}
+		}
+		return nil, data, nil
+	}
+	return nil, nil, err
+}
+
+// httpRequest sends a `method` request with `body` of `contentType` to `url` and returns the response body.
+func httpRequest(method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// incrementalUpdate represents the objects added to a PDF file in an incremental update.
+type incrementalUpdate struct {
+	reader  *model.PdfReader
+	nextNum int64
+	objects []core.PdfObject
+}
+
+// newIncrementalUpdate returns a new incremental update of the PDF file `data`.
+func newIncrementalUpdate(data []byte) (*incrementalUpdate, error) {
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	if trailer.Get("Encrypt") != nil {
+		return nil, errors.New("adding validation data to encrypted documents is not supported")
+	}
+	size, ok := core.GetIntVal(trailer.Get("Size"))
+	if !ok {
+		return nil, errors.New("invalid trailer size")
+	}
+	return &incrementalUpdate{reader: reader, nextNum: int64(size)}, nil
+}
+
+// addObject adds dictionary or stream `obj` as a new object and returns it.
+func (u *incrementalUpdate) addObject(obj core.PdfObject) core.PdfObject {
+	if stream, ok := obj.(*core.PdfObjectStream); ok {
+		stream.ObjectNumber = u.nextNum
+	} else {
+		ind := core.MakeIndirectObject(obj)
+		ind.ObjectNumber = u.nextNum
+		obj = ind
+	}
+	u.nextNum++
+	u.objects = append(u.objects, obj)
+	return obj
+}
+
+// write appends the incremental update with the new objects and the updated catalog `catalog` to the
+// PDF file `data`.
+func (u *incrementalUpdate) write(data []byte, catalog *core.PdfObjectDictionary) ([]byte, error) {
+	trailer, err := u.reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	var rootNum, rootGen int64
+	switch root := trailer.Get("Root").(type) {
+	case *core.PdfObjectReference:
+		rootNum, rootGen = root.ObjectNumber, root.GenerationNumber
+	case *core.PdfIndirectObject:
+		rootNum, rootGen = root.ObjectNumber, root.GenerationNumber
+	default:
+		return nil, errors.New("invalid catalog reference")
+	}
+
+	// Offset of the previous cross-reference section.
+	idx := bytes.LastIndex(data, []byte("startxref"))
+	if idx < 0 {
+		return nil, errors.New("startxref not found")
+	}
+	fields := strings.Fields(string(data[idx+len("startxref"):]))
+	if len(fields) == 0 {
+		return nil, errors.New("invalid startxref")
+	}
+	prevOffset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || prevOffset < 0 || prevOffset >= int64(len(data)) {
+		return nil, errors.New("invalid startxref")
+	}
+	xrefStream := !bytes.HasPrefix(bytes.TrimLeft(data[prevOffset:], " \r\n"), []byte("xref"))
+
+	var buf bytes.Buffer
+	buf.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	catalogOffset := int64(buf.Len())
+	fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", rootNum, rootGen, catalog.WriteString())
+
+	firstNum := u.nextNum - int64(len(u.objects))
+	var offsets []int64
+	for _, obj := range u.objects {
+		offsets = append(offsets, int64(buf.Len()))
+		switch obj := obj.(type) {
+		case *core.PdfIndirectObject:
+			fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", obj.ObjectNumber, obj.PdfObject.WriteString())
+		case *core.PdfObjectStream:
+			fmt.Fprintf(&buf, "%d 0 obj\n%s\nstream\n", obj.ObjectNumber, obj.PdfObjectDictionary.WriteString())
+			buf.Write(obj.Stream)
+			buf.WriteString("\nendstream\nendobj\n")
+		}
+	}
+
+	newTrailer := core.MakeDict()
+	newTrailer.Set("Root", core.MakeIndirectObject(nil))
+	newTrailer.Get("Root").(*core.PdfIndirectObject).ObjectNumber = rootNum
+	for _, key := range []core.PdfObjectName{"Info", "ID"} {
+		if obj := trailer.Get(key); obj != nil {
+			newTrailer.Set(key, obj)
+		}
+	}
+	newTrailer.Set("Prev", core.MakeInteger(prevOffset))
+
+	xrefOffset := int64(buf.Len())
+	if xrefStream {
+		// Cross-reference stream with the catalog, the new objects and the stream itself.
+		var entries bytes.Buffer
+		writeEntry := func(offset, gen int64) {
+			entries.Write([]byte{1, byte(offset >> 24), byte(offset >> 16), byte(offset >> 8), byte(offset),
+				byte(gen >> 8), byte(gen)})
+		}
+		writeEntry(catalogOffset, rootGen)
+		for _, offset := range offsets {
+			writeEntry(offset, 0)
+		}
+		writeEntry(xrefOffset, 0)
+
+		newTrailer.Set("Type", core.MakeName("XRef"))
+		newTrailer.Set("Size", core.MakeInteger(u.nextNum+1))
+		newTrailer.Set("Index", core.MakeArrayFromIntegers64([]int64{rootNum, 1, firstNum, int64(len(offsets)) + 1}))
+		newTrailer.Set("W", core.MakeArrayFromIntegers([]int{1, 4, 2}))
+		newTrailer.Set("Length", core.MakeInteger(int64(entries.Len())))
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nstream\n", u.nextNum, newTrailer.WriteString())
+		buf.Write(entries.Bytes())
+		buf.WriteString("\nendstream\nendobj\n")
+	} else {
+		newTrailer.Set("Size", core.MakeInteger(u.nextNum))
+		fmt.Fprintf(&buf, "xref\n%d 1\n%010d %05d n \n%d %d\n", rootNum, catalogOffset, rootGen, firstNum, len(offsets))
+		for _, offset := range offsets {
+			fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+		}
+		fmt.Fprintf(&buf, "trailer\n%s\n", newTrailer.WriteString())
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes(), nil
+}
+
+// verify validates the signatures of the PDF file in `inputPath` and reports their baseline profiles.
+func verify(inputPath string) error {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sigs, err := getSignatures(data, reader)
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return errors.New("no signatures found")
+	}
+	dss, err := getDSS(reader)
+	if err != nil {
+		return err
+	}
+
+	// Verify all the signatures first, as archive timestamps are reported with the earlier signatures.
+	infos := make([]*cmsInfo, len(sigs))
+	errs := make([]error, len(sigs))
+	for i, sig := range sigs {
+		infos[i], errs[i] = sig.verifyContents()
+	}
+
+	valid := true
+	for i, sig := range sigs {
+		fmt.Printf("--- %s (%s, %s)\n", sig.name, sig.sigType, sig.subFilter)
+		if sig.end == int64(len(data)) {
+			fmt.Println("Covers the whole document")
+		} else {
+			fmt.Println("Covers a previous revision of the document")
+		}
+		if errs[i] != nil {
+			fmt.Printf("Validation: failed: %v\n", errs[i])
+			valid = false
+			continue
+		}
+		fmt.Println("Validation: valid")
+
+		info := infos[i]
+		if info.signer != nil {
+			fmt.Printf("Signer: %s\n", info.signer.Subject)
+		}
+		if info.timestamp != nil {
+			fmt.Printf("Timestamp: %s (serial %s)\n", info.timestamp.GenTime.Format(time.RFC3339),
+				info.timestamp.SerialNumber)
+		}
+		if dss != nil && dss.vri[sig.vriKey()] {
+			fmt.Println("VRI entry: present")
+		}
+		if sig.sigType == "DocTimeStamp" {
+			continue
+		}
+
+		// Baseline profile reached, with the reason for not reaching the next one.
+		level := -1
+		var reason string
+		switch {
+		case sig.subFilter != "ETSI.CAdES.detached":
+			reason = "subfilter is not ETSI.CAdES.detached"
+		case !info.signingCertificate:
+			reason = "missing or invalid signing-certificate-v2 attribute"
+		case info.signingTime:
+			reason = "signing-time attribute present"
+		default:
+			level = levelB
+		}
+		// The signing time is established by the signature timestamp, or else by the first valid document
+		// timestamp covering the signature. The archive timestamps follow the timestamp used.
+		certs := info.certs
+		tsIndex := i
+		if level == levelB {
+			if info.timestamp != nil {
+				level = levelT
+			} else if j := nextDocTimeStamp(sigs, errs, i); j >= 0 {
+				fmt.Printf("Signature time: document timestamp %s\n", sigs[j].name)
+				certs = append(append([]*x509.Certificate{}, certs...), infos[j].certs...)
+				tsIndex = j
+				level = levelT
+			} else {
+				reason = "no signature or document timestamp"
+			}
+		}
+		if level == levelT {
+			if err := checkValidationData(certs, dss); err != nil {
+				reason = err.Error()
+			} else {
+				level = levelLT
+			}
+		}
+		if level == levelLT {
+			reason = "no archive timestamp"
+			if nextDocTimeStamp(sigs, errs, tsIndex) >= 0 {
+				level = levelLTA
+				reason = ""
+			}
+		}
+
+		if level < 0 {
+			fmt.Printf("Profile: not PAdES baseline (%s)\n", reason)
+		} else if reason != "" {
+			fmt.Printf("Profile: PAdES %s (%s)\n", profiles[level], reason)
+		} else {
+			fmt.Printf("Profile: PAdES %s\n", profiles[level])
+		}
+	}
+
+	if !valid {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// nextDocTimeStamp returns the index of the first valid document timestamp after signature `i` in `sigs`
+// (with validation errors `errs`), or -1 if none.
+func nextDocTimeStamp(sigs []*signatureData, errs []error, i int) int {
+	for j := i + 1; j < len(sigs); j++ {
+		if sigs[j].sigType == "DocTimeStamp" && errs[j] == nil {
+			return j
+		}
+	}
+	return -1
+}
+
+// checkValidationData checks that the validation data `dss` contains the revocation data of the
+// certificates `certs` and of their issuers.
+func checkValidationData(certs []*x509.Certificate, dss *dssData) error {
+	if dss == nil {
+		return errors.New("no DSS")
+	}
+	pool := append(append([]*x509.Certificate{}, certs...), dss.certs...)
+	for _, cert := range certs {
+		for cert != nil {
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+				break
+			}
+			var issuer *x509.Certificate
+			for _, c := range pool {
+				if bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil {
+					issuer = c
+					break
+				}
+			}
+			if issuer == nil {
+				return fmt.Errorf("issuer of %s not found", cert.Subject)
+			}
+			if err := dss.checkRevocation(cert, issuer); err != nil {
+				return err
+			}
+			cert = issuer
+		}
+	}
+	return nil
+}
+
+// getCatalog returns the catalog dictionary of `reader`.
+func getCatalog(reader *model.PdfReader) (*core.PdfObjectDictionary, error) {
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root := trailer.Get("Root")
+	if ref, ok := root.(*core.PdfObjectReference); ok {
+		if root, err = reader.GetIndirectObjectByNumber(int(ref.ObjectNumber)); err != nil {
+			return nil, err
+		}
+	}
+	catalog, ok := core.GetDict(root)
+	if !ok {
+		return nil, errors.New("catalog not found")
+	}
+	return catalog, nil
+}
+
+// trimDER returns the DER encoded element at the start of `data`, removing the padding of signature
+// Contents.
+func trimDER(data []byte) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return data
+	}
+	return raw.FullBytes
+}
+
+// hashAlgorithmOID returns the object identifier of `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return pkcs7.OIDDigestAlgorithmSHA1, nil
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %v", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if hashOID, _ := hashAlgorithmOID(hash); hashOID.Equal(oid) {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
+}
+
+// localPKI is a test certification authority with an OCSP responder, a CRL and a TSA, served over HTTP
+// on a local port. The keys, certificates and state of the CA are stored in its directory and reused by
+// later commands, so that the revocation services of previously issued certificates remain available.
+type localPKI struct {
+	dir         string
+	url         string
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+	tsa         *localTSA
+	listener    net.Listener
+
+	mu     sync.Mutex
+	serial int64
+
+	// Serializes the writes of the state file.
+	saveMu sync.Mutex
+}
+
+// localPKIState is the state of the local PKI, stored in pki.json. The serial numbers of the issued
+// certificates and timestamps are persisted as they must be unique per CA and TSA.
+type localPKIState struct {
+	URL       string `json:"url"`
+	Serial    int64  `json:"serial"`
+	TSASerial int64  `json:"tsaSerial"`
+}
+
+// startLocalPKI loads the local PKI from `dir`, or creates it if `dir` does not contain one, and starts
+// serving. A created PKI is served on a free local port, a loaded one on the port of its URL.
+func startLocalPKI(dir string) (*localPKI, error) {
+	pki := &localPKI{dir: dir, serial: 1}
+	loaded, err := pki.load()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := "127.0.0.1:0"
+	if loaded {
+		addr = strings.TrimPrefix(pki.url, "http://")
+	}
+	if pki.listener, err = net.Listen("tcp", addr); err != nil {
+		return nil, fmt.Errorf("unable to serve the local CA of %s: %v", dir, err)
+	}
+	if !loaded {
+		pki.url = "http://" + pki.listener.Addr().String()
+		if err := pki.create(); err != nil {
+			pki.listener.Close()
+			return nil, err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/tsa", pki.tsa)
+	mux.HandleFunc("/ocsp", pki.serveOCSP)
+	mux.HandleFunc("/ca.crl", pki.serveCRL)
+	go http.Serve(pki.listener, mux)
+	return pki, nil
+}
+
+// create generates the CA and TSA keys and certificates and saves them.
+func (pki *localPKI) create() error {
+	if err := os.MkdirAll(pki.dir, 0700); err != nil {
+		return err
+	}
+
+	// Self-signed root certificate.
+	var err error
+	if pki.privateKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		return err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:   "Local Test CA",
+			Organization: []string{"Test Company"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, pki.privateKey.Public(), pki.privateKey)
+	if err != nil {
+		return err
+	}
+	if pki.certificate, err = x509.ParseCertificate(certData); err != nil {
+		return err
+	}
+
+	// TSA certificate. The extended key usage of TSA certificates must be critical and only contain
+	// timeStamping.
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{oidKeyPurposeTimeStamp})
+	if err != nil {
+		return err
+	}
+	tsaKey, tsaCert, err := pki.issueCertificate(&x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "Local Test TSA",
+			Organization: []string{"Test Company"},
+		},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: oidExtKeyUsage, Critical: true, Value: eku}},
+	})
+	if err != nil {
+		return err
+	}
+	pki.tsa = &localTSA{privateKey: tsaKey, certificate: tsaCert, chain: []*x509.Certificate{pki.certificate},
+		save: pki.save}
+
+	if err := writeKeyPair(pki.dir, "ca", pki.privateKey, pki.certificate); err != nil {
+		return err
+	}
+	if err := writeKeyPair(pki.dir, "tsa", tsaKey, tsaCert); err != nil {
+		return err
+	}
+	return pki.save()
+}
+
+// load loads the PKI from its directory. Returns false if the directory does not contain a PKI.
+func (pki *localPKI) load() (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(pki.dir, "pki.json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var state localPKIState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("pki.json: %v", err)
+	}
+	pki.url = state.URL
+	pki.serial = state.Serial
+
+	if pki.privateKey, pki.certificate, err = loadKeyPair(pki.dir, "ca"); err != nil {
+		return false, err
+	}
+	tsaKey, tsaCert, err := loadKeyPair(pki.dir, "tsa")
+	if err != nil {
+		return false, err
+	}
+	pki.tsa = &localTSA{privateKey: tsaKey, certificate: tsaCert, chain: []*x509.Certificate{pki.certificate},
+		serial: state.TSASerial, save: pki.save}
+	return true, nil
+}
+
+// save saves the state of the PKI.
+func (pki *localPKI) save() error {
+	pki.saveMu.Lock()
+	defer pki.saveMu.Unlock()
+
+	pki.mu.Lock()
+	state := localPKIState{URL: pki.url, Serial: pki.serial}
+	pki.mu.Unlock()
+	pki.tsa.mu.Lock()
+	state.TSASerial = pki.tsa.serial
+	pki.tsa.mu.Unlock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(pki.dir, "pki.json"), data, 0600)
+}
+
+// writeKeyPair writes the PKCS #8 private key `priv` and the certificate `cert` to NAME.key and NAME.pem
+// in `dir`.
+func writeKeyPair(dir, name string, priv *rsa.PrivateKey, cert *x509.Certificate) error {
+	keyData, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyData})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644)
+}
+
+// loadKeyPair loads the RSA private key and certificate of `name` from `dir`.
+func loadKeyPair(dir, name string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	keyData, err := ioutil.ReadFile(filepath.Join(dir, name+".key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s.key: invalid PEM data", name)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.key: %v", name, err)
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s.key: unsupported private key %T", name, key)
+	}
+
+	certData, err := ioutil.ReadFile(filepath.Join(dir, name+".pem"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if block, _ = pem.Decode(certData); block == nil {
+		return nil, nil, errors.New(name + ".pem: invalid PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.pem: %v", name, err)
+	}
+	return priv, cert, nil
+}
+
+// Close stops serving.
+func (pki *localPKI) Close() error {
+	return pki.listener.Close()
+}
+
+// issueCertificate generates a key pair and issues a certificate with `template`, referencing the OCSP
+// responder and CRL of the CA.
+func (pki *localPKI) issueCertificate(template *x509.Certificate) (*rsa.PrivateKey, *x509.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pki.mu.Lock()
+	pki.serial++
+	template.SerialNumber = big.NewInt(pki.serial)
+	pki.mu.Unlock()
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.Add(time.Hour * 24 * 365)
+	template.BasicConstraintsValid = true
+	template.OCSPServer = []string{pki.url + "/ocsp"}
+	template.CRLDistributionPoints = []string{pki.url + "/ca.crl"}
+
+	certData, err := x509.CreateCertificate(rand.Reader, template, pki.certificate, priv.Public(), pki.privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, cert, nil
+}
+
+// serveOCSP handles OCSP requests. All the certificates issued by the CA are reported as good.
+func (pki *localPKI) serveOCSP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+
+	req, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	status := ocsp.Good
+	pki.mu.Lock()
+	if req.SerialNumber.Sign() <= 0 || req.SerialNumber.Cmp(big.NewInt(pki.serial)) > 0 {
+		status = ocsp.Unknown
+	}
+	pki.mu.Unlock()
+
+	resp, err := ocsp.CreateResponse(pki.certificate, pki.certificate, ocsp.Response{
+		Status:       status,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour * 24),
+	}, pki.privateKey)
+	if err != nil {
+		log.Printf("OCSP error: %v\n", err)
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Write(resp)
+}
+
+// serveCRL serves the (empty) CRL of the CA.
+func (pki *localPKI) serveCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := pki.certificate.CreateCRL(rand.Reader, pki.privateKey, nil, time.Now().Add(-time.Minute),
+		time.Now().Add(time.Hour*24))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
+
+// localTSA is a minimal RFC 3161 Time Stamping Authority, intended for testing.
+type localTSA struct {
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+	chain       []*x509.Certificate
+
+	mu     sync.Mutex
+	serial int64
+
+	// Persists the serial number after issuing a timestamp.
+	save func() error
+}
+
+// ServeHTTP handles timestamp requests.
+func (tsa *localTSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := tsa.respond(body)
+	if err != nil {
+		log.Printf("TSA error: %v\n", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp)
+}
+
+// respond returns the DER encoded response to the DER encoded timestamp request `data`.
+func (tsa *localTSA) respond(data []byte) ([]byte, error) {
+	// Failure information bits: badAlg (0), badRequest (2).
+	reject := func(failBit int) ([]byte, error) {
+		failInfo := asn1.BitString{Bytes: []byte{byte(0x80 >> uint(failBit))}, BitLength: failBit + 1}
+		return asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 2, FailInfo: failInfo}})
+	}
+
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(data, &req); err != nil || len(rest) > 0 {
+		return reject(2)
+	}
+	hash, err := hashAlgorithm(req.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return reject(0)
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return reject(2)
+	}
+
+	tsa.mu.Lock()
+	tsa.serial++
+	serial := big.NewInt(tsa.serial)
+	tsa.mu.Unlock()
+	if err := tsa.save(); err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         oidLocalTSAPolicy,
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   serial,
+		GenTime:        time.Now().UTC().Truncate(time.Second),
+		Accuracy:       accuracy{Seconds: 1},
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The timestamp token is a signed data with the TSTInfo content.
+	signedData, err := pkcs7.NewSignedData(info)
+	if err != nil {
+		return nil, err
+	}
+	signedData.GetSignedData().ContentInfo.ContentType = oidTSTInfo
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	certHash := crypto.SHA256.New()
+	certHash.Write(tsa.certificate.Raw)
+	signingCert := pkcs7.Attribute{
+		Type:  oidSigningCertificateV2,
+		Value: signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash.Sum(nil)}}},
+	}
+	config := pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{signingCert}}
+	if err := signedData.AddSignerChain(tsa.certificate, tsa.privateKey, tsa.chain, config); err != nil {
+		return nil, err
+	}
+	token, err := signedData.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: 0},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+}
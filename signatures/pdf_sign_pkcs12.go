@@ -1,82 +1,240 @@
 /*
  * This example showcases how to digitally sign a PDF file using a
- * PKCS12 (.p12/.pfx) file.
+ * PKCS12 (.p12/.pfx) file, a PEM bundle or separate key and certificate
+ * files. RSA (PKCS #1 v1.5 or PSS with -pss) and ECDSA (P-256 or P-384)
+ * keys are supported, with SHA-256, SHA-384 or SHA-512 digests.
  *
- * $ ./pdf_sign_pkcs12 <FILE.p12> <PASSWORD> <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ * The signer file is either a PKCS12 file or a PEM file containing the
+ * private key (PKCS #1, SEC 1 or unencrypted PKCS #8, optionally encrypted
+ * with the legacy PEM encryption) and possibly certificates. Additional
+ * certificates (PEM or DER), such as the signer certificate of a separate
+ * key file or intermediate CA certificates, are loaded with -cert. The
+ * certificate chain of the signer is built from all loaded certificates
+ * and embedded in the signature.
+ *
+ * The password, key, certificate chain and certificate validity are
+ * checked before the output file is written. The signature is validated
+ * after signing.
+ *
+ * With -revocation, the OCSP responses (or the CRLs if no OCSP responder
+ * answers) of the certificates of the chain are fetched from the URLs of
+ * the certificates and embedded in the signature (Adobe revocation
+ * information attribute), so that the signature can be validated offline.
+ *
+ * $ ./pdf_sign_pkcs12 [-hash sha256|sha384|sha512] [-pss] [-revocation] [-cert CERT_FILE[,CERT_FILE...]] <SIGNER_FILE> <PASSWORD> <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * PKCS12 files must use the legacy algorithms (3DES/RC2 encryption and SHA-1 MAC),
+ * as the pkcs12 package does not support the PBES2/AES encryption and SHA-256 MAC
+ * used by default since OpenSSL 3: such files are rejected with an explicit error.
+ * Re-export them with -legacy, or sign with the PEM key and certificate files:
+ * $ openssl pkcs12 -in modern.p12 -nodes -out bundle.pem
+ * $ openssl pkcs12 -export -legacy -in bundle.pem -out file.p12
+ *
+ * A PKCS12 file with an ECDSA P-384 key can be created with OpenSSL:
+ * $ openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-384 -nodes -subj "/CN=Test" -keyout key.pem -out cert.pem
+ * $ openssl pkcs12 -export -legacy -inkey key.pem -in cert.pem -out file.p12
+ *
+ * The intermediate CA certificates are included with -certfile:
+ * $ openssl pkcs12 -export -legacy -inkey key.pem -in cert.pem -certfile chain.pem -out file.p12
+ *
+ * Sign with separate key and certificate files (empty password for an unencrypted key):
+ * $ ./pdf_sign_pkcs12 -cert cert.pem,chain.pem key.pem "" input.pdf output.pdf
+ *
+ * Sign with a certificate of the test CA of pdf_sign_ca, embedding the revocation data of its responder:
+ * $ ./pdf_sign_pkcs12 -revocation -cert ca/alice.pem,ca/chain.pem ca/alice.key "" input.pdf output.pdf
  */
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/gunnsth/pkcs7"
 	"github.com/unidoc/unipdf/v3/annotator"
 	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
-	"github.com/unidoc/unipdf/v3/model/sighandler"
+	"golang.org/x/crypto/ocsp"
 	"golang.org/x/crypto/pkcs12"
 )
 
-const usage = "Usage: %s P12_FILE PASSWORD INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+var now = time.Now()
+
+const usage = "Usage: %s [-hash sha256|sha384|sha512] [-pss] [-revocation] [-cert CERT_FILE[,CERT_FILE...]] SIGNER_FILE PASSWORD INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+
+// Size of the signature Contents, without the certificates.
+const signatureLen = 8192
+
+// Digest algorithms, by name.
+var hashes = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSASSAPSS     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+
+	oidRevocationInfoArchival = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8}
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// CMS (RFC 5652) structures.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+// RSASSA-PSS parameters (RFC 4055).
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// Adobe revocation information signed attribute.
+type revocationInfoArchival struct {
+	CRLs  []asn1.RawValue `asn1:"explicit,optional,tag:0"`
+	OCSPs []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+}
 
 func main() {
-	args := os.Args
-	if len(args) < 4 {
+	var hashName, certPaths string
+	var pss, fetchRevocation bool
+	flag.StringVar(&hashName, "hash", "sha256", "Digest algorithm: sha256, sha384 or sha512")
+	flag.BoolVar(&pss, "pss", false, "Use the RSASSA-PSS signature scheme for RSA keys")
+	flag.BoolVar(&fetchRevocation, "revocation", false, "Embed the OCSP responses or CRLs of the certificate chain")
+	flag.StringVar(&certPaths, "cert", "", "Comma-separated additional certificate files (PEM or DER)")
+	flag.Usage = func() {
 		fmt.Printf(usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 4 {
+		flag.Usage()
 		return
 	}
-	p12Path := args[1]
-	password := args[2]
-	inputPath := args[3]
-	outputPath := args[4]
+	signerPath := flag.Arg(0)
+	password := flag.Arg(1)
+	inputPath := flag.Arg(2)
+	outputPath := flag.Arg(3)
 
-	// Get private key and X509 certificate from the P12 file.
-	pfxData, err := ioutil.ReadFile(p12Path)
-	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+	hash, ok := hashes[hashName]
+	if !ok {
+		log.Fatalf("Fail: unsupported digest algorithm %s\n", hashName)
 	}
 
-	priv, cert, err := pkcs12.Decode(pfxData, password)
+	// Get private key and X509 certificate chain from the signer and certificate files.
+	var paths []string
+	if certPaths != "" {
+		paths = strings.Split(certPaths, ",")
+	}
+	signer, chain, err := loadSigner(signerPath, password, paths)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
+	}
+	if err := checkChain(chain); err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+	for i, cert := range chain {
+		log.Printf("Certificate %d: %s (issuer %s)\n", i, cert.Subject, cert.Issuer)
+	}
+
+	// Fetch the revocation data of the certificate chain.
+	var revocation *revocationInfoArchival
+	if fetchRevocation {
+		revocation, err = getRevocationData(chain)
+		if err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
 	}
 
 	// Create reader.
 	file, err := os.Open(inputPath)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 	defer file.Close()
 
 	reader, err := model.NewPdfReader(file)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create appender.
 	appender, err := model.NewPdfAppender(reader)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create signature handler.
-	handler, err := sighandler.NewAdobePKCS7Detached(priv.(*rsa.PrivateKey), cert)
-	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+	handler := &cmsHandler{
+		signer:     signer,
+		chain:      chain,
+		hash:       hash,
+		pss:        pss,
+		revocation: revocation,
 	}
 
 	// Create signature.
 	signature := model.NewPdfSignature(handler)
 	signature.SetName("Test Self Signed PDF")
 	signature.SetReason("TestSelfSignedPDF")
-	signature.SetDate(time.Now(), "")
+	signature.SetDate(now, "")
 
 	if err := signature.Initialize(); err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Create signature field and appearance.
@@ -93,17 +251,667 @@ func main() {
 		},
 		opts,
 	)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
 	field.T = core.MakeString("Self signed PDF")
 
 	if err = appender.Sign(1, field); err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	// Write output PDF file.
 	err = appender.WriteToFile(outputPath)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		log.Fatalf("Fail: %v\n", err)
 	}
 
 	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+
+	// Validate the signature of the output PDF file.
+	if err := validate(outputPath); err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+	log.Printf("Signature successfully validated\n")
+}
+
+// validate validates the signatures of the PDF file in `inputPath`.
+func validate(inputPath string) error {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	results, err := reader.ValidateSignatures([]model.SignatureHandler{&cmsHandler{}})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		if !res.IsVerified {
+			return fmt.Errorf("signature not verified: %s", res.String())
+		}
+	}
+	return nil
+}
+
+// loadSigner loads the private key and the certificate chain of the signer from the PKCS12 or PEM file
+// in `path`, decrypted with `password`, and the additional certificate files `certPaths`.
+func loadSigner(path, password string, certPaths []string) (crypto.Signer, []*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var blocks []*pem.Block
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			blocks = append(blocks, block)
+		}
+	} else {
+		// Unlike pkcs12.Decode, ToPEM accepts files with CA certificates.
+		blocks, err = pkcs12.ToPEM(data, password)
+		if err == pkcs12.ErrIncorrectPassword {
+			return nil, nil, fmt.Errorf("%s: wrong password", path)
+		}
+		if err != nil && isUnsupportedPKCS12(err) {
+			return nil, nil, fmt.Errorf("%s: the file uses a modern PKCS12 encryption (e.g. the AES and SHA-256 "+
+				"defaults of OpenSSL 3), which is not supported: re-export it with openssl pkcs12 -legacy or use "+
+				"PEM key and certificate files (%v)", path, err)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	var keys []interface{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch {
+		case block.Type == "CERTIFICATE":
+			blockCerts, err := x509.ParseCertificates(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			certs = append(certs, blockCerts...)
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			return nil, nil, fmt.Errorf("%s: encrypted PKCS #8 keys are not supported, use a PKCS12 file", path)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			der := block.Bytes
+			if x509.IsEncryptedPEMBlock(block) {
+				if password == "" {
+					return nil, nil, fmt.Errorf("%s: the private key is encrypted, password required", path)
+				}
+				der, err = x509.DecryptPEMBlock(block, []byte(password))
+				if err == x509.IncorrectPasswordError {
+					return nil, nil, fmt.Errorf("%s: wrong password", path)
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %v", path, err)
+				}
+			}
+			key, err := parsePrivateKey(der)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", path, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	for _, certPath := range certPaths {
+		pathCerts, err := loadCertificates(certPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		certs = append(certs, pathCerts...)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("%s: no private key found", path)
+	}
+	if len(keys) > 1 {
+		return nil, nil, fmt.Errorf("%s: %d private keys found, expected one", path, len(keys))
+	}
+	var signer crypto.Signer
+	switch key := keys[0].(type) {
+	case *rsa.PrivateKey:
+		signer = key
+	case *ecdsa.PrivateKey:
+		signer = key
+	default:
+		return nil, nil, fmt.Errorf("%s: unsupported private key %T", path, key)
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no certificate found, specify the signer certificate with -cert", path)
+	}
+
+	chain, err := buildChain(signer.Public(), certs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, chain, nil
+}
+
+// isUnsupportedPKCS12 returns true if `err` is the error of the pkcs12 package for files encrypted with
+// PBES2 (AES) or authenticated with a SHA-2 MAC, which it does not implement.
+func isUnsupportedPKCS12(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "unknown digest algorithm") || strings.Contains(msg, "is not supported")
+}
+
+// loadCertificates loads the PEM or DER certificates in `path`.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	if !bytes.Contains(data, []byte("-----BEGIN ")) {
+		certs, err = x509.ParseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificate found", path)
+	}
+	return certs, nil
+}
+
+// parsePrivateKey parses the PKCS #1, SEC 1 or PKCS #8 private key `der`.
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("invalid private key")
+	}
+	return key, nil
+}
+
+// buildChain returns the certificate chain of the certificate of public key `pub` among `certs`, from the
+// signer certificate to the root or the last issuer found.
+func buildChain(pub crypto.PublicKey, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	pubData, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawSubjectPublicKeyInfo, pubData) {
+			chain = append(chain, cert)
+			break
+		}
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate matches the private key")
+	}
+
+	for {
+		cert := chain[len(chain)-1]
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return chain, nil
+		}
+
+		var issuer *x509.Certificate
+		var issuerErr error
+		for _, candidate := range certs {
+			if !bytes.Equal(candidate.RawSubject, cert.RawIssuer) || inChain(chain, candidate) {
+				continue
+			}
+			if issuerErr = cert.CheckSignatureFrom(candidate); issuerErr == nil {
+				issuer = candidate
+				break
+			}
+		}
+		if issuerErr != nil {
+			return nil, fmt.Errorf("certificate %q: invalid signature of issuer: %v", cert.Subject, issuerErr)
+		}
+		if issuer == nil {
+			log.Printf("Warning: issuer %q of certificate %q not found, the chain is incomplete\n",
+				cert.Issuer, cert.Subject)
+			return chain, nil
+		}
+		chain = append(chain, issuer)
+	}
+}
+
+// inChain returns true if `cert` is in `chain`.
+func inChain(chain []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range chain {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChain checks that the certificates of `chain` are valid now and that the signer certificate can be
+// used for signing.
+func checkChain(chain []*x509.Certificate) error {
+	for _, cert := range chain {
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("certificate %q is not valid before %s", cert.Subject, cert.NotBefore)
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("certificate %q expired on %s", cert.Subject, cert.NotAfter)
+		}
+	}
+
+	usage := chain[0].KeyUsage
+	if usage != 0 && usage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+		return fmt.Errorf("certificate %q key usage does not allow signing", chain[0].Subject)
+	}
+	return nil
+}
+
+// getRevocationData returns the revocation data of the certificates of `chain` issued by the next
+// certificate of the chain: the OCSP response of their responder or, if not available, the CRL of their
+// distribution points.
+func getRevocationData(chain []*x509.Certificate) (*revocationInfoArchival, error) {
+	revocation := &revocationInfoArchival{}
+	for i := 0; i+1 < len(chain); i++ {
+		ocspResp, crl, err := fetchRevocationData(chain[i], chain[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if ocspResp != nil {
+			log.Printf("Embedding the OCSP response for %s\n", chain[i].Subject)
+			revocation.OCSPs = append(revocation.OCSPs, asn1.RawValue{FullBytes: ocspResp})
+		} else {
+			log.Printf("Embedding the CRL for %s\n", chain[i].Subject)
+			revocation.CRLs = append(revocation.CRLs, asn1.RawValue{FullBytes: crl})
+		}
+	}
+	return revocation, nil
+}
+
+// fetchRevocationData returns the OCSP response of the responder of `cert` issued by `issuer` or, if
+// not available, the CRL of its distribution points.
+func fetchRevocationData(cert, issuer *x509.Certificate) (ocspResp []byte, crl []byte, err error) {
+	err = fmt.Errorf("no revocation information for %s", cert.Subject)
+	for _, url := range cert.OCSPServer {
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := httpRequest("POST", url, "application/ocsp-request", req)
+		if err != nil {
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+		if err != nil {
+			continue
+		}
+		if resp.Status != ocsp.Good {
+			return nil, nil, fmt.Errorf("certificate %s is revoked or unknown", cert.Subject)
+		}
+		return data, nil, nil
+	}
+
+	for _, url := range cert.CRLDistributionPoints {
+		data, err := httpRequest("GET", url, "", nil)
+		if err != nil {
+			continue
+		}
+		list, err := x509.ParseCRL(data)
+		if err != nil || issuer.CheckCRLSignature(list) != nil {
+			continue
+		}
+		for _, revoked := range list.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return nil, nil, fmt.Errorf("certificate %s is revoked", cert.Subject)
+			}
+		}
+		return nil, data, nil
+	}
+	return nil, nil, err
+}
+
+// httpRequest sends a `method` request with `body` of `contentType` to `url` and returns the response body.
+func httpRequest(method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// cmsHandler is an adbe.pkcs7.detached signature handler signing with a crypto.Signer, supporting RSA
+// (PKCS #1 v1.5 or PSS) and ECDSA keys.
+type cmsHandler struct {
+	signer crypto.Signer
+	chain  []*x509.Certificate // Signer certificate first.
+	hash   crypto.Hash
+	pss    bool
+
+	// Revocation data embedded in the signed attributes, if not nil.
+	revocation *revocationInfoArchival
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *cmsHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "adbe.pkcs7.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *cmsHandler) InitSignature(sig *model.PdfSignature) error {
+	if h.signer == nil || len(h.chain) == 0 {
+		return errors.New("signer and certificate must not be nil")
+	}
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("adbe.pkcs7.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, h.contentsLen())))
+	return nil
+}
+
+// contentsLen returns the size of the signature Contents, which embed the certificate chain and the
+// revocation data.
+func (h *cmsHandler) contentsLen() int {
+	size := signatureLen
+	for _, cert := range h.chain {
+		size += len(cert.Raw)
+	}
+	if h.revocation != nil {
+		for _, raw := range append(h.revocation.CRLs, h.revocation.OCSPs...) {
+			size += len(raw.FullBytes)
+		}
+	}
+	return size
+}
+
+// NewDigest creates a new digest.
+func (h *cmsHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *cmsHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	data, err := signCMS(digest.(*bytes.Buffer).Bytes(), h.signer, h.chain, h.hash, h.pss, h.revocation)
+	if err != nil {
+		return err
+	}
+	if len(data) > h.contentsLen() {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, h.contentsLen())
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// Validate validates PdfSignature.
+func (h *cmsHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if err := verifyCMS(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// signCMS returns the detached CMS signature of `data` by `signer` with certificate chain `chain`, using
+// digest algorithm `hash` and the RSASSA-PSS scheme for RSA keys if `pss` is true. The revocation data
+// `revocation` is added to the signed attributes if not nil.
+func signCMS(data []byte, signer crypto.Signer, chain []*x509.Certificate, hash crypto.Hash, pss bool,
+	revocation *revocationInfoArchival) ([]byte, error) {
+	digestAlg, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	var sigAlg pkix.AlgorithmIdentifier
+	var opts crypto.SignerOpts = hash
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		if !pss {
+			sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+			break
+		}
+		sigAlg, err = pssAlgorithm(hash)
+		if err != nil {
+			return nil, err
+		}
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA256:
+			sigAlg.Algorithm = oidECDSASHA256
+		case crypto.SHA384:
+			sigAlg.Algorithm = oidECDSASHA384
+		case crypto.SHA512:
+			sigAlg.Algorithm = oidECDSASHA512
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	// Signed attributes, DER encoded as a SET OF sorted by encoding.
+	h := hash.New()
+	h.Write(data)
+	type attribute struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}
+	signedAttrs := []attribute{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, h.Sum(nil)},
+	}
+	if revocation != nil {
+		signedAttrs = append(signedAttrs, attribute{oidRevocationInfoArchival, *revocation})
+	}
+	var attrs [][]byte
+	for _, attr := range signedAttrs {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}{attr.oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sign the signed attributes.
+	h = hash.New()
+	h.Write(set)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw...)
+	}
+	cert := chain[0]
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestAlg}},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerial{
+				IssuerName:   asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: pkix.AlgorithmIdentifier{Algorithm: digestAlg},
+			AuthenticatedAttributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: sigAlg,
+			EncryptedDigest:           signature,
+		}},
+	}
+	content, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+}
+
+// verifyCMS verifies the detached CMS signature `contents` of `data`.
+func verifyCMS(contents, data []byte) error {
+	p7, err := pkcs7.Parse(contents)
+	if err != nil {
+		return err
+	}
+	p7.Content = data
+	if len(p7.Signers) != 1 {
+		return errors.New("signature must have a single signer")
+	}
+	signer := p7.Signers[0]
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		// RSA PKCS #1 v1.5 and ECDSA signatures are verified by the pkcs7 package.
+		return p7.Verify()
+	}
+
+	// Verify the message digest of the RSASSA-PSS signature.
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return errors.New("signer certificate not found")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signer certificate key is not RSA")
+	}
+	hash, err := hashAlgorithm(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if err := p7.UnmarshalSignedAttribute(oidMessageDigest, &digest); err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("message digest mismatch")
+	}
+
+	// Verify the signature of the signed attributes.
+	var attrs [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return err
+	}
+
+	var params pssParameters
+	if _, err := asn1.Unmarshal(signer.DigestEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	pssHash, err := hashAlgorithm(params.Hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	h = pssHash.New()
+	h.Write(set)
+	opts := &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: pssHash}
+	return rsa.VerifyPSS(pub, pssHash, h.Sum(nil), signer.EncryptedDigest, opts)
+}
+
+// pssAlgorithm returns the RSASSA-PSS algorithm identifier with digest algorithm `hash`.
+func pssAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	oid, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	hashAlg := pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}
+	mgfParams, err := asn1.Marshal(hashAlg)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	params, err := asn1.Marshal(pssParameters{
+		Hash:         hashAlg,
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		SaltLength:   hash.Size(),
+		TrailerField: 1,
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSASSAPSS, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
+
+// hashAlgorithmOID returns the object identifier of hash algorithm `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
 }
@@ -0,0 +1,703 @@
+/*
+ * This example showcases how to find out what changed in a signed PDF file after it was signed. Signatures
+ * only cover the revision they were applied to (up to the end of their byte range); the incremental updates
+ * appended after it are not covered.
+ *
+ * The list command lists the revisions of the file and, for each signature, the revisions it covers and
+ * the later revisions it does not cover.
+ *
+ * The extract command writes the exact revision signed by a signature (the bytes up to the end of its byte
+ * range) as a standalone PDF file, which is what the signer saw and signed.
+ *
+ * The diff command reports the structural differences between the revision signed by each signature and
+ * the final file: the objects added, changed and removed, the page count and page content changes, the
+ * annotations and the form field values.
+ *
+ * $ ./pdf_sign_revisions list <INPUT_PDF_PATH>
+ * $ ./pdf_sign_revisions extract [-signature NAME] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ * $ ./pdf_sign_revisions diff [-signature NAME] [-format text|json] <INPUT_PDF_PATH>
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+const usage = "Usage:\n" +
+	"  %[1]s list INPUT_PDF_PATH\n" +
+	"  %[1]s extract [-signature NAME] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s diff [-signature NAME] [-format text|json] INPUT_PDF_PATH\n"
+
+// Kinds of changes between revisions.
+const (
+	changePages      = "pages"
+	changeContent    = "page content"
+	changeAnnotation = "annotation"
+	changeFieldAdd   = "field added"
+	changeFieldDel   = "field removed"
+	changeFill       = "form fill-in"
+	changeSignature  = "signature"
+)
+
+// change represents a change between two revisions.
+type change struct {
+	Kind string `json:"kind"`
+	Desc string `json:"description"`
+}
+
+// objectChange represents an object added, changed or removed between two revisions.
+type objectChange struct {
+	Number int    `json:"number"`
+	Status string `json:"status"`
+	Type   string `json:"type"`
+}
+
+// revisionDiff represents the differences between the revision signed by a signature and the final file.
+type revisionDiff struct {
+	Signature   string         `json:"signature"`
+	Revision    int            `json:"revision"`
+	Revisions   int            `json:"revisions"`
+	SignedBytes int64          `json:"signedBytes"`
+	FileBytes   int64          `json:"fileBytes"`
+	Objects     []objectChange `json:"objects"`
+	Changes     []change       `json:"changes"`
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(os.Args[2])
+	case "extract":
+		err = extractCommand(os.Args[2:])
+	case "diff":
+		err = diffCommand(os.Args[2:])
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// signature represents a signature or document timestamp of a PDF file.
+type signature struct {
+	name      string
+	sigType   string
+	signer    string
+	byteRange []int64 // Offsets of the signed ranges, as returned by parseByteRange.
+	revision  int     // Number of the signed revision.
+}
+
+// end returns the end offset of the revision signed by `sig`.
+func (sig *signature) end() int64 {
+	return sig.byteRange[3]
+}
+
+// list prints the revisions of the PDF file in `inputPath` and the revisions covered by each signature.
+func list(inputPath string) error {
+	data, revisions, sigs, err := load(inputPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d revisions, %d signatures\n", len(revisions), len(sigs))
+	start := int64(0)
+	for i, end := range revisions {
+		fmt.Printf("Revision %d: bytes %d-%d", i+1, start, end)
+		for _, sig := range sigs {
+			if sig.revision == i+1 {
+				fmt.Printf(", signed by %s", sig.name)
+			}
+		}
+		fmt.Println()
+		start = end
+	}
+
+	for _, sig := range sigs {
+		fmt.Printf("Signature %s (%s", sig.name, sig.sigType)
+		if sig.signer != "" {
+			fmt.Printf(", %s", sig.signer)
+		}
+		fmt.Println(")")
+		br := sig.byteRange
+		fmt.Printf("  Byte range: %d-%d, %d-%d (Contents at %d-%d)\n", br[0], br[1], br[2], br[3], br[1], br[2])
+		if br[0] != 0 || !isContents(data[br[1]:br[2]]) {
+			fmt.Println("  Warning: the byte range does not cover the whole revision except the Contents")
+		}
+		if revisions[sig.revision-1] != sig.end() && !isWhitespace(data[sig.end():revisions[sig.revision-1]]) {
+			fmt.Printf("  Warning: the byte range ends inside revision %d\n", sig.revision)
+		}
+
+		fmt.Printf("  Covers %s\n", revisionList(1, sig.revision))
+		if sig.revision < len(revisions) {
+			fmt.Printf("  Does not cover %s (%d bytes added after signing)\n",
+				revisionList(sig.revision+1, len(revisions)), int64(len(data))-sig.end())
+		} else {
+			fmt.Println("  Covers the whole document")
+		}
+	}
+	return nil
+}
+
+// extractCommand writes the revision signed by a signature with the options in `args`.
+func extractCommand(args []string) error {
+	var name string
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	flags.StringVar(&name, "signature", "", "Name of the signature field (default: the first signature)")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	data, _, sigs, err := load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	sig, err := findSignature(sigs, name)
+	if err != nil {
+		return err
+	}
+
+	// The signed revision is a complete PDF file on its own.
+	signed := data[:sig.end()]
+	if _, err := model.NewPdfReader(bytes.NewReader(signed)); err != nil {
+		return fmt.Errorf("signed revision of %s cannot be read: %v", sig.name, err)
+	}
+	if err := ioutil.WriteFile(flags.Arg(1), signed, 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Revision %d signed by %s (%d of %d bytes) written to %s\n", sig.revision, sig.name,
+		len(signed), len(data), flags.Arg(1))
+	return nil
+}
+
+// diffCommand prints the differences between the revisions signed by the signatures and the final file
+// with the options in `args`.
+func diffCommand(args []string) error {
+	var name, format string
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.StringVar(&name, "signature", "", "Name of the signature field (default: all signatures)")
+	flags.StringVar(&format, "format", "text", "Report format: text or json")
+	flags.Parse(args)
+	if flags.NArg() < 1 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported format %s", format)
+	}
+
+	data, revisions, sigs, err := load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		sig, err := findSignature(sigs, name)
+		if err != nil {
+			return err
+		}
+		sigs = []*signature{sig}
+	}
+
+	final, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	diffs := []*revisionDiff{}
+	for _, sig := range sigs {
+		signed, err := model.NewPdfReader(bytes.NewReader(data[:sig.end()]))
+		if err != nil {
+			return err
+		}
+		diff := &revisionDiff{
+			Signature:   sig.name,
+			Revision:    sig.revision,
+			Revisions:   len(revisions),
+			SignedBytes: sig.end(),
+			FileBytes:   int64(len(data)),
+		}
+		if diff.Objects, err = compareObjects(signed, final); err != nil {
+			return err
+		}
+		if diff.Changes, err = compareRevisions(signed, final); err != nil {
+			return err
+		}
+		diffs = append(diffs, diff)
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	for _, diff := range diffs {
+		fmt.Printf("Signature %s: revision %d of %d, %d of %d bytes signed\n", diff.Signature, diff.Revision,
+			diff.Revisions, diff.SignedBytes, diff.FileBytes)
+		if len(diff.Objects) == 0 && len(diff.Changes) == 0 {
+			fmt.Println("  No changes after signing")
+			continue
+		}
+		fmt.Printf("  Objects (%d):\n", len(diff.Objects))
+		for _, obj := range diff.Objects {
+			fmt.Printf("    %s %d 0 obj (%s)\n", obj.Status, obj.Number, obj.Type)
+		}
+		fmt.Printf("  Changes (%d):\n", len(diff.Changes))
+		for _, c := range diff.Changes {
+			fmt.Printf("    %s: %s\n", c.Kind, c.Desc)
+		}
+	}
+	return nil
+}
+
+// load reads the PDF file in `inputPath` and returns its data, the end offsets of its revisions and its
+// signatures.
+func load(inputPath string) ([]byte, []int64, []*signature, error) {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	revisions := findRevisions(data)
+	if len(revisions) == 0 {
+		return nil, nil, nil, errors.New("no revisions found")
+	}
+	sigs, err := getSignatures(reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(sigs) == 0 {
+		return nil, nil, nil, errors.New("no signatures found")
+	}
+	for _, sig := range sigs {
+		if sig.end() > int64(len(data)) {
+			return nil, nil, nil, fmt.Errorf("byte range of %s exceeds the file size", sig.name)
+		}
+		sig.revision = revisionNumber(revisions, sig.end())
+	}
+	return data, revisions, sigs, nil
+}
+
+// findSignature returns the signature named `name` among `sigs`, or the first signature if `name` is empty.
+func findSignature(sigs []*signature, name string) (*signature, error) {
+	if name == "" {
+		return sigs[0], nil
+	}
+	for _, sig := range sigs {
+		if sig.name == name {
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("signature %s not found", name)
+}
+
+// getSignatures returns the signatures and document timestamps of the form of `reader`, sorted by revision.
+func getSignatures(reader *model.PdfReader) ([]*signature, error) {
+	if reader.AcroForm == nil {
+		return nil, nil
+	}
+
+	var sigs []*signature
+	seen := map[*model.PdfField]bool{}
+	for _, field := range reader.AcroForm.AllFields() {
+		if seen[field] || field.V == nil {
+			continue
+		}
+		seen[field] = true
+		dict, ok := core.GetDict(field.V)
+		if !ok || dict.Get("ByteRange") == nil {
+			continue
+		}
+		sigType, _ := core.GetNameVal(dict.Get("Type"))
+		if sigType == "" {
+			sigType = "Sig"
+		}
+		if sigType != "Sig" && sigType != "DocTimeStamp" {
+			continue
+		}
+
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+		arr, _ := core.GetArray(dict.Get("ByteRange"))
+		byteRange, err := parseByteRange(arr)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %v", name, err)
+		}
+		if byteRange[0] < 0 || byteRange[1] < byteRange[0] || byteRange[2] < byteRange[1] || byteRange[3] < byteRange[2] {
+			return nil, fmt.Errorf("signature %s: invalid byte range", name)
+		}
+		sig := &signature{name: name, sigType: sigType, byteRange: byteRange}
+		sig.signer, _ = core.GetStringVal(dict.Get("Name"))
+		sigs = append(sigs, sig)
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].end() < sigs[j].end()
+	})
+	return sigs, nil
+}
+
+// parseByteRange parses the signature byte range `byteRange` and returns the start and end offsets of
+// the two signed ranges.
+func parseByteRange(byteRange *core.PdfObjectArray) ([]int64, error) {
+	if byteRange == nil {
+		return nil, errors.New("byte range cannot be nil")
+	}
+	if byteRange.Len() != 4 {
+		return nil, errors.New("invalid byte range length")
+	}
+
+	s1, err := core.GetNumberAsInt64(byteRange.Get(0))
+	if err != nil {
+		return nil, errors.New("invalid byte range value")
+	}
+	l1, err := core.GetNumberAsInt64(byteRange.Get(1))
+	if err != nil {
+		return nil, errors.New("invalid byte range value")
+	}
+
+	s2, err := core.GetNumberAsInt64(byteRange.Get(2))
+	if err != nil {
+		return nil, errors.New("invalid byte range value")
+	}
+	l2, err := core.GetNumberAsInt64(byteRange.Get(3))
+	if err != nil {
+		return nil, errors.New("invalid byte range value")
+	}
+
+	return []int64{s1, s1 + l1, s2, s2 + l2}, nil
+}
+
+// compareObjects returns the objects added, changed and removed between the revisions read by `prev`
+// and `next`.
+func compareObjects(prev, next *model.PdfReader) ([]objectChange, error) {
+	prevNums := map[int]bool{}
+	for _, num := range prev.GetObjectNums() {
+		prevNums[num] = true
+	}
+
+	var changes []objectChange
+	for _, num := range next.GetObjectNums() {
+		obj2, err := next.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		if !prevNums[num] {
+			changes = append(changes, objectChange{num, "added", objectType(obj2)})
+			continue
+		}
+		delete(prevNums, num)
+
+		obj1, err := prev.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		if serializeObject(obj1) != serializeObject(obj2) {
+			changes = append(changes, objectChange{num, "changed", objectType(obj2)})
+		}
+	}
+
+	var removed []int
+	for num := range prevNums {
+		removed = append(removed, num)
+	}
+	sort.Ints(removed)
+	for _, num := range removed {
+		obj, err := prev.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, objectChange{num, "removed", objectType(obj)})
+	}
+	return changes, nil
+}
+
+// serializeObject returns the serialized contents of indirect object or stream `obj`.
+func serializeObject(obj core.PdfObject) string {
+	switch t := obj.(type) {
+	case *core.PdfObjectStream:
+		return t.PdfObjectDictionary.WriteString() + "stream\n" + string(t.Stream)
+	case *core.PdfIndirectObject:
+		return t.PdfObject.WriteString()
+	}
+	return obj.WriteString()
+}
+
+// objectType returns a description of the type of indirect object or stream `obj`.
+func objectType(obj core.PdfObject) string {
+	kind := "object"
+	if _, ok := obj.(*core.PdfObjectStream); ok {
+		kind = "stream"
+	}
+	dict, ok := core.GetDict(obj)
+	if !ok {
+		if ind, ok := obj.(*core.PdfIndirectObject); ok {
+			switch ind.PdfObject.(type) {
+			case *core.PdfObjectArray:
+				kind = "array"
+			case *core.PdfObjectString:
+				kind = "string"
+			case *core.PdfObjectInteger, *core.PdfObjectFloat:
+				kind = "number"
+			}
+		}
+		return kind
+	}
+
+	typ, _ := core.GetNameVal(dict.Get("Type"))
+	if subtype, _ := core.GetNameVal(dict.Get("Subtype")); subtype != "" {
+		typ += "/" + subtype
+	} else if ft, _ := core.GetNameVal(dict.Get("FT")); ft != "" {
+		typ += "Field/" + ft
+	}
+	if typ == "" {
+		return kind
+	}
+	return kind + " " + typ
+}
+
+// compareRevisions returns the changes between the revisions read by `prev` and `next`.
+func compareRevisions(prev, next *model.PdfReader) ([]change, error) {
+	var changes []change
+
+	// Pages and page contents.
+	if len(prev.PageList) != len(next.PageList) {
+		changes = append(changes, change{changePages,
+			fmt.Sprintf("page count changed from %d to %d", len(prev.PageList), len(next.PageList))})
+	}
+	for i := 0; i < len(prev.PageList) && i < len(next.PageList); i++ {
+		contents1, err := prev.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		contents2, err := next.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		if contents1 != contents2 {
+			changes = append(changes, change{changeContent, fmt.Sprintf("page %d contents changed", i+1)})
+		}
+
+		annots1, err := getAnnotations(prev.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		annots2, err := getAnnotations(next.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range compareMaps(annots1, annots2) {
+			changes = append(changes, change{changeAnnotation, fmt.Sprintf("page %d annotation %s", i+1, desc)})
+		}
+	}
+
+	// Form fields.
+	fields1, err := getFieldValues(prev)
+	if err != nil {
+		return nil, err
+	}
+	fields2, err := getFieldValues(next)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(fields2) {
+		v2 := fields2[name]
+		v1, has := fields1[name]
+		switch {
+		case v2.isSignature && v2.value != "" && v1.value == "":
+			changes = append(changes, change{changeSignature, fmt.Sprintf("field %s signed", name)})
+		case !has:
+			changes = append(changes, change{changeFieldAdd, fmt.Sprintf("field %s added", name)})
+		case v1.value != v2.value && v1.isSignature:
+			changes = append(changes, change{changeSignature, fmt.Sprintf("signature %s changed", name)})
+		case v1.value != v2.value:
+			changes = append(changes, change{changeFill, fmt.Sprintf("field %s set to %s", name, v2.value)})
+		}
+	}
+	for _, name := range sortedKeys(fields1) {
+		if _, has := fields2[name]; !has {
+			changes = append(changes, change{changeFieldDel, fmt.Sprintf("field %s removed", name)})
+		}
+	}
+	return changes, nil
+}
+
+// getAnnotations returns the non-widget annotations of `page` by object number.
+func getAnnotations(page *model.PdfPage) (map[string]string, error) {
+	annotations, err := page.GetAnnotations()
+	if err != nil {
+		return nil, err
+	}
+
+	annots := map[string]string{}
+	for i, annot := range annotations {
+		if _, ok := annot.GetContext().(*model.PdfAnnotationWidget); ok {
+			continue
+		}
+		key := fmt.Sprintf("#%d", i)
+		obj := annot.GetContainingPdfObject()
+		if ind, ok := obj.(*core.PdfIndirectObject); ok && ind.ObjectNumber > 0 {
+			key = fmt.Sprintf("%d", ind.ObjectNumber)
+		}
+		if dict, ok := core.GetDict(obj); ok {
+			annots[key] = dict.WriteString()
+		}
+	}
+	return annots, nil
+}
+
+// compareMaps returns descriptions of the entries added, removed and changed between `m1` and `m2`.
+func compareMaps(m1, m2 map[string]string) []string {
+	var diffs []string
+	for _, key := range sortedKeys(m2) {
+		v1, has := m1[key]
+		switch {
+		case !has:
+			diffs = append(diffs, key+" added")
+		case v1 != m2[key]:
+			diffs = append(diffs, key+" changed")
+		}
+	}
+	for _, key := range sortedKeys(m1) {
+		if _, has := m2[key]; !has {
+			diffs = append(diffs, key+" removed")
+		}
+	}
+	return diffs
+}
+
+// fieldValue represents the value of a terminal form field.
+type fieldValue struct {
+	value       string
+	isSignature bool
+}
+
+// getFieldValues returns the values of the terminal form fields of `reader` by full name.
+func getFieldValues(reader *model.PdfReader) (map[string]fieldValue, error) {
+	values := map[string]fieldValue{}
+	if reader.AcroForm == nil {
+		return values, nil
+	}
+
+	for _, field := range reader.AcroForm.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		var fv fieldValue
+		if sig, ok := field.GetContext().(*model.PdfFieldSignature); ok {
+			fv.isSignature = true
+			if sig.V != nil {
+				fv.value = sig.V.ToPdfObject().WriteString()
+			}
+		} else if field.V != nil {
+			fv.value = field.V.WriteString()
+		}
+		values[name] = fv
+	}
+	return values, nil
+}
+
+// findRevisions returns the end offsets of the revisions of the PDF file `data`.
+func findRevisions(data []byte) []int64 {
+	var revisions []int64
+	marker := []byte("%%EOF")
+	for offset := 0; ; {
+		idx := bytes.Index(data[offset:], marker)
+		if idx < 0 {
+			break
+		}
+		// The revision ends after the end-of-line marker following %%EOF.
+		end := offset + idx + len(marker)
+		if end < len(data) && data[end] == '\r' {
+			end++
+		}
+		if end < len(data) && data[end] == '\n' {
+			end++
+		}
+		revisions = append(revisions, int64(end))
+		offset = end
+	}
+	return revisions
+}
+
+// revisionNumber returns the number of the revision ending at `end` (approximated by the first
+// revision ending after `end`).
+func revisionNumber(revisions []int64, end int64) int {
+	for i, rev := range revisions {
+		if rev >= end {
+			return i + 1
+		}
+	}
+	return len(revisions)
+}
+
+// revisionList returns a description of the revisions from `first` to `last`.
+func revisionList(first, last int) string {
+	if first == last {
+		return fmt.Sprintf("revision %d", first)
+	}
+	return fmt.Sprintf("revisions %d-%d", first, last)
+}
+
+// isContents returns true if `data` is a hexadecimal string, as the Contents excluded from the byte range.
+func isContents(data []byte) bool {
+	return len(data) >= 2 && data[0] == '<' && data[len(data)-1] == '>'
+}
+
+// isWhitespace returns true if `data` only contains whitespace characters.
+func isWhitespace(data []byte) bool {
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// sortedKeys returns the sorted keys of map `m`.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]string:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]fieldValue:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,888 @@
+/*
+ * This example showcases a workflow where several parties sign a PDF file sequentially. Each signature
+ * is appended as a separate incremental update, preserving the previous revisions and signatures.
+ *
+ * The first signer can certify the document (DocMDP) with a permission level restricting the changes
+ * allowed after the certification:
+ * 1 - no changes allowed,
+ * 2 - form fill-in and signing allowed,
+ * 3 - form fill-in, signing and annotation changes allowed.
+ *
+ * The verify command validates the signatures and reports, for each revision after a certification
+ * signature, the changes made and whether they violate the certification permissions.
+ *
+ * Each party signs using a generated private/public key pair.
+ *
+ * Sign (or certify) the PDF file:
+ * $ ./pdf_sign_sequential sign [options] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Example workflow:
+ * $ ./pdf_sign_sequential sign -certify 2 -name Alice -rect 50,50,200,100 input.pdf signed1.pdf
+ * $ ./pdf_sign_sequential sign -name Bob -rect 250,50,400,100 signed1.pdf signed2.pdf
+ * $ ./pdf_sign_sequential verify signed2.pdf
+ */
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unidoc/unipdf/v3/annotator"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+	"github.com/unidoc/unipdf/v3/model/sighandler"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s sign [options] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s verify INPUT_PDF_PATH\n"
+
+// Kinds of changes between revisions.
+const (
+	changePages      = "pages"
+	changeContent    = "page content"
+	changeAnnotation = "annotation"
+	changeFieldAdd   = "field added"
+	changeFieldDel   = "field removed"
+	changeFill       = "form fill-in"
+	changeSignature  = "signature"
+)
+
+// change represents a change between two revisions.
+type change struct {
+	kind string
+	desc string
+}
+
+// allowedChange returns true if changes of `kind` are allowed by DocMDP permission level `p`.
+func allowedChange(p int64, kind string) bool {
+	switch kind {
+	case changeFill, changeSignature:
+		return p >= 2
+	case changeAnnotation:
+		return p >= 3
+	}
+	return false
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "sign":
+		err = signCommand(os.Args[2:])
+	case "verify":
+		err = verify(os.Args[2])
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// signCommand signs the PDF file with the options in `args`.
+func signCommand(args []string) error {
+	var name, reason, rectStr, fieldName string
+	var pageNum int
+	var certify int64
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	flags.StringVar(&name, "name", "Jane Doe", "Signer name")
+	flags.StringVar(&reason, "reason", "", "Signing reason")
+	flags.Int64Var(&certify, "certify", 0, "Certify the document with DocMDP permission level 1, 2 or 3")
+	flags.IntVar(&pageNum, "page", 1, "Page number of the signature")
+	flags.StringVar(&rectStr, "rect", "50,50,200,100", "Signature rectangle: llx,lly,urx,ury")
+	flags.StringVar(&fieldName, "field", "", "Name of the signature field (default: signer name)")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	inputPath := flags.Arg(0)
+	outputPath := flags.Arg(1)
+	if certify < 0 || certify > 3 {
+		return fmt.Errorf("invalid certification level: %d", certify)
+	}
+	if fieldName == "" {
+		fieldName = name
+	}
+
+	rect, err := parseRect(rectStr)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sigs, err := getSignatures(reader)
+	if err != nil {
+		return err
+	}
+
+	// Check the existing certification.
+	_, p, err := getDocMDP(reader)
+	if err != nil {
+		return err
+	}
+	switch {
+	case certify > 0 && p > 0:
+		return errors.New("document is already certified")
+	case certify > 0 && len(sigs) > 0:
+		return errors.New("the certification signature must be the first signature")
+	case p == 1:
+		return errors.New("document is certified with no changes allowed")
+	}
+
+	// The appender copies the catalog entries of the previous revision. Add a placeholder permissions
+	// dictionary (/Perms) to the catalog, set to the certification signature when signing.
+	if certify > 0 {
+		if data, err = addPermsPlaceholder(data); err != nil {
+			return err
+		}
+		if reader, err = model.NewPdfReader(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	// Generate key pair.
+	priv, cert, err := generateKeys(name)
+	if err != nil {
+		return err
+	}
+
+	// Create appender.
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		return err
+	}
+
+	// Create signature handler.
+	handler, err := sighandler.NewAdobePKCS7Detached(priv, cert)
+	if err != nil {
+		return err
+	}
+
+	// Create signature.
+	signature := model.NewPdfSignature(handler)
+	signature.SetName(name)
+	signature.SetReason(reason)
+	signature.SetDate(now, "")
+
+	if err := signature.Initialize(); err != nil {
+		return err
+	}
+
+	// Create signature field and appearance.
+	lines := []*annotator.SignatureLine{
+		annotator.NewSignatureLine("Name", name),
+		annotator.NewSignatureLine("Date", now.Format("2006.01.02")),
+	}
+	if reason != "" {
+		lines = append(lines, annotator.NewSignatureLine("Reason", reason))
+	}
+	if certify > 0 {
+		lines = append(lines, annotator.NewSignatureLine("Certified", fmt.Sprintf("DocMDP P=%d", certify)))
+	}
+
+	opts := annotator.NewSignatureFieldOpts()
+	opts.Rect = rect
+	sigField, err := annotator.NewSignatureField(signature, lines, opts)
+	if err != nil {
+		return err
+	}
+	sigField.T = core.MakeString(fieldName)
+
+	if err = appender.Sign(pageNum, sigField); err != nil {
+		return err
+	}
+
+	// Set the certification.
+	if certify > 0 {
+		perms, err := getPerms(reader)
+		if err != nil {
+			return err
+		}
+
+		params := core.MakeDict()
+		params.Set("Type", core.MakeName("TransformParams"))
+		params.Set("P", core.MakeInteger(certify))
+		params.Set("V", core.MakeName("1.2"))
+
+		ref := core.MakeDict()
+		ref.Set("Type", core.MakeName("SigRef"))
+		ref.Set("TransformMethod", core.MakeName("DocMDP"))
+		ref.Set("TransformParams", params)
+		signature.Reference = core.MakeArray(ref)
+
+		perms.PdfObject.(*core.PdfObjectDictionary).Set("DocMDP", signature.ToPdfObject())
+		appender.UpdateObject(perms)
+	}
+
+	// Write output PDF file.
+	if err = appender.WriteToFile(outputPath); err != nil {
+		return err
+	}
+
+	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+	return nil
+}
+
+// addPermsPlaceholder appends an incremental update to the PDF file `data`, adding an empty permissions
+// dictionary to the catalog.
+func addPermsPlaceholder(data []byte) ([]byte, error) {
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	if trailer.Get("Encrypt") != nil {
+		return nil, errors.New("certifying encrypted documents is not supported")
+	}
+
+	// Catalog object.
+	var rootNum, rootGen int64
+	switch root := trailer.Get("Root").(type) {
+	case *core.PdfObjectReference:
+		rootNum, rootGen = root.ObjectNumber, root.GenerationNumber
+	case *core.PdfIndirectObject:
+		rootNum, rootGen = root.ObjectNumber, root.GenerationNumber
+	default:
+		return nil, errors.New("invalid catalog reference")
+	}
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, err
+	}
+	if catalog.Get("Perms") != nil {
+		return data, nil
+	}
+
+	// Offset of the previous cross-reference section.
+	idx := bytes.LastIndex(data, []byte("startxref"))
+	if idx < 0 {
+		return nil, errors.New("startxref not found")
+	}
+	fields := strings.Fields(string(data[idx+len("startxref"):]))
+	if len(fields) == 0 {
+		return nil, errors.New("invalid startxref")
+	}
+	prevOffset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || prevOffset < 0 || prevOffset >= int64(len(data)) {
+		return nil, errors.New("invalid startxref")
+	}
+	xrefStream := !bytes.HasPrefix(bytes.TrimLeft(data[prevOffset:], " \r\n"), []byte("xref"))
+
+	size, ok := core.GetIntVal(trailer.Get("Size"))
+	if !ok {
+		return nil, errors.New("invalid trailer size")
+	}
+	permsNum := int64(size)
+
+	// Updated catalog, referencing the permissions dictionary.
+	perms := core.MakeIndirectObject(core.MakeDict())
+	perms.ObjectNumber = permsNum
+	newCatalog := core.MakeDict()
+	newCatalog.Merge(catalog)
+	newCatalog.Set("Perms", perms)
+
+	var buf bytes.Buffer
+	buf.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	catalogOffset := buf.Len()
+	fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", rootNum, rootGen, newCatalog.WriteString())
+	permsOffset := buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n<<>>\nendobj\n", permsNum)
+
+	newTrailer := core.MakeDict()
+	newTrailer.Set("Root", core.MakeIndirectObject(nil))
+	newTrailer.Get("Root").(*core.PdfIndirectObject).ObjectNumber = rootNum
+	for _, key := range []core.PdfObjectName{"Info", "ID"} {
+		if obj := trailer.Get(key); obj != nil {
+			newTrailer.Set(key, obj)
+		}
+	}
+	newTrailer.Set("Prev", core.MakeInteger(prevOffset))
+
+	xrefOffset := buf.Len()
+	if xrefStream {
+		// Cross-reference stream with the catalog, permissions and stream objects.
+		xrefNum := permsNum + 1
+		var entries bytes.Buffer
+		for _, entry := range [][2]int64{{int64(catalogOffset), rootGen}, {int64(permsOffset), 0}, {int64(xrefOffset), 0}} {
+			entries.Write([]byte{1, byte(entry[0] >> 24), byte(entry[0] >> 16), byte(entry[0] >> 8), byte(entry[0]),
+				byte(entry[1] >> 8), byte(entry[1])})
+		}
+		newTrailer.Set("Type", core.MakeName("XRef"))
+		newTrailer.Set("Size", core.MakeInteger(xrefNum+1))
+		newTrailer.Set("Index", core.MakeArrayFromIntegers64([]int64{rootNum, 1, permsNum, 2}))
+		newTrailer.Set("W", core.MakeArrayFromIntegers([]int{1, 4, 2}))
+		newTrailer.Set("Length", core.MakeInteger(int64(entries.Len())))
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nstream\n", xrefNum, newTrailer.WriteString())
+		buf.Write(entries.Bytes())
+		buf.WriteString("\nendstream\nendobj\n")
+	} else {
+		newTrailer.Set("Size", core.MakeInteger(permsNum+1))
+		fmt.Fprintf(&buf, "xref\n%d 1\n%010d %05d n \n%d 1\n%010d 00000 n \n", rootNum, catalogOffset, rootGen,
+			permsNum, permsOffset)
+		fmt.Fprintf(&buf, "trailer\n%s\n", newTrailer.WriteString())
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes(), nil
+}
+
+// signature represents a signature of a PDF file.
+type signature struct {
+	field       *model.PdfField
+	name        string
+	dict        *core.PdfObjectDictionary
+	objectNum   int64
+	revisionEnd int64
+}
+
+// verify validates the signatures of the PDF file in `inputPath` and checks the changes made after a
+// certification signature.
+func verify(inputPath string) error {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sigs, err := getSignatures(reader)
+	if err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return errors.New("no signatures found")
+	}
+
+	// Validate signatures.
+	handlerX509RSASHA1, err := sighandler.NewAdobeX509RSASHA1(nil, nil)
+	if err != nil {
+		return err
+	}
+	handlerPKCS7Detached, err := sighandler.NewAdobePKCS7Detached(nil, nil)
+	if err != nil {
+		return err
+	}
+	results, err := reader.ValidateSignatures([]model.SignatureHandler{handlerX509RSASHA1, handlerPKCS7Detached})
+	if err != nil {
+		return err
+	}
+
+	revisions := findRevisions(data)
+	fmt.Printf("%d revisions, %d signatures\n", len(revisions), len(sigs))
+
+	certSig, p, err := getDocMDP(reader)
+	if err != nil {
+		return err
+	}
+
+	valid := true
+	for i, sig := range sigs {
+		status := "invalid"
+		for _, res := range results {
+			if len(res.Fields) > 0 && res.Fields[0] == sig.field {
+				if res.IsSigned && res.IsVerified {
+					status = "valid"
+				} else {
+					valid = false
+				}
+				if len(res.Errors) > 0 {
+					status += " (" + strings.Join(res.Errors, ", ") + ")"
+				}
+			}
+		}
+
+		kind := "approval"
+		if certSig != nil && certSig.objectNum == sig.objectNum {
+			kind = fmt.Sprintf("certification, DocMDP P=%d", p)
+		}
+		signer, _ := core.GetStringVal(sig.dict.Get("Name"))
+		fmt.Printf("Signature %d: %s, signed by %s in revision %d (%s): %s\n", i+1, sig.name, signer,
+			revisionNumber(revisions, sig.revisionEnd), kind, status)
+		if sig.revisionEnd == int64(len(data)) {
+			fmt.Println("  Covers the whole document")
+		} else {
+			fmt.Printf("  Covers %d of %d bytes, the document was updated after signing\n", sig.revisionEnd, len(data))
+		}
+	}
+
+	if certSig == nil {
+		fmt.Println("Document is not certified")
+	} else {
+		ok, err := checkCertification(data, revisions, certSig, p)
+		if err != nil {
+			return err
+		}
+		valid = valid && ok
+	}
+
+	if !valid {
+		return errors.New("verification failed")
+	}
+	fmt.Println("Verification succeeded")
+	return nil
+}
+
+// checkCertification reports the changes made in the revisions `revisions` of `data` after the
+// certification signature `certSig` with permission level `p`. Returns false on violations.
+func checkCertification(data []byte, revisions []int64, certSig *signature, p int64) (bool, error) {
+	certified, err := model.NewPdfReader(bytes.NewReader(data[:certSig.revisionEnd]))
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	prev := certified
+	for _, end := range revisions {
+		if end <= certSig.revisionEnd {
+			continue
+		}
+		next, err := model.NewPdfReader(bytes.NewReader(data[:end]))
+		if err != nil {
+			return false, err
+		}
+		changes, err := compareRevisions(prev, next)
+		if err != nil {
+			return false, err
+		}
+
+		fmt.Printf("Revision %d:\n", revisionNumber(revisions, end))
+		if p == 1 {
+			fmt.Println("  VIOLATION: no changes allowed after certification")
+			ok = false
+		}
+		for _, c := range changes {
+			if allowedChange(p, c.kind) {
+				fmt.Printf("  Allowed %s: %s\n", c.kind, c.desc)
+			} else {
+				fmt.Printf("  VIOLATION %s: %s\n", c.kind, c.desc)
+				ok = false
+			}
+		}
+		prev = next
+	}
+	return ok, nil
+}
+
+// compareRevisions returns the changes between the revisions read by `prev` and `next`.
+func compareRevisions(prev, next *model.PdfReader) ([]change, error) {
+	var changes []change
+
+	// Pages and page contents.
+	if len(prev.PageList) != len(next.PageList) {
+		changes = append(changes, change{changePages,
+			fmt.Sprintf("page count changed from %d to %d", len(prev.PageList), len(next.PageList))})
+	}
+	for i := 0; i < len(prev.PageList) && i < len(next.PageList); i++ {
+		contents1, err := prev.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		contents2, err := next.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		if contents1 != contents2 {
+			changes = append(changes, change{changeContent, fmt.Sprintf("page %d contents changed", i+1)})
+		}
+
+		annots1, err := getAnnotations(prev.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		annots2, err := getAnnotations(next.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range compareMaps(annots1, annots2) {
+			changes = append(changes, change{changeAnnotation, fmt.Sprintf("page %d annotation %s", i+1, desc)})
+		}
+	}
+
+	// Form fields.
+	fields1, err := getFieldValues(prev)
+	if err != nil {
+		return nil, err
+	}
+	fields2, err := getFieldValues(next)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(fields2) {
+		v2 := fields2[name]
+		v1, has := fields1[name]
+		switch {
+		case v2.isSignature && v2.value != "" && v1.value == "":
+			changes = append(changes, change{changeSignature, fmt.Sprintf("field %s signed", name)})
+		case !has:
+			changes = append(changes, change{changeFieldAdd, fmt.Sprintf("field %s added", name)})
+		case v1.value != v2.value && v1.isSignature:
+			changes = append(changes, change{changeSignature, fmt.Sprintf("signature %s changed", name)})
+		case v1.value != v2.value:
+			changes = append(changes, change{changeFill, fmt.Sprintf("field %s set to %s", name, v2.value)})
+		}
+	}
+	for _, name := range sortedKeys(fields1) {
+		if _, has := fields2[name]; !has {
+			changes = append(changes, change{changeFieldDel, fmt.Sprintf("field %s removed", name)})
+		}
+	}
+	return changes, nil
+}
+
+// getAnnotations returns the non-widget annotations of `page` by object number.
+func getAnnotations(page *model.PdfPage) (map[string]string, error) {
+	annotations, err := page.GetAnnotations()
+	if err != nil {
+		return nil, err
+	}
+
+	annots := map[string]string{}
+	for i, annot := range annotations {
+		if _, ok := annot.GetContext().(*model.PdfAnnotationWidget); ok {
+			continue
+		}
+		key := fmt.Sprintf("#%d", i)
+		obj := annot.GetContainingPdfObject()
+		if ind, ok := obj.(*core.PdfIndirectObject); ok && ind.ObjectNumber > 0 {
+			key = fmt.Sprintf("%d", ind.ObjectNumber)
+		}
+		if dict, ok := core.GetDict(obj); ok {
+			annots[key] = dict.WriteString()
+		}
+	}
+	return annots, nil
+}
+
+// compareMaps returns descriptions of the entries added, removed and changed between `m1` and `m2`.
+func compareMaps(m1, m2 map[string]string) []string {
+	var diffs []string
+	for _, key := range sortedKeys(m2) {
+		v1, has := m1[key]
+		switch {
+		case !has:
+			diffs = append(diffs, key+" added")
+		case v1 != m2[key]:
+			diffs = append(diffs, key+" changed")
+		}
+	}
+	for _, key := range sortedKeys(m1) {
+		if _, has := m2[key]; !has {
+			diffs = append(diffs, key+" removed")
+		}
+	}
+	return diffs
+}
+
+// fieldValue represents the value of a terminal form field.
+type fieldValue struct {
+	value       string
+	isSignature bool
+}
+
+// getFieldValues returns the values of the terminal form fields of `reader` by full name.
+func getFieldValues(reader *model.PdfReader) (map[string]fieldValue, error) {
+	values := map[string]fieldValue{}
+	if reader.AcroForm == nil {
+		return values, nil
+	}
+
+	for _, field := range reader.AcroForm.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		var fv fieldValue
+		if sig, ok := field.GetContext().(*model.PdfFieldSignature); ok {
+			fv.isSignature = true
+			if sig.V != nil {
+				fv.value = sig.V.ToPdfObject().WriteString()
+			}
+		} else if field.V != nil {
+			fv.value = field.V.WriteString()
+		}
+		values[name] = fv
+	}
+	return values, nil
+}
+
+// getSignatures returns the signatures of the form of `reader`, sorted by revision.
+func getSignatures(reader *model.PdfReader) ([]*signature, error) {
+	if reader.AcroForm == nil {
+		return nil, nil
+	}
+
+	var sigs []*signature
+	seen := map[*model.PdfField]bool{}
+	for _, field := range reader.AcroForm.AllFields() {
+		if seen[field] || field.V == nil {
+			continue
+		}
+		seen[field] = true
+		dict, ok := core.GetDict(field.V)
+		if !ok {
+			continue
+		}
+		if t, _ := core.GetNameVal(dict.Get("Type")); t != "Sig" {
+			continue
+		}
+
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+		sig := &signature{field: field, name: name, dict: dict}
+		if ind, ok := field.V.(*core.PdfIndirectObject); ok {
+			sig.objectNum = ind.ObjectNumber
+		}
+
+		// The signed revision ends with the last byte range.
+		if arr, ok := core.GetArray(dict.Get("ByteRange")); ok && arr.Len() == 4 {
+			byteRange, err := arr.ToInt64Slice()
+			if err != nil {
+				return nil, err
+			}
+			sig.revisionEnd = byteRange[2] + byteRange[3]
+		}
+		sigs = append(sigs, sig)
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].revisionEnd < sigs[j].revisionEnd
+	})
+	return sigs, nil
+}
+
+// getDocMDP returns the certification signature of the document read by `reader` and its DocMDP
+// permission level. Returns a nil signature if the document is not certified.
+func getDocMDP(reader *model.PdfReader) (*signature, int64, error) {
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	perms, ok := core.GetDict(catalog.Get("Perms"))
+	if !ok {
+		return nil, 0, nil
+	}
+	docMDP, ok := core.GetIndirect(perms.Get("DocMDP"))
+	if !ok {
+		return nil, 0, nil
+	}
+
+	sigs, err := getSignatures(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, sig := range sigs {
+		if sig.objectNum != docMDP.ObjectNumber {
+			continue
+		}
+
+		// Permission level of the DocMDP transform parameters (default 2).
+		p := int64(2)
+		if refs, ok := core.GetArray(sig.dict.Get("Reference")); ok {
+			for _, obj := range refs.Elements() {
+				ref, ok := core.GetDict(obj)
+				if !ok {
+					continue
+				}
+				if method, _ := core.GetNameVal(ref.Get("TransformMethod")); method != "DocMDP" {
+					continue
+				}
+				if params, ok := core.GetDict(ref.Get("TransformParams")); ok {
+					if val, ok := core.GetIntVal(params.Get("P")); ok {
+						p = int64(val)
+					}
+				}
+			}
+		}
+		return sig, p, nil
+	}
+	return nil, 0, errors.New("certification signature not found")
+}
+
+// getPerms returns the permissions dictionary object of the catalog of `reader`.
+func getPerms(reader *model.PdfReader) (*core.PdfIndirectObject, error) {
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, err
+	}
+	obj := catalog.Get("Perms")
+	if ref, ok := obj.(*core.PdfObjectReference); ok {
+		if obj, err = reader.GetIndirectObjectByNumber(int(ref.ObjectNumber)); err != nil {
+			return nil, err
+		}
+	}
+	perms, ok := core.GetIndirect(obj)
+	if !ok {
+		return nil, errors.New("permissions dictionary not found")
+	}
+	return perms, nil
+}
+
+// getCatalog returns the catalog dictionary of `reader`.
+func getCatalog(reader *model.PdfReader) (*core.PdfObjectDictionary, error) {
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root := trailer.Get("Root")
+	if ref, ok := root.(*core.PdfObjectReference); ok {
+		if root, err = reader.GetIndirectObjectByNumber(int(ref.ObjectNumber)); err != nil {
+			return nil, err
+		}
+	}
+	catalog, ok := core.GetDict(root)
+	if !ok {
+		return nil, errors.New("catalog not found")
+	}
+	return catalog, nil
+}
+
+// findRevisions returns the end offsets of the revisions of the PDF file `data`.
+func findRevisions(data []byte) []int64 {
+	var revisions []int64
+	marker := []byte("%%EOF")
+	for offset := 0; ; {
+		idx := bytes.Index(data[offset:], marker)
+		if idx < 0 {
+			break
+		}
+		// The revision ends after the end-of-line marker following %%EOF.
+		end := offset + idx + len(marker)
+		if end < len(data) && data[end] == '\r' {
+			end++
+		}
+		if end < len(data) && data[end] == '\n' {
+			end++
+		}
+		revisions = append(revisions, int64(end))
+		offset = end
+	}
+	return revisions
+}
+
+// revisionNumber returns the number of the revision ending at `end` (approximated by the first
+// revision ending after `end`).
+func revisionNumber(revisions []int64, end int64) int {
+	for i, rev := range revisions {
+		if rev >= end {
+			return i + 1
+		}
+	}
+	return len(revisions)
+}
+
+// sortedKeys returns the sorted keys of map `m`.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]string:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]fieldValue:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseRect parses rectangle `str` of format llx,lly,urx,ury.
+func parseRect(str string) ([]float64, error) {
+	parts := strings.Split(str, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid rectangle: %s", str)
+	}
+
+	rect := make([]float64, 4)
+	for i, part := range parts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rectangle: %s", str)
+		}
+		rect[i] = val
+	}
+	return rect, nil
+}
+
+func generateKeys(name string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	// Generate private key.
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Initialize X509 certificate template.
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{"Test Company"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour * 24 * 365),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// Generate X509 certificate.
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, cert, nil
+}
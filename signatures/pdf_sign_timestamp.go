@@ -0,0 +1,819 @@
+/*
+ * This example showcases how to embed RFC 3161 timestamps in PDF signatures:
+ * - signatures (adbe.pkcs7.detached) with a timestamp token of the signature value as an unsigned
+ *   attribute (id-aa-timeStampToken), providing a trusted signing time,
+ * - document timestamps (/DocTimeStamp, ETSI.RFC3161) of the whole document.
+ *
+ * The timestamps are requested from the Time Stamping Authority (TSA) specified by -tsa. If no TSA is
+ * specified, a local TSA stand-in is started, which is useful for offline testing. The local TSA can also
+ * be run as a server with the tsa-server command.
+ *
+ * The verify command validates the signatures and timestamp tokens of a PDF file.
+ *
+ * The file is signed using a generated private/public key pair.
+ *
+ * Sign with a timestamp:
+ * $ ./pdf_sign_timestamp sign [-tsa URL] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Add a document timestamp:
+ * $ ./pdf_sign_timestamp doctimestamp [-tsa URL] <INPUT_PDF_PATH> <OUTPUT_PDF_PATH>
+ *
+ * Verify signatures and timestamps:
+ * $ ./pdf_sign_timestamp verify <INPUT_PDF_PATH>
+ *
+ * Run the local TSA:
+ * $ ./pdf_sign_timestamp tsa-server [-addr localhost:8080]
+ */
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/core"
+	"github.com/unidoc/unipdf/v3/model"
+)
+
+var now = time.Now()
+
+const usage = "Usage:\n" +
+	"  %[1]s sign [-tsa URL] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s doctimestamp [-tsa URL] INPUT_PDF_PATH OUTPUT_PDF_PATH\n" +
+	"  %[1]s verify INPUT_PDF_PATH\n" +
+	"  %[1]s tsa-server [-addr ADDRESS]\n"
+
+// Size of the signature Contents.
+const signatureLen = 8192
+
+var (
+	oidTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidExtKeyUsage          = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidKeyPurposeTimeStamp  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+	// Policy of the timestamps issued by the local TSA.
+	oidLocalTSAPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+)
+
+// RFC 3161 structures.
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional,default:false"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// ESS signing certificate (RFC 5035), identifying the TSA certificate in the timestamp token.
+type essCertIDv2 struct {
+	CertHash []byte // SHA256 hash of the certificate.
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "sign", "doctimestamp":
+		err = signCommand(os.Args[1], os.Args[2:])
+	case "verify":
+		if len(os.Args) < 3 {
+			fmt.Printf(usage, os.Args[0])
+			return
+		}
+		err = verify(os.Args[2])
+	case "tsa-server":
+		var addr string
+		flags := flag.NewFlagSet("tsa-server", flag.ExitOnError)
+		flags.StringVar(&addr, "addr", "localhost:8080", "Listening address")
+		flags.Parse(os.Args[2:])
+
+		var tsa *localTSA
+		if tsa, err = newLocalTSA(); err == nil {
+			log.Printf("TSA listening on http://%s\n", addr)
+			err = http.ListenAndServe(addr, tsa)
+		}
+	default:
+		fmt.Printf(usage, os.Args[0])
+		return
+	}
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+}
+
+// signCommand signs (`command` sign) or timestamps (`command` doctimestamp) the PDF file with the
+// options in `args`.
+func signCommand(command string, args []string) error {
+	var tsaURL string
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&tsaURL, "tsa", "", "URL of the RFC 3161 TSA (default: start a local TSA)")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Printf(usage, os.Args[0])
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	inputPath := flags.Arg(0)
+	outputPath := flags.Arg(1)
+
+	// Start the local TSA.
+	if tsaURL == "" {
+		tsa, err := newLocalTSA()
+		if err != nil {
+			return err
+		}
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		go http.Serve(listener, tsa)
+
+		tsaURL = "http://" + listener.Addr().String()
+		log.Printf("Using local TSA at %s\n", tsaURL)
+	}
+
+	// Create reader.
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := model.NewPdfReader(file)
+	if err != nil {
+		return err
+	}
+
+	// Create appender.
+	appender, err := model.NewPdfAppender(reader)
+	if err != nil {
+		return err
+	}
+
+	// Create the signature with the signature handler.
+	var handler model.SignatureHandler
+	if command == "doctimestamp" {
+		handler = &docTimeStampHandler{tsaURL: tsaURL}
+	} else {
+		priv, cert, err := generateKeys()
+		if err != nil {
+			return err
+		}
+		handler = &timestampedPKCS7Handler{privateKey: priv, certificate: cert, tsaURL: tsaURL}
+	}
+
+	signature := model.NewPdfSignature(handler)
+	if command == "sign" {
+		signature.SetName("Test Timestamped Signature")
+		signature.SetReason("TestTimestamp")
+		signature.SetDate(now, "")
+	}
+
+	if err := signature.Initialize(); err != nil {
+		return err
+	}
+
+	// Create an invisible signature field.
+	sigField := model.NewPdfFieldSignature(signature)
+	sigField.Rect = core.MakeArray(core.MakeInteger(0), core.MakeInteger(0), core.MakeInteger(0), core.MakeInteger(0))
+	if command == "doctimestamp" {
+		sigField.T = core.MakeString(docTimeStampFieldName(reader))
+	}
+
+	if err = appender.Sign(1, sigField); err != nil {
+		return err
+	}
+
+	// Write output PDF file.
+	if err = appender.WriteToFile(outputPath); err != nil {
+		return err
+	}
+
+	log.Printf("PDF file successfully signed. Output path: %s\n", outputPath)
+	return nil
+}
+
+// docTimeStampFieldName returns the name of a new document timestamp field, numbered after the signature
+// fields of the form of `reader`.
+func docTimeStampFieldName(reader *model.PdfReader) string {
+	names := map[string]bool{}
+	count := 0
+	if reader.AcroForm != nil {
+		for _, field := range reader.AcroForm.AllFields() {
+			names[field.PartialName()] = true
+			if _, ok := field.GetContext().(*model.PdfFieldSignature); ok {
+				count++
+			}
+		}
+	}
+	for n := count + 1; ; n++ {
+		if name := fmt.Sprintf("DocTimeStamp %d", n); !names[name] {
+			return name
+		}
+	}
+}
+
+// timestampedPKCS7Handler is an adbe.pkcs7.detached signature handler embedding a timestamp token of
+// the signature value.
+type timestampedPKCS7Handler struct {
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+	tsaURL      string
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *timestampedPKCS7Handler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "adbe.pkcs7.detached"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *timestampedPKCS7Handler) InitSignature(sig *model.PdfSignature) error {
+	sig.Handler = h
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("adbe.pkcs7.detached")
+	sig.Reference = nil
+
+	// Reserve the space for the signature, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *timestampedPKCS7Handler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *timestampedPKCS7Handler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	signedData, err := pkcs7.NewSignedData(digest.(*bytes.Buffer).Bytes())
+	if err != nil {
+		return err
+	}
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signedData.AddSigner(h.certificate, h.privateKey, pkcs7.SignerInfoConfig{}); err != nil {
+		return err
+	}
+
+	// Timestamp the signature value.
+	signer := &signedData.GetSignedData().SignerInfos[0]
+	hash := crypto.SHA256.New()
+	hash.Write(signer.EncryptedDigest)
+	token, err := requestTimestamp(h.tsaURL, hash.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	err = signer.SetUnauthenticatedAttributes([]pkcs7.Attribute{
+		{Type: oidTimeStampToken, Value: asn1.RawValue{FullBytes: token}},
+	})
+	if err != nil {
+		return err
+	}
+
+	signedData.Detach()
+	data, err := signedData.Finish()
+	if err != nil {
+		return err
+	}
+	return setContents(sig, data)
+}
+
+// Validate validates PdfSignature.
+func (h *timestampedPKCS7Handler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if _, err := verifyPKCS7(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// docTimeStampHandler is an ETSI.RFC3161 document timestamp handler.
+type docTimeStampHandler struct {
+	tsaURL string
+}
+
+// IsApplicable returns true if the signature handler is applicable for the PdfSignature.
+func (h *docTimeStampHandler) IsApplicable(sig *model.PdfSignature) bool {
+	return sig != nil && sig.SubFilter != nil && *sig.SubFilter == "ETSI.RFC3161"
+}
+
+// InitSignature initialises the PdfSignature.
+func (h *docTimeStampHandler) InitSignature(sig *model.PdfSignature) error {
+	sig.Handler = h
+	sig.Type = core.MakeName("DocTimeStamp")
+	sig.Filter = core.MakeName("Adobe.PPKLite")
+	sig.SubFilter = core.MakeName("ETSI.RFC3161")
+	sig.Reference = nil
+
+	// Reserve the space for the timestamp token, set when writing the file.
+	sig.Contents = core.MakeHexString(string(make([]byte, signatureLen)))
+	return nil
+}
+
+// NewDigest creates a new digest.
+func (h *docTimeStampHandler) NewDigest(sig *model.PdfSignature) (model.Hasher, error) {
+	return bytes.NewBuffer(nil), nil
+}
+
+// Sign sets the Contents fields.
+func (h *docTimeStampHandler) Sign(sig *model.PdfSignature, digest model.Hasher) error {
+	hash := crypto.SHA256.New()
+	hash.Write(digest.(*bytes.Buffer).Bytes())
+	token, err := requestTimestamp(h.tsaURL, hash.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return err
+	}
+	return setContents(sig, token)
+}
+
+// Validate validates PdfSignature.
+func (h *docTimeStampHandler) Validate(sig *model.PdfSignature, digest model.Hasher) (model.SignatureValidationResult, error) {
+	if _, err := verifyTimestampToken(sig.Contents.Bytes(), digest.(*bytes.Buffer).Bytes()); err != nil {
+		return model.SignatureValidationResult{}, err
+	}
+	return model.SignatureValidationResult{IsSigned: true, IsVerified: true}, nil
+}
+
+// setContents sets the Contents of `sig` to `data`, padded to the reserved size.
+func setContents(sig *model.PdfSignature, data []byte) error {
+	if len(data) > signatureLen {
+		return fmt.Errorf("signature too large: %d bytes", len(data))
+	}
+	contents := make([]byte, signatureLen)
+	copy(contents, data)
+	sig.Contents = core.MakeHexString(string(contents))
+	return nil
+}
+
+// requestTimestamp requests a timestamp token for `digest` computed with `hash` from the TSA at `tsaURL`.
+func requestTimestamp(tsaURL string, digest []byte, hash crypto.Hash) ([]byte, error) {
+	hashOID, err := hashAlgorithmOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: hashOID, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := http.Post(tsaURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA error: %s", httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid TSA response: %v", err)
+	}
+	// Status granted (0) or granted with modifications (1).
+	if resp.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected: status %d", resp.Status.Status)
+	}
+
+	token := resp.TimeStampToken.FullBytes
+	info, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("timestamp message imprint mismatch")
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp nonce mismatch")
+	}
+	return token, nil
+}
+
+// parseTimestampToken verifies the signature of timestamp token `token` and returns its timestamp
+// information.
+func parseTimestampToken(token []byte) (*tstInfo, error) {
+	p7, err := pkcs7.Parse(trimDER(token))
+	if err != nil {
+		return nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token signature: %v", err)
+	}
+
+	var info tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &info); err != nil {
+		return nil, fmt.Errorf("invalid timestamp info: %v", err)
+	}
+	return &info, nil
+}
+
+// verifyTimestampToken verifies the signature of timestamp token `token` and that it timestamps `data`.
+// Returns the timestamp information.
+func verifyTimestampToken(token []byte, data []byte) (*tstInfo, error) {
+	info, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashAlgorithm(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(info.MessageImprint.HashedMessage, h.Sum(nil)) {
+		return nil, errors.New("timestamp message imprint mismatch")
+	}
+	return info, nil
+}
+
+// verifyPKCS7 verifies the detached PKCS7 signature `contents` of `data` and its timestamp token, if any.
+// Returns the timestamp information (nil if the signature is not timestamped).
+func verifyPKCS7(contents, data []byte) (*tstInfo, error) {
+	p7, err := pkcs7.Parse(trimDER(contents))
+	if err != nil {
+		return nil, err
+	}
+	p7.Content = data
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+
+	for _, attr := range p7.Signers[0].UnauthenticatedAttributes {
+		if !attr.Type.Equal(oidTimeStampToken) {
+			continue
+		}
+		// The timestamp token timestamps the signature value.
+		return verifyTimestampToken(attr.Value.Bytes, p7.Signers[0].EncryptedDigest)
+	}
+	return nil, nil
+}
+
+// verify verifies the signatures and timestamps of the PDF file in `inputPath`.
+func verify(inputPath string) error {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if reader.AcroForm == nil {
+		return errors.New("no signatures found")
+	}
+
+	count := 0
+	valid := true
+	for _, field := range reader.AcroForm.AllFields() {
+		sigDict, ok := core.GetDict(field.V)
+		if !ok {
+			continue
+		}
+		sigType, _ := core.GetNameVal(sigDict.Get("Type"))
+		if sigType != "Sig" && sigType != "DocTimeStamp" {
+			continue
+		}
+		count++
+		name, _ := field.FullName()
+		subFilter, _ := core.GetNameVal(sigDict.Get("SubFilter"))
+		fmt.Printf("--- %s (%s, %s)\n", name, sigType, subFilter)
+
+		// Signed data of the byte range.
+		var signed []byte
+		contents, _ := core.GetStringBytes(sigDict.Get("Contents"))
+		byteRange, ok := core.GetArray(sigDict.Get("ByteRange"))
+		if ok {
+			br, err := byteRange.ToInt64Slice()
+			if err != nil || len(br) != 4 || br[2]+br[3] > int64(len(data)) {
+				return fmt.Errorf("invalid byte range: %s", byteRange)
+			}
+			signed = append(append(signed, data[br[0]:br[0]+br[1]]...), data[br[2]:br[2]+br[3]]...)
+			if br[2]+br[3] == int64(len(data)) {
+				fmt.Println("Covers the whole document")
+			} else {
+				fmt.Println("Covers a previous revision of the document")
+			}
+		}
+
+		var info *tstInfo
+		switch subFilter {
+		case "adbe.pkcs7.detached":
+			info, err = verifyPKCS7(contents, signed)
+		case "ETSI.RFC3161":
+			info, err = verifyTimestampToken(contents, signed)
+		default:
+			err = fmt.Errorf("unsupported subfilter %s", subFilter)
+		}
+		if err != nil {
+			fmt.Printf("Validation: failed: %v\n", err)
+			valid = false
+			continue
+		}
+		fmt.Println("Validation: valid")
+
+		if signTime, ok := core.GetStringVal(sigDict.Get("M")); ok {
+			fmt.Printf("Claimed signing time: %s\n", signTime)
+		}
+		if info != nil {
+			fmt.Printf("Timestamp: %s (serial %s, policy %s)\n", info.GenTime.Format(time.RFC3339),
+				info.SerialNumber, info.Policy)
+		} else {
+			fmt.Println("Timestamp: none")
+		}
+	}
+
+	if count == 0 {
+		return errors.New("no signatures found")
+	}
+	if !valid {
+		return errors.New("verification failed")
+	}
+	return nil
+}
+
+// trimDER returns the DER encoded element at the start of `data`, removing the padding of signature
+// Contents.
+func trimDER(data []byte) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return data
+	}
+	return raw.FullBytes
+}
+
+// hashAlgorithmOID returns the object identifier of `hash`.
+func hashAlgorithmOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return pkcs7.OIDDigestAlgorithmSHA1, nil
+	case crypto.SHA256:
+		return pkcs7.OIDDigestAlgorithmSHA256, nil
+	case crypto.SHA384:
+		return pkcs7.OIDDigestAlgorithmSHA384, nil
+	case crypto.SHA512:
+		return pkcs7.OIDDigestAlgorithmSHA512, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %v", hash)
+}
+
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if hashOID, _ := hashAlgorithmOID(hash); hashOID.Equal(oid) {
+			return hash, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
+}
+
+// localTSA is a minimal RFC 3161 Time Stamping Authority with a generated key pair, intended for testing.
+type localTSA struct {
+	privateKey  *rsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+// newLocalTSA returns a new local TSA with a generated timestamping certificate.
+func newLocalTSA() (*localTSA, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	// The extended key usage of TSA certificates must be critical and only contain timeStamping.
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{oidKeyPurposeTimeStamp})
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   "Local Test TSA",
+			Organization: []string{"Test Company"},
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour * 24 * 365),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtraExtensions:       []pkix.Extension{{Id: oidExtKeyUsage, Critical: true, Value: eku}},
+		BasicConstraintsValid: true,
+	}
+
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localTSA{privateKey: priv, certificate: cert}, nil
+}
+
+// randomSerial returns a random 128-bit serial number, so that the certificates and tokens of the TSAs of
+// different runs are not confused.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// ServeHTTP handles timestamp requests.
+func (tsa *localTSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := tsa.respond(body)
+	if err != nil {
+		log.Printf("TSA error: %v\n", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(resp)
+}
+
+// respond returns the DER encoded response to the DER encoded timestamp request `data`.
+func (tsa *localTSA) respond(data []byte) ([]byte, error) {
+	// Failure information bits: badAlg (0), badRequest (2).
+	reject := func(failBit int) ([]byte, error) {
+		failInfo := asn1.BitString{Bytes: []byte{byte(0x80 >> uint(failBit))}, BitLength: failBit + 1}
+		return asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 2, FailInfo: failInfo}})
+	}
+
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(data, &req); err != nil || len(rest) > 0 {
+		return reject(2)
+	}
+	hash, err := hashAlgorithm(req.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return reject(0)
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return reject(2)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         oidLocalTSAPolicy,
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   serial,
+		GenTime:        time.Now().UTC().Truncate(time.Second),
+		Accuracy:       accuracy{Seconds: 1},
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The timestamp token is a signed data with the TSTInfo content.
+	signedData, err := pkcs7.NewSignedData(info)
+	if err != nil {
+		return nil, err
+	}
+	signedData.GetSignedData().ContentInfo.ContentType = oidTSTInfo
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+
+	certHash := crypto.SHA256.New()
+	certHash.Write(tsa.certificate.Raw)
+	signingCert := pkcs7.Attribute{
+		Type:  oidSigningCertificateV2,
+		Value: signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash.Sum(nil)}}},
+	}
+	config := pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{signingCert}}
+	if err := signedData.AddSigner(tsa.certificate, tsa.privateKey, config); err != nil {
+		return nil, err
+	}
+	token, err := signedData.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: 0},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+}
+
+func generateKeys() (*rsa.PrivateKey, *x509.Certificate, error) {
+	// Generate private key.
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Initialize X509 certificate template.
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test Company"},
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour * 24 * 365),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// Generate X509 certificate.
+	certData, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, cert, nil
+}
@@ -1,71 +1,1673 @@
 /*
- * This example showcases how to validate a digital signature with UniDoc.
+ * This example showcases how to validate the digital signatures of a PDF file with UniDoc and produce a
+ * validation report. Every signature and document timestamp is checked for:
+ * - byte range: the signed byte ranges cover the whole revision except the Contents,
+ * - integrity: the digest and signature value match the signed data,
+ * - certificate chain: the signer certificate chains to the trust store (-trust, PEM or DER files,
+ *   the system roots by default),
+ * - key usage: the signer certificate is allowed to sign documents (or timestamps),
+ * - validity: the signer certificate is valid at the signing time (from the signature timestamp,
+ *   the signing-time attribute or the /M entry, in order of preference),
+ * - revocation: the embedded OCSP responses and CRLs (in the Document Security Store, the Adobe
+ *   revocation information attribute or the CMS) report the certificates as not revoked,
+ * - modifications: the changes made in the revisions after the signed revision, checked against the
+ *   permissions of the certification (DocMDP) signature. Every added or changed object must belong to
+ *   a signature, the validation data (DSS), a form field or an annotation.
  *
- * $ ./pdf_sign_validate <INPUT_PDF_PATH>
+ * Each signature gets a verdict: VALID, INDETERMINATE (e.g. untrusted chain, missing revocation data)
+ * or INVALID. The report is printed as text or JSON.
+ *
+ * $ ./pdf_sign_validate [-trust CERT_PATH[,CERT_PATH...]] [-format text|json] <INPUT_PDF_PATH>
  */
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/gunnsth/pkcs7"
+	"github.com/unidoc/unipdf/v3/core"
 	"github.com/unidoc/unipdf/v3/model"
-	"github.com/unidoc/unipdf/v3/model/sighandler"
+	"golang.org/x/crypto/ocsp"
+)
+
+const usage = "Usage: %s [-trust CERT_PATH[,CERT_PATH...]] [-format text|json] INPUT_PDF_PATH\n"
+
+// Check statuses, by increasing severity.
+const (
+	statusPassed        = "passed"
+	statusWarning       = "warning"
+	statusIndeterminate = "indeterminate"
+	statusFailed        = "failed"
+)
+
+// Signature verdicts.
+const (
+	verdictValid         = "VALID"
+	verdictIndeterminate = "INDETERMINATE"
+	verdictInvalid       = "INVALID"
 )
 
-const usage = "Usage: %s P12_FILE PASSWORD INPUT_PDF_PATH OUTPUT_PDF_PATH\n"
+// Kinds of changes between revisions.
+const (
+	changePages      = "pages"
+	changeContent    = "page content"
+	changeAnnotation = "annotation"
+	changeFieldAdd   = "field added"
+	changeFieldDel   = "field removed"
+	changeFill       = "form fill-in"
+	changeSignature  = "signature"
+	changeObject     = "object"
+)
+
+var (
+	oidMessageDigest           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidRSASSAPSS               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidTimeStampToken          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidRevocationInfoArchival  = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 8}
+	oidKeyPurposeDocSigning    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 36}
+	oidKeyPurposeAdobeAuthDocs = asn1.ObjectIdentifier{1, 2, 840, 113583, 1, 1, 5}
+	oidKeyPurposeMSDocSigning  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 3, 12}
+)
+
+// RFC 3161 structures.
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional,default:false"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// RSASSA-PSS parameters (RFC 4055).
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// Adobe revocation information signed attribute.
+type revocationInfoArchival struct {
+	CRLs  []asn1.RawValue `asn1:"explicit,optional,tag:0"`
+	OCSPs []asn1.RawValue `asn1:"explicit,optional,tag:1"`
+	Other []asn1.RawValue `asn1:"explicit,optional,tag:2"`
+}
+
+// validationReport is the validation report of a PDF file.
+type validationReport struct {
+	File       string             `json:"file"`
+	Revisions  int                `json:"revisions"`
+	Signatures []*signatureReport `json:"signatures"`
+}
+
+// signatureReport is the validation report of a signature.
+type signatureReport struct {
+	Name              string     `json:"name"`
+	Type              string     `json:"type"`
+	SubFilter         string     `json:"subFilter"`
+	Revision          int        `json:"revision"`
+	CoversWholeFile   bool       `json:"coversWholeFile"`
+	Certification     int64      `json:"docMDP,omitempty"` // DocMDP permission level of certifications.
+	Signer            string     `json:"signer,omitempty"`
+	Issuer            string     `json:"issuer,omitempty"`
+	SigningTime       *time.Time `json:"signingTime,omitempty"`
+	SigningTimeSource string     `json:"signingTimeSource,omitempty"`
+	Checks            []check    `json:"checks"`
+	Modifications     []string   `json:"modifications,omitempty"`
+	Verdict           string     `json:"verdict"`
+}
+
+// check is the result of a validation check.
+type check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// addCheck adds the result of check `name` to the report.
+func (r *signatureReport) addCheck(name, status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, check{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// setVerdict sets the verdict of the report from the status of its checks.
+func (r *signatureReport) setVerdict() {
+	r.Verdict = verdictValid
+	for _, c := range r.Checks {
+		switch c.Status {
+		case statusFailed:
+			r.Verdict = verdictInvalid
+			return
+		case statusIndeterminate:
+			r.Verdict = verdictIndeterminate
+		}
+	}
+}
 
 func main() {
-	args := os.Args
-	if len(args) < 2 {
+	var trustPaths, format string
+	flag.StringVar(&trustPaths, "trust", "", "Comma-separated PEM or DER files of the trusted certificates (default: system roots)")
+	flag.StringVar(&format, "format", "text", "Report format: text or json")
+	flag.Usage = func() {
 		fmt.Printf(usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		return
 	}
-	inputPath := args[1]
+	if format != "text" && format != "json" {
+		log.Fatalf("Fail: unsupported format %s\n", format)
+	}
+
+	roots, err := loadTrustStore(trustPaths)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	report, err := validate(flag.Arg(0), roots)
+	if err != nil {
+		log.Fatalf("Fail: %v\n", err)
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Fail: %v\n", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		printReport(report)
+	}
+
+	invalid := 0
+	for _, sig := range report.Signatures {
+		if sig.Verdict != verdictValid {
+			invalid++
+		}
+	}
+	if len(report.Signatures) == 0 {
+		log.Fatal("Fail: no signatures found\n")
+	}
+	if invalid > 0 {
+		log.Fatalf("Fail: %d of %d signatures are not valid\n", invalid, len(report.Signatures))
+	}
+}
+
+// loadTrustStore returns the pool of the certificates in the comma-separated files `paths`, or the system
+// roots if no paths are specified.
+func loadTrustStore(paths string) (*x509.CertPool, error) {
+	if paths == "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return x509.NewCertPool(), nil
+		}
+		return roots, nil
+	}
+
+	roots := x509.NewCertPool()
+	for _, path := range strings.Split(paths, ",") {
+		data, err := ioutil.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Contains(data, []byte("-----BEGIN")) {
+			cert, err := x509.ParseCertificate(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			roots.AddCert(cert)
+			continue
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			roots.AddCert(cert)
+		}
+	}
+	return roots, nil
+}
+
+// signatureData represents a signature or document timestamp of a PDF file.
+type signatureData struct {
+	name      string
+	dict      *core.PdfObjectDictionary
+	objectNum int64
+	sigType   string
+	subFilter string
+	contents  []byte
+	byteRange []int64
+	signed    []byte // Data of the byte range.
+	end       int64  // End offset of the signed revision.
+}
+
+// validationContext represents the data shared by the validation of the signatures of a PDF file.
+type validationContext struct {
+	data      []byte
+	revisions []int64
+	roots     *x509.CertPool
+
+	// Embedded validation data of the DSS.
+	certs []*x509.Certificate
+	ocsps [][]byte
+	crls  []*pkix.CertificateList
+
+	// Readers of the revisions, by end offset.
+	readers map[int64]*model.PdfReader
+}
+
+// validate validates the signatures of the PDF file in `inputPath` with the trusted certificates `roots`.
+func validate(inputPath string, roots *x509.CertPool) (*validationReport, error) {
+	data, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := model.NewPdfReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	isEncrypted, err := reader.IsEncrypted()
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted {
+		if _, err := reader.Decrypt([]byte("")); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx := &validationContext{
+		data:      data,
+		revisions: findRevisions(data),
+		roots:     roots,
+		readers:   map[int64]*model.PdfReader{int64(len(data)): reader},
+	}
+	if err := ctx.loadDSS(reader); err != nil {
+		return nil, err
+	}
+
+	sigs, err := getSignatures(data, reader)
+	if err != nil {
+		return nil, err
+	}
+	certSig, p, err := getDocMDP(reader, sigs)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &validationReport{File: inputPath, Revisions: len(ctx.revisions)}
+	for _, sig := range sigs {
+		sigReport := ctx.validateSignature(sig)
+		if sig == certSig {
+			sigReport.Certification = p
+		}
+		if err := ctx.checkModifications(sig, sigReport); err != nil {
+			return nil, err
+		}
+		sigReport.setVerdict()
+		report.Signatures = append(report.Signatures, sigReport)
+	}
+	return report, nil
+}
+
+// loadDSS loads the validation data of the Document Security Store of the document read by `reader`.
+func (ctx *validationContext) loadDSS(reader *model.PdfReader) error {
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return err
+	}
+	dss, ok := core.GetDict(catalog.Get("DSS"))
+	if !ok {
+		return nil
+	}
+
+	for _, key := range []core.PdfObjectName{"Certs", "OCSPs", "CRLs"} {
+		arr, ok := core.GetArray(dss.Get(key))
+		if !ok {
+			continue
+		}
+		for _, obj := range arr.Elements() {
+			stream, ok := core.GetStream(obj)
+			if !ok {
+				continue
+			}
+			data, err := core.DecodeStream(stream)
+			if err != nil {
+				return err
+			}
+			switch key {
+			case "Certs":
+				if cert, err := x509.ParseCertificate(data); err == nil {
+					ctx.certs = append(ctx.certs, cert)
+				}
+			case "OCSPs":
+				ctx.ocsps = append(ctx.ocsps, data)
+			case "CRLs":
+				if crl, err := x509.ParseCRL(data); err == nil {
+					ctx.crls = append(ctx.crls, crl)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// signatureContents represents the verified contents of a signature.
+type signatureContents struct {
+	signer       *x509.Certificate
+	certs        []*x509.Certificate
+	ocsps        [][]byte
+	crls         []*pkix.CertificateList
+	signingTime  *time.Time // Signing-time attribute.
+	timestamp    *tstInfo
+	timestampErr error
+}
+
+// validateSignature validates signature `sig` and returns its report, without the modifications check.
+func (ctx *validationContext) validateSignature(sig *signatureData) *signatureReport {
+	report := &signatureReport{
+		Name:            sig.name,
+		Type:            sig.sigType,
+		SubFilter:       sig.subFilter,
+		Revision:        revisionNumber(ctx.revisions, sig.end),
+		CoversWholeFile: sig.end == int64(len(ctx.data)),
+	}
+
+	// Byte range.
+	if err := ctx.checkByteRange(sig); err != nil {
+		report.addCheck("byte range", statusFailed, "%v", err)
+		return report
+	}
+	if report.CoversWholeFile {
+		report.addCheck("byte range", statusPassed, "covers the whole file except the signature contents")
+	} else {
+		report.addCheck("byte range", statusPassed, "covers revision %d of %d except the signature contents",
+			report.Revision, len(ctx.revisions))
+	}
 
-	// Create reader.
-	file, err := os.Open(inputPath)
+	// Integrity.
+	contents, err := verifyContents(sig)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		report.addCheck("integrity", statusFailed, "%v", err)
+		return report
+	}
+	report.addCheck("integrity", statusPassed, "digest and signature value match the signed data")
+	report.Signer = contents.signer.Subject.String()
+	report.Issuer = contents.signer.Issuer.String()
+
+	// Signing time, preferring trusted timestamps to the time claimed by the signer.
+	signingTime := time.Now()
+	report.SigningTimeSource = "validation time"
+	if contents.timestampErr != nil {
+		report.addCheck("timestamp", statusFailed, "%v", contents.timestampErr)
+	}
+	if contents.timestamp != nil {
+		signingTime = contents.timestamp.GenTime
+		report.SigningTimeSource = "timestamp"
+		if sig.sigType != "DocTimeStamp" {
+			report.addCheck("timestamp", statusPassed, "signature timestamp of %s", signingTime.Format(time.RFC3339))
+		}
+	} else if contents.signingTime != nil {
+		signingTime = *contents.signingTime
+		report.SigningTimeSource = "signing-time attribute"
+	} else if date, ok := core.GetStringVal(sig.dict.Get("M")); ok {
+		if pdfDate, err := model.NewPdfDate(date); err == nil {
+			signingTime = pdfDate.ToGoTime()
+			report.SigningTimeSource = "claimed (/M)"
+		}
+	}
+	report.SigningTime = &signingTime
+
+	// Certificate chain.
+	pool := append(append([]*x509.Certificate{}, contents.certs...), ctx.certs...)
+	intermediates := x509.NewCertPool()
+	for _, cert := range pool {
+		intermediates.AddCert(cert)
+	}
+	keyUsage := x509.ExtKeyUsageAny
+	if sig.sigType == "DocTimeStamp" {
+		keyUsage = x509.ExtKeyUsageTimeStamping
+	}
+	chains, err := contents.signer.Verify(x509.VerifyOptions{
+		Roots:         ctx.roots,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{keyUsage},
+	})
+	var chain []*x509.Certificate
+	trusted := err == nil
+	if !trusted {
+		report.addCheck("certificate chain", statusIndeterminate, "%v", err)
+		chain = buildChain(contents.signer, pool)
+	} else {
+		chain = chains[0]
+		report.addCheck("certificate chain", statusPassed, "chains to trusted %s", chain[len(chain)-1].Subject)
+	}
+
+	// Key usage. Extended key usages other than document signing (e.g. server authentication of the
+	// generated test certificates of the other examples) are reported as warnings.
+	if err := checkKeyUsage(contents.signer, sig.sigType == "DocTimeStamp"); err != nil {
+		report.addCheck("key usage", statusFailed, "%v", err)
+	} else if err := checkExtKeyUsage(contents.signer); err != nil && sig.sigType != "DocTimeStamp" {
+		report.addCheck("key usage", statusWarning, "%v", err)
+	} else {
+		report.addCheck("key usage", statusPassed, "signer certificate allowed for signing")
+	}
+
+	// Validity at signing time.
+	if signingTime.Before(contents.signer.NotBefore) || signingTime.After(contents.signer.NotAfter) {
+		report.addCheck("validity", statusFailed, "signer certificate valid from %s to %s, not at signing time",
+			contents.signer.NotBefore.Format(time.RFC3339), contents.signer.NotAfter.Format(time.RFC3339))
+	} else {
+		report.addCheck("validity", statusPassed, "signer certificate valid at signing time")
+	}
+
+	// Revocation.
+	ocsps := append(append([][]byte{}, contents.ocsps...), ctx.ocsps...)
+	crls := append(append([]*pkix.CertificateList{}, contents.crls...), ctx.crls...)
+	status, message := statusPassed, "no certificate in the chain is revoked"
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		revoked, revokedAt, err := revocationStatus(cert, issuer, ocsps, crls)
+		switch {
+		case err != nil:
+			status, message = statusIndeterminate, err.Error()
+		case !revoked:
+		case revokedAt.After(signingTime) && contents.timestamp != nil:
+			if status == statusPassed {
+				status = statusWarning
+				message = fmt.Sprintf("%s revoked at %s, after signing", cert.Subject, revokedAt.Format(time.RFC3339))
+			}
+		default:
+			status = statusFailed
+			message = fmt.Sprintf("%s revoked at %s", cert.Subject, revokedAt.Format(time.RFC3339))
+		}
+		if status == statusFailed {
+			break
+		}
+	}
+	if len(chain) < 2 && !trusted {
+		status, message = statusIndeterminate, "issuer of the signer certificate not found"
+	} else if len(chain) < 2 {
+		message = "signer certificate is trusted directly"
+	}
+	report.addCheck("revocation", status, "%s", message)
+	return report
+}
+
+// checkByteRange checks that the byte range of `sig` covers its revision except the Contents.
+func (ctx *validationContext) checkByteRange(sig *signatureData) error {
+	br := sig.byteRange
+	if len(br) != 4 {
+		return errors.New("invalid byte range")
+	}
+	if br[0] != 0 || br[1] <= 0 || br[2] <= br[1] || br[3] < 0 || br[2]+br[3] > int64(len(ctx.data)) {
+		return fmt.Errorf("invalid byte range %v", br)
+	}
+
+	// The gap is the Contents hexadecimal string.
+	gap := ctx.data[br[1]:br[2]]
+	if len(gap) < 2 || gap[0] != '<' || gap[len(gap)-1] != '>' {
+		return errors.New("byte range gap is not the signature contents")
+	}
+	for _, c := range gap[1 : len(gap)-1] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(c)) {
+			return errors.New("byte range gap is not the signature contents")
+		}
+	}
+	if len(gap)-2 != 2*len(sig.contents) {
+		return errors.New("byte range gap does not match the signature contents")
 	}
-	defer file.Close()
 
-	reader, err := model.NewPdfReader(file)
+	// The byte range must end at the end of a revision.
+	for _, end := range ctx.revisions {
+		if end == sig.end {
+			return nil
+		}
+	}
+	if sig.end == int64(len(ctx.data)) {
+		return nil
+	}
+	return fmt.Errorf("byte range ends at %d, not at the end of a revision", sig.end)
+}
+
+// checkModifications adds the modifications made after signature `sig` to `report`, checked against the
+// DocMDP permissions if `sig` is a certification signature.
+func (ctx *validationContext) checkModifications(sig *signatureData, report *signatureReport) error {
+	if report.CoversWholeFile {
+		report.addCheck("modifications", statusPassed, "no modifications after signing")
+		return nil
+	}
+	if len(report.Checks) > 0 && report.Checks[0].Status == statusFailed {
+		return nil
+	}
+
+	prev, err := ctx.readerAt(sig.end)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return err
 	}
+	status, message := statusPassed, "only signatures and validation data added after signing"
+	for _, end := range ctx.revisions {
+		if end <= sig.end {
+			continue
+		}
+		next, err := ctx.readerAt(end)
+		if err != nil {
+			return err
+		}
+		changes, err := compareRevisions(prev, next)
+		if err != nil {
+			return err
+		}
+
+		rev := revisionNumber(ctx.revisions, end)
+		for _, c := range changes {
+			report.Modifications = append(report.Modifications, fmt.Sprintf("revision %d: %s: %s", rev, c.kind, c.desc))
+			switch {
+			case report.Certification > 0 && !allowedChange(report.Certification, c.kind):
+				status = statusFailed
+				message = fmt.Sprintf("changes not allowed by the certification (DocMDP P=%d)", report.Certification)
+			case c.kind != changeSignature && status == statusPassed:
+				status, message = statusWarning, "document modified after signing"
+			}
+		}
+		prev = next
+	}
+	report.addCheck("modifications", status, "%s", message)
+	return nil
+}
 
-	// Create signature handlers.
-	handlerX509RSASHA1, err := sighandler.NewAdobeX509RSASHA1(nil, nil)
+// readerAt returns the reader of the revision ending at `end`.
+func (ctx *validationContext) readerAt(end int64) (*model.PdfReader, error) {
+	if reader, ok := ctx.readers[end]; ok {
+		return reader, nil
+	}
+	reader, err := model.NewPdfReader(bytes.NewReader(ctx.data[:end]))
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return nil, err
+	}
+	if isEncrypted, err := reader.IsEncrypted(); err == nil && isEncrypted {
+		if _, err := reader.Decrypt([]byte("")); err != nil {
+			return nil, err
+		}
+	}
+	ctx.readers[end] = reader
+	return reader, nil
+}
+
+// verifyContents verifies the integrity of signature `sig` and returns its contents.
+func verifyContents(sig *signatureData) (*signatureContents, error) {
+	switch sig.subFilter {
+	case "adbe.pkcs7.detached", "ETSI.CAdES.detached", "adbe.pkcs7.sha1":
+		return verifyCMS(sig)
+	case "adbe.x509.rsa_sha1":
+		return verifyX509RSASHA1(sig)
+	case "ETSI.RFC3161":
+		info, p7, err := verifyTimestampToken(sig.contents, sig.signed)
+		if err != nil {
+			return nil, err
+		}
+		signer := p7.GetOnlySigner()
+		if signer == nil {
+			return nil, errors.New("timestamp signer certificate not found")
+		}
+		return &signatureContents{signer: signer, certs: p7.Certificates, timestamp: info}, nil
 	}
+	return nil, fmt.Errorf("unsupported subfilter %s", sig.subFilter)
+}
 
-	handlerPKCS7Detached, err := sighandler.NewAdobePKCS7Detached(nil, nil)
+// verifyCMS verifies the CMS signature `sig`.
+func verifyCMS(sig *signatureData) (*signatureContents, error) {
+	p7, err := pkcs7.Parse(trimDER(sig.contents))
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return nil, err
+	}
+	if sig.subFilter == "adbe.pkcs7.sha1" {
+		// The signed content is the SHA1 digest of the byte range.
+		digest := sha1.Sum(sig.signed)
+		if !bytes.Equal(p7.Content, digest[:]) {
+			return nil, errors.New("message digest mismatch")
+		}
+	} else {
+		p7.Content = sig.signed
+	}
+	if err := verifySignerInfo(p7); err != nil {
+		return nil, err
+	}
+
+	contents := &signatureContents{signer: p7.GetOnlySigner(), certs: p7.Certificates}
+	if contents.signer == nil {
+		return nil, errors.New("signer certificate not found")
+	}
+
+	for i := range p7.CRLs {
+		contents.crls = append(contents.crls, &p7.CRLs[i])
+	}
+
+	var signingTime time.Time
+	if err := p7.UnmarshalSignedAttribute(oidSigningTime, &signingTime); err == nil {
+		contents.signingTime = &signingTime
+	}
+	var archival revocationInfoArchival
+	if err := p7.UnmarshalSignedAttribute(oidRevocationInfoArchival, &archival); err == nil {
+		for _, raw := range archival.CRLs {
+			if crl, err := x509.ParseCRL(raw.FullBytes); err == nil {
+				contents.crls = append(contents.crls, crl)
+			}
+		}
+		for _, raw := range archival.OCSPs {
+			contents.ocsps = append(contents.ocsps, raw.FullBytes)
+		}
+	}
+
+	for _, attr := range p7.Signers[0].UnauthenticatedAttributes {
+		if !attr.Type.Equal(oidTimeStampToken) {
+			continue
+		}
+		// The timestamp token timestamps the signature value.
+		info, token, err := verifyTimestampToken(attr.Value.Bytes, p7.Signers[0].EncryptedDigest)
+		if err != nil {
+			contents.timestampErr = fmt.Errorf("invalid signature timestamp: %v", err)
+			continue
+		}
+		contents.timestamp = info
+		contents.certs = append(contents.certs, token.Certificates...)
+	}
+	return contents, nil
+}
+
+// verifyX509RSASHA1 verifies the adbe.x509.rsa_sha1 signature `sig`.
+func verifyX509RSASHA1(sig *signatureData) (*signatureContents, error) {
+	var certs []*x509.Certificate
+	certObj := sig.dict.Get("Cert")
+	certObjs := []core.PdfObject{certObj}
+	if arr, ok := core.GetArray(certObj); ok {
+		certObjs = arr.Elements()
+	}
+	for _, obj := range certObjs {
+		data, ok := core.GetStringBytes(obj)
+		if !ok {
+			continue
+		}
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("signer certificate not found")
+	}
+	pub, ok := certs[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("signer certificate key is not RSA")
+	}
+
+	var sigValue []byte
+	if _, err := asn1.Unmarshal(sig.contents, &sigValue); err != nil {
+		return nil, err
+	}
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		h := hash.New()
+		h.Write(sig.signed)
+		if rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sigValue) == nil {
+			return &signatureContents{signer: certs[0], certs: certs}, nil
+		}
 	}
+	return nil, errors.New("signature value mismatch")
+}
 
-	handlers := []model.SignatureHandler{
-		handlerX509RSASHA1,
-		handlerPKCS7Detached,
+// verifyTimestampToken verifies the signature of timestamp token `token` and that it timestamps `data`.
+// Returns the timestamp information and the token.
+func verifyTimestampToken(token []byte, data []byte) (*tstInfo, *pkcs7.PKCS7, error) {
+	p7, err := pkcs7.Parse(trimDER(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, nil, fmt.Errorf("invalid timestamp token signature: %v", err)
 	}
 
-	// Validate signatures.
-	res, err := reader.ValidateSignatures(handlers)
+	var info tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &info); err != nil {
+		return nil, nil, fmt.Errorf("invalid timestamp info: %v", err)
+	}
+	hash, err := hashAlgorithm(info.MessageImprint.HashAlgorithm.Algorithm)
 	if err != nil {
-		log.Fatal("Fail: %v\n", err)
+		return nil, nil, err
 	}
-	if len(res) == 0 {
-		log.Fatal("Fail: no signature fields found")
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(info.MessageImprint.HashedMessage, h.Sum(nil)) {
+		return nil, nil, errors.New("timestamp message imprint mismatch")
 	}
+	return &info, p7, nil
+}
 
-	if !res[0].IsSigned || !res[0].IsVerified {
-		log.Fatal("Fail: validation failed")
+// buildChain returns the chain of `cert` built from the certificates `pool`, up to a self-signed
+// certificate or a certificate without known issuer.
+func buildChain(cert *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{cert}
+	for len(chain) <= len(pool) {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			break
+		}
+		var issuer *x509.Certificate
+		for _, c := range pool {
+			if bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer)
+		cert = issuer
 	}
+	return chain
+}
+
+// checkKeyUsage checks that the key usage of `cert` allows signing, and that its extended key usage allows
+// signing timestamps if `timestamp` is true.
+func checkKeyUsage(cert *x509.Certificate, timestamp bool) error {
+	if cert.KeyUsage != 0 && cert.KeyUsage&(x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment) == 0 {
+		return errors.New("key usage does not include digital signature or non-repudiation")
+	}
+
+	if timestamp {
+		if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageTimeStamping {
+			return errors.New("extended key usage is not time stamping")
+		}
+	}
+	return nil
+}
+
+// checkExtKeyUsage checks that the extended key usage of `cert`, if any, allows signing documents.
+func checkExtKeyUsage(cert *x509.Certificate) error {
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageAny || usage == x509.ExtKeyUsageEmailProtection {
+			return nil
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		if oid.Equal(oidKeyPurposeDocSigning) || oid.Equal(oidKeyPurposeAdobeAuthDocs) || oid.Equal(oidKeyPurposeMSDocSigning) {
+			return nil
+		}
+	}
+	return errors.New("extended key usage does not allow document signing")
+}
+
+// revocationStatus returns the revocation status of `cert` issued by `issuer` from the OCSP responses
+// `ocsps` and CRLs `crls`. Returns an error if no revocation data of the certificate is available.
+func revocationStatus(cert, issuer *x509.Certificate, ocsps [][]byte, crls []*pkix.CertificateList) (bool, time.Time, error) {
+	for _, data := range ocsps {
+		resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+		if err != nil {
+			continue
+		}
+		switch resp.Status {
+		case ocsp.Good:
+			return false, time.Time{}, nil
+		case ocsp.Revoked:
+			return true, resp.RevokedAt, nil
+		}
+	}
+	for _, crl := range crls {
+		if issuer.CheckCRLSignature(crl) != nil {
+			continue
+		}
+		for _, revoked := range crl.TBSCertList.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true, revoked.RevocationTime, nil
+			}
+		}
+		return false, time.Time{}, nil
+	}
+	return false, time.Time{}, fmt.Errorf("no embedded revocation data for %s", cert.Subject)
+}
+
+// printReport prints the validation report `report` in human-readable form.
+func printReport(report *validationReport) {
+	fmt.Printf("File: %s (%d revisions, %d signatures)\n", report.File, report.Revisions, len(report.Signatures))
+	for i, sig := range report.Signatures {
+		fmt.Printf("\n--- Signature %d: %s (%s, %s)\n", i+1, sig.Name, sig.Type, sig.SubFilter)
+		coverage := "covers the whole file"
+		if !sig.CoversWholeFile {
+			coverage = "the document was updated after signing"
+		}
+		fmt.Printf("Revision: %d of %d, %s\n", sig.Revision, report.Revisions, coverage)
+		if sig.Certification > 0 {
+			fmt.Printf("Certification: DocMDP P=%d\n", sig.Certification)
+		}
+		if sig.Signer != "" {
+			fmt.Printf("Signer: %s\n", sig.Signer)
+			fmt.Printf("Issuer: %s\n", sig.Issuer)
+		}
+		if sig.SigningTime != nil {
+			fmt.Printf("Signing time: %s (%s)\n", sig.SigningTime.Format(time.RFC3339), sig.SigningTimeSource)
+		}
+		for _, c := range sig.Checks {
+			fmt.Printf("  %-15s %-18s %s\n", "["+c.Status+"]", c.Name, c.Message)
+		}
+		if len(sig.Modifications) > 0 {
+			fmt.Println("Modifications after signing:")
+			for _, m := range sig.Modifications {
+				fmt.Printf("  %s\n", m)
+			}
+		}
+		fmt.Printf("Verdict: %s\n", sig.Verdict)
+	}
+}
+
+// getSignatures returns the signatures and document timestamps of the form of `reader` reading the PDF file
+// `data`, sorted by revision.
+func getSignatures(data []byte, reader *model.PdfReader) ([]*signatureData, error) {
+	if reader.AcroForm == nil {
+		return nil, nil
+	}
+
+	var sigs []*signatureData
+	seen := map[*model.PdfField]bool{}
+	for _, field := range reader.AcroForm.AllFields() {
+		if seen[field] || field.V == nil {
+			continue
+		}
+		seen[field] = true
+		sigDict, ok := core.GetDict(field.V)
+		if !ok || sigDict.Get("ByteRange") == nil {
+			continue
+		}
+		sigType, _ := core.GetNameVal(sigDict.Get("Type"))
+		if sigType == "" {
+			sigType = "Sig"
+		}
+		if sigType != "Sig" && sigType != "DocTimeStamp" {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		sig := &signatureData{name: name, dict: sigDict, sigType: sigType}
+		if ind, ok := field.V.(*core.PdfIndirectObject); ok {
+			sig.objectNum = ind.ObjectNumber
+		}
+		sig.subFilter, _ = core.GetNameVal(sigDict.Get("SubFilter"))
+		sig.contents, _ = core.GetStringBytes(sigDict.Get("Contents"))
+		if arr, ok := core.GetArray(sigDict.Get("ByteRange")); ok {
+			sig.byteRange, _ = arr.ToInt64Slice()
+		}
+
+		// Signed data of valid byte ranges (checked by checkByteRange).
+		br := sig.byteRange
+		if len(br) == 4 && br[0] >= 0 && br[1] >= 0 && br[2] >= br[0]+br[1] && br[3] >= 0 {
+			sig.end = br[2] + br[3]
+			if sig.end <= int64(len(data)) {
+				sig.signed = append(append(sig.signed, data[br[0]:br[0]+br[1]]...), data[br[2]:sig.end]...)
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+
+	sort.Slice(sigs, func(i, j int) bool {
+		return sigs[i].end < sigs[j].end
+	})
+	return sigs, nil
+}
+
+// getDocMDP returns the certification signature among `sigs` of the document read by `reader` and its
+// DocMDP permission level. Returns a nil signature if the document is not certified.
+func getDocMDP(reader *model.PdfReader, sigs []*signatureData) (*signatureData, int64, error) {
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	perms, ok := core.GetDict(catalog.Get("Perms"))
+	if !ok {
+		return nil, 0, nil
+	}
+	docMDP, ok := core.GetIndirect(perms.Get("DocMDP"))
+	if !ok {
+		return nil, 0, nil
+	}
+
+	for _, sig := range sigs {
+		if sig.objectNum != docMDP.ObjectNumber {
+			continue
+		}
+
+		// Permission level of the DocMDP transform parameters (default 2).
+		p := int64(2)
+		if refs, ok := core.GetArray(sig.dict.Get("Reference")); ok {
+			for _, obj := range refs.Elements() {
+				ref, ok := core.GetDict(obj)
+				if !ok {
+					continue
+				}
+				if method, _ := core.GetNameVal(ref.Get("TransformMethod")); method != "DocMDP" {
+					continue
+				}
+				if params, ok := core.GetDict(ref.Get("TransformParams")); ok {
+					if val, ok := core.GetIntVal(params.Get("P")); ok {
+						p = int64(val)
+					}
+				}
+			}
+		}
+		return sig, p, nil
+	}
+	return nil, 0, nil
+}
+
+// getCatalog returns the catalog dictionary of `reader`.
+func getCatalog(reader *model.PdfReader) (*core.PdfObjectDictionary, error) {
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	root := trailer.Get("Root")
+	if ref, ok := root.(*core.PdfObjectReference); ok {
+		if root, err = reader.GetIndirectObjectByNumber(int(ref.ObjectNumber)); err != nil {
+			return nil, err
+		}
+	}
+	catalog, ok := core.GetDict(root)
+	if !ok {
+		return nil, errors.New("catalog not found")
+	}
+	return catalog, nil
+}
+
+// change represents a change between two revisions.
+type change struct {
+	kind string
+	desc string
+}
+
+// objectChange represents an object added, changed or removed between two revisions.
+type objectChange struct {
+	Number int    `json:"number"`
+	Status string `json:"status"`
+	Type   string `json:"type"`
+}
+
+// allowedChange returns true if changes of `kind` are allowed by DocMDP permission level `p`.
+func allowedChange(p int64, kind string) bool {
+	switch kind {
+	case changeFill, changeSignature:
+		return p >= 2
+	case changeAnnotation:
+		return p >= 3
+	}
+	return false
+}
+
+// compareRevisions returns the changes between the revisions read by `prev` and `next`.
+func compareRevisions(prev, next *model.PdfReader) ([]change, error) {
+	var changes []change
+
+	// Pages and page contents.
+	if len(prev.PageList) != len(next.PageList) {
+		changes = append(changes, change{changePages,
+			fmt.Sprintf("page count changed from %d to %d", len(prev.PageList), len(next.PageList))})
+	}
+	for i := 0; i < len(prev.PageList) && i < len(next.PageList); i++ {
+		contents1, err := prev.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		contents2, err := next.PageList[i].GetAllContentStreams()
+		if err != nil {
+			return nil, err
+		}
+		if contents1 != contents2 {
+			changes = append(changes, change{changeContent, fmt.Sprintf("page %d contents changed", i+1)})
+		}
+
+		annots1, err := getAnnotations(prev.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		annots2, err := getAnnotations(next.PageList[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range compareMaps(annots1, annots2) {
+			changes = append(changes, change{changeAnnotation, fmt.Sprintf("page %d annotation %s", i+1, desc)})
+		}
+	}
+
+	// Form fields.
+	fields1, err := getFieldValues(prev)
+	if err != nil {
+		return nil, err
+	}
+	fields2, err := getFieldValues(next)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(fields2) {
+		v2 := fields2[name]
+		v1, has := fields1[name]
+		switch {
+		case v2.isSignature && v2.value != "" && v1.value == "":
+			changes = append(changes, change{changeSignature, fmt.Sprintf("field %s signed", name)})
+		case !has:
+			changes = append(changes, change{changeFieldAdd, fmt.Sprintf("field %s added", name)})
+		case v1.value != v2.value && v1.isSignature:
+			changes = append(changes, change{changeSignature, fmt.Sprintf("signature %s changed", name)})
+		case v1.value != v2.value:
+			changes = append(changes, change{changeFill, fmt.Sprintf("field %s set to %s", name, v2.value)})
+		}
+	}
+	for _, name := range sortedKeys(fields1) {
+		if _, has := fields2[name]; !has {
+			changes = append(changes, change{changeFieldDel, fmt.Sprintf("field %s removed", name)})
+		}
+	}
+
+	// Objects changed for other purposes, e.g. page resources, catalog actions or metadata.
+	objChanges, err := compareObjects(prev, next)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := getAllowedObjects(next)
+	if err != nil {
+		return nil, err
+	}
+	for _, oc := range objChanges {
+		if isAllowedObjectChange(oc, allowed) {
+			continue
+		}
+		changes = append(changes, change{changeObject,
+			fmt.Sprintf("object %d %s (%s)", oc.Number, oc.Status, oc.Type)})
+	}
+	catalogChanges, err := compareCatalogs(prev, next)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range catalogChanges {
+		changes = append(changes, change{changeObject, fmt.Sprintf("catalog entry %s", key)})
+	}
+	for i := 0; i < len(prev.PageList) && i < len(next.PageList); i++ {
+		for _, key := range comparePages(prev.PageList[i], next.PageList[i]) {
+			changes = append(changes, change{changeObject, fmt.Sprintf("page %d entry %s", i+1, key)})
+		}
+	}
+	return changes, nil
+}
+
+// compareObjects returns the objects added, changed and removed between the revisions read by `prev`
+// and `next`.
+func compareObjects(prev, next *model.PdfReader) ([]objectChange, error) {
+	prevNums := map[int]bool{}
+	for _, num := range prev.GetObjectNums() {
+		prevNums[num] = true
+	}
+
+	var changes []objectChange
+	for _, num := range next.GetObjectNums() {
+		obj2, err := next.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		if !prevNums[num] {
+			changes = append(changes, objectChange{num, "added", objectType(obj2)})
+			continue
+		}
+		delete(prevNums, num)
+
+		obj1, err := prev.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		if serializeObject(obj1) != serializeObject(obj2) {
+			changes = append(changes, objectChange{num, "changed", objectType(obj2)})
+		}
+	}
+
+	var removed []int
+	for num := range prevNums {
+		removed = append(removed, num)
+	}
+	sort.Ints(removed)
+	for _, num := range removed {
+		obj, err := prev.GetIndirectObjectByNumber(num)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, objectChange{num, "removed", objectType(obj)})
+	}
+	return changes, nil
+}
+
+// serializeObject returns the serialized contents of indirect object or stream `obj`.
+func serializeObject(obj core.PdfObject) string {
+	switch t := obj.(type) {
+	case *core.PdfObjectStream:
+		return t.PdfObjectDictionary.WriteString() + "stream\n" + string(t.Stream)
+	case *core.PdfIndirectObject:
+		return t.PdfObject.WriteString()
+	}
+	return obj.WriteString()
+}
+
+// objectType returns a description of the type of indirect object or stream `obj`.
+func objectType(obj core.PdfObject) string {
+	kind := "object"
+	if _, ok := obj.(*core.PdfObjectStream); ok {
+		kind = "stream"
+	}
+	dict, ok := core.GetDict(obj)
+	if !ok {
+		if ind, ok := obj.(*core.PdfIndirectObject); ok {
+			switch ind.PdfObject.(type) {
+			case *core.PdfObjectArray:
+				kind = "array"
+			case *core.PdfObjectString:
+				kind = "string"
+			case *core.PdfObjectInteger, *core.PdfObjectFloat:
+				kind = "number"
+			}
+		}
+		return kind
+	}
+
+	typ, _ := core.GetNameVal(dict.Get("Type"))
+	if subtype, _ := core.GetNameVal(dict.Get("Subtype")); subtype != "" {
+		typ += "/" + subtype
+	} else if ft, _ := core.GetNameVal(dict.Get("FT")); ft != "" {
+		typ += "Field/" + ft
+	}
+	if typ == "" {
+		return kind
+	}
+	return kind + " " + typ
+}
+
+// allowedObjects contains the object numbers of the objects which may be added or changed after signing.
+type allowedObjects struct {
+	// Objects reachable from signatures, the DSS, the form and the annotations, which may be added.
+	added map[int]bool
+	// Signatures, DSS, form field, annotation and appearance objects, which may be changed. The catalog,
+	// pages and document information are compared separately.
+	changed map[int]bool
+}
+
+// getAllowedObjects returns the objects of the revision read by `reader` which may be added or changed
+// by signing, adding validation data, filling in forms and annotating.
+func getAllowedObjects(reader *model.PdfReader) (*allowedObjects, error) {
+	allowed := &allowedObjects{added: map[int]bool{}, changed: map[int]bool{}}
+
+	catalog, err := getCatalog(reader)
+	if err != nil {
+		return nil, err
+	}
+	trailer, err := reader.GetTrailer()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range []core.PdfObjectName{"Root", "Info"} {
+		if ref, ok := trailer.Get(key).(*core.PdfObjectReference); ok {
+			allowed.changed[int(ref.ObjectNumber)] = true
+			allowed.added[int(ref.ObjectNumber)] = true
+		}
+	}
+	for _, page := range reader.PageList {
+		if ind, ok := page.GetContainingPdfObject().(*core.PdfIndirectObject); ok {
+			allowed.changed[int(ind.ObjectNumber)] = true
+		}
+	}
+
+	// The whole DSS may be updated.
+	markObjects(reader, catalog.Get("DSS"), allowed.added, allowed.changed)
+
+	// Form fields, signatures and annotations, with their appearances.
+	markObjects(reader, catalog.Get("AcroForm"), allowed.added, nil)
+	for _, page := range reader.PageList {
+		annotations, err := page.GetAnnotations()
+		if err != nil {
+			return nil, err
+		}
+		for _, annot := range annotations {
+			markObjects(reader, annot.GetContainingPdfObject(), allowed.added, nil)
+		}
+	}
+	for num := range allowed.added {
+		obj, err := reader.GetIndirectObjectByNumber(num)
+		if err != nil {
+			continue
+		}
+		dict, ok := core.GetDict(obj)
+		if !ok {
+			continue
+		}
+		typ, _ := core.GetNameVal(dict.Get("Type"))
+		isAnnot := typ == "Annot" || typ == "" && dict.Get("Subtype") != nil && dict.Get("Rect") != nil
+		if typ == "Sig" || typ == "DocTimeStamp" || dict.Get("FT") != nil || dict.Get("Kids") != nil ||
+			dict.Get("Fields") != nil || isAnnot {
+			allowed.changed[num] = true
+			if ap, ok := core.GetDict(dict.Get("AP")); ok {
+				markAppearances(ap, allowed.changed)
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// isAllowedObjectChange returns true if object change `oc` is allowed by `allowed`.
+func isAllowedObjectChange(oc objectChange, allowed *allowedObjects) bool {
+	switch {
+	case oc.Type == "stream XRef" || oc.Type == "stream ObjStm":
+		return oc.Status != "removed"
+	case oc.Status == "added":
+		return allowed.added[oc.Number]
+	case oc.Status == "changed":
+		return allowed.changed[oc.Number]
+	}
+	return false
+}
+
+// markObjects marks the indirect objects reachable from `obj` in `marked` and, if not nil, in
+// `changeable`. Page, page tree and catalog dictionaries and the parent (/P, /Parent) and signature
+// reference data (/Data) entries are not followed.
+func markObjects(reader *model.PdfReader, obj core.PdfObject, marked, changeable map[int]bool) {
+	switch t := obj.(type) {
+	case *core.PdfObjectReference:
+		resolved, err := reader.GetIndirectObjectByNumber(int(t.ObjectNumber))
+		if err != nil {
+			return
+		}
+		markObjects(reader, resolved, marked, changeable)
+	case *core.PdfIndirectObject:
+		if dict, ok := t.PdfObject.(*core.PdfObjectDictionary); ok {
+			if typ, _ := core.GetNameVal(dict.Get("Type")); typ == "Page" || typ == "Pages" || typ == "Catalog" {
+				return
+			}
+		}
+		if !mark(int(t.ObjectNumber), marked, changeable) {
+			return
+		}
+		markObjects(reader, t.PdfObject, marked, changeable)
+	case *core.PdfObjectStream:
+		if !mark(int(t.ObjectNumber), marked, changeable) {
+			return
+		}
+		markObjects(reader, t.PdfObjectDictionary, marked, changeable)
+	case *core.PdfObjectDictionary:
+		for _, key := range t.Keys() {
+			if key == "P" || key == "Parent" || key == "Data" {
+				continue
+			}
+			markObjects(reader, t.Get(key), marked, changeable)
+		}
+	case *core.PdfObjectArray:
+		for _, elem := range t.Elements() {
+			markObjects(reader, elem, marked, changeable)
+		}
+	}
+}
+
+// mark marks object `num` in `marked` and, if not nil, in `changeable`. Returns false if the object is
+// already marked.
+func mark(num int, marked, changeable map[int]bool) bool {
+	if num <= 0 {
+		return true
+	}
+	if marked[num] && (changeable == nil || changeable[num]) {
+		return false
+	}
+	marked[num] = true
+	if changeable != nil {
+		changeable[num] = true
+	}
+	return true
+}
+
+// markAppearances marks the appearance streams of appearance dictionary `ap` in `marked`.
+func markAppearances(ap *core.PdfObjectDictionary, marked map[int]bool) {
+	for _, key := range []core.PdfObjectName{"N", "R", "D"} {
+		objs := []core.PdfObject{ap.Get(key)}
+		if states, ok := core.GetDict(ap.Get(key)); ok {
+			objs = objs[:0]
+			for _, state := range states.Keys() {
+				objs = append(objs, states.Get(state))
+			}
+		}
+		for _, obj := range objs {
+			if ref, ok := obj.(*core.PdfObjectReference); ok {
+				marked[int(ref.ObjectNumber)] = true
+			}
+		}
+	}
+}
+
+// compareCatalogs returns descriptions of the catalog entries changed between the revisions read by `prev`
+// and `next`, except for the DSS, the form (checked by field) and the version.
+func compareCatalogs(prev, next *model.PdfReader) ([]string, error) {
+	catalog1, err := getCatalog(prev)
+	if err != nil {
+		return nil, err
+	}
+	catalog2, err := getCatalog(next)
+	if err != nil {
+		return nil, err
+	}
+	return compareDicts(catalog1, catalog2, "DSS", "AcroForm", "Version"), nil
+}
+
+// comparePages returns descriptions of the entries of `page2` changed from `page1`, except for the
+// annotations (checked by annotation). Missing inheritable entries are taken from the page tree.
+func comparePages(page1, page2 *model.PdfPage) []string {
+	dict1, ok1 := core.GetDict(page1.GetContainingPdfObject())
+	dict2, ok2 := core.GetDict(page2.GetContainingPdfObject())
+	if !ok1 || !ok2 {
+		return nil
+	}
+	inherit := func(dict *core.PdfObjectDictionary) *core.PdfObjectDictionary {
+		d := core.MakeDict()
+		for _, key := range dict.Keys() {
+			d.Set(key, dict.Get(key))
+		}
+		for parent, _ := core.GetDict(dict.Get("Parent")); parent != nil; parent, _ = core.GetDict(parent.Get("Parent")) {
+			for _, key := range []core.PdfObjectName{"Resources", "MediaBox", "CropBox", "Rotate"} {
+				if d.Get(key) == nil && parent.Get(key) != nil {
+					d.Set(key, parent.Get(key))
+				}
+			}
+		}
+		return d
+	}
+	return compareDicts(inherit(dict1), inherit(dict2), "Annots", "Parent")
+}
+
+// compareDicts returns descriptions of the entries added, changed or removed between `d1` and `d2`,
+// except for `ignored`.
+func compareDicts(d1, d2 *core.PdfObjectDictionary, ignored ...core.PdfObjectName) []string {
+	m1 := map[string]string{}
+	m2 := map[string]string{}
+	for _, kv := range []struct {
+		d *core.PdfObjectDictionary
+		m map[string]string
+	}{{d1, m1}, {d2, m2}} {
+		for _, key := range kv.d.Keys() {
+			skip := false
+			for _, ign := range ignored {
+				skip = skip || key == ign
+			}
+			if !skip {
+				kv.m[string(key)] = kv.d.Get(key).WriteString()
+			}
+		}
+	}
+
+	var diffs []string
+	for _, diff := range compareMaps(m1, m2) {
+		diffs = append(diffs, "/"+diff)
+	}
+	return diffs
+}
+
+// getAnnotations returns the non-widget annotations of `page` by object number.
+func getAnnotations(page *model.PdfPage) (map[string]string, error) {
+	annotations, err := page.GetAnnotations()
+	if err != nil {
+		return nil, err
+	}
+
+	annots := map[string]string{}
+	for i, annot := range annotations {
+		if _, ok := annot.GetContext().(*model.PdfAnnotationWidget); ok {
+			continue
+		}
+		key := fmt.Sprintf("#%d", i)
+		obj := annot.GetContainingPdfObject()
+		if ind, ok := obj.(*core.PdfIndirectObject); ok && ind.ObjectNumber > 0 {
+			key = fmt.Sprintf("%d", ind.ObjectNumber)
+		}
+		if dict, ok := core.GetDict(obj); ok {
+			annots[key] = dict.WriteString()
+		}
+	}
+	return annots, nil
+}
+
+// compareMaps returns descriptions of the entries added, removed and changed between `m1` and `m2`.
+func compareMaps(m1, m2 map[string]string) []string {
+	var diffs []string
+	for _, key := range sortedKeys(m2) {
+		v1, has := m1[key]
+		switch {
+		case !has:
+			diffs = append(diffs, key+" added")
+		case v1 != m2[key]:
+			diffs = append(diffs, key+" changed")
+		}
+	}
+	for _, key := range sortedKeys(m1) {
+		if _, has := m2[key]; !has {
+			diffs = append(diffs, key+" removed")
+		}
+	}
+	return diffs
+}
+
+// fieldValue represents the value of a terminal form field.
+type fieldValue struct {
+	value       string
+	isSignature bool
+}
+
+// getFieldValues returns the values of the terminal form fields of `reader` by full name.
+func getFieldValues(reader *model.PdfReader) (map[string]fieldValue, error) {
+	values := map[string]fieldValue{}
+	if reader.AcroForm == nil {
+		return values, nil
+	}
+
+	for _, field := range reader.AcroForm.AllFields() {
+		if !field.IsTerminal() {
+			continue
+		}
+		name, err := field.FullName()
+		if err != nil {
+			return nil, err
+		}
+
+		var fv fieldValue
+		if sig, ok := field.GetContext().(*model.PdfFieldSignature); ok {
+			fv.isSignature = true
+			if sig.V != nil {
+				fv.value = sig.V.ToPdfObject().WriteString()
+			}
+		} else if field.V != nil {
+			fv.value = field.V.WriteString()
+		}
+		values[name] = fv
+	}
+	return values, nil
+}
+
+// findRevisions returns the end offsets of the revisions of the PDF file `data`.
+func findRevisions(data []byte) []int64 {
+	var revisions []int64
+	marker := []byte("%%EOF")
+	for offset := 0; ; {
+		idx := bytes.Index(data[offset:], marker)
+		if idx < 0 {
+			break
+		}
+		// The revision ends after the end-of-line marker following %%EOF.
+		end := offset + idx + len(marker)
+		if end < len(data) && data[end] == '\r' {
+			end++
+		}
+		if end < len(data) && data[end] == '\n' {
+			end++
+		}
+		revisions = append(revisions, int64(end))
+		offset = end
+	}
+	return revisions
+}
+
+// revisionNumber returns the number of the revision ending at `end` (approximated by the first
+// revision ending after `end`).
+func revisionNumber(revisions []int64, end int64) int {
+	for i, rev := range revisions {
+		if rev >= end {
+			return i + 1
+		}
+	}
+	return len(revisions)
+}
+
+// sortedKeys returns the sorted keys of map `m`.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]string:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]fieldValue:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verifySignerInfo verifies the signature of the single signer of `p7`. RSASSA-PSS signatures, not
+// supported by the pkcs7 package, are verified here.
+func verifySignerInfo(p7 *pkcs7.PKCS7) error {
+	if len(p7.Signers) != 1 {
+		return errors.New("signature must have a single signer")
+	}
+	signer := p7.Signers[0]
+	if !signer.DigestEncryptionAlgorithm.Algorithm.Equal(oidRSASSAPSS) {
+		return p7.Verify()
+	}
+
+	// Verify the message digest.
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return errors.New("signer certificate not found")
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("signer certificate key is not RSA")
+	}
+	hash, err := hashAlgorithm(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var digest []byte
+	if err := p7.UnmarshalSignedAttribute(oidMessageDigest, &digest); err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(p7.Content)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return errors.New("message digest mismatch")
+	}
+
+	// Verify the signature of the signed attributes, DER encoded as a SET OF sorted by encoding.
+	var attrs [][]byte
+	for _, attr := range signer.AuthenticatedAttributes {
+		der, err := asn1.Marshal(attr)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, der)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	if err != nil {
+		return err
+	}
+
+	var params pssParameters
+	if _, err := asn1.Unmarshal(signer.DigestEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return err
+	}
+	pssHash, err := hashAlgorithm(params.Hash.Algorithm)
+	if err != nil {
+		return err
+	}
+	h = pssHash.New()
+	h.Write(set)
+	opts := &rsa.PSSOptions{SaltLength: params.SaltLength, Hash: pssHash}
+	return rsa.VerifyPSS(pub, pssHash, h.Sum(nil), signer.EncryptedDigest, opts)
+}
+
+// trimDER returns the DER encoded element at the start of `data`, removing the padding of signature
+// Contents.
+func trimDER(data []byte) []byte {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &raw); err != nil {
+		return data
+	}
+	return raw.FullBytes
+}
 
-	for i, item := range res {
-		fmt.Printf("--- Signature %d\n%s\n", i+1, item.String())
+// hashAlgorithm returns the hash algorithm with object identifier `oid`.
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(pkcs7.OIDDigestAlgorithmSHA512):
+		return crypto.SHA512, nil
 	}
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", oid)
 }